@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// runBench times each hash algorithm and worker count on sample images
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	workerList := fs.String("workers", defaultBenchWorkers(), "comma-separated worker counts to try")
+	limit := fs.Int("limit", 200, "maximum number of sample images to hash (0 for all)")
+	var paths tools.Paths
+	fs.StringVar(&paths.Vips, "vips", "", "path to the libvips vips command (default: look up on PATH)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s bench [flags] <sample_directory>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var workers []int
+	for _, field := range strings.Split(*workerList, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid -workers: %q", *workerList)
+		}
+		workers = append(workers, n)
+	}
+
+	results, err := imagedup.Bench(fs.Arg(0), workers, *limit, paths)
+	if err != nil {
+		log.Fatalf("Benchmark failed: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ALGORITHM\tWORKERS\tFILES\tFILES/S\tMB/S")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%.1f\n", r.Algorithm, r.Workers, r.Files, r.FilesPerSecond(), r.MBPerSecond())
+	}
+	w.Flush()
+
+	best := results[0]
+	fmt.Printf("\nFastest: %s with %d workers (%s)\n", best.Algorithm, best.Workers, benchFlags(best))
+}
+
+// defaultBenchWorkers doubles from one worker up to the number of CPUs
+func defaultBenchWorkers() string {
+	var counts []string
+	n := 1
+	for ; n < runtime.NumCPU(); n *= 2 {
+		counts = append(counts, strconv.Itoa(n))
+	}
+	counts = append(counts, strconv.Itoa(runtime.NumCPU()))
+	return strings.Join(counts, ",")
+}
+
+// benchFlags returns the import flags selecting a benchmark configuration
+func benchFlags(r imagedup.BenchResult) string {
+	flags := []string{"-workers " + strconv.Itoa(r.Workers)}
+	if strings.Contains(r.Algorithm, "vips") {
+		flags = append(flags, "-decoder vips")
+	}
+	if strings.HasPrefix(r.Algorithm, "batch") {
+		flags = append(flags, "-batch-hash")
+	}
+	return strings.Join(flags, " ")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runCheck reports whether an archive already contains a file. The exit
+// status is 0 if it does, 1 if it doesn't and 2 on errors, for scripts.
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	exact := fs.Bool("exact", false, "only count byte-identical copies as already archived")
+	quiet := fs.Bool("q", false, "print nothing, only set the exit status")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s check [flags] <destination_directory> <file>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	result, err := imagedup.Check(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		log.Printf("Failed to check %s: %v", fs.Arg(1), err)
+		os.Exit(2)
+	}
+	if *exact {
+		result.Near = nil
+	}
+
+	if !*quiet {
+		for _, p := range result.Exact {
+			fmt.Printf("exact\t%s\n", p)
+		}
+		for _, p := range result.Near {
+			fmt.Printf("near\t%s\n", p)
+		}
+		if !result.Found() {
+			fmt.Printf("%s is not in the archive\n", fs.Arg(1))
+		}
+	}
+	if !result.Found() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runChunks reports chunk-level redundancy across the videos in a directory
+func runChunks(args []string) {
+	fs := flag.NewFlagSet("chunks", flag.ExitOnError)
+	allMedia := fs.Bool("all", false, "analyze images and RAW files as well as videos")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s chunks [flags] <directory>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	stats, err := imagedup.AnalyzeChunks(fs.Arg(0), *allMedia)
+	if err != nil {
+		log.Fatalf("Failed to analyze chunks: %v", err)
+	}
+
+	fmt.Printf("%d files, %s in %d chunks\n", stats.Files, imagedup.FormatSize(stats.Bytes), stats.Chunks)
+	fmt.Printf("%s unique, %.1f%% redundant across %d files with repeated content\n",
+		imagedup.FormatSize(stats.UniqueBytes), 100*stats.Redundancy(), stats.SharedFiles)
+	if len(stats.Top) > 0 {
+		fmt.Println("\nMost repeated content:")
+		for _, f := range stats.Top {
+			fmt.Printf("  %s: %s of %s\n", f.File, imagedup.FormatSize(f.Shared), imagedup.FormatSize(f.Bytes))
+		}
+	}
+}
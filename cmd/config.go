@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/config"
+)
+
+// configArg finds the value of -config in args before flags are parsed, so
+// the file can supply defaults that command line flags then override
+func configArg(args []string) string {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// applyConfig loads a config file and sets every flag it names
+func applyConfig(fs *flag.FlagSet, path string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range cfg.FlagValues() {
+		if fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown flag %q in %s", name, path)
+		}
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				return nil, fmt.Errorf("invalid value for %q in %s: %w", name, path, err)
+			}
+		}
+	}
+	return cfg, nil
+}
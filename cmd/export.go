@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runExport copies a subset of an organized archive to a new location
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	from := fs.String("from", "", "only export files captured on or after this year, month or date, e.g. 2019-07")
+	until := fs.String("until", "", "only export files captured on or before this year, month or date")
+	camera := fs.String("camera", "", "only export files whose EXIF camera make or model contains this text")
+	folder := fs.String("folder", "", "only export files in archive folders matching this glob, e.g. 2019-07-*")
+	var tags stringList
+	fs.Var(&tags, "tag", "only export files carrying this tag (repeatable, all must match)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s export [flags] <destination_directory> <export_directory>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	filter := imagedup.ExportFilter{Camera: *camera, Folder: *folder}
+	var err error
+	for _, tag := range tags {
+		if tag, err = imagedup.ParseTag(tag); err != nil {
+			log.Fatalf("Invalid -tag: %v", err)
+		}
+		filter.Tags = append(filter.Tags, tag)
+	}
+	if *from != "" {
+		if filter.From, err = imagedup.ParseDateBound(*from); err != nil {
+			log.Fatalf("Invalid -from: %v", err)
+		}
+	}
+	if *until != "" {
+		if filter.Until, err = imagedup.ParseDateBound(*until); err != nil {
+			log.Fatalf("Invalid -until: %v", err)
+		}
+	}
+
+	result, err := imagedup.Export(fs.Arg(0), fs.Arg(1), filter)
+	if err != nil {
+		log.Fatalf("Failed to export: %v", err)
+	}
+	fmt.Printf("%d files exported, %d already present, %d encrypted files left out\n",
+		result.Exported, result.Present, result.Encrypted)
+}
@@ -0,0 +1,15 @@
+package main
+
+import "strings"
+
+// stringList is a flag that may be repeated to collect several values
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+	"github.com/gavinmcnair/pictureprocess/pkg/parity"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// runFsck verifies an archive against the checksums in its manifest
+func runFsck(args []string) {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", 0, "only re-verify files not verified within this duration, e.g. 720h")
+	repair := fs.Bool("repair", false, "rebuild missing or corrupt files from folder parity data")
+	quick := fs.Bool("quick", false, "trust the checksum cached in the extended attribute of files unchanged since, written by -hash-xattr, instead of reading them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s fsck [flags] <destination_directory>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	destDir := fs.Arg(0)
+
+	// fsck saves verification times and repairs, so an import must not be
+	// writing the manifest or its journal meanwhile
+	lock, err := imagedup.LockDestination(destDir, 0)
+	if err != nil {
+		log.Fatalf("Failed to lock %s: %v", destDir, err)
+	}
+	result, err := fsckLocked(destDir, *olderThan, *repair, *quick)
+	// Released before exiting, which skips deferred calls
+	lock.Release()
+	if err != nil {
+		log.Fatalf("Failed to check %s: %v", destDir, err)
+	}
+
+	for _, path := range result.Missing {
+		fmt.Printf("MISSING  %s\n", path)
+	}
+	for _, path := range result.Corrupt {
+		fmt.Printf("CORRUPT  %s\n", path)
+	}
+	fmt.Printf("%d files checked, %d ok, %d missing, %d corrupt, %d present but unverifiable (encrypted or transformed), %d skipped as recently verified\n",
+		result.Checked, result.OK, len(result.Missing), len(result.Corrupt), len(result.Unverifiable), result.Skipped)
+	if result.Cached > 0 {
+		fmt.Printf("%d files judged by their cached checksum without being read\n", result.Cached)
+	}
+
+	if !result.Healthy() {
+		os.Exit(2)
+	}
+}
+
+// fsckLocked verifies, and with repair set repairs, the archive at destDir,
+// whose lock the caller holds, and saves the verification times
+func fsckLocked(destDir string, olderThan time.Duration, repair, quick bool) (*manifest.FsckResult, error) {
+	m, err := manifest.Load(destDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load manifest: %w", err)
+	}
+	if len(m.Entries) == 0 {
+		return nil, fmt.Errorf("no manifest entries found in %s", destDir)
+	}
+
+	check := manifest.Fsck
+	if quick {
+		check = manifest.QuickFsck
+	}
+	result := check(destDir, m, olderThan, time.Now())
+	if repair && !result.Healthy() {
+		repairFiles(destDir, m, result)
+	}
+	if err := m.Save(destDir); err != nil {
+		log.Printf("Failed to record verification times: %v", err)
+	}
+	return result, nil
+}
+
+// repairFiles rebuilds damaged files from parity and re-verifies them,
+// removing repaired files from the result's problem lists
+func repairFiles(destDir string, m *manifest.Manifest, result *manifest.FsckResult) {
+	tl := tools.Detect(tools.Paths{})
+	repaired := make(map[string]bool)
+	for _, p := range append(append([]string{}, result.Missing...), result.Corrupt...) {
+		dir := filepath.Join(destDir, filepath.Dir(filepath.FromSlash(p)))
+		if err := parity.Repair(dir, filepath.Base(p), tl); err != nil {
+			log.Printf("Failed to repair %s: %v", p, err)
+			continue
+		}
+		repaired[p] = true
+	}
+	if len(repaired) == 0 {
+		return
+	}
+
+	// Only count a repair if the rebuilt file matches its recorded checksum
+	recheck := manifest.New()
+	for p := range repaired {
+		recheck.Add(m.Entries[p])
+	}
+	verified := manifest.Fsck(destDir, recheck, 0, time.Now())
+	bad := make(map[string]bool)
+	for _, p := range append(verified.Missing, verified.Corrupt...) {
+		bad[p] = true
+	}
+	for p := range recheck.Entries {
+		if !bad[p] {
+			fmt.Printf("REPAIRED %s\n", p)
+			m.Add(recheck.Entries[p])
+			result.OK++
+		}
+	}
+	result.Missing = filterPaths(result.Missing, repaired, bad)
+	result.Corrupt = filterPaths(result.Corrupt, repaired, bad)
+}
+
+// filterPaths drops paths that were repaired and verified
+func filterPaths(paths []string, repaired, bad map[string]bool) []string {
+	var kept []string
+	for _, p := range paths {
+		if !repaired[p] || bad[p] {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
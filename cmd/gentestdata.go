@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runGenTestData writes a synthetic source tree with known duplicates
+func runGenTestData(args []string) {
+	spec := imagedup.DefaultTestDataSpec()
+	fs := flag.NewFlagSet("gen-testdata", flag.ExitOnError)
+	fs.IntVar(&spec.Images, "images", spec.Images, "number of distinct pictures")
+	fs.IntVar(&spec.Size, "size", spec.Size, "width and height of the pictures in pixels")
+	fs.Float64Var(&spec.Copies, "copies", spec.Copies, "fraction of pictures also written as a byte-identical copy")
+	fs.Float64Var(&spec.Resized, "resized", spec.Resized, "fraction of pictures also written at half size")
+	fs.Float64Var(&spec.Stripped, "stripped", spec.Stripped, "fraction of EXIF-dated pictures also written without EXIF")
+	fs.IntVar(&spec.RAWs, "raw", spec.RAWs, "number of RAW stubs, a quarter of them duplicated")
+	fs.Int64Var(&spec.Seed, "seed", spec.Seed, "random seed; the same flags and seed give the same tree")
+	expect := fs.String("expect", "", "write a JSON description of every generated file and its picture to this file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s gen-testdata [flags] <directory>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	data, err := imagedup.GenerateTestData(fs.Arg(0), spec)
+	if err != nil {
+		log.Fatalf("Failed to generate test data: %v", err)
+	}
+	if *expect != "" {
+		if err := data.WriteJSON(*expect); err != nil {
+			log.Fatalf("Failed to write -expect: %v", err)
+		}
+	}
+	fmt.Printf("%d files of %d distinct pictures written to %s\n", len(data.Files), data.Pictures, fs.Arg(0))
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runLocate prints where source files ended up in an archive
+func runLocate(args []string) {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s locate <destination_directory> <source_file|sha256>...\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	missing := false
+	for _, query := range args[1:] {
+		found, err := imagedup.Locate(args[0], query)
+		if err != nil {
+			log.Fatalf("Failed to locate %s: %v", query, err)
+		}
+		if len(found) == 0 {
+			fmt.Fprintf(os.Stderr, "%s: not found in archive\n", query)
+			missing = true
+		}
+		for _, loc := range found {
+			fmt.Printf("%s\t%s\t(%s)\n", query, loc.Path, loc.Match)
+		}
+	}
+	if missing {
+		os.Exit(1)
+	}
+}
@@ -1,29 +1,425 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"runtime"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/gavinmcnair/pictureprocess/pkg/dateutil"
 	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+	"github.com/gavinmcnair/pictureprocess/pkg/parity"
 )
 
+// subcommands maps subcommand names to their entry points. Anything else is
+// treated as an import run.
+var subcommands = map[string]func(args []string){
+	"fsck":       runFsck,
+	"bench":      runBench,
+	"reindex":    runReindex,
+	"reorganize": runReorganize,
+	"merge":      runMerge,
+	"export":     runExport,
+	"provenance": runProvenance,
+	"locate":     runLocate,
+	"check":      runCheck,
+	"tag":        runTag,
+	"chunks":     runChunks,
+	"prune":      runPrune,
+	"reconcile":  runReconcile,
+
+	"install-service": runInstallService,
+	"gen-testdata":    runGenTestData,
+}
+
 func main() {
-	if len(os.Args) < 3 {
-		log.Fatalf("Usage: %s <source_directory> <destination_directory>\n", filepath.Base(os.Args[0]))
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+	}
+	runImport()
+}
+
+// runImport organizes a source directory into a destination
+func runImport() {
+	opts := imagedup.DefaultOptions()
+
+	flag.IntVar(&opts.NumWorkers, "workers", opts.NumWorkers, "number of concurrent workers")
+	flag.BoolVar(&opts.ExcludeDestination, "exclude-dest", opts.ExcludeDestination, "allow a destination inside the source, skipping it while scanning")
+	filesFrom := flag.String("files-from", "", "process the files listed in this file (- for stdin), one per line or NUL-separated, instead of walking the source")
+	flag.BoolVar(&opts.Incremental, "incremental", opts.Incremental, "only scan files modified since the last run from this source that copied without failures")
+	flag.Var((*stringList)(&opts.OnlyPaths), "only-path", "only process files within this file or folder, relative to the source (repeatable)")
+	since := flag.String("since", "", "only copy files captured on or after this year, month or date, e.g. 2023 or 2023-06")
+	until := flag.String("until", "", "only copy files captured on or before this year, month or date")
+	flag.BoolVar(&opts.IncludeSystemFiles, "include-system-files", opts.IncludeSystemFiles, "don't skip .DS_Store, Thumbs.db, ._* and other system files")
+	flag.BoolVar(&opts.BatchHash, "batch-hash", opts.BatchHash, "hash images as batches of 8x8 grayscale tiles (faster on huge libraries)")
+	flag.Var((*stringList)(&opts.Replicas), "replica", "additional destination to copy every unique file to (repeatable)")
+	skipUnreadable := flag.Bool("skip-unreadable", false, "skip files and folders that can't be read for lack of permission, listing them in the report (the default)")
+	flag.BoolVar(&opts.FailOnUnreadable, "fail-on-unreadable", opts.FailOnUnreadable, "fail before copying anything if any file or folder in the source can't be read for lack of permission")
+	flag.Var((*stringList)(&opts.Protected), "protect", "source folder, relative to the source, whose files are always kept and never offered for deletion (repeatable)")
+	flag.Var((*stringList)(&opts.Catalogs), "catalog", "Lightroom catalog (.lrcat) or Capture One session whose files are protected from deletion and recorded with their collections (repeatable)")
+	flag.StringVar(&opts.ColdStorage, "cold-storage", opts.ColdStorage, "also write compressed RAWs (DNG) and videos (zstd) to this archive tier")
+	flag.StringVar(&opts.Tools.Zstd, "zstd", opts.Tools.Zstd, "path to zstd (default: look up on PATH)")
+	flag.StringVar(&opts.Tools.DNGLab, "dnglab", opts.Tools.DNGLab, "path to dnglab (default: look up on PATH)")
+	var encryptDests, recipients stringList
+	flag.Var(&encryptDests, "encrypt-dest", "encrypt every file written to this destination or replica (repeatable)")
+	flag.Var(&recipients, "recipient", "age or gpg recipient for -encrypt-dest (repeatable)")
+	encryptWith := flag.String("encrypt-with", "age", "encryption tool for -encrypt-dest: age or gpg")
+	flag.StringVar(&opts.Tools.Age, "age", opts.Tools.Age, "path to age (default: look up on PATH)")
+	flag.StringVar(&opts.Tools.GPG, "gpg", opts.Tools.GPG, "path to gpg (default: look up on PATH)")
+	flag.StringVar(&opts.Parity, "parity", opts.Parity, "write parity sidecars per destination folder for fsck -repair: xor or par2")
+	flag.BoolVar(&opts.FolderSummaries, "folder-summaries", opts.FolderSummaries, "write a README.txt summarizing each destination folder touched (count, dates, cameras, events)")
+	flag.StringVar(&opts.Tools.Par2, "par2", opts.Tools.Par2, "path to par2 (default: look up on PATH)")
+	flag.BoolVar(&opts.Verify, "verify", opts.Verify, "re-read every copy and compare its checksum with the source")
+	flag.BoolVar(&opts.Strict, "strict", opts.Strict, "only treat byte-identical files as duplicates")
+	flag.BoolVar(&opts.Salvage, "salvage", opts.Salvage, "hash and archive truncated JPEGs from the part that decodes, flagged as damaged, instead of skipping them")
+	flag.DurationVar(&opts.MatchTime, "match-time", opts.MatchTime, "only collapse same-hash files whose capture times are within this tolerance (e.g. 500ms)")
+	flag.BoolVar(&opts.PregroupTime, "pregroup-time", opts.PregroupTime, "bucket images by capture timestamp first and decode identical copies only once")
+	flag.BoolVar(&opts.Sequences, "sequences", opts.Sequences, "keep timelapse sequences whole in a sequence folder instead of deduplicating their frames")
+	flag.BoolVar(&opts.Related, "related", opts.Related, "report images that look like photos or screenshots of another image")
+	faceDetector := flag.String("face-detector", "", "command run on each image, printing {\"faces\": n, \"eyes_open\": n}; keepers prefer open eyes and more faces")
+	embedCommand := flag.String("embed-command", "", "command printing an image's embedding as a JSON array, for grouping visually similar images")
+	embedURL := flag.String("embed-url", "", "embedding service receiving each image by POST and answering {\"embedding\": [...]}")
+	flag.Float64Var(&opts.Similarity, "similarity", imagedup.DefaultSimilarity, "cosine similarity at which embeddings group images as similar")
+	flag.BoolVar(&opts.FuzzyVideo, "fuzzy-video", opts.FuzzyVideo, "match videos across containers by duration, resolution and sampled frames")
+	flag.BoolVar(&opts.DetectTrims, "detect-trims", opts.DetectTrims, "report videos that are trimmed subsets of others (requires ffmpeg)")
+	flag.BoolVar(&opts.DropTrimmed, "drop-trimmed", opts.DropTrimmed, "keep only the full-length original of trimmed videos (implies -detect-trims)")
+	flag.StringVar(&opts.Tools.FFmpeg, "ffmpeg", opts.Tools.FFmpeg, "path to ffmpeg (default: look up on PATH)")
+	flag.StringVar(&opts.Tools.FFprobe, "ffprobe", opts.Tools.FFprobe, "path to ffprobe (default: look up on PATH)")
+	flag.StringVar(&opts.Tools.ExifTool, "exiftool", opts.Tools.ExifTool, "path to exiftool (default: look up on PATH)")
+	flag.StringVar(&opts.Tools.Vips, "vips", opts.Tools.Vips, "path to the libvips vips command (default: look up on PATH)")
+	flag.StringVar(&opts.Tools.SQLite3, "sqlite3", opts.Tools.SQLite3, "path to sqlite3, used to read Lightroom catalogs (default: look up on PATH)")
+	flag.BoolVar(&opts.Tools.Disabled, "no-external-tools", opts.Tools.Disabled, "never use external tools, even if installed")
+	flag.StringVar(&opts.ReportPath, "report", opts.ReportPath, "write a JSON report of the run to this file")
+	var reportTo stringList
+	flag.Var(&reportTo, "report-to", "also send the JSON report to - (stdout), a file or an http(s):// URL it is POSTed to (repeatable)")
+	flag.StringVar(&opts.HTMLReportPath, "html-report", opts.HTMLReportPath, "write the run's summary and duplicate highlights to this file as a compact HTML page, e.g. to attach to a notification")
+	flag.BoolVar(&opts.HTMLThumbnails, "html-thumbnails", opts.HTMLThumbnails, "show a thumbnail of each duplicate group's keeper in the -html-report")
+	flag.StringVar(&opts.GraphPath, "graph", opts.GraphPath, "export the duplicate graph to this file (.dot for GraphViz, otherwise JSON)")
+	layout := flag.String("layout", string(opts.Layout), "destination folder template, e.g. {year}/{month}/{date}")
+	dateOverrides := flag.String("date-overrides", "", "CSV or JSON file mapping source paths, folders or SHA-256 checksums to dates that override all date extraction")
+	flag.BoolVar(&opts.Periods, "periods", opts.Periods, "file undated files under the nearest source folder naming a period, such as 1980s or 1994-summer")
+	dateOrder := flag.String("date-order", string(opts.DateOrder), "order for filename dates that read more than one way, e.g. 02/03/2004: ymd, dmy or mdy")
+	flag.BoolVar(&opts.Mirror, "mirror", opts.Mirror, "keep the source's folders and file names instead of -layout and -naming, only leaving out duplicates")
+	naming := flag.String("naming", string(opts.Naming), "destination naming policy: counter or hash")
+	flag.BoolVar(&opts.NormalizeExtensions, "normalize-ext", opts.NormalizeExtensions, "name copies with the canonical lower case extension, e.g. .jpg for .JPG and .jpeg")
+	profile := flag.String("dest-profile", string(imagedup.ProfileNative), "destination file system profile: native, exfat or fat32")
+	decoder := flag.String("decoder", string(opts.Decoder), "image decoding backend for hashing: go or vips")
+	appleDouble := flag.String("appledouble", string(opts.AppleDouble), "AppleDouble ._ resource forks: drop, or merge to copy them alongside their file")
+	flag.BoolVar(&opts.HashXattr, "hash-xattr", opts.HashXattr, "cache each copy's SHA-256 in its user.pictureprocess.hash extended attribute, on Linux, for reindex and fsck -quick")
+	streams := flag.String("streams", string(opts.Streams), "NTFS alternate data streams such as Zone.Identifier, on Windows: strip, or keep to copy them with their file")
+	keeper := flag.String("keep", string(opts.Keeper), "keeper among duplicates differing by edits: largest, edited, original, or resolution for the most pixels")
+	flag.IntVar(&opts.LargeGroup, "large-group", opts.LargeGroup, "warn about keepers with more than this many duplicates, 0 to never warn")
+	flag.Float64Var(&opts.LowResolution, "low-res", opts.LowResolution, "list kept images below this many megapixels in the report, e.g. 2")
+	collisions := flag.String("collisions", string(opts.Collisions), "images sharing a hash that look different on a second check: keep both, drop as duplicates, or review each on the terminal")
+	window := flag.String("window", "", "only copy during this daily window, e.g. 01:00-06:00")
+	flag.IntVar(&opts.MaxFiles, "max-files", opts.MaxFiles, "copy at most this many files, leaving the rest for the next run")
+	maxBytes := flag.String("max-bytes", "", "copy at most this much data, e.g. 500G, leaving the rest for the next run")
+	maxMemory := flag.String("max-memory", "", "throttle decoding to keep memory under this size, e.g. 1536M or 2G")
+	maxLoad := flag.Float64("max-load", 0, "pause copying while the 1 minute load average exceeds this")
+	progress := flag.String("progress", "text", "progress output: text, or json for newline-delimited JSON events on stdout")
+	pprofAddr := flag.String("pprof", "", "serve net/http/pprof profiling endpoints on this address, e.g. localhost:6060")
+	logFile := flag.String("log-file", "", "append log and summary output to this file instead of the terminal, e.g. when run as a service")
+	statusAddr := flag.String("status", "", "serve the run's progress as JSON, with POST /pause and /resume, on this Unix socket path or host:port")
+	flag.IntVar(&opts.Shards, "shards", opts.Shards, "deduplicate in this many passes by hash prefix, up to 256, to bound memory on huge libraries")
+	flag.StringVar(&opts.SpillDir, "spill-dir", opts.SpillDir, "keep scan results in temporary files in this directory until each shard is deduplicated")
+	flag.BoolVar(&opts.Estimate, "estimate", opts.Estimate, "scan and deduplicate, then report how many files and bytes would be eliminated without copying")
+	flag.BoolVar(&opts.CheckIdempotent, "check-idempotent", opts.CheckIdempotent, "after copying, plan the source again and fail if a second run would copy anything")
+	printDuplicates := flag.Bool("print-duplicates", false, "print the source paths of duplicates not kept to stdout, moving other output to stderr")
+	nulSeparated := flag.Bool("0", false, "separate -print-duplicates paths with NUL bytes, for xargs -0")
+	preflight := flag.Bool("preflight", false, "only report file counts and sizes per extension and an estimated run time")
+	selfTest := flag.Bool("self-test", false, "run the configuration against a generated sample tree in a temporary directory and report whether it behaves as expected")
+	watch := flag.Duration("watch", 0, "keep running, rescanning the source at this interval")
+	flag.DurationVar(&opts.LockWait, "wait", 0, "wait up to this long for another run writing the destination to finish, instead of failing at once")
+	flag.String("config", "", "JSON configuration file; command line flags override its values")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <source_directory> <destination_directory>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s -self-test [flags]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s fsck [flags] <destination_directory>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s reindex [flags] <destination_directory>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s reorganize [flags] <destination_directory>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s merge <destination_directory> <other_archive>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s export [flags] <destination_directory> <export_directory>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s provenance <destination_directory> <archived_file>...\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s locate <destination_directory> <source_file|sha256>...\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s check [flags] <destination_directory> <file>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s tag [flags] <destination_directory> [archived_file...]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s chunks [flags] <directory>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s prune [flags] <destination_directory>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s reconcile [flags] <destination_directory>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s install-service [flags] [import flags] <source_directory> <destination_directory>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s bench [flags] <sample_directory>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s gen-testdata [flags] <directory>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	if path := configArg(os.Args[1:]); path != "" {
+		cfg, err := applyConfig(flag.CommandLine, path)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		opts.Derivatives = cfg.Derivatives
+		opts.Policies = cfg.Policies
+		opts.Events = cfg.Events
 	}
+	flag.Parse()
 
-	sourceDir := os.Args[1]
-	destDir := os.Args[2]
+	if flag.NArg() < 2 && !*selfTest {
+		flag.Usage()
+		os.Exit(1)
+	}
 
-	numWorkers := runtime.NumCPU()
-	err := imagedup.ProcessFiles(sourceDir, destDir, numWorkers)
-	if err != nil {
-		log.Fatalf("Failed to process files: %v", err)
+	var err error
+	if opts.Naming, err = imagedup.ParseNamingPolicy(*naming); err != nil {
+		log.Fatalf("Invalid -naming: %v", err)
 	}
 
-	fmt.Println("File processing complete")
-}
+	if opts.Layout, err = imagedup.ParseLayout(*layout); err != nil {
+		log.Fatalf("Invalid -layout: %v", err)
+	}
+
+	if opts.Profile, err = imagedup.ParseDestinationProfile(*profile); err != nil {
+		log.Fatalf("Invalid -dest-profile: %v", err)
+	}
+
+	if opts.DateOrder, err = dateutil.ParseDateOrder(*dateOrder); err != nil {
+		log.Fatalf("Invalid -date-order: %v", err)
+	}
+
+	if *dateOverrides != "" {
+		if opts.DateOverrides, err = imagedup.LoadDateOverrides(*dateOverrides); err != nil {
+			log.Fatalf("Invalid -date-overrides: %v", err)
+		}
+	}
+
+	if opts.Decoder, err = imagedup.ParseDecoderBackend(*decoder); err != nil {
+		log.Fatalf("Invalid -decoder: %v", err)
+	}
+
+	if opts.AppleDouble, err = imagedup.ParseAppleDoublePolicy(*appleDouble); err != nil {
+		log.Fatalf("Invalid -appledouble: %v", err)
+	}
+
+	if opts.Streams, err = imagedup.ParseStreamPolicy(*streams); err != nil {
+		log.Fatalf("Invalid -streams: %v", err)
+	}
+
+	if opts.Keeper, err = imagedup.ParseKeeperPolicy(*keeper); err != nil {
+		log.Fatalf("Invalid -keep: %v", err)
+	}
+
+	if *skipUnreadable && opts.FailOnUnreadable {
+		log.Fatalf("-skip-unreadable and -fail-on-unreadable can't be combined")
+	}
+
+	if opts.Collisions, err = imagedup.ParseCollisionPolicy(*collisions); err != nil {
+		log.Fatalf("Invalid -collisions: %v", err)
+	}
+	if opts.Collisions == imagedup.CollisionReview {
+		if *filesFrom == "-" {
+			log.Fatalf("-collisions review reads answers from stdin, which -files-from - already uses")
+		}
+		opts.Reviewer = newTerminalReviewer()
+	}
+
+	for _, target := range reportTo {
+		sink, err := imagedup.ParseReportSink(target)
+		if err != nil {
+			log.Fatalf("Invalid -report-to: %v", err)
+		}
+		opts.ReportSinks = append(opts.ReportSinks, sink)
+	}
+
+	if *faceDetector != "" {
+		if opts.FaceDetector, err = imagedup.NewCommandDetector(*faceDetector); err != nil {
+			log.Fatalf("Invalid -face-detector: %v", err)
+		}
+	}
+
+	switch {
+	case *embedCommand != "" && *embedURL != "":
+		log.Fatalf("-embed-command and -embed-url are mutually exclusive")
+	case *embedCommand != "":
+		if opts.Embedder, err = imagedup.NewCommandEmbedder(*embedCommand); err != nil {
+			log.Fatalf("Invalid -embed-command: %v", err)
+		}
+	case *embedURL != "":
+		opts.Embedder = imagedup.NewServiceEmbedder(*embedURL)
+	}
+	if opts.Similarity <= 0 || opts.Similarity > 1 {
+		log.Fatalf("Invalid -similarity: %v", opts.Similarity)
+	}
 
+	if *filesFrom != "" {
+		in := os.Stdin
+		if *filesFrom != "-" {
+			if in, err = os.Open(*filesFrom); err != nil {
+				log.Fatalf("Invalid -files-from: %v", err)
+			}
+		}
+		if opts.FileList, err = imagedup.ReadFileList(in); err != nil {
+			log.Fatalf("Failed to read -files-from: %v", err)
+		}
+		in.Close()
+		if opts.FileList == nil {
+			opts.FileList = []string{}
+		}
+	}
+
+	if *since != "" {
+		if opts.Since, err = imagedup.ParseDateBound(*since); err != nil {
+			log.Fatalf("Invalid -since: %v", err)
+		}
+	}
+	if *until != "" {
+		if opts.Until, err = imagedup.ParseDateBound(*until); err != nil {
+			log.Fatalf("Invalid -until: %v", err)
+		}
+	}
+
+	if *window != "" || *maxLoad > 0 {
+		opts.Schedule = &imagedup.Schedule{MaxLoad: *maxLoad}
+		if *window != "" {
+			if opts.Schedule.Start, opts.Schedule.End, err = imagedup.ParseWindow(*window); err != nil {
+				log.Fatalf("Invalid -window: %v", err)
+			}
+		}
+	}
+
+	if *maxBytes != "" {
+		if opts.MaxBytes, err = imagedup.ParseSize(*maxBytes); err != nil {
+			log.Fatalf("Invalid -max-bytes: %v", err)
+		}
+	}
+
+	if *maxMemory != "" {
+		if opts.MaxMemory, err = imagedup.ParseSize(*maxMemory); err != nil {
+			log.Fatalf("Invalid -max-memory: %v", err)
+		}
+	}
+
+	if len(encryptDests) > 0 {
+		if *encryptWith != "age" && *encryptWith != "gpg" {
+			log.Fatalf("Invalid -encrypt-with: %q", *encryptWith)
+		}
+		if len(recipients) == 0 {
+			log.Fatalf("-encrypt-dest requires at least one -recipient")
+		}
+		opts.Encryption = &imagedup.Encryption{Method: *encryptWith, Recipients: recipients, Destinations: encryptDests}
+	}
+
+	if opts.Parity != "" && opts.Parity != parity.XOR && opts.Parity != parity.PAR2 {
+		log.Fatalf("Invalid -parity: %q", opts.Parity)
+	}
+
+	// SOURCE_DATE_EPOCH fixes the clock and numbers run IDs from 1, so
+	// repeated runs write identical manifests
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid SOURCE_DATE_EPOCH: %v", err)
+		}
+		opts.Clock = dateutil.FixedClock(time.Unix(seconds, 0))
+		opts.IDs = &imagedup.Counter{}
+	}
+
+	if *selfTest {
+		runSelfTest(opts)
+		return
+	}
+
+	if *preflight {
+		runPreflight(flag.Arg(0), flag.Arg(1), opts)
+		return
+	}
+
+	opts.Pauser = imagedup.NewPauser()
+	handlePauseSignals(opts.Pauser)
+
+	if *progress != "text" && *progress != "json" {
+		log.Fatalf("Invalid -progress: %q", *progress)
+	}
+
+	// JSON progress takes over stdout, so human-readable output moves to stderr
+	opts.Output = os.Stdout
+	logOutput := io.Writer(os.Stderr)
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatalf("Failed to open -log-file: %v", err)
+		}
+		defer f.Close()
+		logOutput = f
+		opts.Output = f
+		log.SetOutput(f)
+	}
+	if *statusAddr != "" || *progress == "json" {
+		opts.Status = imagedup.NewStatus()
+		log.SetOutput(opts.Status.ErrorCounter(logOutput))
+	}
+	if *progress == "json" {
+		opts.Status.Stream(os.Stdout)
+		opts.Output = logOutput
+	}
+	if *printDuplicates {
+		if *progress == "json" {
+			log.Fatalf("-print-duplicates and -progress json both need stdout")
+		}
+		opts.DuplicateList = os.Stdout
+		opts.DuplicateListNUL = *nulSeparated
+		opts.Output = logOutput
+	}
+	if *statusAddr != "" {
+		if err := serveStatus(*statusAddr, opts.Status, opts.Pauser); err != nil {
+			log.Fatalf("Failed to listen on -status %s: %v", *statusAddr, err)
+		}
+	}
+
+	if *pprofAddr != "" {
+		servePprof(*pprofAddr)
+	}
+
+	sourceDir := flag.Arg(0)
+	destDir := flag.Arg(1)
+
+	// An interrupt or SIGTERM, as sent by service managers, stops copying
+	// and records what was copied; a second one exits at once
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	for {
+		err = imagedup.ProcessFilesContext(ctx, sourceDir, destDir, opts)
+		if ctx.Err() != nil {
+			log.Printf("Stopped: %v", context.Cause(ctx))
+			return
+		}
+		if err != nil {
+			if *watch == 0 {
+				log.Fatalf("Failed to process files: %v", err)
+			}
+			log.Printf("Failed to process files: %v", err)
+		}
+
+		fmt.Fprintln(opts.Output, "File processing complete")
+		if *watch == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopped: %v", context.Cause(ctx))
+			return
+		case <-time.After(*watch):
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runMerge merges one organized archive into another
+func runMerge(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s merge <destination_directory> <other_archive>\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	result, err := imagedup.Merge(args[0], args[1])
+	if err != nil {
+		log.Fatalf("Failed to merge: %v", err)
+	}
+	fmt.Printf("%d files copied, %d duplicates skipped, %d renamed to avoid collisions\n",
+		result.Copied, result.Duplicates, result.Renamed)
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	_ "net/http/pprof"
+)
+
+// servePprof serves the net/http/pprof endpoints under /debug/pprof/ on addr
+func servePprof(addr string) {
+	go func() {
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Printf("Failed to serve pprof on %s: %v", addr, err)
+		}
+	}()
+	log.Printf("Serving pprof on http://%s/debug/pprof/", addr)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runPreflight prints what an import would process and how long it might
+// take, without copying anything
+func runPreflight(sourceDir, destDir string, opts imagedup.Options) {
+	result, err := imagedup.Preflight(sourceDir, destDir, opts)
+	if err != nil {
+		log.Fatalf("Failed to scan source: %v", err)
+	}
+
+	classFiles := make(map[string]int)
+	classBytes := make(map[string]int64)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EXTENSION\tCLASS\tFILES\tSIZE")
+	for _, stat := range result.Extensions {
+		class := stat.Class
+		if class == "" {
+			class = "skipped"
+		}
+		ext := stat.Extension
+		if ext == "" {
+			ext = "(none)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", ext, class, stat.Files, imagedup.FormatSize(stat.Bytes))
+		classFiles[class] += stat.Files
+		classBytes[class] += stat.Bytes
+	}
+	w.Flush()
+
+	fmt.Println()
+	for _, class := range []string{"image", "raw", "video", "skipped"} {
+		if classFiles[class] > 0 {
+			fmt.Printf("%s: %d files, %s\n", class, classFiles[class], imagedup.FormatSize(classBytes[class]))
+		}
+	}
+	fmt.Printf("Estimated run time: %s\n", result.Estimate.Round(time.Second))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runProvenance prints where archived files came from
+func runProvenance(args []string) {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s provenance <destination_directory> <archived_file>...\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	failed := false
+	for i, file := range args[1:] {
+		e, err := imagedup.Provenance(args[0], file)
+		if err != nil {
+			log.Printf("Failed to look up %s: %v", file, err)
+			failed = true
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+
+		var transforms []string
+		if e.Transform != "" {
+			transforms = append(transforms, e.Transform)
+		}
+		if e.Encryption != "" {
+			transforms = append(transforms, "encrypted with "+e.Encryption)
+		}
+		if len(transforms) == 0 {
+			transforms = append(transforms, "none")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Archived as:\t%s\n", e.Path)
+		fmt.Fprintf(w, "Original path:\t%s\n", e.Source)
+		fmt.Fprintf(w, "Original filename:\t%s\n", filepath.Base(e.Source))
+		fmt.Fprintf(w, "Source root:\t%s\n", orUnknown(e.SourceRoot))
+		fmt.Fprintf(w, "Source modified:\t%s\n", formatTime(e.SourceModTime, "unknown"))
+		fmt.Fprintf(w, "SHA-256:\t%s\n", e.SHA256)
+		fmt.Fprintf(w, "Size:\t%d bytes\n", e.Size)
+		fmt.Fprintf(w, "Capture date:\t%s (from %s)\n", e.Date, orUnknown(e.DateSource))
+		if e.Camera != nil {
+			fmt.Fprintf(w, "Camera:\t%s\n", e.Camera)
+		}
+		switch {
+		case e.Rating < 0:
+			fmt.Fprintf(w, "Rating:\trejected\n")
+		case e.Rating > 0:
+			fmt.Fprintf(w, "Rating:\t%d stars\n", e.Rating)
+		}
+		if e.Label != "" {
+			fmt.Fprintf(w, "Label:\t%s\n", e.Label)
+		}
+		fmt.Fprintf(w, "Import run:\t%s\n", orUnknown(e.RunID))
+		fmt.Fprintf(w, "Imported:\t%s\n", formatTime(e.ImportedAt, "unknown"))
+		fmt.Fprintf(w, "Transforms:\t%s\n", strings.Join(transforms, ", "))
+		fmt.Fprintf(w, "Last verified:\t%s\n", formatTime(e.VerifiedAt, "never"))
+		w.Flush()
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// orUnknown returns s, or "unknown" if it is empty
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// formatTime formats an optional manifest timestamp, or returns missing
+func formatTime(t *time.Time, missing string) string {
+	if t == nil {
+		return missing
+	}
+	return t.Local().Format(time.RFC3339)
+}
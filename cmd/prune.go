@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runPrune proposes derived files that could be removed from an archive
+func runPrune(args []string) {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	threshold := fs.Int("threshold", imagedup.DefaultPruneThreshold, "largest perceptual hash distance at which a smaller image counts as a downscaled copy")
+	planPath := fs.String("plan", "", "also write the pruning plan to this file as JSON")
+	var tags, keep stringList
+	fs.Var(&tags, "tag", "only propose files carrying this tag (repeatable, all must match)")
+	fs.Var(&keep, "keep-tag", "never propose files carrying this tag, as with keep-forever (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s prune [flags] <destination_directory>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *threshold < 0 || *threshold > 64 {
+		log.Fatalf("Invalid -threshold: %d is outside 0-64", *threshold)
+	}
+
+	var filter imagedup.PruneFilter
+	for _, tag := range tags {
+		tag, err := imagedup.ParseTag(tag)
+		if err != nil {
+			log.Fatalf("Invalid -tag: %v", err)
+		}
+		filter.Tags = append(filter.Tags, tag)
+	}
+	for _, tag := range keep {
+		tag, err := imagedup.ParseTag(tag)
+		if err != nil {
+			log.Fatalf("Invalid -keep-tag: %v", err)
+		}
+		filter.Keep = append(filter.Keep, tag)
+	}
+
+	plan, err := imagedup.AnalyzePrune(fs.Arg(0), *threshold, filter)
+	if err != nil {
+		log.Fatalf("Failed to analyze the archive: %v", err)
+	}
+	for _, c := range plan.Candidates {
+		fmt.Printf("%s\t%s\t%s\n", c.Reason, c.Path, c.Master)
+	}
+	fmt.Printf("%d derived files could be pruned, reclaiming %s\n", len(plan.Candidates), imagedup.FormatSize(plan.Bytes))
+	if plan.Protected > 0 {
+		fmt.Printf("%d more are protected by a tag or a catalog and were left out\n", plan.Protected)
+	}
+	if *planPath != "" {
+		if err := plan.WriteJSON(*planPath); err != nil {
+			log.Fatalf("Failed to write -plan: %v", err)
+		}
+	}
+}
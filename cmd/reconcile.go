@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runReconcile reports archived files whose sources were deleted or changed
+func runReconcile(args []string) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	record := fs.Bool("record", false, "mark files with no surviving source in the manifest (source_gone_at), and unmark those whose source is back")
+	reportPath := fs.String("report", "", "also write the reconciliation to this file as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s reconcile [flags] <destination_directory>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	result, err := imagedup.Reconcile(fs.Arg(0), *record, time.Now())
+	if err != nil {
+		log.Fatalf("Failed to reconcile: %v", err)
+	}
+
+	for _, ref := range result.Missing {
+		fmt.Printf("MISSING   %s\t%s\n", ref.Path, ref.Source)
+	}
+	for _, ref := range result.Changed {
+		fmt.Printf("CHANGED   %s\t%s\n", ref.Path, ref.Source)
+	}
+	for _, path := range result.Orphaned {
+		fmt.Printf("ORPHANED  %s\n", path)
+	}
+	var roots []string
+	for root := range result.Offline {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	for _, root := range roots {
+		fmt.Printf("OFFLINE   %s (%d files not checked)\n", root, result.Offline[root])
+	}
+	fmt.Printf("%d files checked, %d sources missing, %d changed, %d files with no surviving source\n",
+		result.Checked, len(result.Missing), len(result.Changed), len(result.Orphaned))
+
+	if *reportPath != "" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to encode -report: %v", err)
+		}
+		if err := os.WriteFile(*reportPath, data, 0644); err != nil {
+			log.Fatalf("Failed to write -report: %v", err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runReindex rebuilds a destination's index.json files and manifest
+func runReindex(args []string) {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	source := fs.String("source", "", "source directory of the original import, to recover index paths from the manifest")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s reindex [flags] <destination_directory>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	result, err := imagedup.Reindex(fs.Arg(0), *source)
+	if err != nil {
+		log.Fatalf("Failed to reindex: %v", err)
+	}
+
+	for _, path := range result.Unknown {
+		fmt.Printf("UNKNOWN   %s\n", path)
+	}
+	for _, path := range result.Mismatched {
+		fmt.Printf("MISMATCH  %s\n", path)
+	}
+	for _, path := range result.Dropped {
+		fmt.Printf("DROPPED   %s\n", path)
+	}
+	fmt.Printf("%d files indexed, %d with recovered origins, %d of unknown origin, %d not matching the manifest, %d missing entries dropped\n",
+		result.Files, len(result.Recovered), len(result.Unknown), len(result.Mismatched), len(result.Dropped))
+	if len(result.Mismatched) > 0 {
+		fmt.Println("Run fsck to check and repair the mismatched files.")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/config"
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runReorganize moves an organized destination into a new folder layout
+func runReorganize(args []string) {
+	fs := flag.NewFlagSet("reorganize", flag.ExitOnError)
+	layoutFlag := fs.String("layout", string(imagedup.DefaultLayout), "new destination folder template, e.g. {year}/{month}")
+	dryRun := fs.Bool("dry-run", false, "only print the moves that would be made")
+	configPath := fs.String("config", "", "JSON configuration file whose events name folders, as for imports")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s reorganize [flags] <destination_directory>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	layout, err := imagedup.ParseLayout(*layoutFlag)
+	if err != nil {
+		log.Fatalf("Invalid -layout: %v", err)
+	}
+
+	var events []imagedup.Event
+	if *configPath != "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		events = cfg.Events
+	}
+	// Without events the token is empty for every file, stripping the
+	// names an import placed
+	if len(events) == 0 && strings.Contains(string(layout), "{event}") {
+		log.Fatalf("Invalid -layout: {event} needs the events of a -config file")
+	}
+
+	result, err := imagedup.Reorganize(fs.Arg(0), layout, events, *dryRun)
+	if err != nil {
+		log.Fatalf("Failed to reorganize: %v", err)
+	}
+
+	if *dryRun {
+		for _, mv := range result.Moves {
+			fmt.Printf("%s -> %s\n", mv.From, mv.To)
+		}
+	}
+	fmt.Printf("%d files moved, %d renamed to avoid collisions, %d already in place\n",
+		len(result.Moves), result.Renamed, result.Unchanged)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// terminalReviewer asks on the terminal whether files sharing a perceptual
+// hash but looking different are duplicates. Without an answer, as when
+// stdin is not a terminal, both are kept and the pair is asked again next
+// run.
+type terminalReviewer struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// newTerminalReviewer reads answers from stdin and asks on stderr, leaving
+// stdout to -print-duplicates and JSON progress
+func newTerminalReviewer() *terminalReviewer {
+	return &terminalReviewer{in: bufio.NewReader(os.Stdin), out: os.Stderr}
+}
+
+func (r *terminalReviewer) SameImage(keeper, file string, distance int) (bool, error) {
+	fmt.Fprintf(r.out, "\n%s\n%s\nThese share a perceptual hash but differ in %d of 64 bits of a second one. Treat them as duplicates? [y/N] ", keeper, file, distance)
+	answer, err := r.in.ReadString('\n')
+	if err != nil && answer == "" {
+		if err == io.EOF {
+			fmt.Fprintln(r.out)
+			return false, imagedup.ErrNoAnswer
+		}
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runSelfTest imports a generated sample tree with the configured options
+// into a temporary directory and reports whether it behaved as expected
+func runSelfTest(opts imagedup.Options) {
+	dir, err := os.MkdirTemp("", "pictureprocess-self-test-")
+	if err != nil {
+		log.Fatalf("Failed to create a temporary directory: %v", err)
+	}
+	result, err := imagedup.SelfTest(dir, opts)
+	if err != nil {
+		log.Fatalf("Self-test failed to run: %v (files left in %s)", err, dir)
+	}
+
+	for _, check := range result.Checks {
+		if check.Passed {
+			fmt.Printf("PASS  %s\n", check.Name)
+		} else {
+			fmt.Printf("FAIL  %s: %s\n", check.Name, check.Detail)
+		}
+	}
+	if !result.Passed() {
+		fmt.Printf("Self-test failed; the sample source and archive are in %s\n", dir)
+		os.Exit(1)
+	}
+	os.RemoveAll(dir)
+	fmt.Printf("All %d checks passed\n", len(result.Checks))
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// serviceManagers are the service managers install-service can write for
+var serviceManagers = []string{"systemd", "launchd", "windows"}
+
+// service describes an import run in watch mode as a boot-time service
+type service struct {
+	Name    string
+	Exe     string
+	Args    []string
+	LogFile string
+	User    bool
+}
+
+// systemdUnit runs the watch loop as a systemd service. SIGTERM makes the
+// run stop copying and record what it copied before exiting.
+var systemdUnit = template.Must(template.New("systemd").Parse(`[Unit]
+Description=Photo and video auto-import ({{.Name}})
+Wants=network-online.target
+After=network-online.target local-fs.target
+
+[Service]
+Type=simple
+ExecStart={{.Command}}
+Restart=on-failure
+RestartSec=30
+KillSignal=SIGTERM
+TimeoutStopSec=120
+{{- if .LogFile}}
+StandardOutput=append:{{.LogFile}}
+StandardError=append:{{.LogFile}}
+{{- end}}
+
+[Install]
+WantedBy={{if .User}}default.target{{else}}multi-user.target{{end}}
+`))
+
+// launchdPlist runs the watch loop as a launchd job started at load
+var launchdPlist = template.Must(template.New("launchd").Funcs(template.FuncMap{"xml": template.HTMLEscapeString}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Argv}}
+		<string>{{xml .}}</string>
+{{- end}}
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ExitTimeOut</key>
+	<integer>120</integer>
+	<key>StandardOutPath</key>
+	<string>{{xml .LogFile}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .LogFile}}</string>
+</dict>
+</plist>
+`))
+
+// defaultManager returns the service manager of the running platform
+func defaultManager() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "launchd"
+	case "windows":
+		return "windows"
+	}
+	return "systemd"
+}
+
+// quoteArg quotes an argument for a systemd ExecStart line when it needs it
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\%$;") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(arg) + `"`
+}
+
+// windowsArg quotes an argument for a Windows command line, where
+// backslashes are only special before a quote
+func windowsArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"") {
+		return arg
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for _, r := range arg {
+		switch r {
+		case '\\':
+			slashes++
+		case '"':
+			b.WriteString(strings.Repeat(`\`, slashes*2+1))
+			slashes = 0
+		default:
+			b.WriteString(strings.Repeat(`\`, slashes))
+			slashes = 0
+		}
+		if r != '\\' {
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString(strings.Repeat(`\`, slashes*2))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// command returns the service's command line, quoted by quote
+func (s service) command(quote func(string) string) string {
+	parts := []string{quote(s.Exe)}
+	for _, arg := range s.Args {
+		parts = append(parts, quote(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// unitPath returns where the service definition is installed
+func (s service) unitPath(manager string) (string, error) {
+	switch manager {
+	case "systemd":
+		if s.User {
+			dir, err := os.UserConfigDir()
+			if err != nil {
+				return "", err
+			}
+			return filepath.Join(dir, "systemd", "user", s.Name+".service"), nil
+		}
+		return filepath.Join("/etc/systemd/system", s.Name+".service"), nil
+	case "launchd":
+		if s.User {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			return filepath.Join(home, "Library", "LaunchAgents", s.label()+".plist"), nil
+		}
+		return filepath.Join("/Library/LaunchDaemons", s.label()+".plist"), nil
+	}
+	return "", nil
+}
+
+// label is the launchd job label
+func (s service) label() string {
+	return "com.github.gavinmcnair.pictureprocess." + s.Name
+}
+
+// render returns the service definition for a manager. On Windows it is a
+// boot-time scheduled task, registered by the schtasks command shown, not a
+// Windows service: schtasks /End kills it rather than asking it to stop.
+func (s service) render(manager string) (string, error) {
+	var buf bytes.Buffer
+	var err error
+	switch manager {
+	case "systemd":
+		err = systemdUnit.Execute(&buf, struct {
+			service
+			Command string
+		}{s, s.command(quoteArg)})
+	case "launchd":
+		err = launchdPlist.Execute(&buf, struct {
+			service
+			Label string
+			Argv  []string
+		}{s, s.label(), append([]string{s.Exe}, s.Args...)})
+	case "windows":
+		fmt.Fprintf(&buf, "schtasks /Create /F /TN %s /SC ONSTART /RU %s /TR %s\n", windowsArg(s.Name), windowsArg(s.windowsUser()), windowsArg(s.command(windowsArg)))
+	}
+	return buf.String(), err
+}
+
+// windowsUser is the account a Windows task runs as
+func (s service) windowsUser() string {
+	if s.User {
+		return os.Getenv("USERNAME")
+	}
+	return "SYSTEM"
+}
+
+// systemctl runs systemctl for the system or the user's service manager
+func (s service) systemctl(args ...string) error {
+	if s.User {
+		args = append([]string{"--user"}, args...)
+	}
+	return runTool("systemctl", args...)
+}
+
+// install writes the service definition and starts the service
+func (s service) install(manager string) error {
+	if manager == "windows" {
+		if err := runTool("schtasks", "/Create", "/F", "/TN", s.Name, "/SC", "ONSTART", "/RU", s.windowsUser(), "/TR", s.command(windowsArg)); err != nil {
+			return err
+		}
+		return runTool("schtasks", "/Run", "/TN", s.Name)
+	}
+
+	def, err := s.render(manager)
+	if err != nil {
+		return err
+	}
+	path, err := s.unitPath(manager)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(def), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", path)
+	if manager == "launchd" {
+		return runTool("launchctl", "load", "-w", path)
+	}
+	if err := s.systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return s.systemctl("enable", "--now", s.Name+".service")
+}
+
+// uninstall stops the service and removes its definition
+func (s service) uninstall(manager string) error {
+	if manager == "windows" {
+		// Ends the task's process at once; a copy in flight is redone by
+		// the next run, as after a crash
+		runTool("schtasks", "/End", "/TN", s.Name)
+		return runTool("schtasks", "/Delete", "/F", "/TN", s.Name)
+	}
+	path, err := s.unitPath(manager)
+	if err != nil {
+		return err
+	}
+	if manager == "launchd" {
+		runTool("launchctl", "unload", "-w", path)
+	} else {
+		s.systemctl("disable", "--now", s.Name+".service")
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fmt.Printf("Removed %s\n", path)
+	if manager == "systemd" {
+		return s.systemctl("daemon-reload")
+	}
+	return nil
+}
+
+// runTool runs a service manager command, passing its output through
+func runTool(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s failed: %w", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// runInstallService installs an import in watch mode as a service started at
+// boot, or removes it with -uninstall
+func runInstallService(args []string) {
+	fs := flag.NewFlagSet("install-service", flag.ExitOnError)
+	name := fs.String("name", "dedup", "service name")
+	manager := fs.String("manager", defaultManager(), "service manager: "+strings.Join(serviceManagers, ", "))
+	interval := fs.Duration("interval", 15*time.Minute, "rescan interval passed as -watch, unless the import flags set one")
+	user := fs.Bool("user", false, "install for the current user (systemd --user, a LaunchAgent, or a task run as the user) instead of system-wide")
+	logFile := fs.String("log-file", "", "file the service logs to (default: the journal for systemd, dedup.log in the system or user log folder otherwise)")
+	printOnly := fs.Bool("print", false, "print the service definition instead of installing it")
+	uninstall := fs.Bool("uninstall", false, "stop the service and remove it")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s install-service [flags] [-- import flags] <source_directory> <destination_directory>\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "       %s install-service -uninstall [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	valid := false
+	for _, m := range serviceManagers {
+		valid = valid || m == *manager
+	}
+	if !valid {
+		log.Fatalf("Invalid -manager: %q is not one of %s", *manager, strings.Join(serviceManagers, ", "))
+	}
+	if strings.ContainsAny(*name, `/\ `) || *name == "" {
+		log.Fatalf("Invalid -name: %q", *name)
+	}
+	s := service{Name: *name, User: *user}
+	if *uninstall {
+		if err := s.uninstall(*manager); err != nil {
+			log.Fatalf("Failed to uninstall %s: %v", *name, err)
+		}
+		return
+	}
+
+	importArgs := fs.Args()
+	if len(importArgs) < 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Failed to find this program: %v", err)
+	}
+	if s.Exe, err = filepath.Abs(exe); err != nil {
+		log.Fatalf("Failed to find this program: %v", err)
+	}
+
+	// Services start in another working directory, so the source and
+	// destination are made absolute
+	n := len(importArgs)
+	for i := n - 2; i < n; i++ {
+		if importArgs[i], err = filepath.Abs(importArgs[i]); err != nil {
+			log.Fatalf("Invalid path %s: %v", importArgs[i], err)
+		}
+	}
+	watching := false
+	for _, arg := range importArgs[:n-2] {
+		flagName, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		watching = watching || (strings.HasPrefix(arg, "-") && flagName == "watch")
+	}
+	if !watching {
+		if *interval <= 0 {
+			log.Fatalf("Invalid -interval: %v", *interval)
+		}
+		s.Args = append(s.Args, "-watch", interval.String())
+	}
+
+	s.LogFile = *logFile
+	if s.LogFile == "" && *manager != "systemd" {
+		s.LogFile = defaultServiceLog(*manager, *user)
+	}
+	if s.LogFile != "" {
+		if s.LogFile, err = filepath.Abs(s.LogFile); err != nil {
+			log.Fatalf("Invalid -log-file: %v", err)
+		}
+		// Without a console, Windows tasks log through the import itself
+		if *manager == "windows" {
+			s.Args = append(s.Args, "-log-file", s.LogFile)
+		}
+	}
+	s.Args = append(s.Args, importArgs...)
+
+	if *printOnly {
+		def, err := s.render(*manager)
+		if err != nil {
+			log.Fatalf("Failed to render service: %v", err)
+		}
+		fmt.Print(def)
+		return
+	}
+	if s.LogFile != "" {
+		if err := os.MkdirAll(filepath.Dir(s.LogFile), 0755); err != nil {
+			log.Fatalf("Failed to create log folder: %v", err)
+		}
+	}
+	if err := s.install(*manager); err != nil {
+		log.Fatalf("Failed to install %s: %v", *name, err)
+	}
+	fmt.Printf("Installed %s; it imports %s into %s from boot on\n", *name, importArgs[n-2], importArgs[n-1])
+}
+
+// defaultServiceLog returns the log file of a service without -log-file
+func defaultServiceLog(manager string, user bool) string {
+	switch {
+	case manager == "launchd" && user:
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, "Library", "Logs", "dedup.log")
+	case manager == "launchd":
+		return "/Library/Logs/dedup.log"
+	case user:
+		dir, _ := os.UserCacheDir()
+		return filepath.Join(dir, "dedup", "dedup.log")
+	}
+	return filepath.Join(os.Getenv("ProgramData"), "dedup", "dedup.log")
+}
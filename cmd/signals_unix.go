@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// handlePauseSignals pauses the run on SIGUSR1 and resumes it on SIGUSR2
+func handlePauseSignals(p *imagedup.Pauser) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGUSR1 {
+				p.Pause()
+			} else {
+				p.Resume()
+			}
+		}
+	}()
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+
+// handlePauseSignals is a no-op: Windows has no SIGUSR1/SIGUSR2
+func handlePauseSignals(p *imagedup.Pauser) {}
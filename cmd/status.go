@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// serveStatus answers HTTP requests on addr with the run's status as JSON,
+// and pauses and resumes the run on POST /pause and /resume, which also work
+// on Windows, where there are no pause signals. An addr containing a colon is
+// a TCP address such as localhost:7070; anything else is the path of a Unix
+// socket.
+func serveStatus(addr string, status *imagedup.Status, pauser *imagedup.Pauser) error {
+	network := "unix"
+	if strings.Contains(addr, ":") {
+		network = "tcp"
+	} else if err := removeStaleSocket(addr); err != nil {
+		return err
+	}
+
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+
+	writeStatus := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		snap := status.Snapshot()
+		snap.Paused = pauser.Paused()
+		enc.Encode(snap)
+	}
+	control := func(action func()) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, "use POST", http.StatusMethodNotAllowed)
+				return
+			}
+			action()
+			writeStatus(w)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { writeStatus(w) })
+	mux.Handle("/pause", control(pauser.Pause))
+	mux.Handle("/resume", control(pauser.Resume))
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Printf("Failed to serve status on %s: %v", addr, err)
+		}
+	}()
+	log.Printf("Serving run status on %s %s", network, addr)
+	return nil
+}
+
+// removeStaleSocket clears a socket left behind at path by an earlier run.
+// Anything else there, or a socket another run still answers on, is an
+// error rather than removed.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("another run is serving status on %s", path)
+	}
+	return os.Remove(path)
+}
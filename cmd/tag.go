@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// runTag attaches tags to archived files, or lists tags and tagged files
+func runTag(args []string) {
+	fs := flag.NewFlagSet("tag", flag.ExitOnError)
+	var add, remove, find stringList
+	fs.Var(&add, "add", "tag to attach to the files (repeatable)")
+	fs.Var(&remove, "remove", "tag to detach from the files (repeatable)")
+	fs.Var(&find, "find", "list the archived files carrying this tag (repeatable, all must match)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s tag [-add tag] [-remove tag] <destination_directory> <archived_file>...\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "       %s tag [-find tag] <destination_directory>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	destDir := fs.Arg(0)
+
+	for _, list := range []stringList{add, remove, find} {
+		for i, tag := range list {
+			var err error
+			if list[i], err = imagedup.ParseTag(tag); err != nil {
+				log.Fatalf("Invalid tag: %v", err)
+			}
+		}
+	}
+
+	switch {
+	case len(add) > 0 || len(remove) > 0:
+		if fs.NArg() < 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		changed, err := imagedup.Tag(destDir, fs.Args()[1:], add, remove)
+		if err != nil {
+			log.Fatalf("Failed to tag: %v", err)
+		}
+		fmt.Printf("%d files updated\n", changed)
+	case len(find) > 0:
+		files, err := imagedup.Tagged(destDir, find)
+		if err != nil {
+			log.Fatalf("Failed to read manifest: %v", err)
+		}
+		for _, file := range files {
+			fmt.Println(file)
+		}
+	default:
+		tags, err := imagedup.Tags(destDir)
+		if err != nil {
+			log.Fatalf("Failed to read manifest: %v", err)
+		}
+		for _, tag := range tags {
+			fmt.Printf("%s\t%d\n", tag.Tag, tag.Files)
+		}
+	}
+}
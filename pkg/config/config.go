@@ -0,0 +1,59 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/imagedup"
+)
+
+// Config is the JSON configuration file. Flags holds values for any command
+// line flag by name, so everything settable on the command line can live in
+// the file; structured settings get their own sections.
+type Config struct {
+	Flags       map[string]any               `json:"flags"`
+	Derivatives []imagedup.DerivativeProfile `json:"derivatives"`
+
+	// Policies sets the dedup strategy per media class
+	Policies map[string]imagedup.ClassPolicy `json:"policies"`
+
+	// Events name date folders, as in 2022-12-25_Christmas
+	Events []imagedup.Event `json:"events"`
+}
+
+// Load reads a configuration file
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := imagedup.ValidatePolicies(cfg.Policies); err != nil {
+		return nil, fmt.Errorf("invalid policies in %s: %w", path, err)
+	}
+	if err := imagedup.ValidateEvents(cfg.Events); err != nil {
+		return nil, fmt.Errorf("invalid events in %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// FlagValues flattens Flags into the string values flag.Set expects. Arrays
+// produce one value per element, for repeatable flags.
+func (c *Config) FlagValues() map[string][]string {
+	values := make(map[string][]string)
+	for name, value := range c.Flags {
+		switch v := value.(type) {
+		case []any:
+			for _, elem := range v {
+				values[name] = append(values[name], fmt.Sprint(elem))
+			}
+		default:
+			values[name] = []string{fmt.Sprint(v)}
+		}
+	}
+	return values
+}
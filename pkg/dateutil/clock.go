@@ -0,0 +1,27 @@
+package dateutil
+
+import "time"
+
+// Clock tells the time. Dates later than the end of next year by its time
+// are rejected as implausible, so fixing it makes date extraction
+// reproducible.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the real clock
+var SystemClock Clock = systemClock{}
+
+// FixedClock is a Clock stopped at the given time
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
@@ -1,80 +1,413 @@
 package dateutil
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 
+	"github.com/gavinmcnair/pictureprocess/pkg/xmp"
 	"github.com/rwcarlsen/goexif/exif"
 )
 
-// dateLayouts defines formats to try parsing filename dates
-var dateLayouts = []string{
-	"2006-01-02", "02-01-2006", "2006/01/02",
-	"02/01/2006", "20060102", "060102",
+// filenameDate matches the numeric dates recognized in file names
+var filenameDate = regexp.MustCompile(`\d{4}[-/]\d{2}[-/]\d{2}|\d{2}[-/]\d{2}[-/]\d{4}|\d{8}|\d{6}`)
+
+// ErrNoDate is returned when no date could be found for a file
+var ErrNoDate = errors.New("no date found")
+
+// earliestDate is the first capture date accepted as plausible; earlier dates
+// come from misread numbers or reset clocks
+var earliestDate = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Plausible reports whether t could be a real capture date: from 1970 up to
+// the end of next year
+func Plausible(t time.Time) bool {
+	return PlausibleAt(t, time.Now())
+}
+
+// PlausibleAt is Plausible with "next year" counted from now
+func PlausibleAt(t, now time.Time) bool {
+	latest := time.Date(now.Year()+2, 1, 1, 0, 0, 0, 0, time.UTC)
+	return !t.Before(earliestDate) && t.Before(latest)
+}
+
+// DateOrder is the order of year, month and day preferred when a numeric
+// filename date can be read more than one way, such as 02/03/2004
+type DateOrder string
+
+const (
+	// OrderYMD prefers year first, and day first for dates ending in the
+	// year (the default)
+	OrderYMD DateOrder = "ymd"
+	// OrderDMY prefers day first, as in most of Europe
+	OrderDMY DateOrder = "dmy"
+	// OrderMDY prefers month first, as in the US
+	OrderMDY DateOrder = "mdy"
+)
+
+// ParseDateOrder validates a date order name
+func ParseDateOrder(s string) (DateOrder, error) {
+	switch o := DateOrder(strings.ToLower(s)); o {
+	case OrderYMD, OrderDMY, OrderMDY:
+		return o, nil
+	}
+	return "", fmt.Errorf("unknown date order %q, use ymd, dmy or mdy", s)
+}
+
+// preference lists every order, most preferred first
+func (o DateOrder) preference() []DateOrder {
+	switch o {
+	case OrderDMY:
+		return []DateOrder{OrderDMY, OrderMDY, OrderYMD}
+	case OrderMDY:
+		return []DateOrder{OrderMDY, OrderDMY, OrderYMD}
+	}
+	return []DateOrder{OrderYMD, OrderDMY, OrderMDY}
+}
+
+// Source identifies where a date was extracted from. Higher values are more
+// trustworthy.
+type Source int
+
+const (
+	SourceUnknown Source = iota
+	SourceModTime
+	SourceDirectory
+	SourceFilename
+	SourceExif
+	// SourceManual is a date supplied by hand, which overrides the rest
+	SourceManual
+)
+
+// String returns the name of the date source
+func (s Source) String() string {
+	switch s {
+	case SourceModTime:
+		return "mtime"
+	case SourceDirectory:
+		return "directory"
+	case SourceFilename:
+		return "filename"
+	case SourceExif:
+		return "exif"
+	case SourceManual:
+		return "manual"
+	}
+	return "unknown"
+}
+
+// Confidence grades how far a date can be trusted to be when a file was
+// taken
+type Confidence int
+
+const (
+	ConfidenceNone Confidence = iota
+	// ConfidenceLow is a modification time, or a filename or folder date
+	// that reads more than one way
+	ConfidenceLow
+	// ConfidenceMedium is a date read unambiguously from a filename or
+	// folder name
+	ConfidenceMedium
+	// ConfidenceHigh is a date recorded by the camera, or supplied by hand
+	ConfidenceHigh
+)
+
+// String returns the name of the confidence level
+func (c Confidence) String() string {
+	switch c {
+	case ConfidenceLow:
+		return "low"
+	case ConfidenceMedium:
+		return "medium"
+	case ConfidenceHigh:
+		return "high"
+	}
+	return "none"
+}
+
+// Timestamp is when a file was taken, as far as its date source tells. Its
+// confidence is the Confidence method rather than a field, so it can't
+// disagree with Source and Alternatives.
+type Timestamp struct {
+	Time   time.Time
+	Source Source
+
+	// Clock reports whether Time includes a time of day. Dates without one,
+	// as in most filenames, are at midnight.
+	Clock bool
+
+	// Alternatives lists the other dates, in ISO form, an ambiguous
+	// filename date could be read as
+	Alternatives []string
+}
+
+// Confidence grades the date by its source, and lower when an ambiguous
+// filename date had other readings
+func (t Timestamp) Confidence() Confidence {
+	switch {
+	case t.Time.IsZero() || t.Source == SourceUnknown:
+		return ConfidenceNone
+	case t.Source >= SourceExif:
+		return ConfidenceHigh
+	case t.Source == SourceModTime || len(t.Alternatives) > 0:
+		return ConfidenceLow
+	}
+	return ConfidenceMedium
+}
+
+// Date returns the ISO date, or "" if nothing was found
+func (t Timestamp) Date() string {
+	if t.Time.IsZero() {
+		return ""
+	}
+	return t.Time.Format("2006-01-02")
 }
 
 // ExtractDate uses EXIF and filename parsing to get an ISO date
 func ExtractDate(filePath, filename string) (string, error) {
+	ts, err := Extract(filePath, filename, OrderYMD)
+	return ts.Date(), err
+}
+
+// ExtractFile finds when the file at path was taken with the built-in readers
+// alone, as Extract does with the file's own name and year-first dates. An
+// import also applies .ppdate sidecars and date overrides, asks exiftool
+// when these find no EXIF date, and may run on an injected clock;
+// imagedup.DateFile dates a file exactly as an import does.
+func ExtractFile(path string) (Timestamp, error) {
+	return Extract(path, filepath.Base(path), OrderYMD)
+}
+
+// directoryLevels is how many enclosing folders are searched for a date
+const directoryLevels = 3
+
+// Extract finds when a file was taken: from EXIF, else a date in filename
+// (read in the given order when ambiguous), else a date in the names of the
+// folders holding the file, such as "2019-07-04 Birthday" or 2019/07/04, else
+// the file's modification time
+func Extract(filePath, filename string, order DateOrder) (Timestamp, error) {
+	return ExtractAt(filePath, filename, order, SystemClock)
+}
+
+// ExtractAt is Extract with the plausibility of dates judged by clock's time
+// rather than the real time
+func ExtractAt(filePath, filename string, order DateOrder, clock Clock) (Timestamp, error) {
+	now := clock.Now()
+
 	// First, try to extract from EXIF data
-	if date, err := extractExifDate(filePath); err == nil {
-		return date, nil
+	if t, err := extractExifDate(filePath, now); err == nil {
+		return Timestamp{Time: t, Source: SourceExif, Clock: true}, nil
 	}
 
-	// Else, parse date from file name
-	if date, err := extractDateFromFilename(filename); err == nil {
-		return date, nil
+	// Else, parse date from file name. A file can't have been taken after it
+	// was last modified, so later readings are rejected, allowing a day for
+	// time zones.
+	var notAfter time.Time
+	if info, err := os.Stat(filePath); err == nil && PlausibleAt(info.ModTime(), now) {
+		notAfter = info.ModTime().Add(24 * time.Hour)
+	}
+	if ts, err := parseFilenameDate(filename, order, notAfter, now); err == nil {
+		return ts, nil
+	}
+	if ts, err := parseDirectoryDate(filepath.Dir(filePath), order, notAfter, now); err == nil {
+		return ts, nil
 	}
 
 	// Fallback: Use file's modification time
-	return extractFileModTime(filePath)
+	t, err := extractFileModTime(filePath)
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("%w: %w", ErrNoDate, err)
+	}
+	return Timestamp{Time: t, Source: SourceModTime, Clock: true}, nil
 }
 
-// extractExifDate gets the date from EXIF data
-func extractExifDate(filePath string) (string, error) {
+// CaptureTime reads the full capture timestamp from a file's EXIF data,
+// falling back to a minimal parser of the date tags alone when the EXIF
+// block can't be fully decoded, and then to its XMP metadata
+func CaptureTime(filePath string) (time.Time, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return "", err
+		return time.Time{}, err
 	}
 	defer file.Close()
 
 	x, err := exif.Decode(file)
-	if err != nil {
-		return "", err
+	if err == nil {
+		if t, err := x.DateTime(); err == nil {
+			return t, nil
+		}
+	}
+
+	// goexif gives up on some maker notes and truncated blocks, so read the
+	// date tags directly before giving up
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return time.Time{}, err
+	}
+	t, err := readExifDate(file)
+	if err == nil {
+		return t, nil
+	}
+
+	// Then an XMP sidecar or packet, as editors write for RAW files and
+	// images without EXIF
+	for _, packet := range xmp.Read(filePath) {
+		if t, err := xmpDate(packet); err == nil {
+			return t, nil
+		}
 	}
+	return time.Time{}, fmt.Errorf("%w: %v", ErrNoDate, err)
+}
 
-	date, err := x.DateTime()
+// extractExifDate gets the capture time from EXIF data
+func extractExifDate(filePath string, now time.Time) (time.Time, error) {
+	date, err := CaptureTime(filePath)
 	if err != nil {
-		return "", err
+		return time.Time{}, err
+	}
+	if !PlausibleAt(date, now) {
+		return time.Time{}, fmt.Errorf("implausible EXIF date %s", date.Format("2006-01-02"))
 	}
 
-	return date.Format("2006-01-02"), nil
+	return date, nil
 }
 
-// extractDateFromFilename parses possible date formats
-func extractDateFromFilename(filename string) (string, error) {
-	re := regexp.MustCompile(`(\d{4}-\d{2}-\d{2}|\d{2}/\d{2}/\d{4}|\d{8}|\d{6})`)
-	match := re.FindStringSubmatch(filename)
+// ParseFilenameDate finds a numeric date in a file name, such as 2004-03-02,
+// 02/03/2004, 20040302 or 040302, with the time of day when one follows it
+// as in IMG_20040302_153000. When the digits form a valid date in more than
+// one order, the date read in the most preferred order is returned and the
+// others are listed as alternatives, so the guess can be reviewed. Readings
+// that aren't Plausible are ignored.
+func ParseFilenameDate(filename string, order DateOrder) (Timestamp, error) {
+	return ParseFilenameDateAt(filename, order, SystemClock)
+}
 
-	if len(match) > 0 {
-		for _, layout := range dateLayouts {
-			if t, err := time.Parse(layout, match[0]); err == nil {
-				return t.Format("2006-01-02"), nil
+// ParseFilenameDateAt is ParseFilenameDate with the plausibility of dates
+// judged by clock's time rather than the real time
+func ParseFilenameDateAt(filename string, order DateOrder, clock Clock) (Timestamp, error) {
+	return parseFilenameDate(filename, order, time.Time{}, clock.Now())
+}
+
+// parseFilenameDate is ParseFilenameDate as of now, also ignoring readings
+// after notAfter unless it is zero
+func parseFilenameDate(filename string, order DateOrder, notAfter, now time.Time) (Timestamp, error) {
+	// Later numbers are tried when an earlier one, such as a long counter,
+	// isn't a plausible date
+	for _, loc := range filenameDate.FindAllStringIndex(filename, -1) {
+		match := filename[loc[0]:loc[1]]
+		layouts := matchLayouts(match)
+		var readings []time.Time
+		var dates []string
+		for _, o := range order.preference() {
+			layout, ok := layouts[o]
+			if !ok {
+				continue
+			}
+			t, err := time.ParseInLocation(layout, match, time.Local)
+			if err != nil || !PlausibleAt(t, now) || (!notAfter.IsZero() && t.After(notAfter)) {
+				continue
+			}
+			d := t.Format("2006-01-02")
+			if !contains(dates, d) {
+				readings = append(readings, t)
+				dates = append(dates, d)
 			}
 		}
+		if len(readings) == 0 {
+			continue
+		}
+
+		ts := Timestamp{Time: readings[0], Source: SourceFilename, Alternatives: dates[1:]}
+		if clock, ok := filenameClock(filename[loc[1]:]); ok {
+			ts.Time = ts.Time.Add(clock)
+			ts.Clock = true
+		}
+		return ts, nil
+	}
+	return Timestamp{}, fmt.Errorf("%w in filename", ErrNoDate)
+}
+
+// parseDirectoryDate looks for a date in the nearest enclosing folders of a
+// file, first in each folder's own name, nearest first, and then across
+// nested year, month and day folders
+func parseDirectoryDate(dir string, order DateOrder, notAfter, now time.Time) (Timestamp, error) {
+	var names, nested []string
+	for i := 0; i < directoryLevels; i++ {
+		name := filepath.Base(dir)
+		if name == dir || name == "." || name == string(filepath.Separator) {
+			break
+		}
+		names = append(names, name)
+		nested = append([]string{name}, nested...)
+		dir = filepath.Dir(dir)
 	}
 
-	return "", fmt.Errorf("no date found in filename")
+	for _, name := range append(names, strings.Join(nested, "/")) {
+		if ts, err := parseFilenameDate(name, order, notAfter, now); err == nil {
+			ts.Source = SourceDirectory
+			return ts, nil
+		}
+	}
+	return Timestamp{}, fmt.Errorf("%w in directory names", ErrNoDate)
+}
+
+// clockPattern matches a time of day right after a filename date
+var clockPattern = regexp.MustCompile(`^[_\-T ]?(\d{2})[.:\-]?(\d{2})[.:\-]?(\d{2})`)
+
+// filenameClock reads a time of day at the start of rest, such as the
+// 153000 of IMG_20040302_153000
+func filenameClock(rest string) (time.Duration, bool) {
+	m := clockPattern.FindStringSubmatch(rest)
+	if m == nil {
+		return 0, false
+	}
+	t, err := time.Parse("150405", m[1]+m[2]+m[3])
+	if err != nil {
+		return 0, false
+	}
+	return t.Sub(time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)), true
+}
+
+// matchLayouts returns the layout a matched date would have in each order it
+// can be read in
+func matchLayouts(match string) map[DateOrder]string {
+	switch {
+	case len(match) == 10 && (match[4] == '-' || match[4] == '/'):
+		sep := match[4:5]
+		return map[DateOrder]string{OrderYMD: "2006" + sep + "01" + sep + "02"}
+	case len(match) == 10:
+		sep := match[2:3]
+		return map[DateOrder]string{
+			OrderDMY: "02" + sep + "01" + sep + "2006",
+			OrderMDY: "01" + sep + "02" + sep + "2006",
+		}
+	case len(match) == 8:
+		return map[DateOrder]string{OrderYMD: "20060102", OrderDMY: "02012006", OrderMDY: "01022006"}
+	}
+	return map[DateOrder]string{OrderYMD: "060102", OrderDMY: "020106", OrderMDY: "010206"}
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
 }
 
 // extractFileModTime provides modification time
-func extractFileModTime(filePath string) (string, error) {
+func extractFileModTime(filePath string) (time.Time, error) {
 	info, err := os.Stat(filePath)
 	if err != nil {
-		return "", err
+		return time.Time{}, err
 	}
 
-	return info.ModTime().Format("2006-01-02"), nil
+	return info.ModTime(), nil
 }
 
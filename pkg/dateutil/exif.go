@@ -0,0 +1,185 @@
+package dateutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// exifScanLimit is how much of a file is searched for EXIF data. JPEG APP1
+// segments are capped at 64 KiB and TIFF-based RAW files keep their first
+// IFDs near the start.
+const exifScanLimit = 1 << 20
+
+// EXIF tags read by the fallback parser
+const (
+	tagDateTime          = 0x0132
+	tagExifIFD           = 0x8769
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+)
+
+// exifTimeLayouts are the forms EXIF dates are written in; some software
+// uses dashes instead of colons
+var exifTimeLayouts = []string{"2006:01:02 15:04:05", "2006-01-02 15:04:05", "2006:01:02"}
+
+// readExifDate reads the capture date straight from the EXIF date tags of a
+// JPEG or TIFF-based file. Unlike a full EXIF decode it ignores maker notes
+// and every other tag, and tolerates blocks truncated after the dates. PNG
+// and WebP files are searched for EXIF, XMP and text metadata instead.
+func readExifDate(r io.ReadSeeker) (time.Time, error) {
+	var magic [12]byte
+	n, _ := io.ReadFull(r, magic[:])
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return time.Time{}, err
+	}
+	switch {
+	case bytes.HasPrefix(magic[:n], pngSignature):
+		return pngDate(r)
+	case n == len(magic) && string(magic[:4]) == "RIFF" && string(magic[8:]) == "WEBP":
+		return webpDate(r)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, exifScanLimit))
+	if err != nil {
+		return time.Time{}, err
+	}
+	tiff := findTIFF(data)
+	if tiff == nil {
+		return time.Time{}, fmt.Errorf("no EXIF data found")
+	}
+	return tiffDate(tiff)
+}
+
+// tiffDate reads the date tags of an EXIF TIFF structure
+func tiffDate(tiff []byte) (time.Time, error) {
+	var order binary.ByteOrder
+	switch {
+	case len(tiff) < 8:
+		return time.Time{}, fmt.Errorf("truncated TIFF header")
+	case bytes.HasPrefix(tiff, []byte("II*\x00")):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(tiff, []byte("MM\x00*")):
+		order = binary.BigEndian
+	default:
+		return time.Time{}, fmt.Errorf("invalid TIFF header")
+	}
+
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:8]))
+	dates := map[uint16]string{tagDateTime: ifd0.ascii(tagDateTime)}
+	if offset, ok := ifd0.long(tagExifIFD); ok {
+		exifIFD := readIFD(tiff, order, offset)
+		dates[tagDateTimeOriginal] = exifIFD.ascii(tagDateTimeOriginal)
+		dates[tagDateTimeDigitized] = exifIFD.ascii(tagDateTimeDigitized)
+	}
+	for _, tag := range []uint16{tagDateTimeOriginal, tagDateTimeDigitized, tagDateTime} {
+		value := strings.TrimSpace(dates[tag])
+		for _, layout := range exifTimeLayouts {
+			if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("no EXIF date tags found")
+}
+
+// findTIFF returns the TIFF structure holding the EXIF data: the payload of
+// a JPEG's Exif APP1 segment, or the file itself for TIFF-based formats. A
+// truncated segment is returned as far as it goes.
+func findTIFF(data []byte) []byte {
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return data
+	}
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return nil
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return nil
+		}
+		marker := data[i+1]
+		if marker == 0xda || marker == 0xd9 {
+			return nil // image data starts
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		start, end := i+4, i+2+length
+		if end > len(data) {
+			end = len(data)
+		}
+		if marker == 0xe1 && start <= end && bytes.HasPrefix(data[start:end], []byte("Exif\x00\x00")) {
+			if tiff := data[start+6 : end]; len(tiff) >= 8 {
+				return tiff
+			}
+			return nil
+		}
+		i += 2 + length
+	}
+	return nil
+}
+
+// ifdEntry is one raw IFD entry
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte // the four value/offset bytes
+}
+
+// ifd is a parsed image file directory with access to its TIFF data
+type ifd struct {
+	tiff    []byte
+	order   binary.ByteOrder
+	entries map[uint16]ifdEntry
+}
+
+// readIFD parses the directory at offset, keeping the entries that fit
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) ifd {
+	d := ifd{tiff: tiff, order: order, entries: make(map[uint16]ifdEntry)}
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return d
+	}
+	n := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < n; i++ {
+		at := uint64(offset) + 2 + uint64(i)*12
+		if at+12 > uint64(len(tiff)) {
+			break
+		}
+		entry := tiff[at : at+12]
+		d.entries[order.Uint16(entry)] = ifdEntry{
+			typ:   order.Uint16(entry[2:]),
+			count: order.Uint32(entry[4:]),
+			value: entry[8:12],
+		}
+	}
+	return d
+}
+
+// ascii returns an ASCII tag's value, or "" if it is missing or truncated
+func (d ifd) ascii(tag uint16) string {
+	e, ok := d.entries[tag]
+	if !ok || e.typ != 2 {
+		return ""
+	}
+	var raw []byte
+	if e.count <= 4 {
+		raw = e.value[:e.count]
+	} else {
+		offset := uint64(d.order.Uint32(e.value))
+		if offset+uint64(e.count) > uint64(len(d.tiff)) {
+			return ""
+		}
+		raw = d.tiff[offset : offset+uint64(e.count)]
+	}
+	return strings.TrimRight(string(raw), "\x00")
+}
+
+// long returns a LONG (or IFD pointer) tag's value
+func (d ifd) long(tag uint16) (uint32, bool) {
+	e, ok := d.entries[tag]
+	if !ok || (e.typ != 4 && e.typ != 13) {
+		return 0, false
+	}
+	return d.order.Uint32(e.value), true
+}
@@ -0,0 +1,210 @@
+package dateutil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/xmp"
+)
+
+// metadataChunkLimit bounds the size of a metadata chunk that is read, so a
+// corrupt length can't exhaust memory
+const metadataChunkLimit = 4 << 20
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// xmpDateProperties are the XMP properties holding a capture date, most
+// relevant first
+var xmpDateProperties = []string{"exif:DateTimeOriginal", "photoshop:DateCreated", "xmp:CreateDate", "exif:DateTimeDigitized"}
+
+// pngDateKeywords are the PNG text keywords holding a creation date: the
+// one defined by the PNG specification and the one ImageMagick writes
+var pngDateKeywords = []string{"Creation Time", "date:create"}
+
+// metadataTimeLayouts are the date forms found in XMP and PNG text chunks
+var metadataTimeLayouts = []string{
+	time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04Z07:00", "2006-01-02T15:04",
+	"2006:01:02 15:04:05", "2006-01-02 15:04:05", time.RFC1123Z, time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2006-01-02",
+}
+
+// parseMetadataTime reads a date written by XMP or a PNG text chunk. Dates
+// without a zone are taken as local, like EXIF dates.
+func parseMetadataTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range metadataTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", s)
+}
+
+// xmpDate reads the capture date from an XMP packet
+func xmpDate(packet xmp.Packet) (time.Time, error) {
+	for _, property := range xmpDateProperties {
+		if value, ok := packet.Property(property); ok {
+			if t, err := parseMetadataTime(value); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("no XMP date found")
+}
+
+// pngDate reads a PNG's capture date from its eXIf chunk, then its XMP
+// packet, then a creation time text chunk. Image data is skipped over.
+func pngDate(r io.ReadSeeker) (time.Time, error) {
+	if _, err := r.Seek(int64(len(pngSignature)), io.SeekStart); err != nil {
+		return time.Time{}, err
+	}
+	var exifDate, xmpTime, text time.Time
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			break // a truncated file keeps what was found
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		kind := string(header[4:])
+		if kind == "IEND" {
+			break
+		}
+
+		wanted := kind == "eXIf" || kind == "tEXt" || kind == "zTXt" || kind == "iTXt"
+		if !wanted || length > metadataChunkLimit {
+			if _, err := r.Seek(length+4, io.SeekCurrent); err != nil {
+				break
+			}
+			continue
+		}
+		data := make([]byte, length+4) // with the CRC
+		if _, err := io.ReadFull(r, data); err != nil {
+			break
+		}
+		data = data[:length]
+
+		if kind == "eXIf" {
+			if t, err := tiffDate(bytes.TrimPrefix(data, []byte("Exif\x00\x00"))); err == nil && exifDate.IsZero() {
+				exifDate = t
+			}
+			continue
+		}
+		keyword, value, ok := pngText(kind, data)
+		if !ok {
+			continue
+		}
+		if keyword == "XML:com.adobe.xmp" {
+			if t, err := xmpDate(value); err == nil && xmpTime.IsZero() {
+				xmpTime = t
+			}
+			continue
+		}
+		for _, dateKeyword := range pngDateKeywords {
+			if keyword == dateKeyword && text.IsZero() {
+				if t, err := parseMetadataTime(string(value)); err == nil {
+					text = t
+				}
+			}
+		}
+	}
+
+	for _, t := range []time.Time{exifDate, xmpTime, text} {
+		if !t.IsZero() {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no PNG metadata date found")
+}
+
+// pngText splits a tEXt, zTXt or iTXt chunk into its keyword and text,
+// decompressing it if needed
+func pngText(kind string, data []byte) (string, []byte, bool) {
+	i := bytes.IndexByte(data, 0)
+	if i < 0 {
+		return "", nil, false
+	}
+	keyword, rest := string(data[:i]), data[i+1:]
+	compressed := false
+	switch kind {
+	case "zTXt":
+		if len(rest) < 1 {
+			return "", nil, false
+		}
+		rest, compressed = rest[1:], true
+	case "iTXt":
+		// A compression flag and method, then language and translated
+		// keyword, each ending in a NUL
+		if len(rest) < 2 {
+			return "", nil, false
+		}
+		compressed = rest[0] == 1
+		rest = rest[2:]
+		for n := 0; n < 2; n++ {
+			j := bytes.IndexByte(rest, 0)
+			if j < 0 {
+				return "", nil, false
+			}
+			rest = rest[j+1:]
+		}
+	}
+	if compressed {
+		zr, err := zlib.NewReader(bytes.NewReader(rest))
+		if err != nil {
+			return "", nil, false
+		}
+		defer zr.Close()
+		if rest, err = io.ReadAll(io.LimitReader(zr, metadataChunkLimit)); err != nil {
+			return "", nil, false
+		}
+	}
+	return keyword, rest, true
+}
+
+// webpDate reads a WebP's capture date from its EXIF chunk, then its XMP
+// chunk
+func webpDate(r io.ReadSeeker) (time.Time, error) {
+	if _, err := r.Seek(12, io.SeekStart); err != nil {
+		return time.Time{}, err
+	}
+	var xmpTime time.Time
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			break
+		}
+		kind := string(header[:4])
+		size := int64(binary.LittleEndian.Uint32(header[4:]))
+		padded := size + size%2
+
+		if (kind != "EXIF" && kind != "XMP ") || size > metadataChunkLimit {
+			if _, err := r.Seek(padded, io.SeekCurrent); err != nil {
+				break
+			}
+			continue
+		}
+		data := make([]byte, padded)
+		if _, err := io.ReadFull(r, data); err != nil {
+			break
+		}
+		data = data[:size]
+
+		if kind == "EXIF" {
+			// Some writers keep the JPEG APP1 prefix
+			if t, err := tiffDate(bytes.TrimPrefix(data, []byte("Exif\x00\x00"))); err == nil {
+				return t, nil
+			}
+		} else if t, err := xmpDate(data); err == nil && xmpTime.IsZero() {
+			xmpTime = t
+		}
+	}
+	if !xmpTime.IsZero() {
+		return xmpTime, nil
+	}
+	return time.Time{}, fmt.Errorf("no WebP metadata date found")
+}
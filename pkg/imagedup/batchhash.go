@@ -0,0 +1,157 @@
+package imagedup
+
+import (
+	"encoding/binary"
+	"image"
+	"os"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// hashBatchSize is the number of tiles a worker collects before hashing them
+const hashBatchSize = 64
+
+// Byte lane masks used by the SWAR (SIMD within a register) hash kernel
+const (
+	evenBytes = 0x00FF00FF00FF00FF
+	lanes16   = 0x0001000100010001
+)
+
+// grayTile reduces an image to 8x8 grayscale by area averaging. JPEGs are
+// averaged straight from their luma plane, skipping color conversion.
+func grayTile(img image.Image) []byte {
+	tile := make([]byte, 64)
+	b := img.Bounds()
+	if b.Dx() < 8 || b.Dy() < 8 {
+		img = imaging.Resize(img, 8, 8, imaging.Box)
+		b = img.Bounds()
+	}
+
+	var sums [64]uint64
+	var counts [64]uint64
+	if ycc, ok := img.(*image.YCbCr); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			ty := (y - b.Min.Y) * 8 / b.Dy()
+			row := ycc.Y[(y-ycc.Rect.Min.Y)*ycc.YStride:]
+			for x := b.Min.X; x < b.Max.X; x++ {
+				i := ty*8 + (x-b.Min.X)*8/b.Dx()
+				sums[i] += uint64(row[x-ycc.Rect.Min.X])
+				counts[i]++
+			}
+		}
+	} else {
+		gray := imaging.Grayscale(img)
+		for y := 0; y < gray.Rect.Dy(); y++ {
+			ty := y * 8 / gray.Rect.Dy()
+			row := gray.Pix[y*gray.Stride:]
+			for x := 0; x < gray.Rect.Dx(); x++ {
+				i := ty*8 + x*8/gray.Rect.Dx()
+				sums[i] += uint64(row[x*4])
+				counts[i]++
+			}
+		}
+	}
+
+	for i := range tile {
+		if counts[i] > 0 {
+			tile[i] = byte(sums[i] / counts[i])
+		}
+	}
+	return tile
+}
+
+// hashTiles average-hashes a batch of 8x8 grayscale tiles
+func hashTiles(tiles [][]byte) []uint64 {
+	hashes := make([]uint64, len(tiles))
+	for i, tile := range tiles {
+		hashes[i] = swarAverageHash(tile)
+	}
+	return hashes
+}
+
+// swarAverageHash computes the same hash as grayAverageHash for a 64 byte tile,
+// processing eight pixels per 64-bit word instead of one at a time
+func swarAverageHash(tile []byte) uint64 {
+	var words [8]uint64
+	for i := range words {
+		words[i] = binary.BigEndian.Uint64(tile[i*8:])
+	}
+
+	// Sum all bytes in 16-bit lanes; 16 bytes per lane can't overflow
+	var lanes uint64
+	for _, w := range words {
+		lanes += w & evenBytes
+		lanes += (w >> 8) & evenBytes
+	}
+	sum := (lanes & 0xFFFF) + (lanes >> 16 & 0xFFFF) + (lanes >> 32 & 0xFFFF) + (lanes >> 48)
+	mean := sum / 64
+
+	// Adding 255-mean to each pixel in a 16-bit lane carries into bit 8
+	// exactly when the pixel is greater than the mean
+	bias := (255 - mean) * lanes16
+	var hash uint64
+	for i, w := range words {
+		even := ((w & evenBytes) + bias) >> 8 & lanes16       // pixels 1, 3, 5, 7 of the word
+		odd := (((w >> 8) & evenBytes) + bias) >> 8 & lanes16 // pixels 0, 2, 4, 6
+		// Fold lane n's two bits down to bits 2n and 2n+1
+		bits := odd<<1 | even
+		bits |= bits >> 14
+		bits |= bits >> 28
+		hash |= (bits & 0xFF) << (56 - 8*uint(i))
+	}
+	return hash
+}
+
+// tileBatcher collects one worker's tiles and hashes them a batch at a time
+type tileBatcher struct {
+	pending    []imageInfo
+	tiles      [][]byte
+	resultChan chan<- imageInfo
+	timings    *stageTimings
+}
+
+// add decodes an image into a tile, hashing the batch once it is full
+func (b *tileBatcher) add(filePath string, decode decodeFunc) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fileError(filePath, ErrDecode, err)
+	}
+	start := time.Now()
+	img, err := decode(filePath)
+	if err != nil {
+		b.timings.track("decode", start)
+		return fileError(filePath, ErrDecode, err)
+	}
+	width, height := decodedDimensions(filePath, img)
+	b.pending = append(b.pending, imageInfo{
+		filename: filePath,
+		size:     info.Size(),
+		modTime:  info.ModTime(),
+		width:    width,
+		height:   height,
+		damaged:  isDamaged(img),
+	})
+	b.tiles = append(b.tiles, grayTile(img))
+	b.timings.track("decode", start)
+	if len(b.tiles) >= hashBatchSize {
+		b.flush()
+	}
+	return nil
+}
+
+// flush hashes and emits any pending tiles
+func (b *tileBatcher) flush() {
+	if len(b.tiles) == 0 {
+		return
+	}
+	start := time.Now()
+	hashes := hashTiles(b.tiles)
+	b.timings.track("hash", start)
+	for i, hash := range hashes {
+		fileInfo := b.pending[i]
+		fileInfo.hash = hash
+		b.resultChan <- fileInfo
+	}
+	b.pending, b.tiles = b.pending[:0], b.tiles[:0]
+}
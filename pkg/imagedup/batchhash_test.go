@@ -0,0 +1,50 @@
+package imagedup
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+
+	"github.com/corona10/goimagehash"
+)
+
+// benchTile returns a random 8x8 grayscale tile, as grayTile produces
+func benchTile(seed int64) []byte {
+	tile := make([]byte, 64)
+	rand.New(rand.NewSource(seed)).Read(tile)
+	return tile
+}
+
+func TestSwarAverageHash(t *testing.T) {
+	for seed := int64(0); seed < 1000; seed++ {
+		tile := benchTile(seed)
+		if got, want := swarAverageHash(tile), grayAverageHash(tile); got != want {
+			t.Fatalf("swarAverageHash(tile %d) = %016x, grayAverageHash = %016x", seed, got, want)
+		}
+	}
+}
+
+// The three benchmarks hash the same tile: goimagehash as an 8x8 image, the
+// way HashFile does, and the batched kernels as its grayscale bytes
+func BenchmarkSwarAverageHash(b *testing.B) {
+	tile := benchTile(1)
+	for i := 0; i < b.N; i++ {
+		swarAverageHash(tile)
+	}
+}
+
+func BenchmarkGrayAverageHash(b *testing.B) {
+	tile := benchTile(1)
+	for i := 0; i < b.N; i++ {
+		grayAverageHash(tile)
+	}
+}
+
+func BenchmarkGoimagehashAverageHash(b *testing.B) {
+	img := &image.Gray{Pix: benchTile(1), Stride: 8, Rect: image.Rect(0, 0, 8, 8)}
+	for i := 0; i < b.N; i++ {
+		if _, err := goimagehash.AverageHash(img); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
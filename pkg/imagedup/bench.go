@@ -0,0 +1,136 @@
+package imagedup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// BenchResult is the hashing throughput of one algorithm and worker count
+type BenchResult struct {
+	Algorithm string
+	Workers   int
+	Files     int
+	Bytes     int64
+	Elapsed   time.Duration
+}
+
+// FilesPerSecond returns the result's throughput in files per second
+func (r BenchResult) FilesPerSecond() float64 {
+	return float64(r.Files) / r.Elapsed.Seconds()
+}
+
+// MBPerSecond returns the result's throughput in megabytes per second
+func (r BenchResult) MBPerSecond() float64 {
+	return float64(r.Bytes) / 1e6 / r.Elapsed.Seconds()
+}
+
+// benchAlgorithm is a hashing configuration Bench can time
+type benchAlgorithm struct {
+	name    string
+	decoder DecoderBackend
+	batch   bool
+}
+
+// Bench hashes up to limit sample images from dir with every available hash
+// algorithm and each worker count, so settings can be tuned for the hardware
+// before a long run. Files are read once beforehand to warm the page cache.
+func Bench(dir string, workers []int, limit int, paths tools.Paths) ([]BenchResult, error) {
+	scan, err := collectFiles(dir, DefaultOptions(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	sizes := make(map[string]int64)
+	for _, file := range scan.files {
+		if !SupportedImageFormats[strings.ToLower(filepath.Ext(file))] {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		files = append(files, file)
+		sizes[file] = int64(len(data))
+		if limit > 0 && len(files) >= limit {
+			break
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no sample images found in %s", dir)
+	}
+
+	tl := tools.Detect(paths)
+	algorithms := []benchAlgorithm{
+		{name: "average (go)", decoder: DecoderGo},
+		{name: "batch tiles (go)", decoder: DecoderGo, batch: true},
+	}
+	if tl.Vips != "" {
+		algorithms = append(algorithms,
+			benchAlgorithm{name: "average (vips)", decoder: DecoderVips},
+			benchAlgorithm{name: "batch tiles (vips)", decoder: DecoderVips, batch: true})
+	}
+
+	var results []BenchResult
+	for _, alg := range algorithms {
+		decode := newDecoder(alg.decoder, tl)
+		for _, n := range workers {
+			start := time.Now()
+			hashed, bytes := benchHash(files, sizes, n, alg.batch, decode)
+			results = append(results, BenchResult{
+				Algorithm: alg.name,
+				Workers:   n,
+				Files:     hashed,
+				Bytes:     bytes,
+				Elapsed:   time.Since(start),
+			})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].FilesPerSecond() > results[j].FilesPerSecond()
+	})
+	return results, nil
+}
+
+// benchHash hashes files with n workers the way ProcessFiles does, returning
+// how many were hashed and their total size
+func benchHash(files []string, sizes map[string]int64, n int, batch bool, decode decodeFunc) (int, int64) {
+	fileChan := make(chan string, n)
+	resultChan := make(chan imageInfo, len(files))
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			batcher := &tileBatcher{resultChan: resultChan}
+			defer batcher.flush()
+			for file := range fileChan {
+				if batch {
+					batcher.add(file, decode)
+				} else {
+					processImageFile(file, decode, nil, resultChan)
+				}
+			}
+		}()
+	}
+	for _, file := range files {
+		fileChan <- file
+	}
+	close(fileChan)
+	wg.Wait()
+	close(resultChan)
+	hashed, bytes := 0, int64(0)
+	for fileInfo := range resultChan {
+		hashed++
+		bytes += sizes[fileInfo.filename]
+	}
+	return hashed, bytes
+}
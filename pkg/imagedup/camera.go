@@ -0,0 +1,72 @@
+package imagedup
+
+import (
+	"os"
+	"strings"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// readCamera reads the camera, lens and exposure settings from a file's EXIF
+// data, or returns nil if it has none
+func readCamera(filePath string) *manifest.Camera {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	// A failure in a sub-IFD still returns what was decoded before it
+	x, _ := exif.Decode(file)
+	if x == nil {
+		return nil
+	}
+	// Tag accessors panic on empty values, so those count as missing
+	get := func(field exif.FieldName) *tiff.Tag {
+		if tag, err := x.Get(field); err == nil && tag.Count > 0 {
+			return tag
+		}
+		return nil
+	}
+	str := func(field exif.FieldName) string {
+		if tag := get(field); tag != nil {
+			if s, err := tag.StringVal(); err == nil {
+				return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+			}
+		}
+		return ""
+	}
+	num := func(field exif.FieldName) int {
+		if tag := get(field); tag != nil {
+			if n, err := tag.Int(0); err == nil {
+				return n
+			}
+		}
+		return 0
+	}
+	rat := func(field exif.FieldName) float64 {
+		if tag := get(field); tag != nil {
+			if n, d, err := tag.Rat2(0); err == nil && d != 0 {
+				return float64(n) / float64(d)
+			}
+		}
+		return 0
+	}
+
+	c := &manifest.Camera{
+		Make:        str(exif.Make),
+		Model:       str(exif.Model),
+		Lens:        str(exif.LensModel),
+		ISO:         num(exif.ISOSpeedRatings),
+		Aperture:    rat(exif.FNumber),
+		Exposure:    rat(exif.ExposureTime),
+		FocalLength: rat(exif.FocalLength),
+		Orientation: num(exif.Orientation),
+	}
+	if *c == (manifest.Camera{}) {
+		return nil
+	}
+	return c
+}
@@ -0,0 +1,63 @@
+package imagedup
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/dateutil"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// captureTime reads a file's capture timestamp from EXIF, asking exiftool
+// when the built-in parser finds none
+func captureTime(filePath string, tl tools.Tools) (time.Time, bool) {
+	if t, err := dateutil.CaptureTime(filePath); err == nil {
+		return t, true
+	}
+	if t, err := tl.CreateDate(filePath); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// clusterByTime splits a hash group into clusters captured within tolerance
+// of each other, so timelapse frames that share a perceptual hash survive.
+// Clusters are anchored on their earliest member rather than chained, and
+// files without a capture timestamp only match each other.
+func clusterByTime(members []imageInfo, tolerance time.Duration, tl tools.Tools) [][]imageInfo {
+	type timed struct {
+		info imageInfo
+		at   time.Time
+	}
+
+	var stamped []timed
+	var untimed []imageInfo
+	for _, member := range members {
+		if at, ok := captureTime(member.filename, tl); ok {
+			stamped = append(stamped, timed{member, at})
+		} else {
+			untimed = append(untimed, member)
+		}
+	}
+	sort.SliceStable(stamped, func(i, j int) bool { return stamped[i].at.Before(stamped[j].at) })
+
+	var clusters [][]imageInfo
+	var anchor time.Time
+	for _, s := range stamped {
+		if len(clusters) == 0 || s.at.Sub(anchor) > tolerance {
+			clusters = append(clusters, nil)
+			anchor = s.at
+		}
+		clusters[len(clusters)-1] = append(clusters[len(clusters)-1], s.info)
+	}
+	if len(untimed) > 0 {
+		clusters = append(clusters, untimed)
+	}
+
+	if len(clusters) > 1 {
+		log.Printf("Splitting %d files sharing a hash with %s into %d groups by capture time",
+			len(members), members[0].filename, len(clusters))
+	}
+	return clusters
+}
@@ -0,0 +1,96 @@
+package imagedup
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// caseInsensitiveFS reports whether a directory is on a file system that
+// ignores case in names, as macOS and Windows do by default, by creating a
+// probe file and looking it up with its name's case swapped
+func caseInsensitiveFS(dir string) bool {
+	f, err := os.CreateTemp(dir, ".dedup-case-probe-")
+	if err != nil {
+		return false
+	}
+	name := f.Name()
+	f.Close()
+	defer os.Remove(name)
+	base := filepath.Base(name)
+	swapped := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return r
+	}, base)
+	_, err = os.Stat(filepath.Join(filepath.Dir(name), swapped))
+	return err == nil
+}
+
+// caseConflicts groups source files whose paths differ only by case, which
+// can't sit side by side on a case-insensitive file system
+func caseConflicts(files []string) [][]string {
+	byFold := make(map[string][]string)
+	for _, file := range files {
+		key := strings.ToLower(file)
+		byFold[key] = append(byFold[key], file)
+	}
+	var groups [][]string
+	for _, group := range byFold {
+		if len(group) > 1 {
+			sort.Strings(group)
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+// folderCase gives the destination folders of a run one spelling each on a
+// case-insensitive destination, so "1994-Summer" and "1994-summer" share one
+// counter sequence and index.json instead of overwriting each other's files
+type folderCase struct {
+	root     string
+	enabled  bool
+	spelling map[string]string
+}
+
+// newFolderCase probes the case sensitivity of a destination
+func newFolderCase(root string) *folderCase {
+	return &folderCase{root: root, enabled: caseInsensitiveFS(root), spelling: make(map[string]string)}
+}
+
+// canonical returns the spelling a folder is written under: the one already
+// on disk, or else the first one used in this run
+func (c *folderCase) canonical(folder string) string {
+	if !c.enabled {
+		return folder
+	}
+	key := strings.ToLower(folder)
+	if spelled, ok := c.spelling[key]; ok {
+		return spelled
+	}
+	parts := strings.Split(folder, string(filepath.Separator))
+	dir := c.root
+	for i, part := range parts {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			break
+		}
+		for _, e := range entries {
+			if e.IsDir() && e.Name() != part && strings.EqualFold(e.Name(), part) {
+				parts[i] = e.Name()
+				break
+			}
+		}
+		dir = filepath.Join(dir, parts[i])
+	}
+	spelled := filepath.Join(parts...)
+	c.spelling[key] = spelled
+	return spelled
+}
@@ -0,0 +1,161 @@
+package imagedup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// Lightroom catalog queries: every file with its location on disk, and the
+// collections its image belongs to
+const (
+	lightroomFilesQuery = `SELECT fi.id_local, rf.absolutePath || fo.pathFromRoot || fi.baseName || '.' || fi.extension
+		FROM AgLibraryFile fi
+		JOIN AgLibraryFolder fo ON fo.id_local = fi.folder
+		JOIN AgLibraryRootFolder rf ON rf.id_local = fo.rootFolder`
+	lightroomCollectionsQuery = `SELECT i.rootFile, c.name
+		FROM AgLibraryCollectionImage ci
+		JOIN Adobe_images i ON i.id_local = ci.image
+		JOIN AgLibraryCollection c ON c.id_local = ci.collection`
+)
+
+// Catalog cross-references the files a Lightroom catalog or Capture One
+// session manages, so imports can record their collections and never
+// propose them for deletion
+type Catalog struct {
+	// Name is the catalog's file or session folder name
+	Name string
+
+	// files maps the absolute path of each managed file to its
+	// collections, which may be none
+	files map[string][]string
+}
+
+// LoadCatalog reads a Lightroom catalog (.lrcat, with sqlite3) or a Capture
+// One session (its folder or .cosessiondb file)
+func LoadCatalog(path string, tl tools.Tools) (*Catalog, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case info.IsDir():
+		return captureOneSession(path)
+	case strings.EqualFold(filepath.Ext(path), ".cosessiondb"):
+		return captureOneSession(filepath.Dir(path))
+	case strings.EqualFold(filepath.Ext(path), ".lrcat"):
+		return lightroomCatalog(path, tl)
+	}
+	return nil, fmt.Errorf("%s is not a Lightroom catalog or Capture One session", path)
+}
+
+// Managed returns the collections of a file the catalog manages
+func (c *Catalog) Managed(filePath string) ([]string, bool) {
+	collections, ok := c.files[catalogKey(filePath)]
+	return collections, ok
+}
+
+// Len returns how many files the catalog manages
+func (c *Catalog) Len() int {
+	return len(c.files)
+}
+
+// catalogKey is the form paths are compared in: absolute and cleaned, with
+// forward slashes as Lightroom writes them on every platform
+func catalogKey(p string) string {
+	return filepath.ToSlash(filepath.Clean(absPath(p)))
+}
+
+// lightroomCatalog reads a Lightroom Classic catalog, an SQLite database
+func lightroomCatalog(path string, tl tools.Tools) (*Catalog, error) {
+	if tl.SQLite3 == "" {
+		return nil, fmt.Errorf("reading %s needs sqlite3: %w", path, tools.ErrUnavailable)
+	}
+	rows, err := tl.Query(path, lightroomFilesQuery)
+	if err != nil {
+		return nil, err
+	}
+	c := &Catalog{Name: filepath.Base(path), files: make(map[string][]string)}
+	byID := make(map[string]string)
+	for _, row := range rows {
+		if len(row) < 2 {
+			continue
+		}
+		key := catalogKey(row[1])
+		byID[row[0]] = key
+		c.files[key] = nil
+	}
+
+	rows, err = tl.Query(path, lightroomCollectionsQuery)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if len(row) < 2 {
+			continue
+		}
+		if key, ok := byID[row[0]]; ok && !slices.Contains(c.files[key], row[1]) {
+			c.files[key] = append(c.files[key], row[1])
+		}
+	}
+	for key := range c.files {
+		sort.Strings(c.files[key])
+	}
+	return c, nil
+}
+
+// captureOneSession reads a Capture One session folder. Its managed files
+// are those with a settings file in a CaptureOne/Settings folder beside
+// them, and each is in the collection of the session folder it lies in,
+// such as "Wedding/Selects".
+func captureOneSession(dir string) (*Catalog, error) {
+	c := &Catalog{Name: filepath.Base(dir), files: make(map[string][]string)}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == "CaptureOne" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if mediaClass(p) == "" {
+			return nil
+		}
+		settings, _ := filepath.Glob(filepath.Join(filepath.Dir(p), "CaptureOne", "Settings*", info.Name()+".cos"))
+		if len(settings) == 0 {
+			return nil
+		}
+		var collections []string
+		if rel, err := filepath.Rel(dir, p); err == nil {
+			if top := strings.Split(filepath.ToSlash(rel), "/"); len(top) > 1 {
+				collections = []string{c.Name + "/" + top[0]}
+			}
+		}
+		c.files[catalogKey(p)] = collections
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// catalogsFor returns the catalog managing a file, if any, and its
+// collections across every catalog that does
+func catalogsFor(catalogs []*Catalog, filePath string) (string, []string) {
+	var names, collections []string
+	for _, c := range catalogs {
+		if cs, ok := c.Managed(filePath); ok {
+			names = append(names, c.Name)
+			collections = append(collections, cs...)
+		}
+	}
+	return strings.Join(names, ", "), collections
+}
@@ -0,0 +1,103 @@
+package imagedup
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+
+	"github.com/corona10/goimagehash"
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// CheckResult lists the archived copies of a checked file: Exact ones match
+// byte for byte, Near ones share its perceptual hash
+type CheckResult struct {
+	Exact []string `json:"exact"`
+	Near  []string `json:"near"`
+}
+
+// Found reports whether the archive already holds the file in any form
+func (r *CheckResult) Found() bool {
+	return len(r.Exact) > 0 || len(r.Near) > 0
+}
+
+// Check reports whether the archive at destDir already contains a file,
+// exactly or as a near duplicate, the way an import would decide. Images
+// are matched on the perceptual hashes recorded in the manifest; archived
+// images without one, from imports before hashes were recorded, are decoded
+// and hashed on the spot.
+func Check(destDir, filePath string) (*CheckResult, error) {
+	m, err := manifest.Load(destDir)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := fileChecksum(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CheckResult{}
+	var hash uint64
+	isImage := mediaClass(filePath) == "image"
+	decode := newDecoder(DecoderGo, tools.Detect(tools.Paths{}))
+	if isImage {
+		if hash, err = averageHash(decode, filePath); err != nil {
+			return nil, fmt.Errorf("failed to hash %s: %w", filePath, err)
+		}
+	}
+
+	for _, e := range m.Sorted() {
+		if e.SHA256 == sum {
+			result.Exact = append(result.Exact, e.Path)
+			continue
+		}
+		if !isImage || mediaClass(e.Path) != "image" {
+			continue
+		}
+		archivedHash, ok := parseHash(e.PHash)
+		if !ok {
+			// Encrypted copies can't be decoded, and an unreadable hash
+			// means the entry is damaged
+			if e.Encryption != "" || e.PHash != "" {
+				continue
+			}
+			h, err := averageHash(decode, filepath.Join(destDir, filepath.FromSlash(e.Path)))
+			if err != nil {
+				log.Printf("Failed to hash archived %s: %v", e.Path, err)
+				continue
+			}
+			archivedHash = h
+		}
+		if archivedHash == hash {
+			result.Near = append(result.Near, e.Path)
+		}
+	}
+	return result, nil
+}
+
+// averageHash decodes an image and returns its average hash, as computed
+// for deduplication
+func averageHash(decode decodeFunc, filePath string) (uint64, error) {
+	img, err := decode(filePath)
+	if err != nil {
+		return 0, err
+	}
+	hash, err := goimagehash.AverageHash(img)
+	if err != nil {
+		return 0, err
+	}
+	return hash.GetHash(), nil
+}
+
+// formatHash formats a perceptual hash for the manifest
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// parseHash parses a perceptual hash recorded in the manifest
+func parseHash(s string) (uint64, bool) {
+	hash, err := strconv.ParseUint(s, 16, 64)
+	return hash, err == nil && s != ""
+}
@@ -0,0 +1,169 @@
+package imagedup
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Content-defined chunk size bounds, in bytes. Boundaries fall where a
+// rolling hash of the last bytes matches a mask, so content shared between
+// files yields the same chunks whatever its offset in each file.
+const (
+	minChunkSize = 16 << 10
+	avgChunkSize = 64 << 10
+	maxChunkSize = 256 << 10
+)
+
+// chunkTopFiles is how many files with the most repeated content are listed
+const chunkTopFiles = 10
+
+// gearTable holds the random values of the gear rolling hash
+var gearTable = func() (table [256]uint64) {
+	// splitmix64, so the table and therefore the boundaries are stable
+	state := uint64(0x9e3779b97f4a7c15)
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// ChunkFile is a file whose content repeats chunks seen earlier in the scan
+type ChunkFile struct {
+	File   string `json:"file"`
+	Bytes  int64  `json:"bytes"`
+	Shared int64  `json:"shared"`
+}
+
+// ChunkStats summarizes how much content repeats across files at the chunk
+// level, as a block-level deduplicating backup would see it
+type ChunkStats struct {
+	Files       int   `json:"files"`
+	Bytes       int64 `json:"bytes"`
+	Chunks      int   `json:"chunks"`
+	UniqueBytes int64 `json:"unique_bytes"`
+
+	// SharedFiles counts files with at least one chunk seen before
+	SharedFiles int `json:"shared_files"`
+
+	// Top lists the files with the most repeated bytes
+	Top []ChunkFile `json:"top"`
+}
+
+// Redundancy is the share of bytes a block-level deduplicator would save
+func (s *ChunkStats) Redundancy() float64 {
+	if s.Bytes == 0 {
+		return 0
+	}
+	return 1 - float64(s.UniqueBytes)/float64(s.Bytes)
+}
+
+// AnalyzeChunks splits every video under dir (every media file with
+// allMedia set) into content-defined chunks and counts how many bytes repeat
+// across and within files, such as shared intro segments. Repeated bytes
+// are attributed to the file in which they are seen again. Memory grows with
+// the number of distinct chunks, about 40 bytes per 64 KiB of content.
+func AnalyzeChunks(dir string, allMedia bool) (*ChunkStats, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && isSystemFile(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		class := mediaClass(path)
+		if class == "video" || (allMedia && class != "") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &ChunkStats{}
+	seen := make(map[[16]byte]bool)
+	var shared []ChunkFile
+	for _, file := range files {
+		f := ChunkFile{File: file}
+		err := chunkFile(file, func(chunk []byte) {
+			sum := sha256.Sum256(chunk)
+			var key [16]byte
+			copy(key[:], sum[:])
+			stats.Chunks++
+			f.Bytes += int64(len(chunk))
+			if seen[key] {
+				f.Shared += int64(len(chunk))
+				return
+			}
+			seen[key] = true
+			stats.UniqueBytes += int64(len(chunk))
+		})
+		if err != nil {
+			log.Printf("Failed to read %s: %v", file, err)
+			continue
+		}
+		stats.Files++
+		stats.Bytes += f.Bytes
+		if f.Shared > 0 {
+			stats.SharedFiles++
+			shared = append(shared, f)
+		}
+	}
+
+	sort.SliceStable(shared, func(i, j int) bool { return shared[i].Shared > shared[j].Shared })
+	if len(shared) > chunkTopFiles {
+		shared = shared[:chunkTopFiles]
+	}
+	stats.Top = shared
+	return stats, nil
+}
+
+// chunkFile streams a file through the gear rolling hash, calling emit with
+// each content-defined chunk. The chunk buffer is reused between calls.
+func chunkFile(path string, emit func(chunk []byte)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// A boundary is a hash whose top bits are all zero, which happens every
+	// avgChunkSize bytes on average
+	const mask = uint64(avgChunkSize-1) << (64 - 16)
+	r := bufio.NewReaderSize(f, 1<<20)
+	chunk := make([]byte, 0, maxChunkSize)
+	var hash uint64
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		chunk = append(chunk, b)
+		hash = hash<<1 + gearTable[b]
+		if len(chunk) >= maxChunkSize || (len(chunk) >= minChunkSize && hash&mask == 0) {
+			emit(chunk)
+			chunk = chunk[:0]
+			hash = 0
+		}
+	}
+	if len(chunk) > 0 {
+		emit(chunk)
+	}
+	return nil
+}
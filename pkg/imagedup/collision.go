@@ -0,0 +1,154 @@
+package imagedup
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/corona10/goimagehash"
+)
+
+// CollisionPolicy selects what happens to images that share a perceptual
+// hash with their keeper but look different on a second check
+type CollisionPolicy string
+
+const (
+	// CollisionKeep keeps both files, with a warning
+	CollisionKeep CollisionPolicy = "keep"
+	// CollisionDrop trusts the perceptual hash and skips the second check
+	CollisionDrop CollisionPolicy = "drop"
+	// CollisionReview asks the Options' Reviewer about each collision
+	CollisionReview CollisionPolicy = "review"
+)
+
+// collisionDistance is how many bits of 64 the difference hashes of two
+// images sharing an average hash may differ by before they are taken for
+// different pictures. Recompressed and resized copies stay well within it.
+const collisionDistance = 12
+
+// CollisionReviewer decides whether two images that share a perceptual hash
+// but failed the second check are duplicates after all. Reviewers return
+// ErrNoAnswer when they have no decision, which keeps both and asks again
+// next run.
+type CollisionReviewer interface {
+	SameImage(keeper, file string, distance int) (bool, error)
+}
+
+// ErrNoAnswer is returned by a CollisionReviewer that has no decision for a
+// pair, as when the terminal it asks on is closed
+var ErrNoAnswer = errors.New("no review answer")
+
+// ParseCollisionPolicy validates a collision policy name
+func ParseCollisionPolicy(name string) (CollisionPolicy, error) {
+	switch CollisionPolicy(name) {
+	case CollisionKeep, CollisionDrop, CollisionReview:
+		return CollisionPolicy(name), nil
+	}
+	return "", fmt.Errorf("unknown collision policy %q", name)
+}
+
+// collisionCheck compares the difference hashes of images grouped by their
+// average hash, decoding each at most once
+type collisionCheck struct {
+	policy   CollisionPolicy
+	reviewer CollisionReviewer
+	log      *reviewLog
+	decode   decodeFunc
+	hashes   map[string]*goimagehash.ImageHash
+}
+
+// newCollisionCheck returns the check configured by opts, or nil when the
+// perceptual hash is trusted. Review decisions recorded in destDir's review
+// log by earlier runs are reused.
+func newCollisionCheck(opts Options, destDir string, decode decodeFunc) *collisionCheck {
+	if opts.Collisions == CollisionDrop {
+		return nil
+	}
+	c := &collisionCheck{policy: opts.Collisions, reviewer: opts.Reviewer, decode: decode, hashes: make(map[string]*goimagehash.ImageHash)}
+	if c.policy == CollisionReview && c.reviewer != nil {
+		c.log = newReviewLog(c.reviewer, destDir, opts.Clock.Now)
+	}
+	return c
+}
+
+// reviews returns the review answers given during the check, for the plan
+// to record when it is applied
+func (c *collisionCheck) reviews() []ReviewDecision {
+	if c == nil || c.log == nil {
+		return nil
+	}
+	return c.log.pending
+}
+
+// differenceHash returns an image's difference hash
+func (c *collisionCheck) differenceHash(file string) (*goimagehash.ImageHash, error) {
+	if h, ok := c.hashes[file]; ok {
+		return h, nil
+	}
+	img, err := c.decode(file)
+	if err != nil {
+		return nil, err
+	}
+	h, err := goimagehash.DifferenceHash(img)
+	if err != nil {
+		return nil, err
+	}
+	c.hashes[file] = h
+	return h, nil
+}
+
+// collides reports whether file looks different from keeper despite their
+// shared hash, and kept whether it is archived as a picture of its own.
+// keeperSum and fileSum are their checksums, which review answers are
+// recorded by.
+func (c *collisionCheck) collides(keeper, file, keeperSum, fileSum string) (distance int, kept bool) {
+	a, err := c.differenceHash(keeper)
+	if err != nil {
+		log.Printf("Failed to check %s for hash collisions: %v", keeper, err)
+		return 0, false
+	}
+	b, err := c.differenceHash(file)
+	if err != nil {
+		log.Printf("Failed to check %s for hash collisions: %v", file, err)
+		return 0, false
+	}
+	if distance, _ = a.Distance(b); distance <= collisionDistance {
+		return distance, false
+	}
+	if c.log != nil {
+		same, err := c.log.sameImage(keeper, file, keeperSum, fileSum, distance)
+		switch {
+		case errors.Is(err, ErrNoAnswer):
+		case err != nil:
+			log.Printf("Failed to review the hash collision of %s and %s: %v", keeper, file, err)
+		case same:
+			return distance, false
+		}
+	}
+	log.Printf("Warning: %s shares a hash with %s but looks different; keeping both", file, keeper)
+	return distance, true
+}
+
+// split separates the byte-distinct copies in a group of images that only
+// share the keeper's hash by collision, returning the checksums that stay
+// with the keeper and those archived on their own. One file per checksum is
+// compared.
+func (c *collisionCheck) split(keeper imageInfo, sums []string, byChecksum map[string][]imageInfo, sumOf map[string]string, report *Report) (stay, apart []string) {
+	for _, sum := range sums {
+		if sum == sumOf[keeper.filename] {
+			stay = append(stay, sum)
+			continue
+		}
+		file := byChecksum[sum][0].filename
+		distance, kept := c.collides(keeper.filename, file, sumOf[keeper.filename], sum)
+		if distance > collisionDistance {
+			report.addCollision(keeper.filename, file, distance, kept)
+		}
+		if kept {
+			apart = append(apart, sum)
+		} else {
+			stay = append(stay, sum)
+		}
+	}
+	return stay, apart
+}
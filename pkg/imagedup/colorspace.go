@@ -0,0 +1,297 @@
+package imagedup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/disintegration/imaging"
+)
+
+// colorSpace is a wide-gamut RGB space images are converted from before
+// hashing: the gamut's matrix into linear sRGB and its tone curve
+type colorSpace struct {
+	name   string
+	matrix [3][3]float64
+	gamma  float64 // 0 for the sRGB curve
+}
+
+// wideGamuts are the RGB spaces recognised from embedded ICC profiles, with
+// the names their profile descriptions use
+var wideGamuts = []struct {
+	names []string
+	space colorSpace
+}{
+	{[]string{"Adobe RGB", "AdobeRGB"}, colorSpace{"Adobe RGB", [3][3]float64{
+		{1.3983557, -0.3983557, 0},
+		{0, 1, 0},
+		{0, -0.0429290, 1.0429290},
+	}, 563.0 / 256}},
+	{[]string{"Display P3", "P3 D65", "DCI-P3"}, colorSpace{"Display P3", [3][3]float64{
+		{1.2249401, -0.2249404, 0},
+		{-0.0420569, 1.0420571, 0},
+		{-0.0196376, -0.0786361, 1.0982735},
+	}, 0}},
+	{[]string{"ProPhoto", "ROMM RGB"}, colorSpace{"ProPhoto RGB", [3][3]float64{
+		{2.0343, -0.7276, -0.3067},
+		{-0.2288, 1.2317, -0.0029},
+		{-0.0086, -0.1533, 1.1619},
+	}, 1.8}},
+}
+
+// normalizeColor converts an image to 8-bit sRGB, so 16-bit, CMYK and
+// wide-gamut exports of a picture hash like its 8-bit sRGB ones. profile is
+// the image's embedded ICC profile, if any. Images already 8-bit and
+// without a wide-gamut profile are returned as they are.
+func normalizeColor(img image.Image, profile []byte) image.Image {
+	if space, ok := profileSpace(profile); ok {
+		return toSRGB(img, space)
+	}
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16, *image.CMYK:
+		return imaging.Clone(img)
+	}
+	return img
+}
+
+// profileSpace identifies the wide-gamut space an ICC profile describes
+// from its description, which is stored as ASCII or, in version 4
+// profiles, UTF-16
+func profileSpace(profile []byte) (colorSpace, bool) {
+	if len(profile) < 128 {
+		return colorSpace{}, false
+	}
+	for _, gamut := range wideGamuts {
+		for _, name := range gamut.names {
+			if bytes.Contains(profile, []byte(name)) || bytes.Contains(profile, utf16BE(name)) {
+				return gamut.space, true
+			}
+		}
+	}
+	return colorSpace{}, false
+}
+
+// utf16BE encodes s as big-endian UTF-16, as ICC multi-localized strings are
+func utf16BE(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.BigEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+// toSRGB converts an image from a wide-gamut space to 8-bit sRGB
+func toSRGB(img image.Image, space colorSpace) *image.NRGBA {
+	var linear [256]float64
+	for i := range linear {
+		linear[i] = decodeTone(float64(i)/255, space.gamma)
+	}
+	var encoded [4096]uint8
+	for i := range encoded {
+		encoded[i] = uint8(math.Round(encodeSRGB(float64(i)/4095) * 255))
+	}
+	encode := func(v float64) uint8 {
+		return encoded[int(math.Round(math.Max(0, math.Min(1, v))*4095))]
+	}
+
+	out := imaging.Clone(img)
+	m := space.matrix
+	for i := 0; i+3 < len(out.Pix); i += 4 {
+		r, g, b := linear[out.Pix[i]], linear[out.Pix[i+1]], linear[out.Pix[i+2]]
+		out.Pix[i] = encode(m[0][0]*r + m[0][1]*g + m[0][2]*b)
+		out.Pix[i+1] = encode(m[1][0]*r + m[1][1]*g + m[1][2]*b)
+		out.Pix[i+2] = encode(m[2][0]*r + m[2][1]*g + m[2][2]*b)
+	}
+	return out
+}
+
+// decodeTone linearizes a tone value by a gamma, or the sRGB curve for 0
+func decodeTone(v, gamma float64) float64 {
+	if gamma > 0 {
+		return math.Pow(v, gamma)
+	}
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// encodeSRGB applies the sRGB curve to a linear value
+func encodeSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// maxProfileSize bounds the ICC profiles read from a file. Real profiles are
+// well under a few megabytes; a larger length is a corrupt or hostile chunk.
+const maxProfileSize = 1 << 24
+
+// iccProfile returns the ICC profile embedded in a JPEG, PNG, TIFF or WebP
+// file, or nil if it has none or it can't be read
+func iccProfile(filePath string) []byte {
+	f, err := openReadOnly(filePath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(12)
+	if err != nil {
+		return nil
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0xFF, 0xD8}):
+		return jpegProfile(r)
+	case bytes.HasPrefix(magic, []byte("\x89PNG\r\n\x1a\n")):
+		return pngProfile(r)
+	case bytes.HasPrefix(magic, []byte("RIFF")) && string(magic[8:12]) == "WEBP":
+		return webpProfile(r)
+	case bytes.HasPrefix(magic, []byte("II*\x00")) || bytes.HasPrefix(magic, []byte("MM\x00*")):
+		return tiffProfile(f)
+	}
+	return nil
+}
+
+// jpegProfile joins the ICC_PROFILE chunks of a JPEG's APP2 segments, which
+// all come before the image data
+func jpegProfile(r *bufio.Reader) []byte {
+	r.Discard(2)
+	var profile []byte
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return profile
+		}
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return profile
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return profile
+		}
+		if marker[1] != 0xE2 {
+			if _, err := r.Discard(length); err != nil {
+				return profile
+			}
+			continue
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return profile
+		}
+		if bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00")) && len(segment) > 14 {
+			profile = append(profile, segment[14:]...)
+		}
+	}
+}
+
+// pngProfile inflates a PNG's iCCP chunk, which comes before the image data
+func pngProfile(r *bufio.Reader) []byte {
+	r.Discard(8)
+	for {
+		var head [8]byte
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint32(head[:4]))
+		switch string(head[4:]) {
+		case "IDAT", "IEND":
+			return nil
+		case "iCCP":
+			if length > maxProfileSize {
+				return nil
+			}
+			chunk := make([]byte, length)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil
+			}
+			// A profile name, a NUL and a compression method precede it
+			name := bytes.IndexByte(chunk, 0)
+			if name < 0 || name+2 > len(chunk) {
+				return nil
+			}
+			z, err := zlib.NewReader(bytes.NewReader(chunk[name+2:]))
+			if err != nil {
+				return nil
+			}
+			profile, _ := io.ReadAll(io.LimitReader(z, maxProfileSize))
+			return profile
+		}
+		if _, err := r.Discard(length + 4); err != nil {
+			return nil
+		}
+	}
+}
+
+// webpProfile returns the ICCP chunk of an extended WebP file
+func webpProfile(r *bufio.Reader) []byte {
+	r.Discard(12)
+	for {
+		var head [8]byte
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return nil
+		}
+		length := int(binary.LittleEndian.Uint32(head[4:]))
+		if string(head[:4]) == "ICCP" {
+			if length > maxProfileSize {
+				return nil
+			}
+			profile := make([]byte, length)
+			if _, err := io.ReadFull(r, profile); err != nil {
+				return nil
+			}
+			return profile
+		}
+		if strings.HasPrefix(string(head[:4]), "VP8") {
+			return nil
+		}
+		if _, err := r.Discard(length + length&1); err != nil {
+			return nil
+		}
+	}
+}
+
+// tiffProfile returns the InterColorProfile tag of a TIFF's first IFD
+func tiffProfile(f io.ReaderAt) []byte {
+	var header [8]byte
+	if _, err := f.ReadAt(header[:], 0); err != nil {
+		return nil
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'M' {
+		order = binary.BigEndian
+	}
+	ifd := int64(order.Uint32(header[4:]))
+	var count [2]byte
+	if _, err := f.ReadAt(count[:], ifd); err != nil {
+		return nil
+	}
+	entries := make([]byte, 12*int(order.Uint16(count[:])))
+	if _, err := f.ReadAt(entries, ifd+2); err != nil {
+		return nil
+	}
+	for i := 0; i+12 <= len(entries); i += 12 {
+		entry := entries[i : i+12]
+		if order.Uint16(entry) != 34675 {
+			continue
+		}
+		length := order.Uint32(entry[4:])
+		if length <= 4 || length > maxProfileSize {
+			return nil
+		}
+		profile := make([]byte, length)
+		if _, err := f.ReadAt(profile, int64(order.Uint32(entry[8:]))); err != nil {
+			return nil
+		}
+		return profile
+	}
+	return nil
+}
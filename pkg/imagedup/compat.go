@@ -0,0 +1,125 @@
+package imagedup
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// DestinationProfile adapts what an import writes to the file system of the
+// destination
+type DestinationProfile string
+
+const (
+	// ProfileNative writes names and files as they are
+	ProfileNative DestinationProfile = "native"
+
+	// ProfileExFAT avoids names exFAT and Windows reject, and keeps the
+	// source modification time on copies at the 10ms exFAT stores
+	ProfileExFAT DestinationProfile = "exfat"
+
+	// ProfileFAT32 does the same as ProfileExFAT at the 2s resolution FAT
+	// stores, and refuses files of 4 GiB or more, which FAT32 can't hold
+	ProfileFAT32 DestinationProfile = "fat32"
+)
+
+// fat32MaxSize is the largest file FAT32 can store
+const fat32MaxSize = 1<<32 - 1
+
+// fatEpoch and fatEnd bound the modification times FAT and exFAT store
+var (
+	fatEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	fatEnd   = time.Date(2107, 12, 31, 23, 59, 58, 0, time.UTC)
+)
+
+// fatReserved are the names Windows reserves on FAT volumes, with or without
+// an extension
+var fatReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// ParseDestinationProfile validates a destination profile name
+func ParseDestinationProfile(name string) (DestinationProfile, error) {
+	switch DestinationProfile(name) {
+	case ProfileNative, ProfileExFAT, ProfileFAT32:
+		return DestinationProfile(name), nil
+	}
+	return "", fmt.Errorf("unknown destination profile %q", name)
+}
+
+// fat reports whether the profile targets a FAT file system
+func (p DestinationProfile) fat() bool {
+	return p == ProfileExFAT || p == ProfileFAT32
+}
+
+// name returns a file or folder name the destination accepts: characters
+// FAT rejects become "-", trailing dots and spaces are dropped and reserved
+// device names get a leading "_"
+func (p DestinationProfile) name(s string) string {
+	if !p.fat() {
+		return s
+	}
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"\|?*`, r) {
+			return '-'
+		}
+		return r
+	}, s)
+	s = strings.TrimRight(s, ". ")
+	if s == "" {
+		return "_"
+	}
+	stem := s
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	if fatReserved[strings.ToUpper(stem)] {
+		s = "_" + s
+	}
+	return s
+}
+
+// folder applies name to every element of a slash-separated folder
+func (p DestinationProfile) folder(folder string) string {
+	if !p.fat() {
+		return folder
+	}
+	parts := strings.Split(folder, "/")
+	for i, part := range parts {
+		if part != "." && part != ".." {
+			parts[i] = p.name(part)
+		}
+	}
+	return path.Clean(strings.Join(parts, "/"))
+}
+
+// checkSize returns an error for files the destination can't hold
+func (p DestinationProfile) checkSize(size int64) error {
+	if p == ProfileFAT32 && size > fat32MaxSize {
+		return fmt.Errorf("%d bytes is more than FAT32 can store in one file", size)
+	}
+	return nil
+}
+
+// modTime returns the modification time a copy is given, rounded down to
+// the resolution the file system stores and within the range it can hold,
+// or false for profiles that leave copies with the time they were written
+func (p DestinationProfile) modTime(t time.Time) (time.Time, bool) {
+	if !p.fat() || t.IsZero() {
+		return time.Time{}, false
+	}
+	resolution := 10 * time.Millisecond
+	if p == ProfileFAT32 {
+		resolution = 2 * time.Second
+	}
+	switch {
+	case t.Before(fatEpoch):
+		t = fatEpoch
+	case t.After(fatEnd):
+		t = fatEnd
+	}
+	return t.Truncate(resolution), true
+}
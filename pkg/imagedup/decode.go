@@ -0,0 +1,108 @@
+package imagedup
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+	_ "golang.org/x/image/tiff" // registers the TIFF decoder
+	_ "golang.org/x/image/webp" // registers the WebP decoder
+)
+
+// vipsHashSize is the thumbnail edge length requested from libvips. The
+// average hash only samples 8x8, so anything larger just costs time.
+const vipsHashSize = 64
+
+// decodeFunc decodes an image file for hashing
+type decodeFunc func(filePath string) (image.Image, error)
+
+// ParseDecoderBackend validates a decoder backend name
+func ParseDecoderBackend(name string) (DecoderBackend, error) {
+	switch DecoderBackend(name) {
+	case DecoderGo, DecoderVips:
+		return DecoderBackend(name), nil
+	}
+	return "", fmt.Errorf("unknown decoder backend %q", name)
+}
+
+// newDecoder returns the decode function for a backend, falling back to the
+// pure-Go decoder when libvips isn't installed
+func newDecoder(backend DecoderBackend, tl tools.Tools) decodeFunc {
+	vips := func(filePath string) (image.Image, error) {
+		out, err := tl.Thumbnail(filePath, vipsHashSize)
+		if err != nil {
+			return nil, err
+		}
+		// libvips thumbnails are already colour managed to sRGB
+		img, _, err := image.Decode(bytes.NewReader(out))
+		if err != nil {
+			return nil, err
+		}
+		return normalizeColor(img, nil), nil
+	}
+
+	// HEIC/HEIF have no pure-Go decoder, so they always go through libvips
+	goOrVips := func(filePath string) (image.Image, error) {
+		if isHEIF(filePath) {
+			if tl.Vips == "" {
+				return nil, fmt.Errorf("decoding HEIF requires vips")
+			}
+			return vips(filePath)
+		}
+		img, err := decodeGo(filePath)
+		// The Go WebP decoder can't read extended files without alpha,
+		// which is how files carrying metadata are usually written
+		if err != nil && isWebP(filePath) && tl.Vips != "" {
+			return vips(filePath)
+		}
+		return img, err
+	}
+
+	if backend != DecoderVips {
+		return goOrVips
+	}
+	if tl.Vips == "" {
+		log.Printf("libvips backend requested but vips was not found, using the Go decoder")
+		return goOrVips
+	}
+	return vips
+}
+
+// isHEIF reports whether a file is HEIC/HEIF encoded
+func isHEIF(filePath string) bool {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	return ext == ".heic" || ext == ".heif"
+}
+
+// isWebP reports whether a file is WebP encoded
+func isWebP(filePath string) bool {
+	return strings.ToLower(filepath.Ext(filePath)) == ".webp"
+}
+
+// decodeGo validates and fully decodes an image with the imaging package,
+// converted to 8-bit sRGB
+func decodeGo(filePath string) (image.Image, error) {
+	file, err := openReadOnly(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Validate if it's an actual image file
+	if _, _, err := image.DecodeConfig(file); err != nil {
+		return nil, err
+	}
+
+	file.Seek(0, 0) // Reset file read pointer
+
+	img, err := imaging.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeColor(img, iccProfile(filePath)), nil
+}
@@ -1,20 +1,22 @@
 package imagedup
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"image"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
+	"time"
 
 	"github.com/corona10/goimagehash"
-	"github.com/disintegration/imaging"
 	"github.com/gavinmcnair/pictureprocess/pkg/dateutil"
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
 )
 
 // Supported image formats that we can natively process
@@ -22,6 +24,11 @@ var SupportedImageFormats = map[string]bool{
 	".jpg":  true,
 	".jpeg": true,
 	".png":  true,
+	".webp": true,
+	".tif":  true,
+	".tiff": true,
+	".heic": true,
+	".heif": true,
 }
 
 // Supported RAW formats that need special handling
@@ -42,311 +49,597 @@ var SupportedVideoFormats = map[string]bool{
 type imageInfo struct {
 	hash     uint64
 	filename string
-	isoDate  string
-}
+	taken    dateutil.Timestamp
 
-// ProcessFiles processes files, deduplicating by format requirements.
-func ProcessFiles(srcDir, destDir string, numWorkers int) error {
-	var fileList []string
+	// period, if set, is the folder the file is filed under instead of one
+	// for its date, such as "1980s" for an undated scan
+	period string
 
-	// Walk the directory recursively to collect files
-	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			fileList = append(fileList, path)
-		}
-		return nil
-	})
-	if err != nil {
-		return err
-	}
+	// video and bitrate are set for videos fingerprinted in fuzzy mode
+	video   videoMeta
+	bitrate int64
 
-	if len(fileList) == 0 {
-		fmt.Println("No files found for processing.")
-		return nil
-	}
+	// sequence names the timelapse folder a frame is archived under
+	sequence string
 
-	var wg sync.WaitGroup
-	fileChan := make(chan string, numWorkers)
-	resultChan := make(chan imageInfo, len(fileList))
-	var processedFiles uint64
-
-	var imageCount, rawCount, videoCount, imageDuplicates, rawDuplicates, videoDuplicates, imageCopied, rawCopied, videoCopied uint64
-
-	wg.Add(numWorkers)
-	for i := 0; i < numWorkers; i++ {
-		go func() {
-			defer wg.Done()
-			for file := range fileChan {
-				ext := strings.ToLower(filepath.Ext(file))
-				if SupportedImageFormats[ext] {
-					atomic.AddUint64(&imageCount, 1)
-					processImageFile(file, resultChan)
-				} else if SupportedRawFormats[ext] {
-					atomic.AddUint64(&rawCount, 1)
-					processRawFile(file, resultChan)
-				} else if SupportedVideoFormats[ext] {
-					atomic.AddUint64(&videoCount, 1)
-					processVideoFile(file, resultChan)
-				} else {
-					log.Printf("Unsupported file format: %s", file)
-				}
-				atomic.AddUint64(&processedFiles, 1)
-				fmt.Printf("\rProcessing %d of %d files...", processedFiles, len(fileList))
-			}
-		}()
-	}
+	// faces is set for images run through a face detector
+	faces *Faces
 
-	for _, fileName := range fileList {
-		fileChan <- fileName
-	}
+	// camera holds the settings from the file's EXIF data, if any
+	camera *manifest.Camera
 
-	close(fileChan)
-	wg.Wait()
-	close(resultChan)
+	// rating and label are the file's XMP star rating and colour label
+	rating int
+	label  string
 
-	fmt.Println("\nFiltering unique files...")
+	// protected is set for files within a folder of the Protected option,
+	// which are always kept
+	protected bool
 
-	uniqueFiles := filterUniqueFiles(resultChan)
+	// size and modTime are read by the worker that hashed the file, so
+	// choosing keepers needs no further syscalls
+	size    int64
+	modTime time.Time
 
-	fmt.Println("Copying unique files...")
+	// width and height are an image's size in pixels, read while hashing,
+	// or zero when unknown
+	width  int
+	height int
 
-	dateCounters := make(map[string]uint64)
+	// damaged marks an image only partly decoded, in salvage mode
+	damaged bool
 
-	for _, fileInfo := range uniqueFiles {
-		dateStr := fileInfo.isoDate
-		destPath := filepath.Join(destDir, dateStr)
-		if err := os.MkdirAll(destPath, os.ModePerm); err != nil {
-			log.Printf("Failed to create directory %s: %v", destPath, err)
-			continue
-		}
+	// catalog and collections are set for files a Lightroom catalog or
+	// Capture One session manages
+	catalog     string
+	collections []string
+}
 
-		relPath, err := filepath.Rel(srcDir, fileInfo.filename)
-		if err != nil {
-			log.Printf("Failed to compute relative path for %s: %v", fileInfo.filename, err)
-			continue
-		}
+// ProcessFiles processes files, deduplicating by format requirements. It
+// scans, plans and applies with an Organizer, or only estimates the savings
+// when opts.Estimate is set.
+func ProcessFiles(srcDir, destDir string, opts Options) error {
+	return ProcessFilesContext(context.Background(), srcDir, destDir, opts)
+}
 
-		dateCounters[dateStr]++
-		newFileName := fmt.Sprintf("%03d%s", dateCounters[dateStr], filepath.Ext(fileInfo.filename))
-		destFile := filepath.Join(destPath, newFileName)
+// ProcessFilesContext is ProcessFiles stopping when ctx is cancelled. Files
+// copied before then are recorded in the manifest.
+func ProcessFilesContext(ctx context.Context, srcDir, destDir string, opts Options) error {
+	o, err := NewOrganizer(srcDir, destDir, opts)
+	if err != nil {
+		return err
+	}
+	index, err := o.Scan(ctx)
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+	if len(index.Files) == 0 {
+		fmt.Fprintln(o.opts.Output, "No files found for processing.")
+		o.opts.Status.phase("done")
+		return nil
+	}
+	plan, err := o.Plan(index)
+	if err != nil {
+		return err
+	}
+	if o.opts.Estimate {
+		return o.estimate(plan)
+	}
+	result, err := o.Apply(ctx, plan)
+	if err != nil || !o.opts.CheckIdempotent {
+		return err
+	}
+	if len(result.Deferred) > 0 {
+		log.Printf("Not checking idempotence: %d files were left for the next run", len(result.Deferred))
+		return nil
+	}
+	return o.checkIdempotent(index, result)
+}
 
-		if err := copyFile(fileInfo.filename, destFile); err != nil {
-			log.Printf("Failed to copy file to %s: %v", destFile, err)
-			continue
+// writeReports sends the report to its sinks and writes the duplicate
+// graph, if requested. Every sink is tried even when one fails.
+func writeReports(report *Report, opts Options) error {
+	var failed error
+	for _, sink := range reportSinks(opts) {
+		if err := sink.SendReport(report); err != nil && failed == nil {
+			failed = err
+		} else if err != nil {
+			log.Printf("%v", err)
 		}
-
-		// Create or update the index map for this directory
-		mapping := map[string]string{relPath: newFileName}
-		if err := writeIndexJSON(destPath, mapping); err != nil {
-			log.Printf("Failed to write index.json in %s: %v", destPath, err)
-			continue
+	}
+	if failed != nil {
+		return failed
+	}
+	if opts.GraphPath != "" {
+		if err := report.WriteGraph(opts.GraphPath); err != nil {
+			return fmt.Errorf("failed to write duplicate graph: %w", err)
 		}
+	}
+	return nil
+}
+
+// readIndexJSON loads a directory's index.json, returning an empty mapping if
+// it doesn't exist yet
+func readIndexJSON(destPath string) map[string]string {
+	mapping := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(destPath, "index.json"))
+	if err != nil {
+		return mapping
+	}
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		log.Printf("Error decoding existing JSON in %s: %v", destPath, err)
+	}
+	return mapping
+}
+
+// indexOrigins loads a directory's index.json as the origins of each name in
+// it, sorted: the file imported under the name and the duplicates it stands
+// for
+func indexOrigins(destPath string) map[string][]string {
+	origins := make(map[string][]string)
+	for origin, name := range readIndexJSON(destPath) {
+		origins[name] = append(origins[name], origin)
+	}
+	for _, names := range origins {
+		sort.Strings(names)
+	}
+	return origins
+}
 
-		// Increment copied counts
-		if SupportedImageFormats[strings.ToLower(filepath.Ext(fileInfo.filename))] {
-			atomic.AddUint64(&imageCopied, 1)
-		} else if SupportedRawFormats[strings.ToLower(filepath.Ext(fileInfo.filename))] {
-			atomic.AddUint64(&rawCopied, 1)
-		} else if SupportedVideoFormats[strings.ToLower(filepath.Ext(fileInfo.filename))] {
-			atomic.AddUint64(&videoCopied, 1)
+// highestCounter returns the largest counter used by names in an index
+func highestCounter(mapping map[string]string) uint64 {
+	var highest uint64
+	for _, name := range mapping {
+		n, err := strconv.ParseUint(strings.TrimSuffix(name, filepath.Ext(name)), 10, 64)
+		if err == nil && n > highest {
+			highest = n
 		}
 	}
+	return highest
+}
 
-	// Calculate duplicates
-	imageDuplicates = imageCount - imageCopied
-	rawDuplicates = rawCount - rawCopied
-	videoDuplicates = videoCount - videoCopied
+// equivalentExtensions maps extensions to the canonical spelling of the same
+// format
+var equivalentExtensions = map[string]string{
+	".jpeg": ".jpg",
+}
 
-	// Print summary
-	fmt.Printf("\nSummary:\n")
-	fmt.Printf("%d images processed, %d duplicates found, %d copied\n", imageCount, imageDuplicates, imageCopied)
-	fmt.Printf("%d RAW files processed, %d duplicates found, %d copied\n", rawCount, rawDuplicates, rawCopied)
-	fmt.Printf("%d videos processed, %d duplicates found, %d copied\n", videoCount, videoDuplicates, videoCopied)
+// canonicalExt returns a file's extension in lower case, with equivalent
+// spellings such as .jpeg folded into one, so IMG_1.JPG and IMG_2.jpeg are
+// treated alike
+func canonicalExt(filename string) string {
+	return canonicalExtension(filepath.Ext(filename))
+}
 
-	fmt.Println("All files processed.")
-	return nil
+// canonicalExtension is canonicalExt for an extension such as ".JPEG"
+func canonicalExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	if canonical, ok := equivalentExtensions[ext]; ok {
+		return canonical
+	}
+	return ext
 }
 
-// writes the index.json file for each directory
+// mediaClass names the class of a supported file: image, raw or video
+func mediaClass(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch {
+	case SupportedImageFormats[ext]:
+		return "image"
+	case SupportedRawFormats[ext]:
+		return "raw"
+	case SupportedVideoFormats[ext]:
+		return "video"
+	}
+	return ""
+}
+
+// writes the index.json file for each directory, merging mapping into any
+// existing one. The file is replaced atomically, so a crash mid-run never
+// leaves it truncated.
 func writeIndexJSON(destPath string, mapping map[string]string) error {
 	indexFile := filepath.Join(destPath, "index.json")
 
-	// Open index.json for reading and writing or create it if it doesn't exist
-	f, err := os.OpenFile(indexFile, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	// Read existing data
 	existingData := make(map[string]string)
-	decoder := json.NewDecoder(f)
-	if err := decoder.Decode(&existingData); err != nil && err != io.EOF {
-		log.Printf("Error decoding existing JSON: %v", err)
+	data, err := os.ReadFile(indexFile)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &existingData); err != nil {
+			log.Printf("Error decoding existing JSON: %v", err)
+			return err
+		}
+	}
 
 	// Update with new mappings
 	for k, v := range mapping {
 		existingData[k] = v
 	}
 
-	// Write the updated JSON map to the file
-	f.Seek(0, 0) // Reset file pointer to the beginning
-	f.Truncate(0) // Clear previous content
-	encoder := json.NewEncoder(f)
-	err = encoder.Encode(existingData)
-	return err
+	// Write the updated JSON map next to the old one, then swap them
+	data, err = json.Marshal(existingData)
+	if err != nil {
+		return err
+	}
+	tmp := indexFile + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, indexFile)
 }
 
 // processFile handles the differentiation between image and other media processing.
-func processFile(filePath string, resultChan chan<- imageInfo) {
+func processFile(filePath string, resultChan chan<- imageInfo) error {
 	ext := strings.ToLower(filepath.Ext(filePath))
 
 	if SupportedImageFormats[ext] {
-		processImageFile(filePath, resultChan)
+		return processImageFile(filePath, decodeGo, nil, resultChan)
 	} else if SupportedRawFormats[ext] {
-		processRawFile(filePath, resultChan)
+		return processRawFile(filePath, resultChan)
 	} else if SupportedVideoFormats[ext] {
-		processVideoFile(filePath, resultChan)
-	} else {
-		log.Printf("Skipping unsupported file format: %s", filePath)
+		return processVideoFile(filePath, false, tools.Tools{}, resultChan)
 	}
+	return fileError(filePath, ErrUnsupportedFormat, nil)
 }
 
 // processImageFile processes individual image files, computing hashes.
-func processImageFile(filePath string, resultChan chan<- imageInfo) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Printf("Failed to open file: %s", filePath)
-		return
-	}
-	defer file.Close()
-
-	// Validate if it's an actual image file
-	_, _, err = image.DecodeConfig(file)
+func processImageFile(filePath string, decode decodeFunc, timings *stageTimings, resultChan chan<- imageInfo) error {
+	info, err := os.Stat(filePath)
 	if err != nil {
-		log.Printf("Skipping non-image or unsupported file: %s (%v)", filePath, err)
-		return
+		return fileError(filePath, ErrDecode, err)
 	}
 
-	file.Seek(0, 0) // Reset file read pointer
-
-	img, err := imaging.Decode(file)
+	start := time.Now()
+	img, err := decode(filePath)
+	timings.track("decode", start)
 	if err != nil {
-		log.Printf("Failed to decode file: %s", filePath)
-		return
+		return fileError(filePath, ErrDecode, err)
 	}
 
 	// Compute hash from the full image
+	start = time.Now()
 	hash, err := goimagehash.AverageHash(img)
+	timings.track("hash", start)
 	if err != nil {
-		log.Printf("Failed to compute hash: %s", filePath)
-		return
-	}
-
-	date, err := dateutil.ExtractDate(filePath, filepath.Base(filePath))
-	if err != nil {
-		log.Printf("Failed to extract date: %s", filePath)
-		return
+		return fileError(filePath, ErrDecode, err)
 	}
 
+	width, height := decodedDimensions(filePath, img)
 	resultChan <- imageInfo{
 		hash:     hash.GetHash(),
 		filename: filePath,
-		isoDate:  date,
+		size:     info.Size(),
+		modTime:  info.ModTime(),
+		width:    width,
+		height:   height,
+		damaged:  isDamaged(img),
 	}
+	return nil
 }
 
 // processRawFile handles RAW image formats similarly to video processing.
-func processRawFile(filePath string, resultChan chan<- imageInfo) {
+func processRawFile(filePath string, resultChan chan<- imageInfo) error {
 	info, err := os.Stat(filePath)
 	if err != nil {
-		log.Printf("Failed to get fileinfo: %s", filePath)
-		return
+		return fileError(filePath, ErrDecode, err)
 	}
 	fileSize := info.Size()
 
 	// Use file size as a trivial comparison point for hash
 	hash := uint64(fileSize)
 
-	date, err := dateutil.ExtractDate(filePath, filepath.Base(filePath))
-	if err != nil {
-		log.Printf("Failed to extract date: %s", filePath)
-		if dateTime, err := extractFileCreationDate(filePath); err == nil {
-			date = dateTime
-		}
-	}
-
 	resultChan <- imageInfo{
 		hash:     hash,
 		filename: filePath,
-		isoDate:  date,
+		size:     fileSize,
+		modTime:  info.ModTime(),
 	}
+	return nil
 }
 
 // processVideoFile processes individual video files deduplicated on size and name.
-func processVideoFile(filePath string, resultChan chan<- imageInfo) {
+// In fuzzy mode videos are fingerprinted on duration, resolution and sampled
+// frames instead, so re-containered copies of a clip are grouped together.
+// Videos no frames could be sampled from are matched on size, as resolution
+// and duration alone don't tell different clips apart.
+func processVideoFile(filePath string, fuzzy bool, tl tools.Tools, resultChan chan<- imageInfo) error {
 	info, err := os.Stat(filePath)
 	if err != nil {
-		log.Printf("Failed to get fileinfo: %s", filePath)
-		return
+		return fileError(filePath, ErrDecode, err)
 	}
 	fileSize := info.Size()
 
-	// Use file size as a trivial comparison point for hash
-	hash := uint64(fileSize)
-
-	date, err := dateutil.ExtractDate(filePath, filepath.Base(filePath))
-	if err != nil {
-		log.Printf("Failed to extract date: %s", filePath)
-		if dateTime, err := extractFileCreationDate(filePath); err == nil {
-			date = dateTime
+	if fuzzy {
+		meta, err := probeVideo(filePath, tl)
+		if err == nil && len(meta.frames) > 0 {
+			// A container without a duration gives no bitrate to prefer
+			var bitrate int64
+			if meta.duration > 0 {
+				bitrate = int64(float64(fileSize*8) / meta.duration.Seconds())
+			}
+			resultChan <- imageInfo{
+				hash:     meta.fingerprint(),
+				filename: filePath,
+				video:    meta,
+				bitrate:  bitrate,
+				size:     fileSize,
+				modTime:  info.ModTime(),
+			}
+			return nil
+		}
+		if err != nil {
+			log.Printf("Failed to fingerprint video, matching on size: %s (%v)", filePath, err)
 		}
 	}
 
+	// Use file size as a trivial comparison point for hash
+	hash := uint64(fileSize)
+
 	resultChan <- imageInfo{
 		hash:     hash,
 		filename: filePath,
-		isoDate:  date,
+		size:     fileSize,
+		modTime:  info.ModTime(),
 	}
+	return nil
 }
 
 // extractFileCreationDate retrieves the metadata for file creation date
-func extractFileCreationDate(filePath string) (string, error) {
+func extractFileCreationDate(filePath string) (time.Time, error) {
 	info, err := os.Stat(filePath)
 	if err != nil {
-		return "", err
+		return time.Time{}, err
 	}
 
 	// Retrieve modification time as a best-effort representation of creation
-	modTime := info.ModTime().Format("2006-01-02")
-	return modTime, nil
+	return info.ModTime(), nil
 }
 
-// filterUniqueFiles retains only the largest file with the same hash
-func filterUniqueFiles(files chan imageInfo) map[uint64]imageInfo {
-	unique := make(map[uint64]imageInfo)
-	hashSizes := make(map[uint64]int64)
-
-	for fileInfo := range files {
-		info, _ := os.Stat(fileInfo.filename)
-		fileSize := info.Size()
-		if _, exists := unique[fileInfo.hash]; !exists || fileSize > hashSizes[fileInfo.hash] {
-			unique[fileInfo.hash] = fileInfo
-			hashSizes[fileInfo.hash] = fileSize
+// filterUniqueFiles retains only the largest file with the same hash. Dates are
+// assigned after grouping so copies of one photo with disagreeing dates still
+// collapse, and the keeper takes the best date found among the whole group.
+//
+// Members of a group whose bytes differ from the keeper are recorded as near
+// duplicates. With strict set they are not collapsed at all: only byte
+// identical files are treated as exact duplicates. Otherwise collisions, when
+// set, compares byte-distinct images a second way and keeps those that look
+// different apart. With MatchTime set, files are only grouped when their
+// capture timestamps also agree.
+func filterUniqueFiles(files []imageInfo, opts Options, dates *dater, tl tools.Tools, collisions *collisionCheck, timings *stageTimings, report *Report) []imageInfo {
+	dated := func(keeper imageInfo, members []imageInfo) imageInfo {
+		defer timings.track("exif", time.Now())
+		return assignGroupDate(keeper, members, dates)
+	}
+
+	// Hashes of different media classes mean different things, so they
+	// are grouped separately
+	type groupKey struct {
+		class string
+		hash  uint64
+	}
+	var order []groupKey
+	groups := make(map[groupKey][]imageInfo)
+
+	for _, fileInfo := range files {
+		key := groupKey{mediaClass(fileInfo.filename), fileInfo.hash}
+		if _, exists := groups[key]; !exists {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], fileInfo)
+	}
+
+	var clusters [][]imageInfo
+	var imageSlots []int
+	for _, key := range order {
+		if key.class == "image" {
+			imageSlots = append(imageSlots, len(clusters))
+		}
+		if groups[key][0].video.fingerprinted() {
+			clusters = append(clusters, clusterByFrames(groups[key])...)
+		} else {
+			clusters = append(clusters, groups[key])
+		}
+	}
+
+	// With a perceptual threshold, close image groups join the first of them
+	if threshold := opts.Policies["image"].Threshold; threshold > 0 && len(imageSlots) > 1 {
+		imageGroups := make([][]imageInfo, len(imageSlots))
+		for i, slot := range imageSlots {
+			imageGroups[i] = clusters[slot]
+		}
+		for i, r := range thresholdRoots(imageGroups, threshold) {
+			if r != i {
+				clusters[imageSlots[r]] = append(clusters[imageSlots[r]], imageGroups[i]...)
+				clusters[imageSlots[i]] = nil
+			}
+		}
+		var joined [][]imageInfo
+		for _, members := range clusters {
+			if members != nil {
+				joined = append(joined, members)
+			}
+		}
+		clusters = joined
+	}
+
+	if opts.MatchTime > 0 {
+		var timed [][]imageInfo
+		for _, members := range clusters {
+			if len(members) == 1 {
+				timed = append(timed, members)
+				continue
+			}
+			timed = append(timed, clusterByTime(members, opts.MatchTime, tl)...)
+		}
+		clusters = timed
+	}
+
+	clusters = protectClusters(clusters, opts.Keeper)
+
+	var unique []imageInfo
+	for _, members := range clusters {
+		keeper := largestFile(members, opts.Keeper)
+		if len(members) == 1 {
+			unique = append(unique, dated(keeper, members))
+			continue
+		}
+
+		byChecksum := make(map[string][]imageInfo)
+		sumOf := make(map[string]string)
+		var sums []string
+		for _, member := range members {
+			sum, err := fileChecksum(member.filename)
+			if err != nil {
+				log.Printf("Failed to checksum %s: %v", member.filename, err)
+				sum = "unreadable:" + member.filename
+			}
+			if _, exists := byChecksum[sum]; !exists {
+				sums = append(sums, sum)
+			}
+			byChecksum[sum] = append(byChecksum[sum], member)
+			sumOf[member.filename] = sum
+		}
+
+		if !opts.strictFor(mediaClass(keeper.filename)) || len(sums) == 1 {
+			// Byte-distinct images failing a second look get their own
+			// entries, as under strict matching
+			if collisions != nil && len(sums) > 1 && mediaClass(keeper.filename) == "image" {
+				var apart []string
+				sums, apart = collisions.split(keeper, sums, byChecksum, sumOf, report)
+				for _, sum := range apart {
+					exact := byChecksum[sum]
+					subKeeper := largestFile(exact, opts.Keeper)
+					if len(exact) > 1 {
+						report.addGroup(subKeeper, exact, sumOf)
+					}
+					unique = append(unique, dated(subKeeper, exact))
+				}
+				if len(apart) > 0 {
+					members = nil
+					for _, sum := range sums {
+						members = append(members, byChecksum[sum]...)
+					}
+				}
+			}
+			for _, member := range members {
+				if sumOf[member.filename] != sumOf[keeper.filename] {
+					report.addNearDuplicate(keeper.filename, member.filename, false)
+				}
+			}
+			report.addGroup(keeper, members, sumOf)
+			unique = append(unique, dated(keeper, members))
+			continue
+		}
+
+		for _, sum := range sums {
+			exact := byChecksum[sum]
+			subKeeper := largestFile(exact, opts.Keeper)
+			if subKeeper.filename != keeper.filename {
+				report.addNearDuplicate(keeper.filename, subKeeper.filename, true)
+			}
+			if len(exact) > 1 {
+				report.addGroup(subKeeper, exact, sumOf)
+			}
+			unique = append(unique, dated(subKeeper, exact))
 		}
 	}
 
 	return unique
 }
 
+// largestFile returns the largest of a set of files, or the one with the
+// highest bitrate when every file is a fingerprinted video. Protected files
+// are preferred over all others, then intact files over damaged ones, then
+// edited or unedited files as the policy asks, then files with a higher XMP
+// rating, then under KeepResolution those with the most pixels, and then,
+// for images run through a face detector, open eyes and more faces over
+// size. Of equally large files, one already named with
+// its canonical extension, such as .jpg rather than .JPEG, is kept.
+func largestFile(files []imageInfo, policy KeeperPolicy) imageInfo {
+	files = preferProtected(files)
+	if keeper, ok := highestBitrate(files); ok {
+		return keeper
+	}
+	files = highestRated(preferEdits(intact(files), policy))
+	if policy == KeepResolution {
+		files = mostPixels(files)
+	}
+	if keeper, ok := mostFaces(files); ok {
+		return keeper
+	}
+
+	canonical := func(f imageInfo) bool { return filepath.Ext(f.filename) == canonicalExt(f.filename) }
+	keeper := files[0]
+	for _, fileInfo := range files[1:] {
+		if fileInfo.size > keeper.size || (fileInfo.size == keeper.size && canonical(fileInfo) && !canonical(keeper)) {
+			keeper = fileInfo
+		}
+	}
+	return keeper
+}
+
+// assignGroupDate dates the keeper using the most trustworthy date source
+// available across every member of its duplicate group, and reads its
+// metadata
+func assignGroupDate(keeper imageInfo, members []imageInfo, dates *dater) imageInfo {
+	keeper.taken, keeper.period = dates.date(keeper.filename)
+	keeper.readMetadata()
+	own := keeper
+
+	for _, member := range members {
+		if member.filename == keeper.filename {
+			continue
+		}
+		if taken, period := dates.date(member.filename); taken.Source > keeper.taken.Source {
+			keeper.taken, keeper.period = taken, period
+		}
+	}
+
+	if keeper.dateLabel() != own.dateLabel() {
+		log.Printf("Dating %s as %s (from %s of a duplicate) instead of %s (from its %s)",
+			keeper.filename, keeper.dateLabel(), keeper.taken.Source, own.dateLabel(), own.taken.Source)
+	}
+	return keeper
+}
+
+// dateLabel is the file's ISO date, or its period if it has one
+func (i imageInfo) dateLabel() string {
+	if i.period != "" {
+		return i.period
+	}
+	return i.taken.Date()
+}
+
+// extractDate resolves when a file was taken, asking exiftool when the
+// built-in EXIF parser found nothing and falling back to its creation date.
+// Ambiguous filename dates are read in the given order, and dates judged
+// plausible by clock's time.
+func extractDate(filePath string, order dateutil.DateOrder, tl tools.Tools, clock dateutil.Clock) dateutil.Timestamp {
+	taken, err := dateutil.ExtractAt(filePath, filepath.Base(filePath), order, clock)
+	if taken.Source < dateutil.SourceExif {
+		if t, err := tl.CreateDate(filePath); err == nil && dateutil.PlausibleAt(t, clock.Now()) {
+			return dateutil.Timestamp{Time: t, Source: dateutil.SourceExif, Clock: true}
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to extract date: %s", filePath)
+		if t, err := extractFileCreationDate(filePath); err == nil {
+			return dateutil.Timestamp{Time: t, Source: dateutil.SourceModTime, Clock: true}
+		}
+	}
+	return taken
+}
+
 // copyFile copies a file from source to destination path, preserving binary content.
 func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+	if err := checkNotSameFile(src, dst); err != nil {
+		return err
+	}
+
+	sourceFile, err := openReadOnly(src)
 	if err != nil {
 		return err
 	}
@@ -365,3 +658,16 @@ func copyFile(src, dst string) error {
 	return nil
 }
 
+// highestBitrate returns the file with the highest bitrate if all files have one
+func highestBitrate(files []imageInfo) (imageInfo, bool) {
+	var keeper imageInfo
+	for _, fileInfo := range files {
+		if fileInfo.bitrate <= 0 {
+			return imageInfo{}, false
+		}
+		if fileInfo.bitrate > keeper.bitrate {
+			keeper = fileInfo
+		}
+	}
+	return keeper, len(files) > 0
+}
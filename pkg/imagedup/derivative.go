@@ -0,0 +1,92 @@
+package imagedup
+
+import (
+	"fmt"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// defaultDerivativeQuality is the JPEG quality used when a profile sets none
+const defaultDerivativeQuality = 85
+
+// DerivativeProfile describes a second tree of transcoded, resized copies of
+// images (a "sharing" tree) produced alongside the untouched originals
+type DerivativeProfile struct {
+	Name string `json:"name"`
+	Dest string `json:"dest"`
+
+	// MaxSize bounds the longest edge in pixels; zero keeps the original size
+	MaxSize int `json:"max_size"`
+	// Quality is the JPEG quality of the output
+	Quality int `json:"quality"`
+	// Extensions limits the profile to these source extensions; empty means
+	// every image format
+	Extensions []string `json:"extensions"`
+}
+
+// matches reports whether the profile applies to a file
+func (p DerivativeProfile) matches(filename string) bool {
+	if mediaClass(filename) != "image" {
+		return false
+	}
+	if len(p.Extensions) == 0 {
+		return true
+	}
+	ext := canonicalExt(filename)
+	for _, e := range p.Extensions {
+		if canonicalExtension(e) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDerivative renders the profile's JPEG for a file into
+// <dest>/<folder>/<name>.jpg and records it in that folder's index.json
+func writeDerivative(p DerivativeProfile, tl tools.Tools, fileInfo imageInfo, relPath, folder, newFileName string) error {
+	destPath := filepath.Join(p.Dest, folder)
+	if err := os.MkdirAll(destPath, os.ModePerm); err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(newFileName, filepath.Ext(newFileName)) + ".jpg"
+	destFile := filepath.Join(destPath, name)
+
+	quality := p.Quality
+	if quality <= 0 {
+		quality = defaultDerivativeQuality
+	}
+
+	if isHEIF(fileInfo.filename) {
+		// HEIC/HEIF can't be decoded in pure Go, so libvips does the
+		// transcode and resize in one step
+		if err := tl.Transcode(fileInfo.filename, destFile, p.MaxSize, quality); err != nil {
+			return fmt.Errorf("HEIF transcode of %s: %w", fileInfo.filename, err)
+		}
+	} else {
+		img, err := imaging.Open(fileInfo.filename, imaging.AutoOrientation(true))
+		if err != nil {
+			return err
+		}
+		if p.MaxSize > 0 {
+			img = imaging.Fit(img, p.MaxSize, p.MaxSize, imaging.Lanczos)
+		}
+		out, err := os.Create(destFile)
+		if err != nil {
+			return err
+		}
+		if err := jpeg.Encode(out, img, &jpeg.Options{Quality: quality}); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+
+	return writeIndexJSON(destPath, map[string]string{relPath: name})
+}
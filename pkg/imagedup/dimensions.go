@@ -0,0 +1,75 @@
+package imagedup
+
+import (
+	"fmt"
+	"image"
+)
+
+// imageDimensions reads an image's width and height from its header, so
+// the size is the original's even when the decoder shrinks on load. It
+// returns zeros for formats whose headers Go can't read.
+func imageDimensions(filePath string) (int, int) {
+	f, err := openReadOnly(filePath)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0
+	}
+	return cfg.Width, cfg.Height
+}
+
+// decodedDimensions returns an image's size from its header, or else from
+// the decoded image
+func decodedDimensions(filePath string, img image.Image) (int, int) {
+	if w, h := imageDimensions(filePath); w > 0 && h > 0 {
+		return w, h
+	}
+	b := img.Bounds()
+	return b.Dx(), b.Dy()
+}
+
+// megapixels returns the pixel count of a width and height in millions
+func megapixels(width, height int) float64 {
+	return float64(width) * float64(height) / 1e6
+}
+
+// formatMegapixels formats a pixel count to one decimal, such as "12.2"
+func formatMegapixels(width, height int) string {
+	return fmt.Sprintf("%.1f", megapixels(width, height))
+}
+
+// mostPixels narrows a group to its files of the highest resolution.
+// Files of unknown size only remain if no size is known.
+func mostPixels(files []imageInfo) []imageInfo {
+	best := 0
+	for _, fileInfo := range files {
+		best = max(best, fileInfo.width*fileInfo.height)
+	}
+	if best == 0 {
+		return files
+	}
+	var kept []imageInfo
+	for _, fileInfo := range files {
+		if fileInfo.width*fileInfo.height == best {
+			kept = append(kept, fileInfo)
+		}
+	}
+	return kept
+}
+
+// lowResolution lists the kept images smaller than minMegapixels
+func lowResolution(files []imageInfo, minMegapixels float64) []string {
+	if minMegapixels <= 0 {
+		return nil
+	}
+	var low []string
+	for _, fileInfo := range files {
+		if fileInfo.width > 0 && megapixels(fileInfo.width, fileInfo.height) < minMegapixels {
+			low = append(low, fileInfo.filename)
+		}
+	}
+	return low
+}
@@ -0,0 +1,53 @@
+package imagedup
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/dateutil"
+)
+
+// Failure classes of the files an import skips. They are wrapped in a
+// FileError naming the file, so callers can branch with errors.Is.
+var (
+	// ErrUnsupportedFormat is returned for files that aren't a supported
+	// image, RAW or video format
+	ErrUnsupportedFormat = errors.New("unsupported file format")
+
+	// ErrDecode is returned when a file can't be decoded or hashed
+	ErrDecode = errors.New("failed to decode file")
+
+	// ErrNoDate is returned when no date at all could be found for a file
+	ErrNoDate = dateutil.ErrNoDate
+
+	// ErrCopyFailed is returned when a file couldn't be stored in the primary
+	// destination
+	ErrCopyFailed = errors.New("failed to copy file")
+)
+
+// FileError records why a file was skipped. Err wraps one of the failure
+// classes above and, if there is one, the underlying error.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// fileError returns a FileError for path in class kind, caused by err if it
+// isn't nil
+func fileError(path string, kind, err error) *FileError {
+	switch {
+	case err == nil:
+		err = kind
+	case !errors.Is(err, kind):
+		err = fmt.Errorf("%w: %w", kind, err)
+	}
+	return &FileError{Path: path, Err: err}
+}
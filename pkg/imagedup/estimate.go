@@ -0,0 +1,110 @@
+package imagedup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Savings estimates what deduplicating a source would eliminate
+type Savings struct {
+	// Files and Bytes count the files that would not be copied
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+
+	// ClusterSizes maps a duplicate group's size, keeper included, to how
+	// many groups had that size
+	ClusterSizes map[int]int `json:"cluster_sizes"`
+}
+
+// eliminated returns the scanned files that deduplication didn't keep
+func eliminated(scanned, kept []imageInfo) []string {
+	keep := make(map[string]bool, len(kept))
+	for _, fileInfo := range kept {
+		keep[fileInfo.filename] = true
+	}
+	var dropped []string
+	for _, fileInfo := range scanned {
+		if !keep[fileInfo.filename] {
+			dropped = append(dropped, fileInfo.filename)
+		}
+	}
+	return dropped
+}
+
+// estimateSavings totals the files deduplication eliminated
+func estimateSavings(dropped []string, report *Report) *Savings {
+	savings := &Savings{ClusterSizes: make(map[int]int)}
+	for _, file := range dropped {
+		savings.Files++
+		if info, err := os.Stat(file); err == nil {
+			savings.Bytes += info.Size()
+		}
+	}
+	for _, group := range report.Groups {
+		savings.ClusterSizes[len(group.Members)+1]++
+	}
+	return savings
+}
+
+// print writes the estimate as a few lines with a cluster size histogram
+func (s *Savings) print(w io.Writer, scanned int) {
+	fmt.Fprintf(w, "\nEstimate (nothing was copied):\n")
+	fmt.Fprintf(w, "%d of %d files would be eliminated as duplicates, saving %s\n", s.Files, scanned, FormatSize(s.Bytes))
+	if len(s.ClusterSizes) == 0 {
+		return
+	}
+	sizes := make([]int, 0, len(s.ClusterSizes))
+	for size := range s.ClusterSizes {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	fmt.Fprintf(w, "Duplicate groups by size:\n")
+	for _, size := range sizes {
+		fmt.Fprintf(w, "%6d files: %d groups\n", size, s.ClusterSizes[size])
+	}
+}
+
+// safeToRemove filters eliminated files down to those whose keeper was
+// archived, leaving out duplicates of keepers that failed to copy and files
+// a catalog manages
+func (r *Report) safeToRemove(dropped []string, notStored map[string]bool) []string {
+	keeperOf := make(map[string]string)
+	for _, group := range r.Groups {
+		for _, member := range group.Members {
+			keeperOf[member.File] = group.Keeper
+		}
+	}
+	for _, trim := range r.Trims {
+		if trim.Dropped {
+			keeperOf[trim.Trimmed] = trim.Original
+		}
+	}
+	managed := make(map[string]bool)
+	for _, file := range append(append([]string(nil), r.Managed...), r.Protected...) {
+		managed[file] = true
+	}
+	var safe []string
+	for _, file := range dropped {
+		if !notStored[keeperOf[file]] && !managed[file] {
+			safe = append(safe, file)
+		}
+	}
+	return safe
+}
+
+// printDuplicates writes the eliminated files one per line, or NUL-separated
+// for xargs -0
+func printDuplicates(w io.Writer, dropped []string, nul bool) error {
+	sep := "\n"
+	if nul {
+		sep = "\x00"
+	}
+	for _, file := range dropped {
+		if _, err := io.WriteString(w, file+sep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
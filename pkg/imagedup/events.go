@@ -0,0 +1,87 @@
+package imagedup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Event names the files taken on a date or within a range of dates, for the
+// {event} layout token
+type Event struct {
+	Name string `json:"name"`
+
+	// Date is one day (2022-12-25), or a day of every year (12-25). From
+	// and Until instead bound a range, inclusively, each a year, month or
+	// date as accepted by ParseDateBound.
+	Date  string `json:"date,omitempty"`
+	From  string `json:"from,omitempty"`
+	Until string `json:"until,omitempty"`
+}
+
+// ValidateEvents checks an events mapping
+func ValidateEvents(events []Event) error {
+	for _, e := range events {
+		if strings.TrimSpace(e.Name) == "" {
+			return fmt.Errorf("event without a name")
+		}
+		if strings.ContainsAny(e.Name, `/\`) {
+			return fmt.Errorf("event %q: names can't contain path separators", e.Name)
+		}
+		switch {
+		case e.Date != "" && (e.From != "" || e.Until != ""):
+			return fmt.Errorf("event %q: give a date or a range, not both", e.Name)
+		case e.Date != "":
+			if _, err := time.Parse("2006-01-02", e.Date); err != nil {
+				if _, err := time.Parse("01-02", e.Date); err != nil {
+					return fmt.Errorf("event %q: %q is not a date (2006-01-02) or day of the year (01-02)", e.Name, e.Date)
+				}
+			}
+		case e.From == "" && e.Until == "":
+			return fmt.Errorf("event %q has no date or range", e.Name)
+		}
+		for _, bound := range []string{e.From, e.Until} {
+			if bound == "" {
+				continue
+			}
+			if _, err := ParseDateBound(bound); err != nil {
+				return fmt.Errorf("event %q: %w", e.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether a capture date falls on the event
+func (e Event) matches(date string) bool {
+	switch {
+	case date == "":
+		return false
+	case len(e.Date) == len("01-02"):
+		return datePart(date, 5, 10) == e.Date
+	case e.Date != "":
+		return date == e.Date
+	}
+	return dateInRange(date, e.From, e.Until)
+}
+
+// eventName returns the name of the first event a date falls on, with white
+// space turned into dashes, or "" if there is none
+func eventName(events []Event, date string) string {
+	for _, e := range events {
+		if e.matches(date) {
+			return folderLabel(e.Name)
+		}
+	}
+	return ""
+}
+
+// eventLayout returns the layout events are filed by: l itself if it places
+// the {event} token, or else l with the event's name appended to its last
+// folder, as in 2022-12-25_Christmas
+func (l Layout) eventLayout(events []Event) Layout {
+	if len(events) == 0 || strings.Contains(string(l), "{event}") {
+		return l
+	}
+	return l + "_{event}"
+}
@@ -0,0 +1,182 @@
+package imagedup
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+)
+
+// ExportFilter selects the archived files to export. Zero fields match
+// everything.
+type ExportFilter struct {
+	// From and Until bound capture dates, inclusively. Either may be a year,
+	// a month (2019-07) or a full date.
+	From  string
+	Until string
+
+	// Camera matches a substring of the EXIF camera make and model,
+	// ignoring case
+	Camera string
+
+	// Folder is a glob matched against the archived folder, such as
+	// "2019-07-*" or "*/sequence-*"
+	Folder string
+
+	// Tags must all be attached to a file for it to be exported
+	Tags []string
+}
+
+// ExportResult summarizes an export
+type ExportResult struct {
+	// Exported counts files copied to the export
+	Exported int
+	// Present counts matching files already in the export from an earlier run
+	Present int
+	// Encrypted counts matching files left out because they are encrypted
+	Encrypted int
+}
+
+// ParseDateBound validates a date range bound: a year, a month or a date
+func ParseDateBound(s string) (string, error) {
+	for _, layout := range []string{"2006", "2006-01", "2006-01-02"} {
+		if _, err := time.Parse(layout, s); err == nil {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a year, month (2006-01) or date (2006-01-02)", s)
+}
+
+// Export copies the archived files matching filter from archiveDir into
+// destDir, keeping their folders and names, and writes index.json files and
+// a manifest for them so the export is an archive in its own right. Files
+// are selected from the manifest rather than rehashed, and each copy is
+// checked against its manifest checksum. Running it again only copies what
+// is missing.
+func Export(archiveDir, destDir string, filter ExportFilter) (*ExportResult, error) {
+	src, err := manifest.Load(archiveDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(src.Entries) == 0 {
+		return nil, fmt.Errorf("no manifest entries found in %s; run reindex there first", archiveDir)
+	}
+	if filter.Folder != "" {
+		if _, err := path.Match(filter.Folder, ""); err != nil {
+			return nil, fmt.Errorf("invalid folder pattern %q: %w", filter.Folder, err)
+		}
+	}
+	if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	lock, err := LockDestination(destDir, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+	dst, err := manifest.Load(destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ExportResult{}
+	indexes := make(map[string]map[string][]string)
+	for _, e := range src.Sorted() {
+		archived := filepath.Join(archiveDir, filepath.FromSlash(e.Path))
+		if !filter.matches(e, archived) {
+			continue
+		}
+		if e.Encryption != "" {
+			result.Encrypted++
+			continue
+		}
+		if prev, ok := dst.Entries[e.Path]; ok && prev.SHA256 == e.SHA256 {
+			result.Present++
+			continue
+		}
+		if exists(filepath.Join(destDir, filepath.FromSlash(e.Path))) {
+			log.Printf("Failed to export %s: a different file is already there", e.Path)
+			continue
+		}
+		if err := copyArchived(archiveDir, destDir, e, e.Path); err != nil {
+			log.Printf("Failed to export %s: %v", e.Path, err)
+			continue
+		}
+
+		entry := e
+		entry.VerifiedAt = nil
+		dst.Add(entry)
+
+		folder := path.Dir(e.Path)
+		if _, loaded := indexes[folder]; !loaded {
+			indexes[folder] = indexOrigins(filepath.Join(archiveDir, filepath.FromSlash(folder)))
+		}
+		mapping := make(map[string]string)
+		for _, origin := range indexes[folder][path.Base(e.Path)] {
+			mapping[origin] = path.Base(e.Path)
+		}
+		if len(mapping) == 0 {
+			mapping[unknownOrigin+path.Base(e.Path)] = path.Base(e.Path)
+		}
+		dir := filepath.Join(destDir, filepath.FromSlash(folder))
+		if err := writeIndexJSON(dir, mapping); err != nil {
+			log.Printf("Failed to write index.json in %s: %v", dir, err)
+		}
+
+		result.Exported++
+		if result.Exported%mergeSaveInterval == 0 {
+			if err := dst.Save(destDir); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if result.Exported > 0 {
+		if err := dst.Save(destDir); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// dateInRange reports whether a date (2006-01-02) lies within inclusive
+// bounds given by ParseDateBound; empty bounds are open
+func dateInRange(date, from, until string) bool {
+	if from != "" && date < from {
+		return false
+	}
+	return until == "" || datePart(date, 0, len(until)) <= until
+}
+
+// matches reports whether an archived file passes the filter
+func (f ExportFilter) matches(e manifest.Entry, archived string) bool {
+	if !dateInRange(e.Date, f.From, f.Until) {
+		return false
+	}
+	if !hasTags(e, f.Tags) {
+		return false
+	}
+	if f.Folder != "" {
+		if ok, _ := path.Match(f.Folder, path.Dir(e.Path)); !ok {
+			return false
+		}
+	}
+	if f.Camera != "" {
+		// Files archived before camera settings were recorded are read
+		// again, which only works for stored files left as they were
+		camera := e.Camera
+		if camera == nil {
+			if e.Encryption != "" || e.Transform != "" {
+				return false
+			}
+			camera = readCamera(archived)
+		}
+		return strings.Contains(strings.ToLower(camera.Name()), strings.ToLower(f.Camera))
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package imagedup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Faces is what a face detector found in an image
+type Faces struct {
+	// Count is the number of faces detected
+	Count int `json:"faces"`
+	// EyesOpen is the number of those faces with their eyes open, for
+	// detectors that can tell
+	EyesOpen int `json:"eyes_open"`
+}
+
+// FaceDetector finds faces in an image. Implementations must be safe for
+// concurrent use.
+type FaceDetector interface {
+	DetectFaces(filePath string) (Faces, error)
+}
+
+// commandDetector runs an external program per image
+type commandDetector struct {
+	name string
+	args []string
+}
+
+// NewCommandDetector returns a FaceDetector running an external command with
+// the image's path appended to its arguments. The command must print a JSON
+// object such as {"faces": 2, "eyes_open": 1}.
+func NewCommandDetector(command string) (FaceDetector, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty face detector command")
+	}
+	name, err := exec.LookPath(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	return &commandDetector{name: name, args: fields[1:]}, nil
+}
+
+// DetectFaces runs the command on one image
+func (d *commandDetector) DetectFaces(filePath string) (Faces, error) {
+	var faces Faces
+	out, err := exec.Command(d.name, append(d.args, filePath)...).Output()
+	if err != nil {
+		return faces, err
+	}
+	if err := json.Unmarshal(out, &faces); err != nil {
+		return faces, fmt.Errorf("unexpected face detector output: %w", err)
+	}
+	return faces, nil
+}
+
+// detectFaces runs the detector over every image, using numWorkers at once
+func detectFaces(files []imageInfo, detector FaceDetector, numWorkers int, timings *stageTimings) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				start := time.Now()
+				faces, err := detector.DetectFaces(files[i].filename)
+				timings.track("faces", start)
+				if err != nil {
+					log.Printf("Failed to detect faces in %s: %v", files[i].filename, err)
+					continue
+				}
+				files[i].faces = &faces
+			}
+		}()
+	}
+	for i := range files {
+		if mediaClass(files[i].filename) == "image" {
+			indexes <- i
+		}
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// mostFaces returns the file with the most faces with open eyes, then the
+// most faces. ok is false when detection can't tell the files apart, so the
+// usual keeper rules apply.
+func mostFaces(files []imageInfo) (keeper imageInfo, ok bool) {
+	better := func(a, b *Faces) bool {
+		if a.EyesOpen != b.EyesOpen {
+			return a.EyesOpen > b.EyesOpen
+		}
+		return a.Count > b.Count
+	}
+
+	var best *Faces
+	for _, fileInfo := range files {
+		if fileInfo.faces == nil {
+			return keeper, false
+		}
+		if best == nil || better(fileInfo.faces, best) {
+			keeper, best = fileInfo, fileInfo.faces
+		}
+	}
+	for _, fileInfo := range files {
+		if better(best, fileInfo.faces) {
+			return keeper, true
+		}
+	}
+	return keeper, false
+}
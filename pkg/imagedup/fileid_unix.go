@@ -0,0 +1,23 @@
+//go:build !windows
+
+package imagedup
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileKey identifies a file independently of the path it was reached by
+type fileKey struct {
+	dev uint64
+	ino uint64
+}
+
+// fileID returns the device and inode of a file
+func fileID(info os.FileInfo) (fileKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
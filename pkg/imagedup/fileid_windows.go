@@ -0,0 +1,17 @@
+//go:build windows
+
+package imagedup
+
+import "os"
+
+// fileKey identifies a file independently of the path it was reached by
+type fileKey struct {
+	dev uint64
+	ino uint64
+}
+
+// fileID is unavailable from os.FileInfo on Windows, so aliases found
+// through different paths aren't detected there
+func fileID(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
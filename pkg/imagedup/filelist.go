@@ -0,0 +1,134 @@
+package imagedup
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadFileList reads a list of paths, one per line, or NUL-separated as
+// written by find -print0 when the input contains a NUL byte
+func ReadFileList(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	sep := []byte("\n")
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = []byte{0}
+	}
+	var paths []string
+	for _, field := range bytes.Split(data, sep) {
+		if p := strings.TrimSuffix(string(field), "\r"); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
+// scanSource lists the files a run processes: the file list when one is
+// set, otherwise everything found walking the source, either narrowed to
+// OnlyPaths
+func scanSource(srcDir, destDir string, opts Options) (*sourceScan, error) {
+	var scan *sourceScan
+	var err error
+	if opts.FileList != nil {
+		scan, err = collectListed(srcDir, destDir, opts.FileList, opts)
+	} else {
+		scan, err = collectFiles(srcDir, opts, excludedOutputs(srcDir, destDir, opts))
+	}
+	if err == nil && len(opts.OnlyPaths) > 0 {
+		scan.onlyPaths(srcDir, opts.OnlyPaths)
+	}
+	return scan, err
+}
+
+// collectListed builds the scan from an explicit file list instead of
+// walking the source. Listed files must lie within srcDir, which their
+// index.json paths are relative to; directories, files inside the run's
+// outputs and repeats of one file are skipped, but ignore files and the
+// system file filter are not applied.
+func collectListed(srcDir, destDir string, list []string, opts Options) (*sourceScan, error) {
+	src, err := resolvePath(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []string
+	for _, out := range outputPaths(destDir, opts) {
+		if resolved, err := resolvePath(out); err == nil {
+			outputs = append(outputs, resolved)
+		}
+	}
+
+	scan := &sourceScan{companions: make(map[string]string)}
+	listed := make(map[string]bool)
+	seen := make(map[fileKey]string)
+	var forks []string
+	for _, p := range list {
+		resolved, err := resolvePath(p)
+		if err != nil {
+			log.Printf("Skipping %s: %v", p, err)
+			continue
+		}
+		if !isWithin(resolved, src) {
+			log.Printf("Skipping %s: not inside %s", p, srcDir)
+			continue
+		}
+		// On Windows photo.jpg:thumb.jpg opens a stream of photo.jpg,
+		// which is no image of its own
+		if isStreamName(filepath.Base(resolved)) {
+			log.Printf("Skipping %s: an alternate data stream", p)
+			continue
+		}
+		if within(resolved, outputs) {
+			log.Printf("Skipping %s: inside an output location", p)
+			continue
+		}
+		info, err := os.Stat(resolved)
+		if err != nil {
+			log.Printf("Skipping %s: %v", p, err)
+			if isPermission(err) {
+				scan.denied = append(scan.denied, filepath.Dir(resolved))
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() || !opts.modifiedSince(info) {
+			continue
+		}
+
+		// Name the file as the walker would, under srcDir
+		rel, err := filepath.Rel(src, resolved)
+		if err != nil {
+			continue
+		}
+		file := filepath.Join(srcDir, rel)
+		if isAppleDouble(file, info) {
+			forks = append(forks, file)
+			continue
+		}
+		if id, ok := fileID(info); ok {
+			if first, dup := seen[id]; dup {
+				log.Printf("Skipping %s: same file as %s", file, first)
+				continue
+			}
+			seen[id] = file
+		}
+		scan.files = append(scan.files, file)
+		listed[file] = true
+	}
+	scan.pairForks(forks, listed, opts)
+	return scan, nil
+}
+
+// within reports whether a resolved path lies in any of the resolved roots
+func within(path string, roots []string) bool {
+	for _, root := range roots {
+		if isWithin(path, root) {
+			return true
+		}
+	}
+	return false
+}
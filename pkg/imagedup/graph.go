@@ -0,0 +1,131 @@
+package imagedup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Graph is the duplicate relationship graph of a run: nodes are files and
+// edges connect related files, weighted by hash distance
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a file in the duplicate graph
+type GraphNode struct {
+	ID     int    `json:"id"`
+	File   string `json:"file"`
+	Dir    string `json:"dir"`
+	Keeper bool   `json:"keeper"`
+}
+
+// GraphEdge links two files. Kind is "exact", "near", "trim" or "related".
+type GraphEdge struct {
+	Source   int    `json:"source"`
+	Target   int    `json:"target"`
+	Kind     string `json:"kind"`
+	Distance int    `json:"distance"`
+}
+
+// Graph builds the duplicate relationship graph from the report
+func (r *Report) Graph() *Graph {
+	g := &Graph{}
+	ids := make(map[string]int)
+	node := func(file string, keeper bool) int {
+		if id, ok := ids[file]; ok {
+			if keeper {
+				g.Nodes[id].Keeper = true
+			}
+			return id
+		}
+		id := len(g.Nodes)
+		ids[file] = id
+		g.Nodes = append(g.Nodes, GraphNode{ID: id, File: file, Dir: filepath.Dir(file), Keeper: keeper})
+		return id
+	}
+
+	for _, group := range r.Groups {
+		keeper := node(group.Keeper, true)
+		for _, member := range group.Members {
+			kind := "near"
+			if member.Exact {
+				kind = "exact"
+			}
+			g.Edges = append(g.Edges, GraphEdge{Source: keeper, Target: node(member.File, false), Kind: kind, Distance: member.Distance})
+		}
+	}
+	for _, near := range r.NearDuplicates {
+		if near.Kept {
+			g.Edges = append(g.Edges, GraphEdge{Source: node(near.Keeper, true), Target: node(near.File, true), Kind: "near"})
+		}
+	}
+	for _, trim := range r.Trims {
+		g.Edges = append(g.Edges, GraphEdge{Source: node(trim.Original, true), Target: node(trim.Trimmed, !trim.Dropped), Kind: "trim"})
+	}
+	for _, rel := range r.Related {
+		g.Edges = append(g.Edges, GraphEdge{Source: node(rel.Original, true), Target: node(rel.Copy, true), Kind: "related", Distance: rel.Distance})
+	}
+	return g
+}
+
+// WriteGraph exports the duplicate graph to path, as GraphViz DOT when the
+// extension is .dot or .gv and as JSON otherwise
+func (r *Report) WriteGraph(path string) error {
+	g := r.Graph()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".dot", ".gv":
+		return os.WriteFile(path, []byte(g.DOT()), 0644)
+	}
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// DOT renders the graph in GraphViz format, clustering files by directory
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("graph duplicates {\n")
+
+	byDir := make(map[string][]GraphNode)
+	var dirs []string
+	for _, n := range g.Nodes {
+		if _, ok := byDir[n.Dir]; !ok {
+			dirs = append(dirs, n.Dir)
+		}
+		byDir[n.Dir] = append(byDir[n.Dir], n)
+	}
+	for i, dir := range dirs {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n    label=%q;\n", i, dir)
+		for _, n := range byDir[dir] {
+			shape := "ellipse"
+			if n.Keeper {
+				shape = "box"
+			}
+			fmt.Fprintf(&b, "    n%d [label=%q, shape=%s];\n", n.ID, filepath.Base(n.File), shape)
+		}
+		b.WriteString("  }\n")
+	}
+
+	for _, e := range g.Edges {
+		style := "solid"
+		switch e.Kind {
+		case "near":
+			style = "dashed"
+		case "trim":
+			style = "dotted"
+		case "related":
+			style = "bold"
+		}
+		fmt.Fprintf(&b, "  n%d -- n%d [label=\"%s %d\", weight=%d, style=%s];\n",
+			e.Source, e.Target, e.Kind, e.Distance, 64-e.Distance, style)
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
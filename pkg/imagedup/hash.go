@@ -0,0 +1,67 @@
+package imagedup
+
+import (
+	"math/bits"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// Hash is the 64-bit value an import groups files by: an image's average
+// hash, or the size of a RAW file or video. Its String form is the phash
+// recorded in the manifest.
+type Hash uint64
+
+// String formats the hash as the manifest records it
+func (h Hash) String() string {
+	return formatHash(uint64(h))
+}
+
+// ParseHash parses a hash as formatted by String, such as a manifest phash
+func ParseHash(s string) (Hash, error) {
+	hash, err := strconv.ParseUint(s, 16, 64)
+	return Hash(hash), err
+}
+
+// defaultTools are the external tools an import finds on the PATH, as
+// HashFile and DateFile use them
+var defaultTools = sync.OnceValue(func() tools.Tools {
+	return tools.Detect(tools.Paths{})
+})
+
+// hashDecoder is the decoder HashFile uses, the organizer's default
+var hashDecoder = sync.OnceValue(func() decodeFunc {
+	return newDecoder(DecoderGo, defaultTools())
+})
+
+// HashFile returns a supported file's hash exactly as an import with the
+// default options computes it, so programs sharing an archive with the
+// organizer match files the same way. Videos are hashed by size, as without
+// -fuzzy-video. Errors are *FileError values.
+func HashFile(path string) (Hash, error) {
+	switch mediaClass(path) {
+	case "image":
+		hash, err := averageHash(hashDecoder(), path)
+		if err != nil {
+			return 0, fileError(path, ErrDecode, err)
+		}
+		return Hash(hash), nil
+	case "raw", "video":
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, fileError(path, ErrDecode, err)
+		}
+		return Hash(info.Size()), nil
+	}
+	return 0, fileError(path, ErrUnsupportedFormat, nil)
+}
+
+// CompareHashes returns the Hamming distance between two hashes, the number
+// of bits of 64 they differ in. Images an import groups as duplicates are at
+// distance 0, or within a perceptual policy's threshold; sizes are only
+// meaningful at distance 0.
+func CompareHashes(a, b Hash) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
@@ -0,0 +1,188 @@
+package imagedup
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// htmlHighlights is how many entries each section of the HTML report lists
+// before summarizing the rest as a count
+const htmlHighlights = 10
+
+// htmlReport is what the HTML report template renders
+type htmlReport struct {
+	Source, Dest, RunID string
+	Finished            string
+
+	Classes  []htmlClass
+	Archived int
+	Deferred int
+	Failed   htmlList
+
+	Groups     []htmlGroup
+	MoreGroups int
+	Large      []string
+
+	Collisions, Damaged, LowResolution, MtimeDated, Ambiguous htmlList
+}
+
+// htmlClass is one class's line of the summary
+type htmlClass struct {
+	Label                       string
+	Processed, Duplicates, Kept int
+}
+
+// htmlGroup is a duplicate group, with its files relative to the source
+// and the keeper's thumbnail as a data URL, if any
+type htmlGroup struct {
+	Keeper    string
+	Members   []string
+	Thumbnail template.URL
+}
+
+// htmlList is a section's first files, and how many more there are
+type htmlList struct {
+	Files []string
+	More  int
+}
+
+// htmlReportTemplate is a single self-contained page, readable on a phone
+// and as an email attachment, so it links nothing and embeds its style
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Import of {{.Source}}</title>
+<style>
+body { font: 15px/1.4 system-ui, sans-serif; margin: 1em; max-width: 48em; color: #222; }
+h1 { font-size: 1.2em; } h2 { font-size: 1.05em; margin-top: 1.5em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .3em .5em; border-bottom: 1px solid #ddd; }
+td.n, th.n { text-align: right; }
+ul { padding-left: 1.2em; } li { word-break: break-all; }
+.meta, .more { color: #666; font-size: .9em; }
+.warn { color: #a40; }
+img { float: right; max-width: 6em; max-height: 6em; margin-left: .5em; }
+.group { overflow: hidden; }
+</style>
+</head>
+<body>
+<h1>{{.Source}} &rarr; {{.Dest}}</h1>
+<p class="meta">Run {{.RunID}}, finished {{.Finished}}</p>
+<table>
+<tr><th></th><th class="n">Processed</th><th class="n">Duplicates</th><th class="n">Copied</th></tr>
+{{range .Classes}}<tr><td>{{.Label}}</td><td class="n">{{.Processed}}</td><td class="n">{{.Duplicates}}</td><td class="n">{{.Kept}}</td></tr>
+{{end}}</table>
+{{if .Archived}}<p>{{.Archived}} files were already archived by an earlier run.</p>{{end}}
+{{if .Deferred}}<p>{{.Deferred}} files are left for the next run.</p>{{end}}
+{{with .Failed}}{{if .Files}}<h2 class="warn">Failed to copy</h2>{{template "list" .}}{{end}}{{end}}
+{{if .Groups}}<h2>Largest duplicate groups</h2>
+{{range .Groups}}<div class="group">{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="">{{end}}<p><b>{{.Keeper}}</b> kept over</p><ul>{{range .Members}}<li>{{.}}</li>{{end}}</ul></div>
+{{end}}{{if .MoreGroups}}<p class="more">and {{.MoreGroups}} more groups</p>{{end}}{{end}}
+{{if .Large}}<h2 class="warn">Unusually large duplicate groups</h2><p>Check these before deleting anything.</p><ul>{{range .Large}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{with .Collisions}}{{if .Files}}<h2 class="warn">Kept despite sharing a hash</h2>{{template "list" .}}{{end}}{{end}}
+{{with .Damaged}}{{if .Files}}<h2 class="warn">Damaged images</h2>{{template "list" .}}{{end}}{{end}}
+{{with .LowResolution}}{{if .Files}}<h2>Low resolution images</h2>{{template "list" .}}{{end}}{{end}}
+{{with .Ambiguous}}{{if .Files}}<h2>Ambiguous filename dates</h2>{{template "list" .}}{{end}}{{end}}
+{{with .MtimeDated}}{{if .Files}}<h2>Dated only by modification time</h2>{{template "list" .}}{{end}}{{end}}
+</body>
+</html>
+{{define "list"}}<ul>{{range .Files}}<li>{{.}}</li>{{end}}</ul>{{if .More}}<p class="more">and {{.More}} more</p>{{end}}{{end}}
+`))
+
+// writeHTMLReport writes an applied plan's summary and the decisions most
+// worth a look to path as a compact HTML page. With thumbs set, each listed
+// duplicate group shows its keeper's thumbnail.
+func writeHTMLReport(path string, plan *Plan, result *Result, now time.Time, thumbs *Thumbnailer) error {
+	report := result.Report
+	rel := func(file string) string {
+		if r, err := filepath.Rel(plan.Source, file); err == nil && !filepath.IsAbs(r) {
+			return filepath.ToSlash(r)
+		}
+		return file
+	}
+	list := func(files []string) htmlList {
+		var l htmlList
+		for i, file := range files {
+			if i == htmlHighlights {
+				l.More = len(files) - i
+				break
+			}
+			l.Files = append(l.Files, rel(file))
+		}
+		return l
+	}
+
+	page := htmlReport{
+		Source:   plan.Source,
+		Dest:     plan.Dest,
+		RunID:    plan.RunID,
+		Finished: now.Format("2006-01-02 15:04"),
+		Deferred: len(result.Deferred),
+		Failed:   list(result.Failed),
+
+		Damaged:       list(report.Damaged),
+		LowResolution: list(report.LowResolution),
+		MtimeDated:    list(report.MtimeDated),
+	}
+	for _, class := range []struct{ name, label string }{{"image", "Images"}, {"raw", "RAW files"}, {"video", "Videos"}} {
+		copied := result.Copied[class.name]
+		page.Classes = append(page.Classes, htmlClass{
+			Label:      class.label,
+			Processed:  plan.Counts[class.name],
+			Duplicates: plan.Counts[class.name] - copied - result.Archived[class.name] - result.deferred(class.name),
+			Kept:       copied,
+		})
+		page.Archived += result.Archived[class.name]
+	}
+
+	groups := append([]DuplicateGroup(nil), report.Groups...)
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].Members) > len(groups[j].Members) })
+	for i, group := range groups {
+		if i == htmlHighlights {
+			page.MoreGroups = len(groups) - i
+			break
+		}
+		g := htmlGroup{Keeper: rel(group.Keeper)}
+		if thumbs != nil {
+			if thumb, err := thumbs.Thumbnail(group.Keeper); err == nil {
+				g.Thumbnail = template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(thumb))
+			}
+		}
+		for _, member := range group.Members {
+			g.Members = append(g.Members, rel(member.File))
+		}
+		page.Groups = append(page.Groups, g)
+	}
+
+	for _, large := range report.LargeGroups {
+		page.Large = append(page.Large, fmt.Sprintf("%s: %d duplicates, %d byte-identical", rel(large.Keeper), large.Members, large.Exact))
+	}
+
+	var collided, ambiguous []string
+	for _, c := range report.Collisions {
+		if c.Kept {
+			collided = append(collided, c.File)
+		}
+	}
+	for _, a := range report.AmbiguousDates {
+		ambiguous = append(ambiguous, a.File)
+	}
+	page.Collisions, page.Ambiguous = list(collided), list(ambiguous)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := htmlReportTemplate.Execute(f, page); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,42 @@
+package imagedup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+// ErrNotIdempotent is returned by a run checking idempotency when planning
+// the same source again would still copy files
+var ErrNotIdempotent = errors.New("import is not idempotent")
+
+// checkIdempotent plans an applied index again, as a second run over the
+// same source would, and fails if anything but the files that failed to
+// copy would be copied again. Files already in the archive must all be
+// recognized by checksum.
+func (o *Organizer) checkIdempotent(index *Index, result *Result) error {
+	again := *o
+	again.opts.Output = io.Discard
+	again.opts.Status = nil
+	plan, err := again.Plan(index)
+	if err != nil {
+		return err
+	}
+	failed := make(map[string]bool)
+	for _, file := range result.Failed {
+		failed[file] = true
+	}
+	recopied := 0
+	for _, item := range plan.Items {
+		if !failed[item.Source] {
+			log.Printf("A second run would copy %s again", item.Source)
+			recopied++
+		}
+	}
+	if recopied > 0 {
+		return fmt.Errorf("%w: a second run would copy %d files again", ErrNotIdempotent, recopied)
+	}
+	fmt.Fprintf(o.opts.Output, "Idempotency check passed: a second run would copy nothing (%d files already archived)\n", len(plan.Archived))
+	return nil
+}
@@ -0,0 +1,36 @@
+package imagedup
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+)
+
+// IDSource supplies the unique suffix of run IDs, which follows the run's
+// start time
+type IDSource interface {
+	NewID() string
+}
+
+// randomIDs are eight random hex digits, so concurrent runs can't collide
+type randomIDs struct{}
+
+func (randomIDs) NewID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return hex.EncodeToString(suffix)
+}
+
+// RandomIDs is the default IDSource
+var RandomIDs IDSource = randomIDs{}
+
+// Counter is an IDSource numbering IDs 00000001, 00000002, ... for tests and
+// reproducible runs
+type Counter struct {
+	n atomic.Uint64
+}
+
+func (c *Counter) NewID() string {
+	return fmt.Sprintf("%08x", c.n.Add(1))
+}
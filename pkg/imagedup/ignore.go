@@ -0,0 +1,131 @@
+package imagedup
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName is the per-directory file listing gitignore style patterns
+// of paths to exclude from processing
+const IgnoreFileName = ".ppignore"
+
+// ignoreRule is a single pattern from an ignore file, relative to the
+// directory holding that file
+type ignoreRule struct {
+	base     string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ignoreSet accumulates the rules of every ignore file seen during a walk
+type ignoreSet struct {
+	rules []ignoreRule
+}
+
+// load reads the ignore file in dir, if any
+func (s *ignoreSet) load(dir string) error {
+	f, err := openReadOnly(filepath.Join(dir, IgnoreFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(dir, scanner.Text()); ok {
+			s.rules = append(s.rules, rule)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseIgnoreLine parses one line of gitignore syntax
+func parseIgnoreLine(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	rule.pattern = line
+	return rule, true
+}
+
+// ignored reports whether path is excluded. As in git, the last matching
+// rule wins so later ! patterns can re-include paths.
+func (s *ignoreSet) ignored(filePath string, isDir bool) bool {
+	ignored := false
+	for _, rule := range s.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(rule.base, filePath)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+
+		var matched bool
+		if rule.anchored {
+			matched = matchGlob(rule.pattern, rel)
+		} else {
+			matched = matchGlob(rule.pattern, path.Base(rel))
+		}
+		if matched {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchGlob matches a slash separated path against a pattern where ** spans
+// any number of directories
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
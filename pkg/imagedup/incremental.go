@@ -0,0 +1,80 @@
+package imagedup
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SourcesFileName is the file at the destination root recording when each
+// source last completed a run, for incremental runs
+const SourcesFileName = "sources.json"
+
+// mtimeSlack is taken off the last run's start before comparing it with
+// modification times, covering file systems that store them coarsely (FAT
+// keeps two second resolution). Files seen twice are skipped by the ledger.
+const mtimeSlack = 2 * time.Second
+
+// sourceRun is what SourcesFileName records about one source
+type sourceRun struct {
+	// Scanned is when the last run without failures began walking it
+	Scanned time.Time `json:"scanned"`
+	RunID   string    `json:"run_id"`
+}
+
+// loadSourceRuns reads a destination's SourcesFileName, keyed by absolute
+// source path. A destination without one has no runs recorded.
+func loadSourceRuns(destDir string) (map[string]sourceRun, error) {
+	runs := make(map[string]sourceRun)
+	data, err := os.ReadFile(filepath.Join(destDir, SourcesFileName))
+	if os.IsNotExist(err) {
+		return runs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// lastSourceRun returns when the last run from srcDir into destDir that
+// copied without failures began, or the zero time if there was none
+func lastSourceRun(destDir, srcDir string) (time.Time, error) {
+	runs, err := loadSourceRuns(destDir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return runs[absPath(srcDir)].Scanned, nil
+}
+
+// recordSourceRun records a completed run from srcDir into destDir
+func recordSourceRun(destDir, srcDir string, scanned time.Time, runID string) error {
+	runs, err := loadSourceRuns(destDir)
+	if err != nil {
+		return err
+	}
+	runs[absPath(srcDir)] = sourceRun{Scanned: scanned.UTC(), RunID: runID}
+	data, err := json.MarshalIndent(runs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(destDir, SourcesFileName+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(destDir, SourcesFileName))
+}
+
+// modifiedSince reports whether a file passes the NewerThan option
+func (o Options) modifiedSince(info os.FileInfo) bool {
+	return o.NewerThan.IsZero() || info.ModTime().After(o.NewerThan)
+}
+
+// partial reports whether a run only looks at part of its source, so its
+// completion says nothing about the rest
+func (o Options) partial() bool {
+	return o.FileList != nil || len(o.OnlyPaths) > 0 || o.Since != "" || o.Until != "" || !o.NewerThan.IsZero()
+}
@@ -0,0 +1,65 @@
+package imagedup
+
+import (
+	"log"
+	"sort"
+)
+
+// DefaultLargeGroup is how many duplicates a keeper may have before its
+// group is reported as unusually large
+const DefaultLargeGroup = 50
+
+// LargeGroup records a duplicate group with more members than the
+// LargeGroup option allows. Groups that large are rarely real: blank or
+// single-colour images all share a hash, and a backup copied into itself
+// repeats every file many times over. Flat reports whether the keeper's
+// hash is all zeros or all ones, as flat images hash; Exact counts the
+// members byte-identical to the keeper.
+type LargeGroup struct {
+	Keeper  string `json:"keeper"`
+	Members int    `json:"members"`
+	Exact   int    `json:"exact"`
+	Flat    bool   `json:"flat,omitempty"`
+}
+
+// largeGroups finds the report's duplicate groups of more than limit
+// members besides the keeper, largest first, warning about each
+func largeGroups(report *Report, files []imageInfo, limit int) []LargeGroup {
+	if limit <= 0 {
+		return nil
+	}
+	hashes := make(map[string]uint64, len(files))
+	for _, fileInfo := range files {
+		if mediaClass(fileInfo.filename) == "image" {
+			hashes[fileInfo.filename] = fileInfo.hash
+		}
+	}
+
+	var large []LargeGroup
+	for _, group := range report.Groups {
+		if len(group.Members) <= limit {
+			continue
+		}
+		g := LargeGroup{Keeper: group.Keeper, Members: len(group.Members)}
+		for _, member := range group.Members {
+			if member.Exact {
+				g.Exact++
+			}
+		}
+		if hash, ok := hashes[group.Keeper]; ok && (hash == 0 || hash == ^uint64(0)) {
+			g.Flat = true
+		}
+		large = append(large, g)
+
+		switch {
+		case g.Flat:
+			log.Printf("Warning: %s and %d other files share a hash, as blank or single-colour images do; check them before deleting any, or use -strict", g.Keeper, g.Members)
+		case g.Exact == g.Members:
+			log.Printf("Warning: %d byte-identical copies of %s found; a backup may have been copied into itself", g.Members, g.Keeper)
+		default:
+			log.Printf("Warning: %s has %d duplicates; check them before deleting any", g.Keeper, g.Members)
+		}
+	}
+	sort.SliceStable(large, func(i, j int) bool { return large[i].Members > large[j].Members })
+	return large
+}
@@ -0,0 +1,179 @@
+package imagedup
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/dateutil"
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+)
+
+// Layout is a template for destination folders, such as "{year}/{month}".
+// Folders are separated by "/" whatever the platform.
+type Layout string
+
+// DefaultLayout files everything into one folder per capture date
+const DefaultLayout Layout = "{date}"
+
+// layoutFields are the values a layout's tokens expand to. clock is the time
+// of day as 150405, or "" when it is unknown, width and height an image's
+// size in pixels, or zero, parent the name of its source folder and event
+// the name of the event it was taken at.
+type layoutFields struct {
+	date  string
+	clock string
+
+	width, height int
+	parent, event string
+}
+
+// layoutTokens expands each token supported in layouts
+var layoutTokens = map[string]func(layoutFields) string{
+	"date":   func(f layoutFields) string { return f.date },
+	"year":   func(f layoutFields) string { return datePart(f.date, 0, 4) },
+	"month":  func(f layoutFields) string { return datePart(f.date, 5, 7) },
+	"day":    func(f layoutFields) string { return datePart(f.date, 8, 10) },
+	"hour":   func(f layoutFields) string { return clockPart(f.clock, 0) },
+	"minute": func(f layoutFields) string { return clockPart(f.clock, 2) },
+	"second": func(f layoutFields) string { return clockPart(f.clock, 4) },
+
+	"width":      func(f layoutFields) string { return dimension(f.width, strconv.Itoa(f.width)) },
+	"height":     func(f layoutFields) string { return dimension(f.height, strconv.Itoa(f.height)) },
+	"megapixels": func(f layoutFields) string { return dimension(f.width, formatMegapixels(f.width, f.height)) },
+	"resolution": func(f layoutFields) string { return dimension(f.width, fmt.Sprintf("%dx%d", f.width, f.height)) },
+
+	"parent": func(f layoutFields) string { return f.parent },
+	"event":  func(f layoutFields) string { return f.event },
+}
+
+// timestampFields returns the layout fields of a capture time
+func timestampFields(t dateutil.Timestamp) layoutFields {
+	f := layoutFields{date: t.Date()}
+	if t.Clock {
+		f.clock = t.Time.Format("150405")
+	}
+	return f
+}
+
+// fileFields returns the layout fields of a file being imported from srcDir
+func fileFields(fileInfo imageInfo, srcDir string, events []Event) layoutFields {
+	f := timestampFields(fileInfo.taken)
+	f.width, f.height = fileInfo.width, fileInfo.height
+	f.parent = parentName(fileInfo.filename, srcDir)
+	f.event = eventName(events, f.date)
+	return f
+}
+
+// entryFields returns the layout fields of an archived file
+func entryFields(e manifest.Entry, events []Event) layoutFields {
+	f := layoutFields{date: e.Date, width: e.Width, height: e.Height}
+	f.event = eventName(events, e.Date)
+	source := e.Source
+	if !filepath.IsAbs(source) && e.SourceRoot != "" {
+		source = filepath.Join(e.SourceRoot, source)
+	}
+	f.parent = parentName(source, e.SourceRoot)
+	if e.Taken != nil {
+		f.clock = e.Taken.Format("150405")
+	}
+	return f
+}
+
+// layoutToken matches a {token} in a layout
+var layoutToken = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// emptyTokens match the {parent} and {event} tokens with the separator
+// joining them to the rest of a folder name, dropped for files with no
+// parent or event to name
+var emptyTokens = map[string]*regexp.Regexp{
+	"parent": regexp.MustCompile(`[-_ ]\{parent\}|\{parent\}[-_ ]?`),
+	"event":  regexp.MustCompile(`[-_ ]\{event\}|\{event\}[-_ ]?`),
+}
+
+// labelSpaces matches the runs of white space replaced in folder labels
+var labelSpaces = regexp.MustCompile(`\s+`)
+
+// ParseLayout validates a layout template
+func ParseLayout(tmpl string) (Layout, error) {
+	if strings.TrimSpace(tmpl) == "" {
+		return "", fmt.Errorf("empty layout")
+	}
+	for _, m := range layoutToken.FindAllStringSubmatch(tmpl, -1) {
+		if _, ok := layoutTokens[m[1]]; !ok {
+			return "", fmt.Errorf("unknown layout token {%s}", m[1])
+		}
+	}
+	clean := path.Clean(tmpl)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("layout %q must stay inside the destination", tmpl)
+	}
+	return Layout(tmpl), nil
+}
+
+// folder expands the layout into a destination folder, relative to the root
+func (l Layout) folder(f layoutFields) string {
+	tmpl := string(l)
+	if f.parent == "" {
+		tmpl = emptyTokens["parent"].ReplaceAllString(tmpl, "")
+	}
+	if f.event == "" {
+		tmpl = emptyTokens["event"].ReplaceAllString(tmpl, "")
+	}
+	expanded := layoutToken.ReplaceAllStringFunc(tmpl, func(token string) string {
+		return layoutTokens[strings.Trim(token, "{}")](f)
+	})
+	return filepath.FromSlash(path.Clean(expanded))
+}
+
+// clockPart returns two digits of a 150405 clock from offset, or "00" when
+// the time of day is unknown
+func clockPart(clock string, from int) string {
+	if len(clock) < from+2 {
+		return "00"
+	}
+	return clock[from : from+2]
+}
+
+// parentName returns the name of the folder a source file is in, with white
+// space turned into dashes, for the {parent} token. Files directly in the
+// source root, and folders named only by numbers, such as 2018 or
+// 2018-06-03, have none.
+func parentName(file, srcDir string) string {
+	dir := filepath.Dir(file)
+	if srcDir != "" && absPath(dir) == absPath(srcDir) {
+		return ""
+	}
+	name := filepath.Base(dir)
+	if !strings.ContainsFunc(name, unicode.IsLetter) {
+		return ""
+	}
+	return folderLabel(name)
+}
+
+// folderLabel turns a name into part of a folder name, with white space
+// turned into dashes
+func folderLabel(name string) string {
+	return strings.Trim(labelSpaces.ReplaceAllString(strings.TrimSpace(name), "-"), ".")
+}
+
+// dimension returns value, or "unknown" for files whose size in pixels
+// isn't known, such as videos and RAW files
+func dimension(pixels int, value string) string {
+	if pixels <= 0 {
+		return "unknown"
+	}
+	return value
+}
+
+// datePart slices an ISO date, returning the whole date if it is too short
+func datePart(date string, from, to int) string {
+	if len(date) < to {
+		return date
+	}
+	return date[from:to]
+}
@@ -0,0 +1,57 @@
+package imagedup
+
+import (
+	"log"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+)
+
+// alreadyArchived splits off the files whose content the destination's
+// ledger says was imported before, by an earlier run from any source. Only
+// files matching the size of a ledger entry are checksummed.
+func alreadyArchived(files []imageInfo, ledger *manifest.Ledger, report *Report) (kept []imageInfo, archived []string) {
+	if ledger == nil || ledger.Len() == 0 {
+		return files, nil
+	}
+	for _, fileInfo := range files {
+		if !ledger.HasSize(fileInfo.size) {
+			kept = append(kept, fileInfo)
+			continue
+		}
+		sum, err := fileChecksum(fileInfo.filename)
+		if err != nil {
+			log.Printf("Failed to checksum %s: %v", fileInfo.filename, err)
+			kept = append(kept, fileInfo)
+			continue
+		}
+		e, ok := ledger.Lookup(sum)
+		if !ok {
+			kept = append(kept, fileInfo)
+			continue
+		}
+		archived = append(archived, fileInfo.filename)
+		report.addArchived(fileInfo.filename, e.Path)
+	}
+	return kept, archived
+}
+
+// openLedger loads the ledger of a destination for a run to record the
+// files it stores in, adding any an interrupted run of the same ID stored
+// without reaching the ledger
+func openLedger(dest *destination, runID string) (*manifest.Ledger, error) {
+	ledger, err := manifest.LoadLedger(dest.root)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range dest.manifest.Sorted() {
+		if e.RunID == runID {
+			ledger.Add(ledgerEntry(e))
+		}
+	}
+	return ledger, nil
+}
+
+// ledgerEntry returns the ledger entry of a manifest entry
+func ledgerEntry(e manifest.Entry) manifest.LedgerEntry {
+	return manifest.LedgerEntry{SHA256: e.SHA256, Size: e.Size, Path: e.Path, RunID: e.RunID, ImportedAt: e.ImportedAt}
+}
@@ -0,0 +1,104 @@
+package imagedup
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+)
+
+// checksumQuery matches a SHA-256, or an abbreviation of at least 8 digits
+var checksumQuery = regexp.MustCompile(`^[0-9a-f]{8,64}$`)
+
+// Location is an archived file found by Locate, with how it was matched:
+// "source" for its recorded source path, "index" for an index.json origin
+// and "checksum" for its content
+type Location struct {
+	Path  string `json:"path"`
+	Match string `json:"match"`
+}
+
+// Locate finds where a source file ended up in the archive at destDir. The
+// query is a source path, matched against the manifest's source paths and
+// the origins in index.json files, or a SHA-256 or prefix of one. When the
+// query names an existing file it is also checksummed, so copies dropped as
+// duplicates of an archived file are found too.
+func Locate(destDir, query string) ([]Location, error) {
+	m, err := manifest.Load(destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Location
+	seen := make(map[string]bool)
+	add := func(p, match string) {
+		if !seen[p] {
+			seen[p] = true
+			found = append(found, Location{Path: p, Match: match})
+		}
+	}
+
+	if checksumQuery.MatchString(query) && !exists(query) {
+		for _, e := range m.Sorted() {
+			if strings.HasPrefix(e.SHA256, query) {
+				add(e.Path, "checksum")
+			}
+		}
+		return found, nil
+	}
+
+	abs, err := filepath.Abs(query)
+	if err != nil {
+		abs = query
+	}
+	var folders []string
+	for _, e := range m.Sorted() {
+		if len(folders) == 0 || folders[len(folders)-1] != path.Dir(e.Path) {
+			folders = append(folders, path.Dir(e.Path))
+		}
+		if e.Source == query || absPath(e.Source) == abs {
+			add(e.Path, "source")
+		}
+	}
+
+	// Index origins are relative to an import's source root, which older
+	// manifests don't record, so match them as trailing path components
+	slashed := filepath.ToSlash(abs)
+	for _, folder := range folders {
+		for origin, name := range readIndexJSON(filepath.Join(destDir, filepath.FromSlash(folder))) {
+			origin = filepath.ToSlash(origin)
+			if strings.HasPrefix(origin, unknownOrigin) {
+				continue
+			}
+			if slashed == origin || strings.HasSuffix(slashed, "/"+origin) {
+				add(path.Join(folder, name), "index")
+			}
+		}
+	}
+
+	if info, err := os.Stat(query); err == nil && info.Mode().IsRegular() {
+		sum, err := fileChecksum(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range m.Sorted() {
+			if e.SHA256 == sum {
+				add(e.Path, "checksum")
+			}
+		}
+	}
+	return found, nil
+}
+
+// absPath returns the absolute form of a path, or the path itself if that
+// can't be determined
+func absPath(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	return abs
+}
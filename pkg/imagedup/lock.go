@@ -0,0 +1,153 @@
+package imagedup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LockFileName is the lock file held in a destination while it is written
+const LockFileName = ".dedup.lock"
+
+// ErrLocked is returned when another run holds a destination's lock
+var ErrLocked = errors.New("destination is locked by another run")
+
+// lockPoll is how often a run waiting for a lock tries again
+const lockPoll = time.Second
+
+// errLockHeld is returned by lockFile when another process holds the lock
+var errLockHeld = errors.New("lock held")
+
+// lockOwner is what a lock file records about the run holding it
+type lockOwner struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+}
+
+// Lock is a held destination lock
+type Lock struct {
+	path string
+	f    *os.File
+	once sync.Once
+}
+
+// LockDestination takes the lock of a destination, so two runs never write
+// its counters, index.json files or manifest at once. It waits up to wait
+// for another run to finish, then fails with ErrLocked. The lock is an
+// operating system lock on the lock file, which is released when its
+// process exits, so a crashed run never leaves the destination locked; the
+// file only records who holds it, for the error message.
+func LockDestination(dir string, wait time.Duration) (*Lock, error) {
+	path := filepath.Join(dir, LockFileName)
+	host, _ := os.Hostname()
+	owner, err := json.Marshal(lockOwner{PID: os.Getpid(), Host: host, Started: time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(wait)
+	waiting := false
+	for {
+		l, err := tryLock(path, owner)
+		if l != nil || err != nil {
+			return l, err
+		}
+		holder := lockHolder(path)
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("%w: %s holds %s", ErrLocked, holder, path)
+		}
+		if !waiting {
+			log.Printf("Waiting for %s to release %s", holder, path)
+			waiting = true
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// tryLock takes the lock file's lock and records owner in it, or returns
+// nil without an error while another run holds it
+func tryLock(path string, owner []byte) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if errors.Is(err, errLockHeld) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	// What a crashed holder recorded is replaced
+	if err := f.Truncate(0); err == nil {
+		_, err = f.WriteAt(owner, 0)
+	}
+	if err != nil {
+		unlockFile(f)
+		f.Close()
+		return nil, err
+	}
+	return &Lock{path: path, f: f}, nil
+}
+
+// lockHolder describes the run holding a lock file from what it recorded
+func lockHolder(path string) string {
+	var owner lockOwner
+	data, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &owner) != nil {
+		// A lock being written
+		return "another run"
+	}
+	return fmt.Sprintf("process %d on %s, started %s", owner.PID, owner.Host, owner.Started.Local().Format(time.DateTime))
+}
+
+// Release gives up the lock. The lock file is emptied but stays, as
+// removing it could let a run waiting on the old file and one creating a
+// new file both take the lock.
+func (l *Lock) Release() error {
+	var err error
+	l.once.Do(func() {
+		err = l.f.Truncate(0)
+		if uerr := unlockFile(l.f); err == nil {
+			err = uerr
+		}
+		if cerr := l.f.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
+
+// lockDestinations creates and locks every directory, in order, releasing
+// those taken if one can't be
+func lockDestinations(dirs []string, wait time.Duration) ([]*Lock, error) {
+	var locks []*Lock
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			releaseLocks(locks)
+			return nil, err
+		}
+		l, err := LockDestination(dir, wait)
+		if err != nil {
+			releaseLocks(locks)
+			return nil, err
+		}
+		locks = append(locks, l)
+	}
+	return locks, nil
+}
+
+// releaseLocks releases locks, logging failures
+func releaseLocks(locks []*Lock) {
+	for _, l := range locks {
+		if err := l.Release(); err != nil {
+			log.Printf("Failed to release lock %s: %v", l.path, err)
+		}
+	}
+}
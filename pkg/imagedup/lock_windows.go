@@ -0,0 +1,50 @@
+//go:build windows
+
+package imagedup
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	// errorLockViolation is ERROR_LOCK_VIOLATION
+	errorLockViolation syscall.Errno = 33
+)
+
+// lockRange is the byte locked, far beyond the owner record: Windows locks
+// are mandatory, so locking the record would stop others reading it
+var lockRange = syscall.Overlapped{OffsetHigh: 1}
+
+// lockFile takes an exclusive lock on an open file without waiting, failing
+// with errLockHeld while another process holds it
+func lockFile(f *os.File) error {
+	ol := lockRange
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation || err == syscall.ERROR_IO_PENDING {
+		return errLockHeld
+	}
+	return err
+}
+
+// unlockFile releases a lock taken by lockFile
+func unlockFile(f *os.File) error {
+	ol := lockRange
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
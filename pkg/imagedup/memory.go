@@ -0,0 +1,140 @@
+package imagedup
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// heapMetric is the runtime metric for memory occupied by live and
+// not-yet-collected heap objects
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// memoryGate throttles concurrent decodes to keep the heap under a limit.
+// Concurrency drops by one each time a decode finishes with the heap over
+// the limit and climbs back while it stays below 70% of it. A file whose
+// decoded size alone would overrun the limit waits to be decoded on its own.
+// A nil *memoryGate never throttles.
+type memoryGate struct {
+	limit   uint64
+	max     int
+	mu      sync.Mutex
+	cond    *sync.Cond
+	active  int
+	allowed int
+	sample  []metrics.Sample
+}
+
+// newMemoryGate returns a gate for up to workers concurrent decodes, or nil
+// when limit is zero. It also sets the Go runtime's soft memory limit, so the
+// garbage collector works harder as the heap nears it.
+func newMemoryGate(limit int64, workers int) *memoryGate {
+	if limit <= 0 {
+		return nil
+	}
+	debug.SetMemoryLimit(limit)
+	g := &memoryGate{
+		limit:   uint64(limit),
+		max:     workers,
+		allowed: workers,
+		sample:  []metrics.Sample{{Name: heapMetric}},
+	}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// wrap returns decode throttled by the gate
+func (g *memoryGate) wrap(decode decodeFunc) decodeFunc {
+	if g == nil {
+		return decode
+	}
+	return func(filePath string) (image.Image, error) {
+		g.acquire(decodedSize(filePath))
+		defer g.release()
+		return decode(filePath)
+	}
+}
+
+// acquire waits for a decode slot with room for need more bytes
+func (g *memoryGate) acquire(need uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.active >= g.allowed || (g.active > 0 && g.heap()+need > g.limit) {
+		g.cond.Wait()
+	}
+	g.active++
+}
+
+// release frees a decode slot and adapts concurrency to the heap size
+func (g *memoryGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	switch heap := g.heap(); {
+	case heap > g.limit && g.allowed > 1:
+		g.allowed--
+		log.Printf("Heap at %d MB is over the memory limit, reducing decode concurrency to %d", heap>>20, g.allowed)
+	case heap < g.limit/10*7 && g.allowed < g.max:
+		g.allowed++
+	}
+	g.cond.Broadcast()
+}
+
+// heap reads the current heap size; callers hold g.mu
+func (g *memoryGate) heap() uint64 {
+	metrics.Read(g.sample)
+	return g.sample[0].Value.Uint64()
+}
+
+// decodedSize estimates the memory a decoded image needs from its header,
+// returning 0 when the format can't be sniffed
+func decodedSize(filePath string) uint64 {
+	f, err := openReadOnly(filePath)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0
+	}
+	return uint64(cfg.Width) * uint64(cfg.Height) * 4
+}
+
+// ParseSize parses a byte size such as 512M, 2G or 1.5GiB. Units are binary
+// and an optional trailing B or iB is ignored; a bare number is in bytes.
+func ParseSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "B"), "I")
+	mult := int64(1)
+	if n := len(v); n > 0 {
+		if i := strings.IndexByte("KMGT", v[n-1]); i >= 0 {
+			mult = 1 << (10 * (i + 1))
+			v = v[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// FormatSize formats a byte size in the binary units ParseSize accepts
+func FormatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	f := float64(n)
+	unit := -1
+	for f >= 1024 && unit < len("KMGT")-1 {
+		f /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%c", f, "KMGT"[unit])
+}
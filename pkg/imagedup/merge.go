@@ -0,0 +1,200 @@
+package imagedup
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+	"github.com/gavinmcnair/pictureprocess/pkg/parity"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// mergeSaveInterval is how many copied files pass between manifest saves
+// while merging, bounding the work lost to an interruption
+const mergeSaveInterval = 100
+
+// MergeResult summarizes a merge of one archive into another
+type MergeResult struct {
+	// Copied counts files copied into the archive
+	Copied int
+	// Duplicates counts files already present with the same checksum
+	Duplicates int
+	// Renamed counts copies that took a new name to avoid a collision
+	Renamed int
+}
+
+// Merge copies every file of the archive at fromDir into the archive at
+// intoDir, leaving fromDir untouched. Files already in intoDir with the same
+// checksum are skipped as duplicates, though their origin is still recorded
+// in the keeper's index.json. Copies keep their folders and names, except
+// that colliding names are renumbered or suffixed as by Reorganize. Index
+// entries that would clash with a different file's origin are prefixed with
+// the merged archive's name. Running it again after an interruption skips
+// whatever was already copied.
+func Merge(intoDir, fromDir string) (*MergeResult, error) {
+	lock, err := LockDestination(intoDir, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	into, err := manifest.Load(intoDir)
+	if err != nil {
+		return nil, err
+	}
+	from, err := manifest.Load(fromDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(from.Entries) == 0 {
+		return nil, fmt.Errorf("no manifest entries found in %s; run reindex there first", fromDir)
+	}
+
+	bySum := make(map[string]string)
+	taken := newTakenNames()
+	for _, e := range into.Sorted() {
+		bySum[e.SHA256] = e.Path
+		taken.add(e.Path)
+	}
+
+	result := &MergeResult{}
+	touched := make(map[string]string)
+	fromIndexes := make(map[string]map[string][]string)
+	prefix := filepath.Base(filepath.Clean(fromDir))
+	for _, e := range from.Sorted() {
+		folder := path.Dir(e.Path)
+		if _, loaded := fromIndexes[folder]; !loaded {
+			fromIndexes[folder] = indexOrigins(filepath.Join(fromDir, filepath.FromSlash(folder)))
+		}
+		origins := fromIndexes[folder][path.Base(e.Path)]
+
+		if existing, dup := bySum[e.SHA256]; dup {
+			result.Duplicates++
+			if keeper := into.Entries[existing]; keeper.AddTags(e.Tags...) {
+				into.Add(keeper)
+			}
+			for _, origin := range origins {
+				recordOrigin(intoDir, existing, origin, prefix)
+			}
+			continue
+		}
+
+		to := e.Path
+		renamed := false
+		for taken.taken(to) || exists(filepath.Join(intoDir, filepath.FromSlash(to))) {
+			taken.add(to)
+			to = path.Join(folder, taken.free(folder, path.Base(e.Path)))
+			renamed = true
+		}
+		if renamed {
+			result.Renamed++
+		}
+		if err := copyArchived(fromDir, intoDir, e, to); err != nil {
+			log.Printf("Failed to merge %s: %v", e.Path, err)
+			continue
+		}
+
+		entry := e
+		entry.Path = to
+		entry.VerifiedAt = nil
+		into.Add(entry)
+		bySum[e.SHA256] = to
+		taken.add(to)
+
+		// Every copy needs an index entry so later imports don't reuse its name
+		if len(origins) == 0 {
+			origins = []string{unknownOrigin + path.Base(to)}
+		}
+		for _, origin := range origins {
+			recordOrigin(intoDir, to, origin, prefix)
+		}
+		if _, ok := touched[path.Dir(to)]; !ok {
+			touched[path.Dir(to)] = parity.Method(filepath.Join(fromDir, filepath.FromSlash(folder)))
+		}
+
+		result.Copied++
+		if result.Copied%mergeSaveInterval == 0 {
+			if err := into.Save(intoDir); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := into.Save(intoDir); err != nil {
+		return nil, err
+	}
+	mergeParity(intoDir, into, touched)
+	return result, nil
+}
+
+// copyArchived copies an archived file and its AppleDouble fork between
+// archives, checking plain copies against the manifest checksum
+func copyArchived(fromDir, intoDir string, e manifest.Entry, to string) error {
+	src := filepath.Join(fromDir, filepath.FromSlash(e.Path))
+	dst := filepath.Join(intoDir, filepath.FromSlash(to))
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	sum, _, err := copyFileChecksum(src, dst)
+	if err != nil {
+		return err
+	}
+	if e.Encryption == "" && e.Transform == "" && sum != e.SHA256 {
+		os.Remove(dst)
+		return fmt.Errorf("checksum mismatch, run fsck on %s", fromDir)
+	}
+
+	fork := filepath.Join(filepath.Dir(src), "._"+filepath.Base(src))
+	if exists(fork) {
+		if err := copyFile(fork, filepath.Join(filepath.Dir(dst), "._"+filepath.Base(dst))); err != nil {
+			log.Printf("Failed to copy AppleDouble file %s: %v", fork, err)
+		}
+	}
+	return nil
+}
+
+// recordOrigin maps an origin to an archived file in its folder's index.json,
+// prefixing the origin when it already names a different file there
+func recordOrigin(intoDir, archived, origin, prefix string) {
+	dir := filepath.Join(intoDir, filepath.FromSlash(path.Dir(archived)))
+	name := path.Base(archived)
+	if current, ok := readIndexJSON(dir)[origin]; ok && current != name {
+		origin = filepath.Join(prefix, origin)
+	}
+	if err := writeIndexJSON(dir, map[string]string{origin: name}); err != nil {
+		log.Printf("Failed to write index.json in %s: %v", dir, err)
+	}
+}
+
+// mergeParity regenerates parity in folders that received files, using the
+// method already protecting the folder or else the one protecting the
+// merged files' original folder
+func mergeParity(intoDir string, m *manifest.Manifest, touched map[string]string) {
+	names := make(map[string][]string)
+	for _, e := range m.Sorted() {
+		names[path.Dir(e.Path)] = append(names[path.Dir(e.Path)], path.Base(e.Path))
+	}
+	tl := tools.Detect(tools.Paths{})
+	for folder, fromMethod := range touched {
+		dir := filepath.Join(intoDir, filepath.FromSlash(folder))
+		method := parity.Method(dir)
+		if method == "" {
+			method = fromMethod
+		}
+		if method == "" {
+			continue
+		}
+		if err := parity.Create(dir, names[folder], method, tl); err != nil {
+			log.Printf("Failed to write parity for %s: %v", dir, err)
+		}
+	}
+}
+
+// exists reports whether a path exists
+func exists(p string) bool {
+	_, err := os.Lstat(p)
+	return err == nil
+}
@@ -0,0 +1,30 @@
+package imagedup
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// mirrorFolder returns the folder a file is archived in when mirroring the
+// source: the one it is in, relative to the source root. Files outside the
+// source have none.
+func mirrorFolder(srcDir, file string) (string, bool) {
+	rel, err := filepath.Rel(srcDir, filepath.Dir(file))
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return rel, true
+}
+
+// mirrorName returns the name a mirrored file keeps: its own, with ext and
+// adapted to the destination profile, or with a _1, _2 ... suffix if taken
+// already, as by a file normalizing to the same extension
+func mirrorName(source, ext string, profile DestinationProfile, taken func(name string) bool) string {
+	base := strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+	name := profile.name(base + ext)
+	for n := 1; taken(name); n++ {
+		name = profile.name(fmt.Sprintf("%s_%d%s", base, n, ext))
+	}
+	return name
+}
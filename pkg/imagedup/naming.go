@@ -0,0 +1,76 @@
+package imagedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// shortHashLen is the number of hex characters of the checksum used in names
+const shortHashLen = 8
+
+// ParseNamingPolicy validates a naming policy name
+func ParseNamingPolicy(name string) (NamingPolicy, error) {
+	switch NamingPolicy(name) {
+	case NamingCounter, NamingContentHash:
+		return NamingPolicy(name), nil
+	}
+	return "", fmt.Errorf("unknown naming policy %q", name)
+}
+
+// ParseAppleDoublePolicy validates an AppleDouble policy name
+func ParseAppleDoublePolicy(name string) (AppleDoublePolicy, error) {
+	switch AppleDoublePolicy(name) {
+	case AppleDoubleDrop, AppleDoubleMerge:
+		return AppleDoublePolicy(name), nil
+	}
+	return "", fmt.Errorf("unknown AppleDouble policy %q", name)
+}
+
+// ParseStreamPolicy validates an alternate data stream policy name
+func ParseStreamPolicy(name string) (StreamPolicy, error) {
+	switch StreamPolicy(name) {
+	case StreamsStrip, StreamsKeep:
+		return StreamPolicy(name), nil
+	}
+	return "", fmt.Errorf("unknown stream policy %q", name)
+}
+
+// contentHashName returns a deterministic <date>_<hash><ext> name for a file.
+// The hash prefix is lengthened if a different file already holds the name.
+func contentHashName(srcFile, destPath, dateStr, ext string) (string, error) {
+	sum, err := fileChecksum(srcFile)
+	if err != nil {
+		return "", err
+	}
+
+	for n := shortHashLen; n <= len(sum); n += 4 {
+		name := fmt.Sprintf("%s_%s%s", dateStr, sum[:n], ext)
+		existing := filepath.Join(destPath, name)
+		if _, err := os.Stat(existing); os.IsNotExist(err) {
+			return name, nil
+		}
+		if existingSum, err := fileChecksum(existing); err == nil && existingSum == sum {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no free content-hash name for %s", srcFile)
+}
+
+// fileChecksum returns the hex encoded SHA-256 of a file's contents
+func fileChecksum(filePath string) (string, error) {
+	f, err := openReadOnly(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
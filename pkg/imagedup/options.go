@@ -0,0 +1,440 @@
+package imagedup
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/dateutil"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// NamingPolicy selects how destination filenames are generated
+type NamingPolicy string
+
+const (
+	// NamingCounter names files 001.jpg, 002.jpg, ... per date folder
+	NamingCounter NamingPolicy = "counter"
+	// NamingContentHash names files <date>_<short sha256>.jpg
+	NamingContentHash NamingPolicy = "hash"
+)
+
+// DecoderBackend selects how images are decoded for hashing
+type DecoderBackend string
+
+const (
+	// DecoderGo decodes with the pure-Go imaging package
+	DecoderGo DecoderBackend = "go"
+	// DecoderVips shrinks images on load with libvips' vips command, which is
+	// several times faster for large JPEGs
+	DecoderVips DecoderBackend = "vips"
+)
+
+// AppleDoublePolicy selects what happens to ._ resource fork files
+type AppleDoublePolicy string
+
+const (
+	// AppleDoubleDrop deliberately leaves resource forks behind
+	AppleDoubleDrop AppleDoublePolicy = "drop"
+	// AppleDoubleMerge copies a resource fork alongside its file, renamed to
+	// match, so macOS merges them back together when reading the archive
+	AppleDoubleMerge AppleDoublePolicy = "merge"
+)
+
+// StreamPolicy selects what happens to the NTFS alternate data streams of
+// copied files
+type StreamPolicy string
+
+const (
+	// StreamsStrip leaves alternate data streams behind, such as the
+	// Zone.Identifier marking a file as downloaded
+	StreamsStrip StreamPolicy = "strip"
+	// StreamsKeep copies every alternate data stream along with its file
+	StreamsKeep StreamPolicy = "keep"
+)
+
+// KeeperPolicy selects which of a group of duplicates differing by edits is
+// archived
+type KeeperPolicy string
+
+const (
+	// KeepLargest keeps the highest rated and then the largest file
+	KeepLargest KeeperPolicy = "largest"
+	// KeepEdited prefers files showing signs of editing: an XMP sidecar,
+	// edit history or develop settings, or an editor named as the software
+	KeepEdited KeeperPolicy = "edited"
+	// KeepOriginal prefers files without any of those signs
+	KeepOriginal KeeperPolicy = "original"
+	// KeepResolution keeps the highest rated and then the highest
+	// resolution file, and only then the largest
+	KeepResolution KeeperPolicy = "resolution"
+)
+
+// Encryption configures per-file encryption for untrusted destinations
+type Encryption struct {
+	// Method is "age" or "gpg"
+	Method     string
+	Recipients []string
+
+	// Destinations lists the roots (primary or replicas) to encrypt
+	Destinations []string
+}
+
+// Options controls how ProcessFiles scans, deduplicates and copies files
+type Options struct {
+	NumWorkers int
+	Naming     NamingPolicy
+
+	// Layout is the template destination folders are named by
+	Layout Layout
+
+	// Events name the dates files were taken on, for the {event} layout
+	// token, which is appended to the last folder of a Layout without one;
+	// see ValidateEvents
+	Events []Event
+
+	// Mirror archives files in the folders they have in the source, under
+	// their own names, instead of by Layout and Naming, so only duplicates
+	// are left out
+	Mirror bool
+
+	// NormalizeExtensions names copies with the canonical lower case
+	// extension of their format, such as .jpg for .JPG and .jpeg files
+	NormalizeExtensions bool
+
+	// Profile adapts folder and file names, file sizes and modification
+	// times to the destination's file system, such as ProfileExFAT for a
+	// camera-formatted drive
+	Profile DestinationProfile
+
+	// DateOrder is how numeric filename dates that could be read more than
+	// one way are read; the other readings are listed in the report
+	DateOrder dateutil.DateOrder
+
+	// DateOverrides, when set, supplies dates by hand that take precedence
+	// over every automatic source; DateFileName sidecars apply regardless
+	DateOverrides *DateOverrides
+
+	// Periods files files dated only by modification time under the
+	// nearest source folder naming a period, such as "1980s", "1994-summer"
+	// or "1994-1996"; period labels in overrides apply regardless
+	Periods bool
+
+	// ExcludeDestination allows output locations inside the source tree,
+	// skipping them while scanning instead of refusing to run
+	ExcludeDestination bool
+
+	// FileList, when set, is processed instead of walking the source. The
+	// files must lie within the source directory.
+	FileList []string
+
+	// NewerThan, when set, skips source files not modified after it.
+	// Incremental sets it to when the last run from the same source into
+	// the destination began, if that run copied everything it planned, and
+	// records this run for the next one under the same condition; runs
+	// limited by FileList, OnlyPaths, Since, Until or a NewerThan of the
+	// caller's are never recorded.
+	NewerThan   time.Time
+	Incremental bool
+
+	// OnlyPaths, when set, restricts a run to files within these files or
+	// folders, relative to the source unless absolute
+	OnlyPaths []string
+
+	// Since and Until, when set, restrict a run to files taken within these
+	// inclusive bounds, each a year, a month (2019-07) or a full date.
+	// Duplicates are still found across the whole source, but only kept
+	// files within the range, and their duplicates, are copied or reported.
+	Since string
+	Until string
+
+	// IncludeSystemFiles disables the default filter that skips .DS_Store,
+	// Thumbs.db, ._ resource forks, @eaDir and .trashed-* files
+	IncludeSystemFiles bool
+
+	// AppleDouble selects how ._ resource forks of media files are handled
+	AppleDouble AppleDoublePolicy
+
+	// HashXattr caches the SHA-256 of every copy in its
+	// user.pictureprocess.hash extended attribute, on Linux, so reindex and
+	// fsck -quick needn't read unchanged files again
+	HashXattr bool
+
+	// Streams selects whether copies keep the NTFS alternate data streams
+	// of their sources, on Windows
+	Streams StreamPolicy
+
+	// Decoder selects the image decoding backend used for hashing
+	Decoder DecoderBackend
+
+	// Keeper selects whether duplicates differing by edits keep the edited
+	// version, the unedited original, the highest resolution, or just the
+	// best rated and largest
+	Keeper KeeperPolicy
+
+	// LowResolution lists kept images below this many megapixels in the
+	// report, if set
+	LowResolution float64
+
+	// LargeGroup warns about and reports keepers with more duplicates
+	// than this, usually flat images or a runaway backup rather than real
+	// duplicates; 0 disables it
+	LargeGroup int
+
+	// BatchHash averages images down to 8x8 grayscale tiles and hashes them in
+	// batches with a SWAR kernel. Its hashes are not comparable with those
+	// of the default path, so use one mode consistently per archive.
+	BatchHash bool
+
+	// Strict requires byte-identical checksums before two files with the
+	// same perceptual hash are treated as duplicates
+	Strict bool
+
+	// Salvage hashes and archives JPEGs whose data ends early from as much
+	// as decodes, marking them damaged, instead of skipping them
+	Salvage bool
+
+	// Collisions selects what happens to images sharing a perceptual hash
+	// with their keeper whose difference hashes disagree: kept with a
+	// warning, dropped as before, or put to Reviewer
+	Collisions CollisionPolicy
+	Reviewer   CollisionReviewer
+
+	// Policies, keyed by media class ("image", "raw" or "video"), override
+	// each class's dedup strategy; see ValidatePolicies
+	Policies map[string]ClassPolicy
+
+	// MatchTime, when non-zero, also requires capture timestamps within this
+	// tolerance before files with the same hash are collapsed, so timelapse
+	// frames that hash alike are kept
+	MatchTime time.Duration
+
+	// PregroupTime buckets images by EXIF capture timestamp before decoding,
+	// so byte-identical copies within a bucket are hashed only once
+	PregroupTime bool
+
+	// Sequences detects timelapses (long runs of consecutively numbered,
+	// alike frames taken at a steady interval) and archives them whole in a
+	// sequence folder instead of deduplicating their frames
+	Sequences bool
+
+	// Related runs a slower similarity pass over the kept images, reporting
+	// photos or screenshots of printed or displayed copies of another image
+	Related bool
+
+	// FaceDetector, when set, is run over every image; keepers of duplicate
+	// groups prefer open eyes and then more faces, and counts are recorded
+	// in the manifest
+	FaceDetector FaceDetector
+
+	// Embedder, when set, embeds every kept image so visually similar ones
+	// are grouped in the report; Similarity is the cosine similarity they
+	// must reach, defaulting to DefaultSimilarity
+	Embedder   Embedder
+	Similarity float64
+
+	// FuzzyVideo groups videos by duration, resolution and sampled frame
+	// hashes instead of file size, keeping the highest bitrate copy
+	FuzzyVideo bool
+
+	// DetectTrims compares frame sequences to find videos that are trimmed
+	// subsets of others; DropTrimmed then keeps only the full-length original
+	DetectTrims bool
+	DropTrimmed bool
+
+	// Tools configures optional external programs (ffmpeg, ffprobe,
+	// exiftool) used for richer metadata when installed
+	Tools tools.Paths
+
+	// Catalogs are Lightroom catalogs (.lrcat) or Capture One sessions
+	// whose source files are recorded with their collections in the
+	// manifest and never offered for deletion
+	Catalogs []string
+
+	// FailOnUnreadable fails the scan, before anything is copied, if any
+	// source file or folder can't be read for lack of permission, instead
+	// of skipping them and listing them under unreadable in the report
+	FailOnUnreadable bool
+
+	// Protected are source folders, relative to the source unless
+	// absolute, such as masters, whose files are always kept: each is its
+	// duplicates' keeper, none is dropped as a duplicate or trimmed copy,
+	// and none is offered for deletion
+	Protected []string
+
+	// Replicas are additional destinations that receive a copy of every
+	// unique file, with their own index.json files and manifest
+	Replicas []string
+
+	// ColdStorage, when set, is an extra destination receiving compressed
+	// copies of RAW files and videos (DNG or zstd), while the primary
+	// archive keeps the originals
+	ColdStorage string
+
+	// Derivatives produce extra trees of resized JPEG copies, such as a
+	// "sharing" tree, next to the originals
+	Derivatives []DerivativeProfile
+
+	// Encryption, when set, encrypts files written to selected destinations
+	Encryption *Encryption
+
+	// Parity, when set to "xor" or "par2", writes parity sidecars into
+	// every destination folder touched so fsck can repair damaged files
+	Parity string
+
+	// FolderSummaries writes a README.txt into every destination folder
+	// touched, summarizing its files from the manifest
+	FolderSummaries bool
+
+	// Shards splits deduplication by hash prefix into this many passes, so
+	// only one shard's groups are in memory at once. SpillDir, when set,
+	// also keeps the scan results in temporary files there until each
+	// shard is deduplicated, bounding memory for multi-million file
+	// libraries. Neither applies with Sequences or a perceptual Threshold,
+	// which compare files across shards.
+	Shards   int
+	SpillDir string
+
+	// LockWait is how long Apply waits for another run writing the same
+	// destination to finish before failing with ErrLocked
+	LockWait time.Duration
+
+	// CheckIdempotent plans the source again after copying and fails with
+	// ErrNotIdempotent if a second run would copy anything
+	CheckIdempotent bool
+
+	// Estimate stops after deduplication and reports what would be
+	// eliminated instead of copying anything
+	Estimate bool
+
+	// Verify re-reads every copy and compares its checksum with the source
+	Verify bool
+
+	// Pauser, when set, lets the caller pause and resume the run
+	Pauser *Pauser
+
+	// MaxMemory, when non-zero, caps the heap in bytes: decode concurrency is
+	// throttled while the heap is over it, and it becomes the Go runtime's
+	// soft memory limit
+	MaxMemory int64
+
+	// MaxFiles and MaxBytes, when non-zero, stop a run after copying that
+	// many files or source bytes, for migrating a huge library in chunks.
+	// Files over the limit are left for the next run.
+	MaxFiles int
+	MaxBytes int64
+
+	// Status, if set, is kept up to date with the run's progress
+	Status *Status
+
+	// DuplicateList, if set, receives the source paths of the files not
+	// kept as duplicates, one per line or NUL-separated with
+	// DuplicateListNUL
+	DuplicateList    io.Writer
+	DuplicateListNUL bool
+
+	// Output receives the progress line and summary; it defaults to stdout
+	Output io.Writer
+
+	// Schedule, when set, restricts copying to a time window and/or system
+	// load ceiling
+	Schedule *Schedule
+
+	// ReportPath, when set, receives a JSON report of the run
+	ReportPath string
+
+	// ReportSinks also receive the report, such as stdout or an HTTP
+	// endpoint; see ParseReportSink
+	ReportSinks []ReportSink
+
+	// GraphPath, when set, receives the duplicate relationship graph as DOT
+	// (.dot, .gv) or JSON
+	GraphPath string
+
+	// HTMLReportPath, when set, receives the summary of an applied run and
+	// its largest duplicate groups and warnings as a compact HTML page
+	HTMLReportPath string
+
+	// HTMLThumbnails embeds a thumbnail of each duplicate group's keeper in
+	// the HTML report, from its EXIF data where it has one
+	HTMLThumbnails bool
+
+	// Clock is the time runs are stamped with and dates are judged
+	// plausible against, and IDs supplies the unique part of run IDs.
+	// Fixing both, as with a FixedClock and a Counter, makes the manifest
+	// of a run reproducible.
+	Clock dateutil.Clock
+	IDs   IDSource
+}
+
+// DefaultOptions returns the options used when nothing is configured
+func DefaultOptions() Options {
+	return Options{
+		NumWorkers:  runtime.NumCPU(),
+		Naming:      NamingCounter,
+		Layout:      DefaultLayout,
+		DateOrder:   dateutil.OrderYMD,
+		Decoder:     DecoderGo,
+		AppleDouble: AppleDoubleDrop,
+		Streams:     StreamsStrip,
+		Keeper:      KeepLargest,
+		Collisions:  CollisionKeep,
+		LargeGroup:  DefaultLargeGroup,
+		Clock:       dateutil.SystemClock,
+		IDs:         RandomIDs,
+	}
+}
+
+// normalize fills in zero values with defaults
+func (o Options) normalize() Options {
+	if o.NumWorkers <= 0 {
+		o.NumWorkers = runtime.NumCPU()
+	}
+	if o.Naming == "" {
+		o.Naming = NamingCounter
+	}
+	if o.Layout == "" {
+		o.Layout = DefaultLayout
+	}
+	if o.Profile == "" {
+		o.Profile = ProfileNative
+	}
+	if o.Decoder == "" {
+		o.Decoder = DecoderGo
+	}
+	if o.AppleDouble == "" {
+		o.AppleDouble = AppleDoubleDrop
+	}
+	if o.Streams == "" {
+		o.Streams = StreamsStrip
+	}
+	if o.Keeper == "" {
+		o.Keeper = KeepLargest
+	}
+	if o.Collisions == "" {
+		o.Collisions = CollisionKeep
+	}
+	if o.DateOrder == "" {
+		o.DateOrder = dateutil.OrderYMD
+	}
+	if o.Shards <= 0 {
+		o.Shards = 1
+	}
+	if o.Shards > MaxShards {
+		o.Shards = MaxShards
+	}
+	if o.Similarity <= 0 {
+		o.Similarity = DefaultSimilarity
+	}
+	if o.Output == nil {
+		o.Output = os.Stdout
+	}
+	if o.Clock == nil {
+		o.Clock = dateutil.SystemClock
+	}
+	if o.IDs == nil {
+		o.IDs = RandomIDs
+	}
+	return o
+}
@@ -0,0 +1,906 @@
+package imagedup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/dateutil"
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// Organizer imports a source tree into an archive in three stages: Scan
+// hashes the source, Plan decides what is kept and where it goes, and Apply
+// copies it. Callers can inspect, persist or edit the plan between the last
+// two; ProcessFiles runs all three.
+type Organizer struct {
+	srcDir, destDir string
+	opts            Options
+	tl              tools.Tools
+	catalogs        []*Catalog
+	decode          decodeFunc
+	timings         *stageTimings
+}
+
+// Index is what Scan found in a source: every media file with its hashes
+// and fingerprints. It is only meaningful to the Organizer that made it.
+type Index struct {
+	// Files are the media files found, and Counts how many of each class
+	// ("image", "raw" or "video") were hashed
+	Files  []string
+	Counts map[string]int
+
+	// Errors lists the files that couldn't be hashed, as FileErrors of
+	// class ErrUnsupportedFormat or ErrDecode
+	Errors []error
+
+	// Denied lists the source folders that couldn't be listed for lack of
+	// permission
+	Denied []string
+
+	// results holds what was hashed, unless it was spilled to disk
+	results    []imageInfo
+	scanned    time.Time
+	spill      *spill
+	shards     int
+	companions map[string]string
+}
+
+// shard returns the scanned files in one shard
+func (index *Index) shard(i int) ([]imageInfo, error) {
+	if index.spill != nil {
+		return index.spill.shard(i)
+	}
+	if index.shards == 1 {
+		return index.results, nil
+	}
+	var files []imageInfo
+	for _, fileInfo := range index.results {
+		if shardOf(fileInfo.hash, index.shards) == i {
+			files = append(files, fileInfo)
+		}
+	}
+	return files, nil
+}
+
+// Close removes any scan results spilled to disk. The index can't be
+// planned afterwards.
+func (index *Index) Close() error {
+	if index.spill != nil {
+		index.spill.remove()
+		index.spill = nil
+	}
+	return nil
+}
+
+// Plan lists the files an import will archive and the duplicates it leaves
+// out. It can be saved as JSON and edited before Apply, for instance to
+// move items to other folders or drop them.
+type Plan struct {
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
+
+	// RunID is recorded in the manifest for every file the plan copies
+	RunID string `json:"run_id"`
+
+	// Items are the files to archive, in copy order
+	Items []PlanItem `json:"items"`
+
+	// Dropped lists the duplicates left out of the archive
+	Dropped []string `json:"dropped,omitempty"`
+
+	// Archived lists the files left out because the ledger shows their
+	// content was imported by an earlier run
+	Archived []string `json:"archived,omitempty"`
+
+	// Counts is how many files of each class were scanned
+	Counts map[string]int `json:"counts"`
+
+	// Report holds the decisions made while planning
+	Report *Report `json:"report"`
+
+	// Scanned is when Scan began walking the source, which an incremental
+	// run records once applied
+	Scanned time.Time `json:"scanned"`
+
+	// Reviews are the collision review answers given while planning, which
+	// are added to the destination's review log when the plan is applied
+	Reviews []ReviewDecision `json:"reviews,omitempty"`
+
+	// Errors lists the items no date at all was found for, as FileErrors
+	// of class ErrNoDate
+	Errors []error `json:"-"`
+}
+
+// PlanItem is one file to archive
+type PlanItem struct {
+	Source string `json:"source"`
+
+	// Folder is the destination folder relative to the archive root, with
+	// forward slashes. The file name is assigned by Apply, which knows the
+	// folder's existing contents.
+	Folder string `json:"folder"`
+
+	Taken  dateutil.Timestamp `json:"taken"`
+	Period string             `json:"period,omitempty"`
+
+	// Fork is the AppleDouble resource fork copied alongside, if any
+	Fork string `json:"fork,omitempty"`
+
+	// Duplicates are the files left out in favor of this one, which the
+	// index maps to its copy too
+	Duplicates []string `json:"duplicates,omitempty"`
+
+	// Size and ModTime are the source file's as it was scanned
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+
+	// Hash is the perceptual hash of an image, and Width and Height its
+	// size in pixels
+	Hash   uint64           `json:"hash,omitempty"`
+	Width  int              `json:"width,omitempty"`
+	Height int              `json:"height,omitempty"`
+	Faces  *Faces           `json:"faces,omitempty"`
+	Camera *manifest.Camera `json:"camera,omitempty"`
+	Rating int              `json:"rating,omitempty"`
+	Label  string           `json:"label,omitempty"`
+
+	// Catalog names the Lightroom catalogs or Capture One sessions managing
+	// the source, and Collections the collections it is in there
+	Catalog     string   `json:"catalog,omitempty"`
+	Collections []string `json:"collections,omitempty"`
+
+	// Damaged marks an image salvaged from a file that only partly decodes
+	Damaged bool `json:"damaged,omitempty"`
+
+	// Protected marks a file within a protected folder, archived tagged
+	// keep-forever
+	Protected bool `json:"protected,omitempty"`
+}
+
+// Result is the outcome of applying a plan
+type Result struct {
+	// Copied counts the files archived by class, and Archived those an
+	// earlier run had already archived
+	Copied   map[string]int `json:"copied"`
+	Archived map[string]int `json:"archived"`
+
+	// Failed lists planned files that couldn't be stored in the primary
+	// destination, and Errors why, as FileErrors of class ErrCopyFailed
+	Failed []string `json:"failed,omitempty"`
+	Errors []error  `json:"-"`
+
+	// Deferred lists planned files left for a later run by the MaxFiles
+	// and MaxBytes options
+	Deferred []string `json:"deferred,omitempty"`
+
+	Report *Report `json:"report"`
+}
+
+// NewOrganizer returns an Organizer importing srcDir into destDir
+func NewOrganizer(srcDir, destDir string, opts Options) (*Organizer, error) {
+	opts = opts.normalize()
+	if err := checkSourceSafety(srcDir, destDir, opts); err != nil {
+		return nil, err
+	}
+	if (opts.Shards > 1 || opts.SpillDir != "") && (opts.Sequences || opts.Policies["image"].Threshold > 0) {
+		log.Printf("Deduplicating in one pass: sequences and perceptual thresholds compare files across shards")
+		opts.Shards, opts.SpillDir = 1, ""
+	}
+	tl := tools.Detect(opts.Tools)
+	log.Printf("External tools available: %s", tl)
+	var catalogs []*Catalog
+	for _, path := range opts.Catalogs {
+		c, err := LoadCatalog(path, tl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read catalog %s: %w", path, err)
+		}
+		log.Printf("Catalog %s manages %d files", c.Name, c.Len())
+		catalogs = append(catalogs, c)
+	}
+	decode := newDecoder(opts.Decoder, tl)
+	if opts.Salvage {
+		decode = salvaging(decode)
+	}
+	return &Organizer{
+		srcDir:   srcDir,
+		destDir:  destDir,
+		opts:     opts,
+		tl:       tl,
+		catalogs: catalogs,
+		decode:   newMemoryGate(opts.MaxMemory, opts.NumWorkers).wrap(decode),
+		timings:  newStageTimings(),
+	}, nil
+}
+
+// Scan walks the source and hashes or fingerprints every media file
+func (o *Organizer) Scan(ctx context.Context) (*Index, error) {
+	opts := o.opts
+	opts.Status.start()
+	walkStart := time.Now()
+	if opts.Incremental && opts.NewerThan.IsZero() {
+		last, err := lastSourceRun(o.destDir, o.srcDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", SourcesFileName, err)
+		}
+		if !last.IsZero() {
+			opts.NewerThan = last.Add(-mtimeSlack)
+			log.Printf("Only scanning files modified since %s", last.Local().Format(time.DateTime))
+		}
+	}
+	scan, err := scanSource(o.srcDir, o.destDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	o.timings.track("walk", walkStart)
+	index := &Index{Files: scan.files, Counts: make(map[string]int), Denied: scan.denied, scanned: walkStart, shards: opts.Shards, companions: scan.companions}
+	fileList := scan.files
+	if len(fileList) == 0 {
+		if err := index.unreadable(opts); err != nil {
+			return nil, err
+		}
+		return index, nil
+	}
+	if opts.SpillDir != "" {
+		if index.spill, err = newSpill(opts.SpillDir, opts.Shards); err != nil {
+			return nil, fmt.Errorf("failed to create spill files: %w", err)
+		}
+	}
+
+	var aliases map[string]string
+	if opts.PregroupTime {
+		fileList, aliases = pregroupByCaptureTime(fileList)
+		log.Printf("Found %d identical copies by capture timestamp before decoding", len(aliases))
+	}
+	opts.Status.update("phase", func(st *StatusSnapshot) {
+		st.Phase = "hashing"
+		st.Files = len(fileList)
+	})
+
+	fuzzyVideo := opts.FuzzyVideo || opts.Policies["video"].Strategy == StrategyFingerprint
+	if fuzzyVideo && o.tl.FFmpeg == "" {
+		log.Printf("Warning: fuzzy video matching needs ffmpeg to sample frames, so videos are matched on size")
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	fileChan := make(chan string, opts.NumWorkers)
+	resultChan := make(chan imageInfo, opts.NumWorkers*hashBatchSize)
+	processed := 0
+
+	// Results are collected as they arrive. Spilled results are written
+	// straight to disk, keeping in memory only those aliases resolve to.
+	targets := make(map[string]bool)
+	for _, rep := range aliases {
+		targets[rep] = true
+	}
+	var reps []imageInfo
+	var spillErr error
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for fileInfo := range resultChan {
+			if index.spill == nil {
+				index.results = append(index.results, fileInfo)
+				continue
+			}
+			if targets[fileInfo.filename] {
+				reps = append(reps, fileInfo)
+			}
+			if err := index.spill.add(fileInfo); err != nil && spillErr == nil {
+				spillErr = err
+			}
+		}
+	}()
+
+	wg.Add(opts.NumWorkers)
+	for i := 0; i < opts.NumWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			batch := &tileBatcher{resultChan: resultChan, timings: o.timings}
+			defer batch.flush()
+			for file := range fileChan {
+				if opts.Pauser.wait(ctx) != nil {
+					continue
+				}
+				opts.Status.update("", func(st *StatusSnapshot) { st.Current = file })
+				class := mediaClass(file)
+				var err error
+				switch {
+				case class == "image" && opts.BatchHash:
+					err = batch.add(file, o.decode)
+				case class == "image":
+					err = processImageFile(file, o.decode, o.timings, resultChan)
+				case class == "raw":
+					err = processRawFile(file, resultChan)
+				case class == "video":
+					err = processVideoFile(file, fuzzyVideo, o.tl, resultChan)
+				default:
+					err = fileError(file, ErrUnsupportedFormat, nil)
+				}
+				if err != nil {
+					log.Printf("Skipping %v", err)
+				}
+				mu.Lock()
+				if err != nil {
+					index.Errors = append(index.Errors, err)
+				}
+				if class != "" {
+					index.Counts[class]++
+				}
+				processed++
+				fmt.Fprintf(opts.Output, "\rProcessing %d of %d files...", processed, len(fileList))
+				mu.Unlock()
+				opts.Status.update("hashed", func(st *StatusSnapshot) { st.Processed++ })
+			}
+		}()
+	}
+
+	for _, fileName := range fileList {
+		fileChan <- fileName
+	}
+	close(fileChan)
+	wg.Wait()
+	close(resultChan)
+	<-collected
+	if err := ctx.Err(); err != nil {
+		index.Close()
+		return nil, err
+	}
+
+	if index.spill == nil {
+		index.results = resolveAliases(index.results, aliases)
+	} else {
+		for _, alias := range resolveAliases(reps, aliases)[len(reps):] {
+			if err := index.spill.add(alias); err != nil && spillErr == nil {
+				spillErr = err
+			}
+		}
+		if spillErr != nil {
+			index.Close()
+			return nil, fmt.Errorf("failed to write spill files: %w", spillErr)
+		}
+	}
+	index.Counts["image"] += len(aliases)
+	if err := index.unreadable(opts); err != nil {
+		index.Close()
+		return nil, err
+	}
+	return index, nil
+}
+
+// Plan deduplicates and dates what Scan found and decides where each kept
+// file goes. Nothing is written.
+func (o *Organizer) Plan(index *Index) (*Plan, error) {
+	opts := o.opts
+	fmt.Fprintln(opts.Output, "\nFiltering unique files...")
+	opts.Status.phase("filtering")
+	dates := newDater(o.srcDir, opts, o.tl)
+	collisions := newCollisionCheck(opts, o.destDir, o.decode)
+	run := newImportRun(o.srcDir, opts.Clock.Now(), "", opts.IDs)
+	report := &Report{RunID: run.id}
+	// Each shard is deduplicated on its own; sequences only run unsharded
+	var uniqueFiles, sequenced []imageInfo
+	var dropped []string
+	if opts.FaceDetector != nil {
+		fmt.Fprintln(opts.Output, "Detecting faces...")
+	}
+	protectedDirs := protectedRoots(o.srcDir, opts.Protected)
+	for i := 0; i < index.shards; i++ {
+		results, err := index.shard(i)
+		if err != nil {
+			return nil, err
+		}
+		markProtected(results, protectedDirs)
+		if opts.FaceDetector != nil {
+			detectFaces(results, opts.FaceDetector, opts.NumWorkers, o.timings)
+		}
+		if opts.Sequences {
+			sequenced, results = detectSequences(results, dates, o.tl, report)
+		}
+		unique := filterUniqueFiles(results, opts, dates, o.tl, collisions, o.timings, report)
+		dropped = append(dropped, eliminated(results, unique)...)
+		uniqueFiles = append(uniqueFiles, unique...)
+	}
+	report.LargeGroups = largeGroups(report, uniqueFiles, opts.LargeGroup)
+	if opts.DetectTrims || opts.DropTrimmed {
+		fmt.Fprintln(opts.Output, "Detecting trimmed videos...")
+		uniqueFiles = detectTrims(uniqueFiles, opts.DropTrimmed, o.tl, report)
+	}
+	if opts.Related {
+		fmt.Fprintln(opts.Output, "Looking for reproductions of kept images...")
+		findRelated(uniqueFiles, o.decode, report)
+	}
+	if opts.Embedder != nil {
+		fmt.Fprintln(opts.Output, "Grouping visually similar images...")
+		findSimilar(uniqueFiles, opts.Embedder, opts.Similarity, o.timings, report)
+	}
+	uniqueFiles = append(uniqueFiles, sequenced...)
+	uniqueFiles, dropped, deselected := selectDates(uniqueFiles, dropped, opts, report)
+	counts := index.Counts
+	if len(deselected) > 0 {
+		counts = make(map[string]int)
+		for class, n := range index.Counts {
+			counts[class] = n - deselected[class]
+		}
+	}
+
+	// Content any earlier run imported is left out, wherever it came from
+	ledger, err := manifest.LoadLedger(o.destDir)
+	if err != nil {
+		log.Printf("Failed to read the ledger in %s: %v", o.destDir, err)
+	}
+	uniqueFiles, archived := alreadyArchived(uniqueFiles, ledger, report)
+	report.CaseConflicts = caseConflicts(index.Files)
+	report.Unreadable = unreadableDirs(index.Errors, index.Denied)
+	report.LowResolution = lowResolution(uniqueFiles, opts.LowResolution)
+
+	// Files a catalog manages are never proposed for deletion
+	for _, file := range append(append([]string(nil), dropped...), archived...) {
+		if name, _ := catalogsFor(o.catalogs, file); name != "" {
+			report.Managed = append(report.Managed, file)
+		}
+	}
+	report.Protected = protectedFiles(append(append([]string(nil), dropped...), archived...), protectedDirs)
+
+	for _, fileInfo := range uniqueFiles {
+		if len(fileInfo.taken.Alternatives) > 0 {
+			report.addAmbiguousDate(fileInfo.filename, fileInfo.taken.Date(), fileInfo.taken.Alternatives)
+		}
+		if fileInfo.taken.Source <= dateutil.SourceModTime {
+			report.MtimeDated = append(report.MtimeDated, fileInfo.filename)
+		}
+		if fileInfo.damaged {
+			report.Damaged = append(report.Damaged, fileInfo.filename)
+		}
+		if fileInfo.label != "" {
+			report.addLabel(fileInfo.label, fileInfo.filename)
+		}
+	}
+
+	// Counters follow capture order, so names sort chronologically within
+	// each folder
+	sort.SliceStable(uniqueFiles, func(i, j int) bool {
+		return uniqueFiles[i].taken.Time.Before(uniqueFiles[j].taken.Time)
+	})
+
+	duplicates := report.duplicatesOf()
+	plan := &Plan{
+		Source:   o.srcDir,
+		Dest:     o.destDir,
+		RunID:    run.id,
+		Dropped:  dropped,
+		Archived: archived,
+		Counts:   counts,
+		Report:   report,
+		Scanned:  index.scanned,
+		Reviews:  collisions.reviews(),
+	}
+	for _, fileInfo := range uniqueFiles {
+		fileInfo.catalog, fileInfo.collections = catalogsFor(o.catalogs, fileInfo.filename)
+		if fileInfo.taken.Time.IsZero() && fileInfo.period == "" {
+			plan.Errors = append(plan.Errors, fileError(fileInfo.filename, ErrNoDate, nil))
+		}
+		folder := opts.Layout.eventLayout(opts.Events).folder(fileFields(fileInfo, o.srcDir, opts.Events))
+		if fileInfo.period != "" {
+			folder = fileInfo.period
+		}
+		if fileInfo.sequence != "" {
+			folder = filepath.Join(folder, fileInfo.sequence)
+		}
+		if opts.Mirror {
+			if mirrored, ok := mirrorFolder(o.srcDir, fileInfo.filename); ok {
+				folder = mirrored
+			} else {
+				log.Printf("Warning: %s is outside the source, so it is filed by -layout", fileInfo.filename)
+			}
+		}
+		if err := opts.Profile.checkSize(fileInfo.size); err != nil {
+			log.Printf("Warning: %s will not be copied: %v", fileInfo.filename, err)
+		}
+		plan.Items = append(plan.Items, PlanItem{
+			Source:  fileInfo.filename,
+			Folder:  opts.Profile.folder(filepath.ToSlash(folder)),
+			Taken:   fileInfo.taken,
+			Period:  fileInfo.period,
+			Fork:    index.companions[fileInfo.filename],
+			Size:    fileInfo.size,
+			ModTime: fileInfo.modTime,
+			Hash:    fileInfo.hash,
+			Width:   fileInfo.width,
+			Height:  fileInfo.height,
+			Damaged: fileInfo.damaged,
+			Faces:   fileInfo.faces,
+			Camera:  fileInfo.camera,
+			Rating:  fileInfo.rating,
+			Label:   fileInfo.label,
+
+			Catalog:     fileInfo.catalog,
+			Collections: fileInfo.collections,
+			Protected:   fileInfo.protected,
+			Duplicates:  duplicates[fileInfo.filename],
+		})
+	}
+	return plan, nil
+}
+
+// info returns the file description the copy stage works from
+func (item PlanItem) info() imageInfo {
+	return imageInfo{
+		hash:     item.Hash,
+		filename: item.Source,
+		width:    item.Width,
+		height:   item.Height,
+		damaged:  item.Damaged,
+		taken:    item.Taken,
+		period:   item.Period,
+		faces:    item.Faces,
+		camera:   item.Camera,
+		rating:   item.Rating,
+		label:    item.Label,
+		size:     item.Size,
+		modTime:  item.ModTime,
+
+		catalog:     item.Catalog,
+		collections: item.Collections,
+		protected:   item.Protected,
+	}
+}
+
+// manifestSaveFiles and manifestSaveInterval are how many copies, or how
+// long, the saved manifests and ledger may fall behind a run. Their journals
+// cover the gap meanwhile.
+const (
+	manifestSaveFiles    = 500
+	manifestSaveInterval = time.Minute
+)
+
+// Apply copies a plan's items into the destination and its replicas, then
+// updates the manifests and prints a summary. Files already archived by an
+// earlier run are skipped. When ctx is cancelled the files copied so far
+// are recorded before returning.
+func (o *Organizer) Apply(ctx context.Context, plan *Plan) (*Result, error) {
+	opts := o.opts
+	report := plan.Report
+	if report == nil {
+		report = &Report{RunID: plan.RunID}
+	}
+	result := &Result{Copied: make(map[string]int), Archived: make(map[string]int), Report: report}
+
+	fmt.Fprintln(opts.Output, "Copying unique files...")
+	opts.Status.update("phase", func(st *StatusSnapshot) {
+		st.Phase = "copying"
+		st.Unique = len(plan.Items)
+	})
+
+	// Another run writing the same destinations would reuse counters and
+	// overwrite index.json files
+	dirs := append([]string{o.destDir}, opts.Replicas...)
+	if opts.ColdStorage != "" {
+		dirs = append(dirs, opts.ColdStorage)
+	}
+	locks, err := lockDestinations(dirs, opts.LockWait)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseLocks(locks)
+	if err := recordReviews(o.destDir, plan.Reviews); err != nil {
+		log.Printf("Warning: failed to record review decisions in %s: %v", o.destDir, err)
+	}
+
+	dests, err := openDestinations(o.destDir, opts, o.tl)
+	if err != nil {
+		return nil, err
+	}
+	run := newImportRun(o.srcDir, opts.Clock.Now(), plan.RunID, opts.IDs)
+	if ledger, err := openLedger(dests[0], run.id); err != nil {
+		log.Printf("Failed to read the ledger in %s: %v", dests[0].root, err)
+	} else {
+		dests[0].ledger = ledger
+	}
+	for _, dest := range dests {
+		dest.timings = o.timings
+		dest.run = run
+		dest.batchHash = opts.BatchHash
+		dest.profile = opts.Profile
+		dest.streams = opts.Streams
+		dest.hashXattr = opts.HashXattr
+	}
+
+	for _, dest := range dests {
+		if err := dest.openJournal(); err != nil {
+			return nil, fmt.Errorf("failed to open the manifest journal in %s: %w", dest.root, err)
+		}
+	}
+	unsaved, saved := 0, time.Now()
+
+	dateCounters := make(map[string]uint64)
+	indexes := make(map[string]map[string]string)
+	names := make(map[string]map[string]bool)
+	cases := newFolderCase(o.destDir)
+	notStored := make(map[string]bool)
+	var copiedFiles int
+	var copiedBytes int64
+	fail := func(source string, err error) {
+		log.Printf("%v", err)
+		notStored[source] = true
+		result.Failed = append(result.Failed, source)
+		result.Errors = append(result.Errors, fileError(source, ErrCopyFailed, err))
+	}
+
+	for _, item := range plan.Items {
+		// A stop while paused or outside the schedule ends the run at once
+		if opts.Pauser.wait(ctx) != nil || opts.Schedule.wait(ctx) != nil {
+			break
+		}
+		opts.Status.update("", func(st *StatusSnapshot) { st.Current = item.Source })
+		fileInfo := item.info()
+		folder := filepath.FromSlash(opts.Profile.folder(item.Folder))
+		if !filepath.IsLocal(folder) {
+			log.Printf("Skipping %s: folder %q is outside the archive", item.Source, item.Folder)
+			continue
+		}
+		folder = cases.canonical(folder)
+		if err := opts.Profile.checkSize(fileInfo.size); err != nil {
+			fail(item.Source, fmt.Errorf("failed to copy %s: %w", item.Source, err))
+			continue
+		}
+		destPath := filepath.Join(o.destDir, folder)
+		if err := os.MkdirAll(destPath, os.ModePerm); err != nil {
+			fail(item.Source, fmt.Errorf("failed to create directory %s: %w", destPath, err))
+			continue
+		}
+
+		relPath, err := filepath.Rel(o.srcDir, item.Source)
+		if err != nil {
+			fail(item.Source, fmt.Errorf("failed to compute relative path for %s: %w", item.Source, err))
+			continue
+		}
+
+		// Pick up where earlier runs into this folder left off, so repeated
+		// runs neither overwrite nor re-copy files
+		if _, loaded := indexes[folder]; !loaded {
+			indexes[folder] = readIndexJSON(destPath)
+			dateCounters[folder] = highestCounter(indexes[folder])
+			names[folder] = make(map[string]bool)
+			for _, name := range indexes[folder] {
+				names[folder][name] = true
+			}
+		}
+		if _, done := indexes[folder][relPath]; done {
+			result.Archived[mediaClass(item.Source)]++
+			continue
+		}
+		origins := []string{relPath}
+		for _, duplicate := range item.Duplicates {
+			if rel, err := filepath.Rel(o.srcDir, duplicate); err == nil {
+				origins = append(origins, rel)
+			}
+		}
+
+		// Past the run's limits the rest is left to the next run, which
+		// picks up after the files copied now
+		if opts.MaxFiles > 0 && copiedFiles >= opts.MaxFiles || opts.MaxBytes > 0 && copiedFiles > 0 && copiedBytes+fileInfo.size > opts.MaxBytes {
+			notStored[item.Source] = true
+			result.Deferred = append(result.Deferred, item.Source)
+			continue
+		}
+
+		ext := filepath.Ext(item.Source)
+		if opts.NormalizeExtensions {
+			ext = canonicalExt(item.Source)
+		}
+		var newFileName string
+		if opts.Mirror {
+			newFileName = mirrorName(item.Source, ext, opts.Profile, func(name string) bool {
+				_, err := os.Lstat(filepath.Join(destPath, name))
+				return err == nil || names[folder][name]
+			})
+		} else if opts.Naming == NamingContentHash {
+			newFileName, err = contentHashName(item.Source, destPath, fileInfo.dateLabel(), ext)
+			if err != nil {
+				fail(item.Source, fmt.Errorf("failed to compute content hash name for %s: %w", item.Source, err))
+				continue
+			}
+		} else {
+			dateCounters[folder]++
+			newFileName = fmt.Sprintf("%03d%s", dateCounters[folder], ext)
+		}
+		newFileName = opts.Profile.name(newFileName)
+		var storeErr error
+		for i, dest := range dests {
+			err := dest.store(fileInfo, origins, folder, newFileName, item.Fork, opts.Verify)
+			switch {
+			case err != nil && i == 0:
+				storeErr = err
+			case err != nil:
+				log.Printf("%v", err)
+			}
+		}
+		if storeErr != nil {
+			fail(item.Source, storeErr)
+			continue
+		}
+		for _, origin := range origins {
+			indexes[folder][origin] = newFileName
+		}
+		names[folder][newFileName] = true
+		copiedFiles++
+		copiedBytes += fileInfo.size
+		if unsaved++; unsaved >= manifestSaveFiles || time.Since(saved) >= manifestSaveInterval {
+			o.saveManifests(dests, true)
+			unsaved, saved = 0, time.Now()
+		}
+		opts.Status.update("copied", func(st *StatusSnapshot) { st.Copied++ })
+
+		for _, profile := range opts.Derivatives {
+			if !profile.matches(item.Source) {
+				continue
+			}
+			if err := writeDerivative(profile, o.tl, fileInfo, relPath, folder, newFileName); err != nil {
+				log.Printf("Failed to write %s derivative of %s: %v", profile.Name, item.Source, err)
+			}
+		}
+		result.Copied[mediaClass(item.Source)]++
+	}
+
+	for _, file := range plan.Archived {
+		result.Archived[mediaClass(file)]++
+	}
+	o.saveManifests(dests, false)
+	for _, dest := range dests {
+		if opts.Parity != "" {
+			dest.writeParity(opts.Parity)
+		}
+		if opts.FolderSummaries && !dest.cold {
+			dest.writeSummaries(opts.Derivatives)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	report.Timings = o.timings.results()
+	o.printSummary(plan, result)
+
+	if opts.DuplicateList != nil {
+		if err := printDuplicates(opts.DuplicateList, report.safeToRemove(append(plan.Dropped, plan.Archived...), notStored), opts.DuplicateListNUL); err != nil {
+			return result, err
+		}
+	}
+	if err := writeReports(report, opts); err != nil {
+		return result, err
+	}
+	if opts.HTMLReportPath != "" {
+		var thumbs *Thumbnailer
+		if opts.HTMLThumbnails {
+			thumbs = NewThumbnailer(DefaultThumbnailSize, DefaultThumbnailRate, o.tl)
+		}
+		if err := writeHTMLReport(opts.HTMLReportPath, plan, result, opts.Clock.Now(), thumbs); err != nil {
+			return result, fmt.Errorf("failed to write HTML report: %w", err)
+		}
+	}
+
+	if opts.Incremental && len(result.Failed) == 0 && len(result.Deferred) == 0 && !plan.Scanned.IsZero() && !opts.partial() {
+		if err := recordSourceRun(o.destDir, o.srcDir, plan.Scanned, plan.RunID); err != nil {
+			log.Printf("Failed to record the run in %s: %v", SourcesFileName, err)
+		}
+	}
+
+	opts.Status.phase("done")
+	fmt.Fprintln(opts.Output, "All files processed.")
+	return result, nil
+}
+
+// deferred counts the files of a class left for a later run
+func (r *Result) deferred(class string) int {
+	n := 0
+	for _, file := range r.Deferred {
+		if mediaClass(file) == class {
+			n++
+		}
+	}
+	return n
+}
+
+// saveManifests saves every destination's manifest and the primary's
+// ledger, keeping their journals open if the run goes on
+func (o *Organizer) saveManifests(dests []*destination, keepJournal bool) {
+	start := time.Now()
+	for _, dest := range dests {
+		if err := dest.save(keepJournal); err != nil {
+			log.Printf("Failed to write manifest in %s: %v", dest.root, err)
+		}
+	}
+	o.timings.track("index", start)
+}
+
+// printSummary prints the counts of an applied plan and its report
+func (o *Organizer) printSummary(plan *Plan, result *Result) {
+	opts, report := o.opts, result.Report
+	w := opts.Output
+	fmt.Fprintf(w, "\nSummary:\n")
+	for _, class := range []struct{ name, label string }{{"image", "images"}, {"raw", "RAW files"}, {"video", "videos"}} {
+		copied := result.Copied[class.name]
+		duplicates := plan.Counts[class.name] - copied - result.Archived[class.name] - result.deferred(class.name)
+		fmt.Fprintf(w, "%d %s processed, %d duplicates found, %d copied\n", plan.Counts[class.name], class.label, duplicates, copied)
+	}
+	if total := result.Archived["image"] + result.Archived["raw"] + result.Archived["video"]; total > 0 {
+		fmt.Fprintf(w, "%d files already archived by an earlier run\n", total)
+	}
+	if len(result.Deferred) > 0 {
+		fmt.Fprintf(w, "%d files left for the next run by -max-files or -max-bytes\n", len(result.Deferred))
+	}
+	fmt.Fprintf(w, "%d near-duplicates found (same perceptual hash, different bytes)\n", len(report.NearDuplicates))
+	if len(report.Collisions) > 0 {
+		fmt.Fprintf(w, "%d hash collisions found (same perceptual hash, different pictures; see collisions in the report)\n", len(report.Collisions))
+	}
+	if len(report.AmbiguousDates) > 0 {
+		fmt.Fprintf(w, "%d files dated from ambiguous filename dates (see ambiguous_dates in the report)\n", len(report.AmbiguousDates))
+	}
+	if len(report.MtimeDated) > 0 {
+		fmt.Fprintf(w, "%d files dated only by modification time (see mtime_dated in the report)\n", len(report.MtimeDated))
+	}
+	if len(report.LargeGroups) > 0 {
+		fmt.Fprintf(w, "%d keepers with more than %d duplicates; check them before deleting anything (see large_groups in the report)\n", len(report.LargeGroups), o.opts.LargeGroup)
+	}
+	if len(report.Damaged) > 0 {
+		fmt.Fprintf(w, "%d damaged images salvaged and archived as such (see damaged in the report)\n", len(report.Damaged))
+	}
+	if len(report.LowResolution) > 0 {
+		fmt.Fprintf(w, "%d images below %.1f megapixels (see low_resolution in the report)\n", len(report.LowResolution), o.opts.LowResolution)
+	}
+	if report.Deselected > 0 {
+		fmt.Fprintf(w, "%d files dated outside the selected range left alone\n", report.Deselected)
+	}
+	if len(report.Unreadable) > 0 {
+		files, denied := unreadableCounts(report.Unreadable)
+		fmt.Fprintf(w, "%d files and %d folders skipped for lack of permission (see unreadable in the report); fix their permissions or rerun as a user who can read them:\n", files, denied)
+		printUnreadable(w, report.Unreadable)
+	}
+	if len(report.CaseConflicts) > 0 {
+		fmt.Fprintf(w, "%d sets of source files differ only by case (see case_conflicts in the report)\n", len(report.CaseConflicts))
+	}
+	if len(report.Labels) > 0 {
+		labeled := 0
+		for _, files := range report.Labels {
+			labeled += len(files)
+		}
+		fmt.Fprintf(w, "%d files with %d colour labels (see labels in the report)\n", labeled, len(report.Labels))
+	}
+	if opts.DetectTrims || opts.DropTrimmed {
+		fmt.Fprintf(w, "%d trimmed videos found\n", len(report.Trims))
+	}
+	if opts.Related {
+		fmt.Fprintf(w, "%d related images found (photos or screenshots of another image)\n", len(report.Related))
+	}
+	if opts.Embedder != nil {
+		fmt.Fprintf(w, "%d groups of visually similar images found\n", len(report.Similar))
+	}
+	if opts.Sequences {
+		fmt.Fprintf(w, "%d timelapse sequences kept whole\n", len(report.Sequences))
+	}
+	fmt.Fprintf(w, "Time spent: %s\n", timingSummary(report.Timings))
+}
+
+// estimate prints what a plan would save instead of applying it
+func (o *Organizer) estimate(plan *Plan) error {
+	opts := o.opts
+	report := plan.Report
+	report.Savings = estimateSavings(plan.Dropped, report)
+	report.Savings.print(opts.Output, len(plan.Items)+len(plan.Dropped))
+	report.Timings = o.timings.results()
+	opts.Status.phase("done")
+	if opts.DuplicateList != nil {
+		if err := printDuplicates(opts.DuplicateList, report.safeToRemove(plan.Dropped, nil), opts.DuplicateListNUL); err != nil {
+			return err
+		}
+	}
+	return writeReports(report, opts)
+}
@@ -0,0 +1,261 @@
+package imagedup
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/dateutil"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// DateFileName is the per-folder sidecar holding a date for every file in
+// that folder and beneath it, such as a box of scanned prints
+const DateFileName = ".ppdate"
+
+// sha256Key matches an override keyed by checksum rather than path
+var sha256Key = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// overrideLayouts are the date forms accepted in overrides, most precise
+// first. A year or month alone stands for its first day.
+var overrideLayouts = []string{
+	"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02", "2006-01", "2006",
+}
+
+// periodPattern matches folder names naming a span of time rather than a
+// date: a year, a decade, a season of a year or a range of years
+var periodPattern = regexp.MustCompile(`(?i)^(?:(?:1[89]|20)\d\d|(?:1[89]|20)\d0'?s|(?:1[89]|20)\d\d[-_ ](?:spring|summer|autumn|fall|winter)|(?:spring|summer|autumn|fall|winter)[-_ ](?:1[89]|20)\d\d|(?:1[89]|20)\d\d[-_](?:1[89]|20)\d\d)$`)
+
+// datePattern matches values meant as dates, which must then parse as one
+var datePattern = regexp.MustCompile(`^\d{4}-\d{2}(-\d{2})?\b`)
+
+// yearPattern finds a plausible year in a period label
+var yearPattern = regexp.MustCompile(`(1[89]|20)\d\d`)
+
+// dateOverride is a manual date, or a period label such as "1980s" for
+// files that can only be placed roughly in time
+type dateOverride struct {
+	taken  dateutil.Timestamp
+	period string
+}
+
+// DateOverrides are dates supplied by hand, taking precedence over every
+// automatic source. Paths are files or folders, absolute or relative to the
+// source; a folder's date applies to everything beneath it.
+type DateOverrides struct {
+	paths  map[string]dateOverride
+	hashes map[string]dateOverride
+}
+
+// LoadDateOverrides reads a corrections file mapping paths or SHA-256
+// checksums to dates: a JSON object, or CSV rows of key and date for any
+// other extension. CSV lines starting with # are comments.
+func LoadDateOverrides(path string) (*DateOverrides, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	raw := make(map[string]string)
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.NewDecoder(f).Decode(&raw); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	} else {
+		r := csv.NewReader(f)
+		r.Comment = '#'
+		r.FieldsPerRecord = 2
+		r.TrimLeadingSpace = true
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+			}
+			raw[record[0]] = record[1]
+		}
+	}
+
+	o := &DateOverrides{paths: make(map[string]dateOverride), hashes: make(map[string]dateOverride)}
+	for key, value := range raw {
+		override, err := parseOverride(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date for %s in %s: %w", key, path, err)
+		}
+		if sha256Key.MatchString(key) {
+			o.hashes[strings.ToLower(key)] = override
+		} else {
+			o.paths[filepath.Clean(filepath.FromSlash(key))] = override
+		}
+	}
+	return o, nil
+}
+
+// parseOverride reads a date, year-month or year, with an optional time.
+// Anything else naming a span of time, such as "1980s" or "1994-summer", is
+// a period label.
+func parseOverride(s string) (dateOverride, error) {
+	s = strings.TrimSpace(s)
+	for i, layout := range overrideLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return dateOverride{taken: dateutil.Timestamp{Time: t, Source: dateutil.SourceManual, Clock: i < 2}}, nil
+		}
+	}
+	if !datePattern.MatchString(s) && periodLabel(s) {
+		return dateOverride{period: s}, nil
+	}
+	return dateOverride{}, fmt.Errorf("unrecognized date %q, use YYYY-MM-DD, YYYY-MM, YYYY or a period such as 1980s", s)
+}
+
+// periodLabel reports whether s can name a period folder: a single folder
+// name containing a year or decade
+func periodLabel(s string) bool {
+	if s == "" || s == "." || s == ".." || strings.ContainsAny(s, `/\`) {
+		return false
+	}
+	return yearPattern.MatchString(s)
+}
+
+// dater resolves when files were taken, applying manual dates before the
+// automatic sources
+type dater struct {
+	order  dateutil.DateOrder
+	tl     tools.Tools
+	clock  dateutil.Clock
+	srcDir string
+
+	// periods files undated files under period folders named in their path
+	periods bool
+
+	// paths holds the overrides by absolute path, hashes those by checksum
+	paths  map[string]dateOverride
+	hashes map[string]dateOverride
+
+	// sidecars caches each folder's DateFileName override, if it has one
+	sidecars map[string]*dateOverride
+}
+
+// newDater returns a dater for files under srcDir
+func newDater(srcDir string, opts Options, tl tools.Tools) *dater {
+	d := &dater{
+		order:    opts.DateOrder,
+		tl:       tl,
+		clock:    opts.Clock,
+		srcDir:   absPath(srcDir),
+		periods:  opts.Periods,
+		sidecars: make(map[string]*dateOverride),
+	}
+	if o := opts.DateOverrides; o != nil {
+		d.paths = make(map[string]dateOverride)
+		for path, override := range o.paths {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(d.srcDir, path)
+			}
+			d.paths[path] = override
+		}
+		d.hashes = o.hashes
+	}
+	return d
+}
+
+// DateFile returns when a file was taken exactly as an import of srcDir with
+// the default options dates it: by a DateFileName sidecar in its folder or
+// one above it within srcDir, else from EXIF, exiftool when it is installed,
+// the filename, the folder names and the modification time, in that order.
+// A file a sidecar files under a period rather than a date fails with
+// ErrNoDate. Errors are *FileError values.
+func DateFile(srcDir, path string) (dateutil.Timestamp, error) {
+	taken, period := newDater(srcDir, DefaultOptions(), defaultTools()).date(path)
+	switch {
+	case period != "":
+		return taken, fileError(path, ErrNoDate, fmt.Errorf("filed under the period %s", period))
+	case taken.Time.IsZero():
+		return taken, fileError(path, ErrNoDate, nil)
+	}
+	return taken, nil
+}
+
+// date resolves when one file was taken, and the period it is filed under
+// instead of a date, if any
+func (d *dater) date(filePath string) (dateutil.Timestamp, string) {
+	if override, ok := d.manual(filePath); ok {
+		if override.period != "" {
+			return dateutil.Timestamp{Source: dateutil.SourceManual}, override.period
+		}
+		return override.taken, ""
+	}
+	taken := extractDate(filePath, d.order, d.tl, d.clock)
+	if d.periods && taken.Source <= dateutil.SourceModTime {
+		if period := d.pathPeriod(filePath); period != "" {
+			return dateutil.Timestamp{Source: dateutil.SourceDirectory}, period
+		}
+	}
+	return taken, ""
+}
+
+// pathPeriod returns the name of the nearest folder within the source naming
+// a period, such as "1980s" or "1994-summer"
+func (d *dater) pathPeriod(filePath string) string {
+	for dir := filepath.Dir(absPath(filePath)); isWithin(dir, d.srcDir) && dir != d.srcDir; dir = filepath.Dir(dir) {
+		if name := filepath.Base(dir); periodPattern.MatchString(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// manual looks up a file's override by checksum, then by its own path, then
+// by the nearest enclosing folder with an override or a DateFileName sidecar
+func (d *dater) manual(filePath string) (dateOverride, bool) {
+	if len(d.hashes) > 0 {
+		if sum, _, err := fileChecksumSize(filePath); err == nil {
+			if override, ok := d.hashes[sum]; ok {
+				return override, true
+			}
+		}
+	}
+	path := absPath(filePath)
+	if override, ok := d.paths[path]; ok {
+		return override, true
+	}
+	for dir := filepath.Dir(path); isWithin(dir, d.srcDir); dir = filepath.Dir(dir) {
+		if override, ok := d.paths[dir]; ok {
+			return override, true
+		}
+		if override := d.sidecar(dir); override != nil {
+			return *override, true
+		}
+		if dir == d.srcDir {
+			break
+		}
+	}
+	return dateOverride{}, false
+}
+
+// sidecar reads the DateFileName override in dir, caching the result
+func (d *dater) sidecar(dir string) *dateOverride {
+	if override, ok := d.sidecars[dir]; ok {
+		return override
+	}
+	var found *dateOverride
+	sidecarPath := filepath.Join(dir, DateFileName)
+	if data, err := os.ReadFile(sidecarPath); err == nil {
+		if override, err := parseOverride(string(data)); err == nil {
+			found = &override
+		} else {
+			log.Printf("Failed to read %s: %v", sidecarPath, err)
+		}
+	}
+	d.sidecars[dir] = found
+	return found
+}
@@ -0,0 +1,75 @@
+package imagedup
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Pauser suspends a running pipeline between files. Work already in flight,
+// such as a copy, finishes before the pipeline stops, so pausing never leaves
+// partial output behind. A nil *Pauser never pauses.
+type Pauser struct {
+	mu     sync.Mutex
+	paused bool
+
+	// resumed is closed when a pause ends
+	resumed chan struct{}
+}
+
+// NewPauser returns a Pauser in the running state
+func NewPauser() *Pauser {
+	return &Pauser{}
+}
+
+// Pause suspends the pipeline once in-flight work completes
+func (p *Pauser) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		log.Printf("Pausing after in-flight work completes")
+		p.resumed = make(chan struct{})
+	}
+	p.paused = true
+}
+
+// Resume continues a paused pipeline
+func (p *Pauser) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		log.Printf("Resuming")
+		close(p.resumed)
+	}
+	p.paused = false
+}
+
+// Paused reports whether the pipeline is paused
+func (p *Pauser) Paused() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// wait blocks while the pipeline is paused, returning early when ctx is
+// cancelled
+func (p *Pauser) wait(ctx context.Context) error {
+	if p == nil {
+		return ctx.Err()
+	}
+	p.mu.Lock()
+	paused, resumed := p.paused, p.resumed
+	p.mu.Unlock()
+	if !paused {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resumed:
+		return ctx.Err()
+	}
+}
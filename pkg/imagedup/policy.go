@@ -0,0 +1,101 @@
+package imagedup
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Dedup strategies a media class can be given
+const (
+	// StrategyPerceptual groups images by perceptual hash, optionally
+	// within a Hamming distance threshold (the default for images)
+	StrategyPerceptual = "perceptual"
+	// StrategySize groups files by size (the default for RAW and video)
+	StrategySize = "size"
+	// StrategyFingerprint groups videos by duration, resolution and sampled
+	// frames, as -fuzzy-video does
+	StrategyFingerprint = "fingerprint"
+	// StrategyChecksum only treats byte-identical files as duplicates
+	StrategyChecksum = "checksum"
+)
+
+// classStrategies lists the strategies each media class supports
+var classStrategies = map[string][]string{
+	"image": {StrategyPerceptual, StrategyChecksum},
+	"raw":   {StrategySize, StrategyChecksum},
+	"video": {StrategySize, StrategyFingerprint, StrategyChecksum},
+}
+
+// ClassPolicy is the dedup strategy for one media class
+type ClassPolicy struct {
+	Strategy string `json:"strategy"`
+
+	// Threshold, for the perceptual strategy, is the largest Hamming
+	// distance between hashes still treated as duplicates; 0 requires equal
+	// hashes
+	Threshold int `json:"threshold,omitempty"`
+}
+
+// ValidatePolicies checks per-class policies, keyed by "image", "raw" or
+// "video"
+func ValidatePolicies(policies map[string]ClassPolicy) error {
+	for class, policy := range policies {
+		supported, ok := classStrategies[class]
+		if !ok {
+			return fmt.Errorf("unknown media class %q", class)
+		}
+		valid := false
+		for _, strategy := range supported {
+			valid = valid || policy.Strategy == strategy
+		}
+		if !valid {
+			return fmt.Errorf("strategy %q is not supported for %s files, use one of %v", policy.Strategy, class, supported)
+		}
+		if policy.Threshold < 0 || policy.Threshold > 64 {
+			return fmt.Errorf("threshold %d for %s files is outside 0-64", policy.Threshold, class)
+		}
+		if policy.Threshold > 0 && policy.Strategy != StrategyPerceptual {
+			return fmt.Errorf("a threshold only applies to the %s strategy", StrategyPerceptual)
+		}
+	}
+	return nil
+}
+
+// strictFor reports whether a class only collapses byte-identical files
+func (o Options) strictFor(class string) bool {
+	return o.Strict || o.Policies[class].Strategy == StrategyChecksum
+}
+
+// thresholdRoots links perceptual hash groups whose hashes are within
+// threshold bits of each other, chaining close pairs, and returns for each
+// group the index of the first group it joins
+func thresholdRoots(groups [][]imageInfo, threshold int) []int {
+	parent := make([]int, len(groups))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range groups {
+		for j := i + 1; j < len(groups); j++ {
+			if bits.OnesCount64(groups[i][0].hash^groups[j][0].hash) > threshold {
+				continue
+			}
+			// Keep the lowest index as the root so groups stay in order
+			a, b := root(i), root(j)
+			if a > b {
+				a, b = b, a
+			}
+			parent[b] = a
+		}
+	}
+	for i := range parent {
+		parent[i] = root(i)
+	}
+	return parent
+}
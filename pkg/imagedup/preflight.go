@@ -0,0 +1,131 @@
+package imagedup
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/corona10/goimagehash"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// preflightSamples is how many images are decoded and files read to time
+// the run estimate
+const preflightSamples = 20
+
+// ExtensionStat counts the files of one extension found by Preflight. Class
+// is "image", "raw", "video", or "" for files an import would skip.
+type ExtensionStat struct {
+	Extension string `json:"extension"`
+	Class     string `json:"class"`
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// PreflightResult describes what an import of a source would process
+type PreflightResult struct {
+	Extensions []ExtensionStat `json:"extensions"`
+
+	// Estimate is the expected duration of the import, extrapolated from
+	// timing a sample of decodes and reads with the configured workers
+	Estimate time.Duration `json:"estimate_ns"`
+}
+
+// Preflight walks a source as an import would, honoring ignore files and
+// system file filtering, and counts files and bytes per extension. A small
+// sample is decoded, hashed and read to estimate how long the import would
+// take. Nothing is written.
+func Preflight(srcDir, destDir string, opts Options) (*PreflightResult, error) {
+	opts = opts.normalize()
+	if err := checkSourceSafety(srcDir, destDir, opts); err != nil {
+		return nil, err
+	}
+	scan, err := scanSource(srcDir, destDir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	byExt := make(map[string]*ExtensionStat)
+	var images, media []string
+	var mediaBytes int64
+	for _, file := range scan.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		ext := canonicalExt(file)
+		stat, ok := byExt[ext]
+		if !ok {
+			stat = &ExtensionStat{Extension: ext, Class: mediaClass(file)}
+			byExt[ext] = stat
+		}
+		stat.Files++
+		stat.Bytes += info.Size()
+		if stat.Class != "" {
+			media = append(media, file)
+			mediaBytes += info.Size()
+		}
+		if stat.Class == "image" {
+			images = append(images, file)
+		}
+	}
+
+	result := &PreflightResult{}
+	for _, stat := range byExt {
+		result.Extensions = append(result.Extensions, *stat)
+	}
+	sort.Slice(result.Extensions, func(i, j int) bool {
+		a, b := result.Extensions[i], result.Extensions[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Extension < b.Extension
+	})
+
+	// Hashing images is spread over the workers; reading for checksums and
+	// writing copies is bound by the disks, so it isn't
+	decode := newDecoder(opts.Decoder, tools.Detect(opts.Tools))
+	if total, n := sampleTime(images, func(file string) error {
+		img, err := decode(file)
+		if err == nil {
+			_, err = goimagehash.AverageHash(img)
+		}
+		return err
+	}); n > 0 {
+		result.Estimate += total / time.Duration(n) * time.Duration(len(images)) / time.Duration(opts.NumWorkers)
+	}
+	var sampledBytes int64
+	if total, _ := sampleTime(media, func(file string) error {
+		_, size, err := fileChecksumSize(file)
+		if err == nil {
+			sampledBytes += size
+		}
+		return err
+	}); sampledBytes > 0 {
+		// Every file is read to copy it, and duplicate candidates once more
+		// to checksum them
+		perByte := float64(total) / float64(sampledBytes)
+		result.Estimate += time.Duration(2 * perByte * float64(mediaBytes))
+	}
+	return result, nil
+}
+
+// sampleTime runs fn over up to preflightSamples files spread evenly through
+// files and returns the time taken by the successful calls and their number
+func sampleTime(files []string, fn func(string) error) (time.Duration, int) {
+	step := len(files) / preflightSamples
+	if step < 1 {
+		step = 1
+	}
+	var total time.Duration
+	n := 0
+	for i := 0; i < len(files) && n < preflightSamples; i += step {
+		start := time.Now()
+		if err := fn(files[i]); err != nil {
+			continue
+		}
+		total += time.Since(start)
+		n++
+	}
+	return total, n
+}
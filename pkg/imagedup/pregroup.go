@@ -0,0 +1,95 @@
+package imagedup
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/dateutil"
+)
+
+// pregroupByCaptureTime buckets images by their EXIF capture timestamp, to
+// the second, before anything is decoded. Within a bucket, files with
+// identical bytes are copies a camera or import tool wrote twice: only the
+// first is decoded and the rest are returned as aliases that share its hash.
+// Reading EXIF is far cheaper than decoding, and only files in the same
+// bucket are checksummed against each other.
+func pregroupByCaptureTime(files []string) (toDecode []string, aliases map[string]string) {
+	aliases = make(map[string]string)
+	buckets := make(map[int64][]string)
+	var order []int64
+	for _, file := range files {
+		if !SupportedImageFormats[strings.ToLower(filepath.Ext(file))] {
+			toDecode = append(toDecode, file)
+			continue
+		}
+		at, err := dateutil.CaptureTime(file)
+		if err != nil {
+			toDecode = append(toDecode, file)
+			continue
+		}
+		if _, exists := buckets[at.Unix()]; !exists {
+			order = append(order, at.Unix())
+		}
+		buckets[at.Unix()] = append(buckets[at.Unix()], file)
+	}
+
+	for _, second := range order {
+		bucket := buckets[second]
+		if len(bucket) == 1 {
+			toDecode = append(toDecode, bucket[0])
+			continue
+		}
+
+		first := make(map[string]string)
+		for _, file := range bucket {
+			key, _, err := fileChecksumSize(file)
+			if err != nil {
+				log.Printf("Failed to checksum %s: %v", file, err)
+				toDecode = append(toDecode, file)
+				continue
+			}
+			if rep, exists := first[key]; exists {
+				aliases[file] = rep
+				continue
+			}
+			first[key] = file
+			toDecode = append(toDecode, file)
+		}
+	}
+	return toDecode, aliases
+}
+
+// resolveAliases adds each alias to the results with its representative's
+// hash and size, so it is grouped with it as an exact duplicate
+func resolveAliases(results []imageInfo, aliases map[string]string) []imageInfo {
+	reps := make(map[string]imageInfo)
+	for _, fileInfo := range results {
+		reps[fileInfo.filename] = fileInfo
+	}
+	for _, file := range sortedKeys(aliases) {
+		rep, ok := reps[aliases[file]]
+		if !ok {
+			log.Printf("Skipping %s: its identical copy %s could not be hashed", file, aliases[file])
+			continue
+		}
+		alias := imageInfo{hash: rep.hash, filename: file, size: rep.size}
+		if info, err := os.Stat(file); err == nil {
+			alias.modTime = info.ModTime()
+		}
+		results = append(results, alias)
+	}
+	return results
+}
+
+// sortedKeys returns a map's keys in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
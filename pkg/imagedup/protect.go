@@ -0,0 +1,73 @@
+package imagedup
+
+// protectedRoots resolves the Protected option's folders, relative to the
+// source unless absolute
+func protectedRoots(srcDir string, dirs []string) []string {
+	var roots []string
+	for _, dir := range dirs {
+		roots = append(roots, sourcePath(srcDir, dir))
+	}
+	return roots
+}
+
+// markProtected flags the files within a protected folder
+func markProtected(files []imageInfo, roots []string) {
+	if len(roots) == 0 {
+		return
+	}
+	for i := range files {
+		files[i].protected = within(absPath(files[i].filename), roots)
+	}
+}
+
+// preferProtected returns the protected files of a set, or all of them if
+// none is protected
+func preferProtected(files []imageInfo) []imageInfo {
+	var kept []imageInfo
+	for _, fileInfo := range files {
+		if fileInfo.protected {
+			kept = append(kept, fileInfo)
+		}
+	}
+	if len(kept) == 0 {
+		return files
+	}
+	return kept
+}
+
+// protectClusters separates every protected file but the keeper from its
+// cluster, so each is kept however much it resembles another
+func protectClusters(clusters [][]imageInfo, policy KeeperPolicy) [][]imageInfo {
+	var out [][]imageInfo
+	for _, members := range clusters {
+		if len(members) == 1 {
+			out = append(out, members)
+			continue
+		}
+		keeper := largestFile(members, policy)
+		var rest []imageInfo
+		for _, member := range members {
+			if member.protected && member.filename != keeper.filename {
+				out = append(out, []imageInfo{member})
+			} else {
+				rest = append(rest, member)
+			}
+		}
+		out = append(out, rest)
+	}
+	return out
+}
+
+// protectedFiles returns the files of a list within protected folders
+func protectedFiles(files []string, roots []string) []string {
+	if len(roots) == 0 {
+		return nil
+	}
+	var kept []string
+	for _, file := range files {
+		if within(absPath(file), roots) {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
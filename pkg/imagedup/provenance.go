@@ -0,0 +1,88 @@
+package imagedup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+)
+
+// importRun identifies one import for the provenance recorded in manifests
+type importRun struct {
+	id         string
+	sourceRoot string
+	started    time.Time
+}
+
+// newImportRun starts a run importing from srcDir at started, with an ID
+// made of its start time and a suffix from ids, unless id is already known
+func newImportRun(srcDir string, started time.Time, id string, ids IDSource) importRun {
+	started = started.UTC()
+	root, err := filepath.Abs(srcDir)
+	if err != nil {
+		root = srcDir
+	}
+	if id == "" {
+		id = started.Format("20060102T150405Z") + "-" + ids.NewID()
+	}
+	return importRun{
+		id:         id,
+		sourceRoot: root,
+		started:    started,
+	}
+}
+
+// stamp records the run's provenance on a manifest entry, with the source
+// modification time read while scanning
+func (r importRun) stamp(e *manifest.Entry, src imageInfo) {
+	e.SourceRoot = r.sourceRoot
+	e.RunID = r.id
+	imported := r.started
+	e.ImportedAt = &imported
+	mtime := src.modTime
+	if mtime.IsZero() {
+		if info, err := os.Stat(src.filename); err == nil {
+			mtime = info.ModTime()
+		}
+	}
+	if !mtime.IsZero() {
+		mtime = mtime.UTC()
+		e.SourceModTime = &mtime
+	}
+}
+
+// Provenance looks up the manifest entry of an archived file, given its path
+// relative to destDir or a path to it inside destDir
+func Provenance(destDir, file string) (manifest.Entry, error) {
+	m, err := manifest.Load(destDir)
+	if err != nil {
+		return manifest.Entry{}, err
+	}
+	key, err := entryKey(m, destDir, file)
+	if err != nil {
+		return manifest.Entry{}, err
+	}
+	return m.Entries[key], nil
+}
+
+// entryKey resolves an archived file, given as a path relative to destDir or
+// a path inside it, to its manifest key
+func entryKey(m *manifest.Manifest, destDir, file string) (string, error) {
+	if abs, err := filepath.Abs(file); err == nil {
+		if root, err := filepath.Abs(destDir); err == nil {
+			if r, err := filepath.Rel(root, abs); err == nil && !strings.HasPrefix(r, "..") {
+				if _, ok := m.Entries[filepath.ToSlash(r)]; ok {
+					return filepath.ToSlash(r), nil
+				}
+			}
+		}
+	}
+	key := filepath.ToSlash(filepath.Clean(file))
+	if _, ok := m.Entries[key]; ok {
+		return key, nil
+	}
+	return "", fmt.Errorf("%s is not in the manifest of %s", file, destDir)
+}
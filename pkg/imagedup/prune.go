@@ -0,0 +1,216 @@
+package imagedup
+
+import (
+	"encoding/json"
+	"math/bits"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+)
+
+// keepForeverTag protects a file from being proposed for pruning
+const keepForeverTag = "keep-forever"
+
+// DefaultPruneThreshold is the Hamming distance between perceptual hashes
+// within which a smaller image counts as a downscaled copy of a larger one
+const DefaultPruneThreshold = 4
+
+// Reasons a file is proposed for pruning
+const (
+	// PruneRAWExport is a JPEG or other image exported from a RAW file in
+	// the archive: taken at the same second by the same camera, or named
+	// after the RAW file in the same source folder on the same date
+	PruneRAWExport = "raw-export"
+	// PruneDownscaled is a smaller copy of a larger image in the archive
+	PruneDownscaled = "downscaled"
+)
+
+// PruneCandidate is an archived file derived from a Master that is kept
+type PruneCandidate struct {
+	Path   string `json:"path"`
+	Master string `json:"master"`
+	Reason string `json:"reason"`
+	Size   int64  `json:"size"`
+}
+
+// PruneFilter narrows a pruning analysis by the tags of archived files
+type PruneFilter struct {
+	// Tags must all be attached to a file for it to be proposed
+	Tags []string
+	// Keep lists tags that protect the files carrying any of them, as
+	// keep-forever always does. A protected file is never proposed and is
+	// the master its derived copies point to.
+	Keep []string
+}
+
+// protects reports whether an entry is tagged keep-forever or with one of
+// the filter's Keep tags
+func (f PruneFilter) protects(e manifest.Entry) bool {
+	if e.HasTag(keepForeverTag) {
+		return true
+	}
+	for _, tag := range f.Keep {
+		if e.HasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrunePlan proposes archived files that could be removed to reclaim space.
+// Nothing is removed; Protected counts the files that would have been
+// proposed but carry a protecting tag or were managed by a catalog.
+type PrunePlan struct {
+	Candidates []PruneCandidate `json:"candidates"`
+	Bytes      int64            `json:"bytes"`
+	Protected  int              `json:"protected"`
+}
+
+// WriteJSON writes the plan to path as indented JSON
+func (p *PrunePlan) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// AnalyzePrune finds derived files in the archive at destDir: exports of
+// RAW files it also holds, matched by EXIF capture time and camera or by
+// name, and downscaled copies of larger images, matched by perceptual hash
+// within threshold and capture time. Only the manifest and image headers
+// are read, and only files passing filter are proposed.
+func AnalyzePrune(destDir string, threshold int, filter PruneFilter) (*PrunePlan, error) {
+	m, err := manifest.Load(destDir)
+	if err != nil {
+		return nil, err
+	}
+	entries := m.Sorted()
+	masterOf := make(map[string]PruneCandidate)
+
+	// EXIF lineage: an image sharing a RAW file's capture second and camera,
+	// or its source name and date
+	type lineage struct {
+		taken  int64
+		camera string
+	}
+	type stem struct {
+		date, name string
+	}
+	raws := make(map[lineage]string)
+	rawNames := make(map[stem]string)
+	for _, e := range entries {
+		if mediaClass(e.Path) != "raw" {
+			continue
+		}
+		if e.Taken != nil && e.Camera != nil {
+			raws[lineage{e.Taken.Unix(), e.Camera.Name()}] = e.Path
+		}
+		if e.Source != "" {
+			rawNames[stem{e.Date, sourceStem(e.Source)}] = e.Path
+		}
+	}
+	for _, e := range entries {
+		if mediaClass(e.Path) != "image" {
+			continue
+		}
+		master, ok := "", false
+		if e.Taken != nil && e.Camera != nil {
+			master, ok = raws[lineage{e.Taken.Unix(), e.Camera.Name()}]
+		}
+		if !ok && e.Source != "" {
+			master, ok = rawNames[stem{e.Date, sourceStem(e.Source)}]
+		}
+		if ok {
+			masterOf[e.Path] = PruneCandidate{Path: e.Path, Master: master, Reason: PruneRAWExport, Size: e.Size}
+		}
+	}
+
+	// Hash similarity: of two alike images taken on the same date, and at
+	// the same second if both record it, the one with fewer pixels is a
+	// downscaled copy
+	byDate := make(map[string][]manifest.Entry)
+	for _, e := range entries {
+		if mediaClass(e.Path) == "image" && e.PHash != "" && e.Encryption == "" {
+			byDate[e.Date] = append(byDate[e.Date], e)
+		}
+	}
+	pixels := make(map[string]int)
+	area := func(e manifest.Entry) int {
+		if n, ok := pixels[e.Path]; ok {
+			return n
+		}
+		if e.Width > 0 {
+			pixels[e.Path] = e.Width * e.Height
+		} else {
+			pixels[e.Path] = imageArea(filepath.Join(destDir, filepath.FromSlash(e.Path)))
+		}
+		return pixels[e.Path]
+	}
+	for _, group := range byDate {
+		for i := range group {
+			for j := i + 1; j < len(group); j++ {
+				a, b := group[i], group[j]
+				ha, okA := parseHash(a.PHash)
+				hb, okB := parseHash(b.PHash)
+				if !okA || !okB || bits.OnesCount64(ha^hb) > threshold {
+					continue
+				}
+				if a.Taken != nil && b.Taken != nil && a.Taken.Unix() != b.Taken.Unix() {
+					continue
+				}
+				if area(a) < area(b) {
+					a, b = b, a
+				}
+				if area(b) == 0 || area(b) == area(a) {
+					continue
+				}
+				if _, done := masterOf[b.Path]; !done {
+					masterOf[b.Path] = PruneCandidate{Path: b.Path, Master: a.Path, Reason: PruneDownscaled, Size: b.Size}
+				}
+			}
+		}
+	}
+
+	plan := &PrunePlan{}
+	for _, e := range entries {
+		c, ok := masterOf[e.Path]
+		if !ok || !hasTags(e, filter.Tags) {
+			continue
+		}
+		if filter.protects(e) || e.Catalog != "" {
+			plan.Protected++
+			continue
+		}
+		// A master that is itself derived points on to the file kept
+		for seen := 0; seen < len(masterOf); seen++ {
+			next, derived := masterOf[c.Master]
+			if !derived || filter.protects(m.Entries[c.Master]) {
+				break
+			}
+			c.Master = next.Master
+		}
+		plan.Candidates = append(plan.Candidates, c)
+		plan.Bytes += c.Size
+	}
+	sort.Slice(plan.Candidates, func(i, j int) bool { return plan.Candidates[i].Path < plan.Candidates[j].Path })
+	return plan, nil
+}
+
+// sourceStem is a source file's folder and name without its extension, so a
+// RAW file and the JPEG written beside it match
+func sourceStem(source string) string {
+	source = filepath.ToSlash(source)
+	return strings.ToLower(strings.TrimSuffix(source, path.Ext(source)))
+}
+
+// imageArea reads an image's pixel count from its header, or returns 0 if
+// it can't be read
+func imageArea(filePath string) int {
+	w, h := imageDimensions(filePath)
+	return w * h
+}
@@ -0,0 +1,178 @@
+package imagedup
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/xmp"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// IFD0 tags read for keeper choices: the star rating Windows and some
+// cameras write, and the software that wrote the file
+const (
+	exifRatingTag   = 0x4746
+	exifSoftwareTag = 0x0131
+)
+
+// editorSoftware are substrings of the EXIF Software and XMP CreatorTool
+// values written by editors, as opposed to camera firmware
+var editorSoftware = []string{
+	"photoshop", "lightroom", "camera raw", "gimp", "snapseed", "affinity", "capture one",
+	"darktable", "rawtherapee", "luminar", "pixelmator", "paint.net", "picasa", "digikam",
+}
+
+// ParseKeeperPolicy validates a keeper policy name
+func ParseKeeperPolicy(name string) (KeeperPolicy, error) {
+	switch KeeperPolicy(name) {
+	case KeepLargest, KeepEdited, KeepOriginal, KeepResolution:
+		return KeeperPolicy(name), nil
+	}
+	return "", fmt.Errorf("unknown keeper policy %q", name)
+}
+
+// readRating returns a file's star rating and colour label from XMP,
+// preferring its sidecar over its embedded packet, with the EXIF rating
+// used when XMP has none
+func readRating(filePath string) (rating int, label string) {
+	rated := false
+	for _, packet := range xmp.Read(filePath) {
+		if r, ok := packet.Rating(); ok && !rated {
+			rating, rated = r, true
+		}
+		if label == "" {
+			label = packet.Label()
+		}
+	}
+	if !rated {
+		if tag := exifTag(filePath, exifRatingTag); tag != nil && tag.Count > 0 {
+			if r, err := tag.Int(0); err == nil && r >= -1 && r <= 5 {
+				rating = r
+			}
+		}
+	}
+	return rating, label
+}
+
+// exifTag returns a raw IFD0 tag of a file's EXIF data, for tags goexif has
+// no name for
+func exifTag(filePath string, id uint16) *tiff.Tag {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	x, _ := exif.Decode(file)
+	if x == nil || x.Tiff == nil || len(x.Tiff.Dirs) == 0 {
+		return nil
+	}
+	for _, tag := range x.Tiff.Dirs[0].Tags {
+		if tag.Id == id {
+			return tag
+		}
+	}
+	return nil
+}
+
+// highestRated narrows a group to the files with the highest rating, so a
+// 5-star edit is kept over its unrated original and rejects are kept last.
+// Without differing ratings every file is returned.
+func highestRated(files []imageInfo) []imageInfo {
+	if len(files) < 2 {
+		return files
+	}
+	ratings := make([]int, len(files))
+	best, differ := 0, false
+	for i, fileInfo := range files {
+		ratings[i], _ = readRating(fileInfo.filename)
+		if i == 0 || ratings[i] > best {
+			differ = differ || i > 0
+			best = ratings[i]
+		} else if ratings[i] < best {
+			differ = true
+		}
+	}
+	if !differ {
+		return files
+	}
+	var rated []imageInfo
+	for i, fileInfo := range files {
+		if ratings[i] == best {
+			rated = append(rated, fileInfo)
+		}
+	}
+	return rated
+}
+
+// edited reports whether a file shows signs of editing: an XMP sidecar, an
+// XMP edit history or develop settings, or an editor as its software
+func edited(filePath string) bool {
+	if xmp.SidecarPath(filePath) != "" {
+		return true
+	}
+	for _, packet := range xmp.Read(filePath) {
+		if bytes.Contains(packet, []byte("xmpMM:History")) || bytes.Contains(packet, []byte("photoshop:History")) {
+			return true
+		}
+		if settings, _ := packet.Property("crs:HasSettings"); strings.EqualFold(settings, "true") {
+			return true
+		}
+		if tool, _ := packet.Property("xmp:CreatorTool"); editorName(tool) {
+			return true
+		}
+	}
+	if tag := exifTag(filePath, exifSoftwareTag); tag != nil && tag.Count > 0 {
+		if software, err := tag.StringVal(); err == nil && editorName(software) {
+			return true
+		}
+	}
+	return false
+}
+
+// editorName reports whether software names a known editor
+func editorName(software string) bool {
+	software = strings.ToLower(software)
+	for _, editor := range editorSoftware {
+		if strings.Contains(software, editor) {
+			return true
+		}
+	}
+	return false
+}
+
+// preferEdits narrows a group to its edited files under KeepEdited, or to
+// its unedited ones under KeepOriginal. Groups that are all edited or all
+// unedited are returned whole, as are all groups under KeepLargest and
+// KeepResolution.
+func preferEdits(files []imageInfo, policy KeeperPolicy) []imageInfo {
+	if policy == KeepLargest || policy == KeepResolution || len(files) < 2 {
+		return files
+	}
+	var edits, originals []imageInfo
+	for _, fileInfo := range files {
+		if edited(fileInfo.filename) {
+			edits = append(edits, fileInfo)
+		} else {
+			originals = append(originals, fileInfo)
+		}
+	}
+	switch {
+	case len(edits) == 0 || len(originals) == 0:
+		return files
+	case policy == KeepEdited:
+		return edits
+	default:
+		return originals
+	}
+}
+
+// readMetadata records the camera settings, rating and label of a file
+// being archived
+func (i *imageInfo) readMetadata() {
+	i.camera = readCamera(i.filename)
+	i.rating, i.label = readRating(i.filename)
+}
@@ -0,0 +1,154 @@
+package imagedup
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+)
+
+// SourceRef is one recorded source of an archived file
+type SourceRef struct {
+	// Path is the archived file, relative to the destination root
+	Path   string `json:"path"`
+	Source string `json:"source"`
+}
+
+// Reconciliation compares an archive with the sources it was imported from
+type Reconciliation struct {
+	// Checked counts the archived files whose sources were looked for
+	Checked int `json:"checked"`
+
+	// Missing lists recorded sources that no longer exist
+	Missing []SourceRef `json:"missing,omitempty"`
+
+	// Changed lists sources that still exist but no longer match the size
+	// of the archived file
+	Changed []SourceRef `json:"changed,omitempty"`
+
+	// Orphaned lists archived files none of whose sources survive, so the
+	// archive holds the only copy
+	Orphaned []string `json:"orphaned,omitempty"`
+
+	// Offline counts, by source root, the files whose root can't be found
+	// at all, like an unplugged card or drive; they are not reported
+	// missing
+	Offline map[string]int `json:"offline,omitempty"`
+}
+
+// Reconcile looks for the sources of every file in the archive at destDir:
+// the path recorded in the manifest and every origin its folder's
+// index.json maps to it, such as duplicates of it and origins recorded by
+// merge. With record set, the manifest marks orphaned files with when their
+// last source was found gone, and clears the mark of files whose source is
+// back.
+func Reconcile(destDir string, record bool, now time.Time) (*Reconciliation, error) {
+	if record {
+		lock, err := LockDestination(destDir, 0)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.Release()
+	}
+
+	m, err := manifest.Load(destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Reconciliation{Offline: make(map[string]int)}
+	indexes := make(map[string]map[string][]string)
+	online := make(map[string]bool)
+	changed := false
+	for _, e := range m.Sorted() {
+		folder := path.Dir(e.Path)
+		if _, loaded := indexes[folder]; !loaded {
+			indexes[folder] = indexOrigins(filepath.Join(destDir, filepath.FromSlash(folder)))
+		}
+
+		if e.SourceRoot != "" {
+			up, checked := online[e.SourceRoot]
+			if !checked {
+				_, err := os.Stat(e.SourceRoot)
+				up = err == nil
+				online[e.SourceRoot] = up
+			}
+			if !up {
+				result.Offline[e.SourceRoot]++
+				continue
+			}
+		}
+
+		// Files reindexed from an archive have no known source at all
+		sources := entrySources(e, indexes[folder][path.Base(e.Path)])
+		if len(sources) == 0 {
+			continue
+		}
+		result.Checked++
+		surviving := 0
+		for _, source := range sources {
+			info, err := os.Stat(source)
+			switch {
+			case err != nil:
+				result.Missing = append(result.Missing, SourceRef{Path: e.Path, Source: source})
+			case e.Transform == "" && info.Size() != e.Size:
+				surviving++
+				result.Changed = append(result.Changed, SourceRef{Path: e.Path, Source: source})
+			default:
+				surviving++
+			}
+		}
+		if surviving == 0 {
+			result.Orphaned = append(result.Orphaned, e.Path)
+			if record && e.SourceGoneAt == nil {
+				gone := now.UTC()
+				e.SourceGoneAt = &gone
+				m.Add(e)
+				changed = true
+			}
+		} else if record && e.SourceGoneAt != nil {
+			e.SourceGoneAt = nil
+			m.Add(e)
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := m.Save(destDir); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// entrySources lists where an archived file's sources should be: the path
+// its manifest entry records and the origins its folder's index.json maps
+// to it, resolved against the root the import scanned
+func entrySources(e manifest.Entry, origins []string) []string {
+	seen := make(map[string]bool)
+	var sources []string
+	add := func(p string) {
+		if p != "" && !seen[p] {
+			seen[p] = true
+			sources = append(sources, p)
+		}
+	}
+	if e.SourceRoot == "" || filepath.IsAbs(e.Source) {
+		add(e.Source)
+	}
+	for _, origin := range origins {
+		switch {
+		case strings.HasPrefix(origin, unknownOrigin):
+		case filepath.IsAbs(origin):
+			add(origin)
+		case e.SourceRoot != "":
+			add(filepath.Join(e.SourceRoot, origin))
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
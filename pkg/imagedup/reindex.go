@@ -0,0 +1,208 @@
+package imagedup
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+)
+
+// unknownOrigin prefixes the index.json key of a file whose original path
+// could not be recovered, so its name still counts as taken
+const unknownOrigin = "(unknown)/"
+
+// ReindexResult summarizes a reindex of a destination
+type ReindexResult struct {
+	// Files is the number of archived files found
+	Files int
+	// Recovered lists files whose origin came from a surviving index.json
+	// or manifest entry
+	Recovered []string
+	// Unknown lists files whose original path could not be recovered
+	Unknown []string
+	// Mismatched lists files whose bytes no longer match their manifest
+	// checksum. The recorded checksum is kept so fsck still reports them.
+	Mismatched []string
+	// Dropped lists manifest entries whose file no longer exists
+	Dropped []string
+}
+
+// Reindex rebuilds every index.json and the manifest of a destination from
+// the files it holds. Checksums are recomputed and reconciled with whatever
+// manifest and index files survive: origins are taken from the old index,
+// then from the manifest, which is also matched by checksum so files that
+// were moved between folders keep their history. srcDir, when set, is the
+// source directory of the original import, used to turn manifest sources
+// back into index paths.
+func Reindex(destDir, srcDir string) (*ReindexResult, error) {
+	lock, err := LockDestination(destDir, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	old, err := manifest.Load(destDir)
+	if err != nil {
+		log.Printf("Ignoring unreadable manifest in %s: %v", destDir, err)
+		old = manifest.New()
+	}
+	bySum := make(map[string]manifest.Entry)
+	for _, e := range old.Sorted() {
+		if e.Encryption == "" && e.Transform == "" {
+			bySum[e.SHA256] = e
+		}
+	}
+
+	folders := make(map[string][]string)
+	err = filepath.Walk(destDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") || info.Name() == "index.json" || info.Name() == FolderSummaryName {
+			return nil
+		}
+		rel, err := filepath.Rel(destDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if folder := path.Dir(rel); folder != "." {
+			folders[folder] = append(folders[folder], path.Base(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReindexResult{}
+	rebuilt := manifest.New()
+	for _, folder := range sortedFolders(folders) {
+		origins := make(map[string][]string)
+		for name, relPaths := range indexOrigins(filepath.Join(destDir, folder)) {
+			for _, relPath := range relPaths {
+				if !strings.HasPrefix(relPath, unknownOrigin) {
+					origins[name] = append(origins[name], relPath)
+				}
+			}
+		}
+
+		mapping := make(map[string]string)
+		for _, name := range folders[folder] {
+			rel := folder + "/" + name
+			result.Files++
+			entry := reconcileEntry(destDir, rel, old, bySum, result)
+
+			// Duplicates keep their place beside the file they map to
+			known := origins[name]
+			if len(known) == 0 && entry.Source != "" {
+				if origin, ok := sourceOrigin(srcDir, entry.Source); ok {
+					known = []string{origin}
+				}
+			}
+			if len(known) > 0 {
+				result.Recovered = append(result.Recovered, rel)
+			} else {
+				known = []string{unknownOrigin + name}
+				result.Unknown = append(result.Unknown, rel)
+			}
+			if entry.Source == "" && len(origins[name]) > 0 && srcDir != "" {
+				entry.Source = filepath.Join(srcDir, filepath.FromSlash(known[0]))
+			}
+			for _, origin := range known {
+				mapping[origin] = name
+			}
+			rebuilt.Add(entry)
+		}
+
+		indexPath := filepath.Join(destDir, folder)
+		os.Remove(filepath.Join(indexPath, "index.json"))
+		if err := writeIndexJSON(indexPath, mapping); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, e := range old.Sorted() {
+		if _, ok := rebuilt.Entries[e.Path]; !ok {
+			result.Dropped = append(result.Dropped, e.Path)
+		}
+	}
+	if err := rebuilt.Save(destDir); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// reconcileEntry returns the manifest entry for an archived file, reusing and
+// checking its old entry or, failing that, one recorded with the same checksum
+func reconcileEntry(destDir, rel string, old *manifest.Manifest, bySum map[string]manifest.Entry, result *ReindexResult) manifest.Entry {
+	entry, known := old.Entries[rel]
+
+	// Encrypted and transformed files can't be checked against the
+	// plaintext checksum, so their entries are trusted as they are
+	if known && (entry.Encryption != "" || entry.Transform != "") {
+		return entry
+	}
+
+	stored := filepath.Join(destDir, filepath.FromSlash(rel))
+	sum, size, err := archivedChecksum(stored)
+	if err != nil {
+		log.Printf("Failed to checksum %s: %v", rel, err)
+	}
+	switch {
+	case known && err == nil && entry.SHA256 != sum:
+		result.Mismatched = append(result.Mismatched, rel)
+		return entry
+	case known:
+		return entry
+	}
+
+	// A file moved between folders keeps its history, tags and date
+	if moved, ok := bySum[sum]; ok && err == nil {
+		moved.Path = rel
+		return moved
+	}
+	date := strings.SplitN(rel, "/", 2)[0]
+	return manifest.Entry{Path: rel, SHA256: sum, Size: size, Date: date, Camera: readCamera(stored)}
+}
+
+// archivedChecksum returns an archived file's SHA-256 and size, from the
+// checksum cached in its extended attribute while the file is unchanged
+func archivedChecksum(path string) (string, int64, error) {
+	if sum, ok := manifest.CachedChecksum(path); ok {
+		info, err := os.Stat(path)
+		if err == nil {
+			return sum, info.Size(), nil
+		}
+	}
+	return fileChecksumSize(path)
+}
+
+// sourceOrigin turns a manifest source path back into the relative path used
+// as an index.json key
+func sourceOrigin(srcDir, source string) (string, bool) {
+	if srcDir == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(srcDir, source)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return rel, true
+}
+
+// sortedFolders returns the folder names in order, sorting each folder's
+// files as well
+func sortedFolders(folders map[string][]string) []string {
+	names := make([]string, 0, len(folders))
+	for name := range folders {
+		names = append(names, name)
+		sort.Strings(folders[name])
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,156 @@
+package imagedup
+
+import (
+	"image"
+	"log"
+	"math"
+	"math/bits"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// relatedThreshold is the largest difference hash distance at which two
+// images are considered reproductions of one another
+const relatedThreshold = 10
+
+// borderTolerance is how far a gray level may stray from the border color
+// while still counting as part of a border
+const borderTolerance = 24
+
+// minBorderedShare is the percentage of an image's area its content must
+// fall below after trimming for the image to count as framed by a border
+const minBorderedShare = 95
+
+// relatedSignature describes an image's content independently of borders,
+// moiré and exposure, and how sharp it is
+type relatedSignature struct {
+	file      string
+	hash      uint64
+	sharpness float64
+	bordered  bool
+}
+
+// findRelated flags pairs of kept images where one looks like a photo or
+// screenshot of a printed or displayed copy of the other. Borders are trimmed
+// and the content is blurred before a difference hash is taken, so frames,
+// moiré and lighting changes don't hide the match. The image that had a border
+// trimmed, or else the blurrier one, is taken to be the reproduction.
+func findRelated(files []imageInfo, decode decodeFunc, report *Report) {
+	var sigs []relatedSignature
+	for _, fileInfo := range files {
+		if !SupportedImageFormats[strings.ToLower(filepath.Ext(fileInfo.filename))] {
+			continue
+		}
+		img, err := decode(fileInfo.filename)
+		if err != nil {
+			log.Printf("Failed to decode %s for related image detection: %v", fileInfo.filename, err)
+			continue
+		}
+		sig := signatureOf(img)
+		sig.file = fileInfo.filename
+		sigs = append(sigs, sig)
+	}
+
+	for i := range sigs {
+		for j := i + 1; j < len(sigs); j++ {
+			distance := bits.OnesCount64(sigs[i].hash ^ sigs[j].hash)
+			if distance > relatedThreshold {
+				continue
+			}
+			original, repro := sigs[i], sigs[j]
+			if original.bordered != repro.bordered {
+				if original.bordered {
+					original, repro = repro, original
+				}
+			} else if repro.sharpness > original.sharpness {
+				original, repro = repro, original
+			}
+			report.addRelated(original.file, repro.file, distance, repro.bordered)
+		}
+	}
+}
+
+// signatureOf computes the related signature of an image
+func signatureOf(img image.Image) relatedSignature {
+	gray := imaging.Grayscale(img)
+	content, bordered := trimBorder(gray)
+	small := imaging.Resize(content, 128, 128, imaging.Lanczos)
+	sharpness := laplacianEnergy(small)
+
+	// Blurring suppresses moiré and sensor noise before hashing
+	tiny := imaging.Resize(imaging.Blur(small, 2), 9, 8, imaging.Box)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if tiny.Pix[tiny.PixOffset(x, y)] < tiny.Pix[tiny.PixOffset(x+1, y)] {
+				hash |= 1
+			}
+		}
+	}
+	return relatedSignature{hash: hash, sharpness: sharpness, bordered: bordered}
+}
+
+// trimBorder crops away uniform rows and columns around the edge of a
+// grayscale image, such as a print's white frame or a screen bezel, trimming
+// at most a quarter of the image from each side
+func trimBorder(img *image.NRGBA) (*image.NRGBA, bool) {
+	b := img.Bounds()
+	c := img.Pix[img.PixOffset(b.Min.X, b.Min.Y)]
+	uniform := func(x0, y0, x1, y1 int) bool {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				if d := int(img.Pix[img.PixOffset(x, y)]) - int(c); d > borderTolerance || d < -borderTolerance {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	top, bottom, left, right := b.Min.Y, b.Max.Y, b.Min.X, b.Max.X
+	for top < b.Min.Y+b.Dy()/4 && uniform(left, top, right, top+1) {
+		top++
+	}
+	for bottom > b.Max.Y-b.Dy()/4 && uniform(left, bottom-1, right, bottom) {
+		bottom--
+	}
+	for left < b.Min.X+b.Dx()/4 && uniform(left, top, left+1, bottom) {
+		left++
+	}
+	for right > b.Max.X-b.Dx()/4 && uniform(right-1, top, right, bottom) {
+		right--
+	}
+
+	crop := image.Rect(left, top, right, bottom)
+	if crop == b {
+		return img, false
+	}
+
+	// Only count it as a border when it frames a noticeable share of the
+	// image, not when a flat sky happens to run into an edge
+	bordered := crop.Dx()*crop.Dy() < b.Dx()*b.Dy()*minBorderedShare/100
+	return imaging.Crop(img, crop), bordered
+}
+
+// laplacianEnergy measures sharpness as the mean absolute Laplacian of a
+// grayscale image; reproductions lose fine detail and score lower
+func laplacianEnergy(img *image.NRGBA) float64 {
+	b := img.Bounds()
+	at := func(x, y int) float64 { return float64(img.Pix[img.PixOffset(x, y)]) }
+
+	var total float64
+	n := 0
+	for y := b.Min.Y + 1; y < b.Max.Y-1; y++ {
+		for x := b.Min.X + 1; x < b.Max.X-1; x++ {
+			total += math.Abs(4*at(x, y) - at(x-1, y) - at(x+1, y) - at(x, y-1) - at(x, y+1))
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / float64(n)
+}
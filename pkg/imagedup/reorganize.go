@@ -0,0 +1,343 @@
+package imagedup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+	"github.com/gavinmcnair/pictureprocess/pkg/parity"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// journalFile records a reorganization's planned moves at the destination
+// root until it completes, so an interrupted run can be resumed
+const journalFile = "reorganize.journal.json"
+
+// Move is one file relocated by Reorganize, as paths relative to the root
+type Move struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ReorganizeResult summarizes a reorganization
+type ReorganizeResult struct {
+	Moves []Move
+	// Renamed counts moves that took a new name to avoid a collision
+	Renamed int
+	// Unchanged counts files already where the layout puts them
+	Unchanged int
+	// Resumed reports whether an interrupted reorganization was finished
+	Resumed bool
+}
+
+// Reorganize moves every file in an organized destination to where layout
+// puts it, keeping names where possible and renumbering counter names (or
+// suffixing other names) that would collide. index.json files, the manifest
+// and any folder parity are rewritten to match. The plan is journaled before
+// anything moves and no file is ever overwritten, so running it again after
+// an interruption finishes the job. With dryRun set only the plan is returned.
+// events name dates for the {event} token and, as in an import, are appended
+// to the last folder of a layout without one.
+func Reorganize(destDir string, layout Layout, events []Event, dryRun bool) (*ReorganizeResult, error) {
+	if !dryRun {
+		lock, err := LockDestination(destDir, 0)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.Release()
+	}
+
+	m, err := manifest.Load(destDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Entries) == 0 {
+		return nil, errors.New("no manifest entries found; run reindex first")
+	}
+
+	result := &ReorganizeResult{}
+	journal := filepath.Join(destDir, journalFile)
+	if data, err := os.ReadFile(journal); err == nil {
+		if err := json.Unmarshal(data, &result.Moves); err != nil {
+			return nil, fmt.Errorf("unreadable journal %s: %w", journal, err)
+		}
+		result.Resumed = true
+		log.Printf("Resuming interrupted reorganization of %d files", len(result.Moves))
+	} else {
+		planMoves(m, layout.eventLayout(events), events, result)
+		if dryRun || len(result.Moves) == 0 {
+			return result, nil
+		}
+		data, err := json.MarshalIndent(result.Moves, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(journal, data, 0644); err != nil {
+			return nil, err
+		}
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	// Gather origins and parity from every folder involved before any
+	// index or sidecar is rewritten
+	folders := make(map[string]bool)
+	for _, mv := range result.Moves {
+		folders[path.Dir(mv.From)] = true
+		folders[path.Dir(mv.To)] = true
+	}
+	origins := make(map[string][]string)
+	method := ""
+	for folder := range folders {
+		dir := filepath.Join(destDir, filepath.FromSlash(folder))
+		for name, from := range indexOrigins(dir) {
+			origins[folder+"/"+name] = from
+		}
+		if method == "" {
+			method = parity.Method(dir)
+		}
+	}
+
+	for _, mv := range result.Moves {
+		if err := moveArchived(destDir, mv); err != nil {
+			return nil, err
+		}
+		if entry, ok := m.Entries[mv.From]; ok {
+			delete(m.Entries, mv.From)
+			entry.Path = mv.To
+			m.Add(entry)
+		}
+		if origin, ok := origins[mv.From]; ok {
+			delete(origins, mv.From)
+			origins[mv.To] = origin
+		}
+	}
+
+	if err := rewriteFolders(destDir, folders, origins, m, method); err != nil {
+		return nil, err
+	}
+	if err := m.Save(destDir); err != nil {
+		return nil, err
+	}
+	return result, os.Remove(journal)
+}
+
+// planMoves works out where each manifest entry goes under layout. Every
+// current path stays taken, even those moving away, so moves can run in any
+// order without one landing on a file that hasn't moved yet.
+func planMoves(m *manifest.Manifest, layout Layout, events []Event, result *ReorganizeResult) {
+	entries := m.Sorted()
+	taken := newTakenNames()
+	target := make(map[string]string)
+	for _, e := range entries {
+		taken.add(e.Path)
+		folder := filepath.ToSlash(layout.folder(entryFields(e, events)))
+		if e.Period != "" {
+			folder = e.Period
+		}
+
+		// Timelapse sequences keep their own folder beneath the new one
+		if old := path.Base(path.Dir(e.Path)); strings.HasPrefix(old, "sequence-") {
+			folder = path.Join(folder, old)
+		}
+		target[e.Path] = folder
+		if folder == path.Dir(e.Path) {
+			result.Unchanged++
+		}
+	}
+
+	for _, e := range entries {
+		folder := target[e.Path]
+		if folder == path.Dir(e.Path) {
+			continue
+		}
+		name := path.Base(e.Path)
+		to := path.Join(folder, name)
+		if taken.taken(to) {
+			to = path.Join(folder, taken.free(folder, name))
+			result.Renamed++
+		}
+		taken.add(to)
+		result.Moves = append(result.Moves, Move{From: e.Path, To: to})
+	}
+}
+
+// takenNames tracks the names in use per folder. Counter names such as
+// 004.jpg claim their number for every extension, as imports number files
+// per folder regardless of type.
+type takenNames struct {
+	names    map[string]bool
+	counters map[string]map[uint64]bool
+	highest  map[string]uint64
+}
+
+// newTakenNames returns an empty set of names
+func newTakenNames() *takenNames {
+	return &takenNames{
+		names:    make(map[string]bool),
+		counters: make(map[string]map[uint64]bool),
+		highest:  make(map[string]uint64),
+	}
+}
+
+// counterOf returns the number of a counter name
+func counterOf(name string) (uint64, bool) {
+	n, err := strconv.ParseUint(strings.TrimSuffix(name, filepath.Ext(name)), 10, 64)
+	return n, err == nil
+}
+
+// add marks a slash-separated path as taken
+func (t *takenNames) add(p string) {
+	t.names[p] = true
+	if n, ok := counterOf(path.Base(p)); ok {
+		folder := path.Dir(p)
+		if t.counters[folder] == nil {
+			t.counters[folder] = make(map[uint64]bool)
+		}
+		t.counters[folder][n] = true
+		if n > t.highest[folder] {
+			t.highest[folder] = n
+		}
+	}
+}
+
+// taken reports whether a path, or its counter number, is in use
+func (t *takenNames) taken(p string) bool {
+	if t.names[p] {
+		return true
+	}
+	n, ok := counterOf(path.Base(p))
+	return ok && t.counters[path.Dir(p)][n]
+}
+
+// free picks a name not yet taken in folder: the next counter for counter
+// names, otherwise the name with a numeric suffix
+func (t *takenNames) free(folder, name string) string {
+	ext := filepath.Ext(name)
+	if _, ok := counterOf(name); ok {
+		return fmt.Sprintf("%03d%s", t.highest[folder]+1, ext)
+	}
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s_%d%s", base, i, ext)
+		if !t.taken(path.Join(folder, candidate)) {
+			return candidate
+		}
+	}
+}
+
+// moveArchived moves one file and its AppleDouble fork, if any. A move whose
+// source is gone but whose target exists was completed by an earlier run.
+func moveArchived(destDir string, mv Move) error {
+	from := filepath.Join(destDir, filepath.FromSlash(mv.From))
+	to := filepath.Join(destDir, filepath.FromSlash(mv.To))
+	if _, err := os.Lstat(from); os.IsNotExist(err) {
+		if _, err := os.Lstat(to); err == nil {
+			return nil
+		}
+		log.Printf("Skipping %s: it no longer exists", mv.From)
+		return nil
+	}
+	if _, err := os.Lstat(to); err == nil {
+		return fmt.Errorf("refusing to overwrite %s", mv.To)
+	}
+	if err := os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+
+	fork := filepath.Join(filepath.Dir(from), "._"+filepath.Base(from))
+	if _, err := os.Lstat(fork); err == nil {
+		if err := os.Rename(fork, filepath.Join(filepath.Dir(to), "._"+filepath.Base(to))); err != nil {
+			log.Printf("Failed to move AppleDouble file %s: %v", fork, err)
+		}
+	}
+	return nil
+}
+
+// rewriteFolders rewrites the index.json, parity and summary of every folder
+// involved in a reorganization, removing folders left empty
+func rewriteFolders(destDir string, folders map[string]bool, origins map[string][]string, m *manifest.Manifest, method string) error {
+	names := make(map[string][]string)
+	for _, e := range m.Sorted() {
+		if folder := path.Dir(e.Path); folders[folder] {
+			names[folder] = append(names[folder], path.Base(e.Path))
+		}
+	}
+
+	var sorted []string
+	for folder := range folders {
+		sorted = append(sorted, folder)
+	}
+	sort.Strings(sorted)
+
+	// Summaries are kept up to date in archives that have them
+	summaries := false
+	for _, folder := range sorted {
+		if _, err := os.Stat(filepath.Join(destDir, filepath.FromSlash(folder), FolderSummaryName)); err == nil {
+			summaries = true
+		}
+	}
+	if summaries {
+		for _, folder := range sorted {
+			if err := writeFolderSummary(destDir, folder, m, nil); err != nil {
+				log.Printf("Failed to write summary for %s: %v", filepath.Join(destDir, filepath.FromSlash(folder)), err)
+			}
+		}
+	}
+
+	tl := tools.Detect(tools.Paths{})
+	for _, folder := range sorted {
+		dir := filepath.Join(destDir, filepath.FromSlash(folder))
+		mapping := make(map[string]string)
+		for p, from := range origins {
+			if path.Dir(p) == folder {
+				for _, origin := range from {
+					mapping[origin] = path.Base(p)
+				}
+			}
+		}
+
+		os.Remove(filepath.Join(dir, "index.json"))
+		if err := parity.Remove(dir); err != nil {
+			log.Printf("Failed to remove stale parity in %s: %v", dir, err)
+		}
+		if len(names[folder]) == 0 {
+			removeEmptyDirs(destDir, dir)
+			continue
+		}
+		if len(mapping) > 0 {
+			if err := writeIndexJSON(dir, mapping); err != nil {
+				return err
+			}
+		}
+		if method != "" {
+			if err := parity.Create(dir, names[folder], method, tl); err != nil {
+				log.Printf("Failed to write parity for %s: %v", dir, err)
+			}
+		}
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir and then each parent left empty, stopping at
+// the destination root
+func removeEmptyDirs(destDir, dir string) {
+	for dir != destDir && strings.HasPrefix(dir, destDir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,61 @@
+package imagedup
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+)
+
+// Two files trade folders, each moving onto the other's current name, so
+// neither move may be planned onto a path that is still occupied
+func TestReorganizeSwap(t *testing.T) {
+	dest := t.TempDir()
+	m := manifest.New()
+	for p, date := range map[string]string{
+		"2020/001.jpg": "2021-06-01",
+		"2021/001.jpg": "2020-06-01",
+	} {
+		file := filepath.Join(dest, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(date), 0644); err != nil {
+			t.Fatal(err)
+		}
+		m.Add(manifest.Entry{Path: p, Date: date})
+	}
+	if err := m.Save(dest); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Reorganize(dest, "{year}", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Moves) != 2 || result.Renamed != 2 {
+		t.Fatalf("got %d moves, %d renamed; want 2 and 2", len(result.Moves), result.Renamed)
+	}
+
+	m, err = manifest.Load(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range m.Sorted() {
+		if folder := path.Dir(e.Path); folder != e.Date[:4] {
+			t.Errorf("%s dated %s is in %s", path.Base(e.Path), e.Date, folder)
+		}
+		data, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(e.Path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != e.Date {
+			t.Errorf("%s holds the file dated %s, want %s", e.Path, data, e.Date)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, journalFile)); !os.IsNotExist(err) {
+		t.Errorf("journal left behind: %v", err)
+	}
+}
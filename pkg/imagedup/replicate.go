@@ -0,0 +1,388 @@
+package imagedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/dateutil"
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+	"github.com/gavinmcnair/pictureprocess/pkg/parity"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// destination is one archive root being written during a run
+type destination struct {
+	root     string
+	manifest *manifest.Manifest
+
+	// encryption, when set, encrypts every file written to this root
+	encryption *Encryption
+	tools      tools.Tools
+
+	// cold marks the cold storage tier, which keeps compressed RAWs and
+	// videos instead of originals
+	cold bool
+
+	// timings, when set, records time spent copying and writing indexes
+	timings *stageTimings
+
+	// touched records the folders written to during this run
+	touched map[string]bool
+
+	// journal, when open, receives each entry as it is stored, until the
+	// manifest is next saved
+	journal *manifest.Journal
+
+	// run is the import recorded as each stored file's provenance
+	run importRun
+
+	// ledger, when set, records each stored file's content; it is written
+	// after the manifest
+	ledger *manifest.Ledger
+
+	// profile adapts the copies to the destination's file system
+	profile DestinationProfile
+
+	// hashXattr caches each copy's checksum in an extended attribute, until
+	// the destination's file system is found not to support them
+	hashXattr bool
+
+	// streams selects whether copies keep their sources' alternate data
+	// streams
+	streams StreamPolicy
+
+	// batchHash marks runs whose image hashes come from the batch kernel,
+	// which aren't comparable with the recorded perceptual hashes
+	batchHash bool
+}
+
+// openDestinations loads the manifest of the primary destination and of every
+// replica
+func openDestinations(destDir string, opts Options, tl tools.Tools) ([]*destination, error) {
+	encrypted := make(map[string]bool)
+	if opts.Encryption != nil {
+		for _, root := range opts.Encryption.Destinations {
+			encrypted[filepath.Clean(root)] = true
+		}
+	}
+
+	var dests []*destination
+	for _, root := range append([]string{destDir}, opts.Replicas...) {
+		if err := os.MkdirAll(root, os.ModePerm); err != nil {
+			return nil, err
+		}
+		m, err := manifest.Load(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load manifest in %s: %w", root, err)
+		}
+		dest := &destination{root: root, manifest: m, tools: tl}
+		if encrypted[filepath.Clean(root)] {
+			dest.encryption = opts.Encryption
+		}
+		dests = append(dests, dest)
+	}
+
+	if opts.ColdStorage != "" {
+		if err := os.MkdirAll(opts.ColdStorage, os.ModePerm); err != nil {
+			return nil, err
+		}
+		m, err := manifest.Load(opts.ColdStorage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load manifest in %s: %w", opts.ColdStorage, err)
+		}
+		if tl.Zstd == "" {
+			return nil, fmt.Errorf("cold storage requires zstd")
+		}
+		dests = append(dests, &destination{root: opts.ColdStorage, manifest: m, tools: tl, cold: true})
+	}
+	return dests, nil
+}
+
+// store copies a file into the destination under folder/newFileName,
+// optionally verifies the copy, and records it in the index and manifest.
+// origins are the source paths, relative to the source, the index maps to
+// the copy: the file's own and those of the duplicates it stands for.
+func (d *destination) store(fileInfo imageInfo, origins []string, folder, newFileName, fork string, verify bool) error {
+	// The cold storage tier only holds RAW files and videos
+	if d.cold && mediaClass(fileInfo.filename) == "image" {
+		return nil
+	}
+
+	destPath := filepath.Join(d.root, folder)
+	if err := os.MkdirAll(destPath, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", destPath, err)
+	}
+
+	var sum, transform string
+	var size int64
+	var err error
+	copyStart := time.Now()
+	switch {
+	case d.cold:
+		newFileName, transform, err = d.transformFile(fileInfo.filename, destPath, newFileName)
+		if err == nil {
+			sum, size, err = fileChecksumSize(fileInfo.filename)
+		}
+	case d.encryption != nil:
+		newFileName += "." + d.encryption.Method
+		sum, size, err = d.encryptFile(fileInfo.filename, filepath.Join(destPath, newFileName))
+	default:
+		sum, size, err = copyFileChecksum(fileInfo.filename, filepath.Join(destPath, newFileName))
+	}
+	destFile := filepath.Join(destPath, newFileName)
+	if err != nil {
+		return fmt.Errorf("failed to copy file to %s: %w", destFile, err)
+	}
+
+	// Encrypted and transformed copies can't be compared byte for byte
+	if verify && d.encryption == nil && !d.cold {
+		if copied, err := fileChecksum(destFile); err != nil || copied != sum {
+			os.Remove(destFile)
+			return fmt.Errorf("verification failed for %s", destFile)
+		}
+	}
+
+	// Keep the resource fork next to its file so macOS rejoins them
+	if fork != "" && !d.cold {
+		if err := copyFile(fork, filepath.Join(destPath, "._"+newFileName)); err != nil {
+			log.Printf("Failed to copy AppleDouble file %s: %v", fork, err)
+		}
+	}
+
+	// Streams such as the Zone.Identifier of downloads are only carried
+	// over when asked for
+	if d.streams == StreamsKeep && d.encryption == nil && !d.cold {
+		if err := copyStreams(fileInfo.filename, destFile); err != nil {
+			log.Printf("Failed to copy the alternate data streams of %s: %v", fileInfo.filename, err)
+		}
+	}
+
+	if t, ok := d.profile.modTime(fileInfo.modTime); ok {
+		if err := os.Chtimes(destFile, t, t); err != nil {
+			log.Printf("Failed to set the modification time of %s: %v", destFile, err)
+		}
+	}
+
+	// Encrypted and transformed copies don't hold the bytes sum describes
+	if d.hashXattr && d.encryption == nil && !d.cold {
+		if err := manifest.CacheChecksum(destFile, sum); err != nil {
+			log.Printf("Failed to cache checksums in extended attributes in %s, continuing without: %v", d.root, err)
+			d.hashXattr = false
+		}
+	}
+
+	d.timings.track("copy", copyStart)
+
+	entry := manifest.Entry{
+		Path:      filepath.ToSlash(filepath.Join(folder, newFileName)),
+		Source:    fileInfo.filename,
+		SHA256:    sum,
+		Size:      size,
+		Date:      fileInfo.taken.Date(),
+		Period:    fileInfo.period,
+		Transform: transform,
+		Camera:    fileInfo.camera,
+		Rating:    fileInfo.rating,
+		Label:     fileInfo.label,
+
+		Catalog:     fileInfo.catalog,
+		Collections: fileInfo.collections,
+	}
+	if fileInfo.taken.Clock {
+		taken := fileInfo.taken.Time
+		entry.Taken = &taken
+	}
+	if fileInfo.taken.Source != dateutil.SourceUnknown {
+		entry.DateSource = fileInfo.taken.Source.String()
+	}
+	if d.encryption != nil {
+		entry.Encryption = d.encryption.Method
+	}
+	if mediaClass(fileInfo.filename) == "image" && !d.batchHash {
+		entry.PHash = formatHash(fileInfo.hash)
+	}
+	entry.Width, entry.Height = fileInfo.width, fileInfo.height
+	entry.Damaged = fileInfo.damaged
+	if fileInfo.protected {
+		entry.AddTags(keepForeverTag)
+	}
+	if fileInfo.faces != nil {
+		faces, eyesOpen := fileInfo.faces.Count, fileInfo.faces.EyesOpen
+		entry.Faces, entry.EyesOpen = &faces, &eyesOpen
+	}
+	d.run.stamp(&entry, fileInfo)
+
+	// The journal records the copy before index.json does, so a crash in
+	// between can at worst copy the file again, never leave it unrecorded
+	indexStart := time.Now()
+	if d.journal != nil {
+		if err := d.journal.Add(entry); err != nil {
+			d.timings.track("index", indexStart)
+			return fmt.Errorf("failed to journal %s in %s: %w", entry.Path, d.root, err)
+		}
+	}
+	mapping := make(map[string]string, len(origins))
+	for _, origin := range origins {
+		mapping[origin] = newFileName
+	}
+	err = writeIndexJSON(destPath, mapping)
+	d.timings.track("index", indexStart)
+	if err != nil {
+		return fmt.Errorf("failed to write index.json in %s: %w", destPath, err)
+	}
+	d.manifest.Add(entry)
+	if d.ledger != nil {
+		d.ledger.Add(ledgerEntry(entry))
+	}
+	if d.touched == nil {
+		d.touched = make(map[string]bool)
+	}
+	d.touched[folder] = true
+	return nil
+}
+
+// openJournal starts journaling the entries stored from now on
+func (d *destination) openJournal() error {
+	j, err := manifest.OpenJournal(d.root)
+	if err != nil {
+		return err
+	}
+	d.journal = j
+	return nil
+}
+
+// save writes the manifest, which replaces the journal, and starts a new
+// journal if one was open and keepJournal is set
+func (d *destination) save(keepJournal bool) error {
+	journaling := d.journal != nil
+	if journaling {
+		d.journal.Close()
+		d.journal = nil
+	}
+	if err := d.manifest.Save(d.root); err != nil {
+		// The journal still holds what the manifest lacks
+		if journaling && keepJournal {
+			d.openJournal()
+		}
+		return err
+	}
+	if d.ledger != nil {
+		if err := d.ledger.Save(d.root); err != nil {
+			log.Printf("Failed to update the ledger in %s: %v", d.root, err)
+		}
+	}
+	if journaling && keepJournal {
+		return d.openJournal()
+	}
+	return nil
+}
+
+// writeParity regenerates the parity sidecars of every folder written to
+// during the run, covering all files the manifest lists in that folder
+func (d *destination) writeParity(method string) {
+	for folder := range d.touched {
+		var names []string
+		for _, entry := range d.manifest.Entries {
+			if path.Dir(entry.Path) == filepath.ToSlash(folder) {
+				names = append(names, path.Base(entry.Path))
+			}
+		}
+		if err := parity.Create(filepath.Join(d.root, folder), names, method, d.tools); err != nil {
+			log.Printf("Failed to write parity for %s: %v", filepath.Join(d.root, folder), err)
+		}
+	}
+}
+
+// transformFile writes the cold storage form of a file: RAWs are converted
+// to DNG when dnglab is installed, everything else is zstd compressed. It
+// returns the stored name and the transform applied.
+func (d *destination) transformFile(src, destPath, newFileName string) (string, string, error) {
+	if mediaClass(src) == "raw" && d.tools.DNGLab != "" {
+		name := strings.TrimSuffix(newFileName, filepath.Ext(newFileName)) + ".dng"
+		err := d.tools.ConvertDNG(src, filepath.Join(destPath, name))
+		if err == nil {
+			return name, "dng", nil
+		}
+		log.Printf("DNG conversion failed for %s, compressing instead: %v", src, err)
+	}
+
+	name := newFileName + ".zst"
+	if err := d.tools.Compress(src, filepath.Join(destPath, name)); err != nil {
+		return "", "", err
+	}
+	return name, "zstd", nil
+}
+
+// encryptFile encrypts src into dst, returning the plaintext checksum and size
+func (d *destination) encryptFile(src, dst string) (string, int64, error) {
+	sourceFile, err := openReadOnly(src)
+	if err != nil {
+		return "", 0, err
+	}
+	defer sourceFile.Close()
+
+	h := sha256.New()
+	counter := &countingWriter{w: h}
+	if err := d.tools.Encrypt(d.encryption.Method, d.encryption.Recipients, io.TeeReader(sourceFile, counter), dst); err != nil {
+		os.Remove(dst)
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), counter.n, nil
+}
+
+// countingWriter counts the bytes passed through to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// fileChecksumSize returns the SHA-256 and size of a file
+func fileChecksumSize(filePath string) (string, int64, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	sum, err := fileChecksum(filePath)
+	return sum, info.Size(), err
+}
+
+// copyFileChecksum copies a file like copyFile while computing the SHA-256 of
+// the bytes written
+func copyFileChecksum(src, dst string) (string, int64, error) {
+	if err := checkNotSameFile(src, dst); err != nil {
+		return "", 0, err
+	}
+
+	sourceFile, err := openReadOnly(src)
+	if err != nil {
+		return "", 0, err
+	}
+	defer sourceFile.Close()
+
+	destFile, err := os.Create(dst)
+	if err != nil {
+		return "", 0, err
+	}
+	defer destFile.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(destFile, h), sourceFile)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
@@ -0,0 +1,237 @@
+package imagedup
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Report collects the decisions made during a run that deserve a closer look
+type Report struct {
+	// RunID matches the run_id recorded in the manifest for files this run
+	// copied
+	RunID          string           `json:"run_id"`
+	Groups         []DuplicateGroup `json:"groups,omitempty"`
+	NearDuplicates []NearDuplicate  `json:"near_duplicates,omitempty"`
+	Collisions     []Collision      `json:"collisions,omitempty"`
+	Trims          []Trim           `json:"trims,omitempty"`
+	Sequences      []Sequence       `json:"sequences,omitempty"`
+	Related        []Related        `json:"related,omitempty"`
+	Similar        []SimilarGroup   `json:"similar,omitempty"`
+	AmbiguousDates []AmbiguousDate  `json:"ambiguous_dates,omitempty"`
+
+	// MtimeDated lists kept files dated only by their modification time,
+	// the least trustworthy filing decisions
+	MtimeDated []string `json:"mtime_dated,omitempty"`
+
+	// Damaged lists kept images that only partly decode, archived in
+	// salvage mode, for repair or replacement from another copy
+	Damaged []string `json:"damaged,omitempty"`
+
+	// LowResolution lists kept images smaller than the LowResolution
+	// option, such as thumbnails and images saved from messaging apps
+	LowResolution []string `json:"low_resolution,omitempty"`
+
+	// LargeGroups lists the duplicate groups larger than the LargeGroup
+	// option, to check before deleting anything
+	LargeGroups []LargeGroup `json:"large_groups,omitempty"`
+
+	// Unreadable lists, by folder, the source files and folders that
+	// couldn't be read for lack of permission and were skipped
+	Unreadable []UnreadableDir `json:"unreadable,omitempty"`
+
+	// CaseConflicts lists sets of source paths that differ only by case,
+	// such as IMG_1.JPG and img_1.jpg, which can't coexist on macOS or
+	// Windows file systems
+	CaseConflicts [][]string `json:"case_conflicts,omitempty"`
+
+	// Labels lists the kept files by their XMP colour label
+	Labels map[string][]string `json:"labels,omitempty"`
+
+	// AlreadyArchived maps files whose content the ledger shows an earlier
+	// run imported to where that run stored it
+	AlreadyArchived map[string]string `json:"already_archived,omitempty"`
+
+	// Managed lists the duplicates and already archived files a catalog
+	// manages, which are never offered for deletion
+	Managed []string `json:"managed,omitempty"`
+
+	// Protected lists the files within protected folders left out of the
+	// archive, such as those an earlier run archived, which are never
+	// offered for deletion either
+	Protected []string `json:"protected,omitempty"`
+
+	// Deselected counts kept files left alone for being dated outside the
+	// run's date range
+	Deselected int `json:"deselected,omitempty"`
+
+	Timings []StageTiming `json:"timings,omitempty"`
+	Savings *Savings      `json:"savings,omitempty"`
+}
+
+// DuplicateGroup lists the files collapsed into one keeper
+type DuplicateGroup struct {
+	Keeper  string        `json:"keeper"`
+	Members []GroupMember `json:"members"`
+}
+
+// GroupMember is a file dropped in favor of its group's keeper. Exact reports
+// whether its bytes match the keeper; Distance is the Hamming distance between
+// their perceptual hashes (or sampled frames for videos).
+type GroupMember struct {
+	File     string `json:"file"`
+	Exact    bool   `json:"exact"`
+	Distance int    `json:"distance"`
+}
+
+// NearDuplicate records a file sharing a perceptual hash with a keeper while
+// differing at the byte level. Kept reports whether it was copied anyway.
+type NearDuplicate struct {
+	Keeper string `json:"keeper"`
+	File   string `json:"file"`
+	Kept   bool   `json:"kept"`
+}
+
+// Collision records an image sharing a perceptual hash with a keeper whose
+// difference hash is Distance bits away. Kept reports whether it was
+// archived as a picture of its own.
+type Collision struct {
+	Keeper   string `json:"keeper"`
+	File     string `json:"file"`
+	Distance int    `json:"distance"`
+	Kept     bool   `json:"kept"`
+}
+
+// Trim records a video that is a trimmed subset of a longer original.
+// Dropped reports whether the trimmed copy was left out of the archive.
+type Trim struct {
+	Original string        `json:"original"`
+	Trimmed  string        `json:"trimmed"`
+	Offset   time.Duration `json:"offset_ns"`
+	Length   time.Duration `json:"length_ns"`
+	Dropped  bool          `json:"dropped"`
+}
+
+// Sequence records a timelapse exempted from deduplication and archived
+// whole under Folder
+type Sequence struct {
+	Folder string   `json:"folder"`
+	Frames []string `json:"frames"`
+}
+
+// Related records an image that appears to be a reproduction of another, such
+// as a photo of a print or a screenshot of it on screen. Both are kept.
+// Bordered reports whether a border was trimmed from the reproduction.
+type Related struct {
+	Original string `json:"original"`
+	Copy     string `json:"copy"`
+	Distance int    `json:"distance"`
+	Bordered bool   `json:"bordered"`
+}
+
+// SimilarGroup lists kept images an embedding backend found visually
+// similar, such as shots of the same scene. All of them are archived.
+type SimilarGroup struct {
+	Files []string `json:"files"`
+}
+
+// AmbiguousDate records a file filed under a filename date that could also
+// be read as other dates, such as 02/03/2004, so the choice can be reviewed
+type AmbiguousDate struct {
+	File         string   `json:"file"`
+	Date         string   `json:"date"`
+	Alternatives []string `json:"alternatives"`
+}
+
+// addGroup records a duplicate group, given each member's checksum
+func (r *Report) addGroup(keeper imageInfo, members []imageInfo, sumOf map[string]string) {
+	group := DuplicateGroup{Keeper: keeper.filename}
+	for _, member := range members {
+		if member.filename == keeper.filename {
+			continue
+		}
+		group.Members = append(group.Members, GroupMember{
+			File:     member.filename,
+			Exact:    sumOf[member.filename] == sumOf[keeper.filename],
+			Distance: hashDistance(keeper, member),
+		})
+	}
+	r.Groups = append(r.Groups, group)
+}
+
+// duplicatesOf maps each keeper to the files left out in its favor: the
+// members of its duplicate group and the trimmed copies dropped for it
+func (r *Report) duplicatesOf() map[string][]string {
+	duplicates := make(map[string][]string)
+	for _, group := range r.Groups {
+		for _, member := range group.Members {
+			duplicates[group.Keeper] = append(duplicates[group.Keeper], member.File)
+		}
+	}
+	for _, trim := range r.Trims {
+		if trim.Dropped {
+			duplicates[trim.Original] = append(duplicates[trim.Original], trim.Trimmed)
+		}
+	}
+	return duplicates
+}
+
+// addNearDuplicate records a near-duplicate relationship
+func (r *Report) addNearDuplicate(keeper, file string, kept bool) {
+	r.NearDuplicates = append(r.NearDuplicates, NearDuplicate{Keeper: keeper, File: file, Kept: kept})
+}
+
+// addCollision records a hash collision
+func (r *Report) addCollision(keeper, file string, distance int, kept bool) {
+	r.Collisions = append(r.Collisions, Collision{Keeper: keeper, File: file, Distance: distance, Kept: kept})
+}
+
+// addTrim records a trim relationship
+func (r *Report) addTrim(original, trimmed string, offset, length time.Duration, dropped bool) {
+	r.Trims = append(r.Trims, Trim{Original: original, Trimmed: trimmed, Offset: offset, Length: length, Dropped: dropped})
+}
+
+// addSequence records a timelapse sequence
+func (r *Report) addSequence(folder string, frames []string) {
+	r.Sequences = append(r.Sequences, Sequence{Folder: folder, Frames: frames})
+}
+
+// addRelated records a related-image relationship
+func (r *Report) addRelated(original, repro string, distance int, bordered bool) {
+	r.Related = append(r.Related, Related{Original: original, Copy: repro, Distance: distance, Bordered: bordered})
+}
+
+// addSimilar records a group of visually similar images
+func (r *Report) addSimilar(files []string) {
+	r.Similar = append(r.Similar, SimilarGroup{Files: files})
+}
+
+// addAmbiguousDate records a file dated from an ambiguous filename date
+func (r *Report) addAmbiguousDate(file, date string, alternatives []string) {
+	r.AmbiguousDates = append(r.AmbiguousDates, AmbiguousDate{File: file, Date: date, Alternatives: alternatives})
+}
+
+// addLabel records a kept file's colour label
+func (r *Report) addLabel(label, file string) {
+	if r.Labels == nil {
+		r.Labels = make(map[string][]string)
+	}
+	r.Labels[label] = append(r.Labels[label], file)
+}
+
+// addArchived records a file already imported by an earlier run
+func (r *Report) addArchived(file, stored string) {
+	if r.AlreadyArchived == nil {
+		r.AlreadyArchived = make(map[string]string)
+	}
+	r.AlreadyArchived[file] = stored
+}
+
+// WriteJSON writes the report to path as indented JSON
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
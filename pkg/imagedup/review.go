@@ -0,0 +1,110 @@
+package imagedup
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ReviewLogName is the file in the destination root that review decisions
+// are appended to when a run applies its plan
+const ReviewLogName = "review.jsonl"
+
+// ReviewDecision is one recorded answer of a collision review. The pair is
+// identified by the SHA-256 of both files, so an answer is only reused for
+// the same content, wherever it is found; the paths are for reading.
+type ReviewDecision struct {
+	Keeper       string    `json:"keeper"`
+	File         string    `json:"file"`
+	KeeperSHA256 string    `json:"keeper_sha256"`
+	FileSHA256   string    `json:"file_sha256"`
+	Same         bool      `json:"same"`
+	At           time.Time `json:"at"`
+}
+
+// reviewLog puts a CollisionReviewer in front of the decisions recorded in
+// a destination, so pairs answered by an earlier run aren't asked again.
+// New answers are only held until the plan is applied, which appends them
+// under the destination lock; a pair the reviewer had no answer for is
+// asked again next run. It is used by one goroutine, the planner.
+type reviewLog struct {
+	reviewer CollisionReviewer
+	now      func() time.Time
+
+	decisions map[[2]string]bool
+	pending   []ReviewDecision
+}
+
+// newReviewLog wraps reviewer with the review log of destDir, loading the
+// decisions of earlier runs. Nothing is written.
+func newReviewLog(reviewer CollisionReviewer, destDir string, now func() time.Time) *reviewLog {
+	l := &reviewLog{reviewer: reviewer, now: now, decisions: make(map[[2]string]bool)}
+	f, err := os.Open(filepath.Join(destDir, ReviewLogName))
+	if err != nil {
+		return l
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var d ReviewDecision
+		// A line cut short by a crash is skipped, as are answers recorded
+		// without checksums; later lines win
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil || d.KeeperSHA256 == "" || d.FileSHA256 == "" {
+			continue
+		}
+		l.decisions[[2]string{d.KeeperSHA256, d.FileSHA256}] = d.Same
+	}
+	return l
+}
+
+// sameImage returns the recorded decision for a pair of files with the given
+// checksums, or asks the reviewer and holds its answer for recording
+func (l *reviewLog) sameImage(keeper, file, keeperSum, fileSum string, distance int) (bool, error) {
+	key := [2]string{keeperSum, fileSum}
+	if same, ok := l.decisions[key]; ok {
+		return same, nil
+	}
+	same, err := l.reviewer.SameImage(keeper, file, distance)
+	if err != nil {
+		// ErrNoAnswer included, so the pair is asked again next run
+		return same, err
+	}
+	l.decisions[key] = same
+	l.pending = append(l.pending, ReviewDecision{
+		Keeper:       keeper,
+		File:         file,
+		KeeperSHA256: keeperSum,
+		FileSHA256:   fileSum,
+		Same:         same,
+		At:           l.now().UTC(),
+	})
+	return same, nil
+}
+
+// recordReviews appends decisions to the review log of destDir in a single
+// write, so a crash leaves at most the last line cut short. Callers hold the
+// destination lock. The log is created on the first decision.
+func recordReviews(destDir string, decisions []ReviewDecision) error {
+	if len(decisions) == 0 {
+		return nil
+	}
+	var data []byte
+	for _, d := range decisions {
+		line, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	f, err := os.OpenFile(filepath.Join(destDir, ReviewLogName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
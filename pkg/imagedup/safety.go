@@ -0,0 +1,160 @@
+package imagedup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrDestinationInSource is returned when an output location is the source
+// directory or lies inside it, which would write into the source tree
+var ErrDestinationInSource = errors.New("destination is inside the source directory")
+
+// ErrSourceInDestination is returned when the source directory lies inside
+// an output location, so the run would organize the archive into itself
+var ErrSourceInDestination = errors.New("source is inside the destination directory")
+
+// resolvePath returns the absolute, symlink free form of path. Paths that
+// don't exist yet are resolved through their nearest existing parent.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return filepath.Join(append([]string{abs}, missing...)...), nil
+		}
+		missing = append([]string{filepath.Base(abs)}, missing...)
+		abs = parent
+	}
+}
+
+// isWithin reports whether path equals root or lies beneath it. Both must
+// be resolved.
+func isWithin(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// outputPaths lists every location a run writes to
+func outputPaths(destDir string, opts Options) []string {
+	paths := append([]string{destDir}, opts.Replicas...)
+	if opts.ColdStorage != "" {
+		paths = append(paths, opts.ColdStorage)
+	}
+	for _, profile := range opts.Derivatives {
+		paths = append(paths, profile.Dest)
+	}
+	for _, sink := range reportSinks(opts) {
+		if file, ok := sink.(*fileSink); ok {
+			paths = append(paths, file.path)
+		}
+	}
+	if opts.GraphPath != "" {
+		paths = append(paths, opts.GraphPath)
+	}
+	// Thumbnails are embedded in the HTML report, which is the only file
+	// -html-thumbnails adds to
+	if opts.HTMLReportPath != "" {
+		paths = append(paths, opts.HTMLReportPath)
+	}
+	return paths
+}
+
+// checkSourceSafety refuses to run when any output location would write into
+// the source tree, so the source is never modified, or when the source lies
+// inside an output location. With ExcludeDestination set, outputs strictly
+// inside the source are allowed; collectFiles then skips them.
+func checkSourceSafety(srcDir, destDir string, opts Options) error {
+	src, err := resolvePath(srcDir)
+	if err != nil {
+		return err
+	}
+	for _, out := range outputPaths(destDir, opts) {
+		resolved, err := resolvePath(out)
+		if err != nil {
+			return err
+		}
+		if isWithin(resolved, src) && (!opts.ExcludeDestination || resolved == src) {
+			return fmt.Errorf("%w: %s is within %s (use -exclude-dest to skip it while scanning)", ErrDestinationInSource, out, srcDir)
+		}
+		if isWithin(src, resolved) && resolved != src {
+			return fmt.Errorf("%w: %s is within %s", ErrSourceInDestination, srcDir, out)
+		}
+	}
+	// Spill files go in a temporary folder of their own, so only the spill
+	// directory lying in the source matters
+	if opts.SpillDir != "" {
+		resolved, err := resolvePath(opts.SpillDir)
+		if err != nil {
+			return err
+		}
+		if isWithin(resolved, src) {
+			return fmt.Errorf("%w: %s is within %s", ErrDestinationInSource, opts.SpillDir, srcDir)
+		}
+	}
+	return nil
+}
+
+// excludedOutputs returns the output locations inside the source tree, in the
+// form the walker will see them (joined onto srcDir)
+func excludedOutputs(srcDir, destDir string, opts Options) map[string]bool {
+	excluded := make(map[string]bool)
+	if !opts.ExcludeDestination {
+		return excluded
+	}
+	src, err := resolvePath(srcDir)
+	if err != nil {
+		return excluded
+	}
+	for _, out := range outputPaths(destDir, opts) {
+		resolved, err := resolvePath(out)
+		if err != nil || !isWithin(resolved, src) {
+			continue
+		}
+		if rel, err := filepath.Rel(src, resolved); err == nil {
+			excluded[filepath.Join(srcDir, rel)] = true
+		}
+	}
+	return excluded
+}
+
+// openReadOnly opens a source file. Source files are only ever opened
+// through here, read-only.
+func openReadOnly(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDONLY, 0)
+}
+
+// errSameFile is returned when a copy's source and destination are one file
+var errSameFile = errors.New("source and destination are the same file")
+
+// checkNotSameFile refuses to copy a file onto itself, which would truncate it
+func checkNotSameFile(src, dst string) error {
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return nil
+	}
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(srcInfo, dstInfo) {
+		return fmt.Errorf("%w: %s and %s", errSameFile, src, dst)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package imagedup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckSourceSafety(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(root, "src")
+	if err := os.MkdirAll(filepath.Join(src, "photos"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(src, "photos"), filepath.Join(root, "link-into-src")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(src, filepath.Join(root, "link-to-src")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		dest string
+		opts func(*Options)
+		want error
+	}{
+		{name: "separate", src: src, dest: filepath.Join(root, "archive")},
+		{name: "dest is source", src: src, dest: src, want: ErrDestinationInSource},
+		{name: "dest inside source", src: src, dest: filepath.Join(src, "archive"), want: ErrDestinationInSource},
+		{name: "dest inside source, excluded", src: src, dest: filepath.Join(src, "archive"), opts: func(o *Options) { o.ExcludeDestination = true }},
+		{name: "dest is source, excluded", src: src, dest: src, opts: func(o *Options) { o.ExcludeDestination = true }, want: ErrDestinationInSource},
+		{name: "source inside dest", src: filepath.Join(src, "photos"), dest: src, want: ErrSourceInDestination},
+		{name: "dest into source through symlink", src: src, dest: filepath.Join(root, "link-into-src", "archive"), want: ErrDestinationInSource},
+		{name: "source through symlink", src: filepath.Join(root, "link-to-src"), dest: filepath.Join(src, "archive"), want: ErrDestinationInSource},
+		{name: "replica inside source", src: src, dest: filepath.Join(root, "archive"), opts: func(o *Options) { o.Replicas = []string{filepath.Join(src, "copy")} }, want: ErrDestinationInSource},
+		{name: "HTML report inside source", src: src, dest: filepath.Join(root, "archive"), opts: func(o *Options) { o.HTMLReportPath = filepath.Join(src, "report.html") }, want: ErrDestinationInSource},
+		{name: "report inside source", src: src, dest: filepath.Join(root, "archive"), opts: func(o *Options) { o.ReportPath = filepath.Join(src, "report.json") }, want: ErrDestinationInSource},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			if tt.opts != nil {
+				tt.opts(&opts)
+			}
+			err := checkSourceSafety(tt.src, tt.dest, opts)
+			if tt.want == nil && err != nil {
+				t.Fatalf("checkSourceSafety(%s, %s) = %v, want nil", tt.src, tt.dest, err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("checkSourceSafety(%s, %s) = %v, want %v", tt.src, tt.dest, err, tt.want)
+			}
+		})
+	}
+}
+
+// sourceFile is what a run must leave unchanged about a source file
+type sourceFile struct {
+	data    []byte
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+func snapshotTree(t *testing.T, dir string) map[string]sourceFile {
+	t.Helper()
+	files := make(map[string]sourceFile)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[path] = sourceFile{data: data, modTime: info.ModTime(), mode: info.Mode()}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestRunLeavesSourceUnchanged(t *testing.T) {
+	root := t.TempDir()
+	src, dest := filepath.Join(root, "src"), filepath.Join(root, "archive")
+	spec := DefaultTestDataSpec()
+	spec.Images, spec.Size, spec.RAWs = 12, 32, 4
+	if _, err := GenerateTestData(src, spec); err != nil {
+		t.Fatal(err)
+	}
+	before := snapshotTree(t, src)
+
+	opts := DefaultOptions()
+	opts.Output = io.Discard
+	org, err := NewOrganizer(src, dest, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	index, err := org.Scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := org.Plan(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := org.Apply(ctx, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Failed) > 0 {
+		t.Fatalf("failed to copy %v", result.Failed)
+	}
+	if result.Copied["image"] == 0 {
+		t.Fatal("the run copied no images")
+	}
+
+	after := snapshotTree(t, src)
+	if len(after) != len(before) {
+		t.Fatalf("source has %d files after the run, %d before", len(after), len(before))
+	}
+	for path, was := range before {
+		now, ok := after[path]
+		switch {
+		case !ok:
+			t.Errorf("%s is gone", path)
+		case !bytes.Equal(now.data, was.data):
+			t.Errorf("%s changed content", path)
+		case !now.modTime.Equal(was.modTime):
+			t.Errorf("%s changed modification time from %v to %v", path, was.modTime, now.modTime)
+		case now.mode != was.mode:
+			t.Errorf("%s changed mode from %v to %v", path, was.mode, now.mode)
+		}
+	}
+}
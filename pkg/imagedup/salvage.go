@@ -0,0 +1,101 @@
+package imagedup
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// salvagePadding is how many zero bytes per pixel are appended to a
+// truncated JPEG before decoding it again. Zero bits decode as the shortest
+// Huffman codes, which take under a byte per pixel even without chroma
+// subsampling.
+const salvagePadding = 2
+
+// maxSalvagePixels bounds the image size salvageJPEG trusts from the header
+// of a file already known to be damaged. It is above any camera sensor, so a
+// larger size is a corrupt header and the file is skipped rather than padded
+// with gigabytes of zeros.
+const maxSalvagePixels = 1 << 27
+
+// damagedImage is an image only partly decoded from a damaged file. The
+// lost part of it is noise.
+type damagedImage struct {
+	image.Image
+}
+
+// isDamaged reports whether an image was salvaged from a damaged file
+func isDamaged(img image.Image) bool {
+	_, ok := img.(damagedImage)
+	return ok
+}
+
+// salvaging wraps a decoder so JPEGs it fails on are decoded again as far as
+// their data goes, for old files with truncated bodies
+func salvaging(decode decodeFunc) decodeFunc {
+	return func(filePath string) (image.Image, error) {
+		img, err := decode(filePath)
+		if err == nil || !isJPEG(filePath) {
+			return img, err
+		}
+		salvaged, salvageErr := salvageJPEG(filePath)
+		if salvageErr != nil {
+			return nil, err
+		}
+		return damagedImage{salvaged}, nil
+	}
+}
+
+// isJPEG reports whether a file is JPEG encoded
+func isJPEG(filePath string) bool {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	return ext == ".jpg" || ext == ".jpeg"
+}
+
+// salvageJPEG decodes a JPEG whose scan data ends early by padding it out
+// and closing it. The headers must be intact.
+func salvageJPEG(filePath string) (image.Image, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("no image size in %s", filePath)
+	}
+	pixels := int64(cfg.Width) * int64(cfg.Height)
+	if pixels > maxSalvagePixels {
+		return nil, fmt.Errorf("implausible image size %dx%d in %s", cfg.Width, cfg.Height, filePath)
+	}
+	padding := int(pixels) * salvagePadding
+	padded := make([]byte, len(data), len(data)+padding+2)
+	copy(padded, data)
+	padded = append(padded, make([]byte, padding)...)
+	padded = append(padded, 0xFF, 0xD9)
+	img, err := jpeg.Decode(bytes.NewReader(padded))
+	if err != nil {
+		return nil, err
+	}
+	return normalizeColor(img, iccProfile(filePath)), nil
+}
+
+// intact narrows a group to its undamaged files, unless all are damaged
+func intact(files []imageInfo) []imageInfo {
+	var kept []imageInfo
+	for _, fileInfo := range files {
+		if !fileInfo.damaged {
+			kept = append(kept, fileInfo)
+		}
+	}
+	if len(kept) == 0 {
+		return files
+	}
+	return kept
+}
@@ -0,0 +1,115 @@
+package imagedup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedulePollInterval is how often a waiting pipeline rechecks its schedule
+const schedulePollInterval = time.Minute
+
+// Schedule restricts when copying may happen so continuous organization
+// can coexist with other workloads. A nil *Schedule always allows copying.
+type Schedule struct {
+	// Start and End bound the daily copy window as offsets from midnight.
+	// A window may wrap past midnight (e.g. 22:00-06:00). Equal values
+	// mean no window.
+	Start, End time.Duration
+
+	// MaxLoad pauses copying while the 1 minute load average exceeds it.
+	// Zero disables the check.
+	MaxLoad float64
+}
+
+// ParseWindow parses a daily window such as "01:00-06:00"
+func ParseWindow(window string) (time.Duration, time.Duration, error) {
+	from, to, ok := strings.Cut(window, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("window %q must look like HH:MM-HH:MM", window)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// parseClock parses HH:MM into an offset from midnight
+func parseClock(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", clock)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// inWindow reports whether now falls inside the daily window
+func (s *Schedule) inWindow(now time.Time) bool {
+	if s.Start == s.End {
+		return true
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	if s.Start < s.End {
+		return offset >= s.Start && offset < s.End
+	}
+	return offset >= s.Start || offset < s.End
+}
+
+// underLoad reports whether the system load is below the ceiling. Systems
+// without /proc/loadavg are always considered under the ceiling.
+func (s *Schedule) underLoad() bool {
+	if s.MaxLoad <= 0 {
+		return true
+	}
+	load, err := loadAverage()
+	if err != nil {
+		return true
+	}
+	return load <= s.MaxLoad
+}
+
+// loadAverage reads the 1 minute load average
+func loadAverage() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty /proc/loadavg")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// wait blocks until the schedule allows copying, or ctx is cancelled
+func (s *Schedule) wait(ctx context.Context) error {
+	if s == nil {
+		return ctx.Err()
+	}
+	logged := false
+	for !s.inWindow(time.Now()) || !s.underLoad() {
+		if !logged {
+			log.Printf("Outside the copy schedule, waiting")
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(schedulePollInterval):
+		}
+	}
+	if logged {
+		log.Printf("Copy schedule allows copying again, continuing")
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,85 @@
+package imagedup
+
+import "path/filepath"
+
+// onlyPaths keeps the scanned files lying within one of paths, which are
+// relative to the source unless absolute, together with their forks
+func (scan *sourceScan) onlyPaths(srcDir string, paths []string) {
+	var roots []string
+	for _, p := range paths {
+		roots = append(roots, sourcePath(srcDir, p))
+	}
+	var files []string
+	for _, file := range scan.files {
+		if within(absPath(file), roots) {
+			files = append(files, file)
+		} else {
+			delete(scan.companions, file)
+		}
+	}
+	scan.files = files
+}
+
+// sourcePath resolves a path relative to the source unless absolute
+func sourcePath(srcDir, p string) string {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(srcDir, p)
+	}
+	return absPath(p)
+}
+
+// dateSelected reports whether a kept file's date falls within the Since and
+// Until options. Files filed under a period, and undated files, are outside
+// any range.
+func (o Options) dateSelected(fileInfo imageInfo) bool {
+	if o.Since == "" && o.Until == "" {
+		return true
+	}
+	if fileInfo.period != "" || fileInfo.taken.Time.IsZero() {
+		return false
+	}
+	return dateInRange(fileInfo.taken.Date(), o.Since, o.Until)
+}
+
+// selectDates leaves out the kept files dated outside the Since and Until
+// options, and the duplicates they stand for, so neither is copied nor
+// offered for deletion. It returns the files left, and how many of each
+// class were left out.
+func selectDates(files []imageInfo, dropped []string, opts Options, report *Report) ([]imageInfo, []string, map[string]int) {
+	deselected := make(map[string]int)
+	if opts.Since == "" && opts.Until == "" {
+		return files, dropped, deselected
+	}
+	left := make(map[string]bool)
+	var kept []imageInfo
+	for _, fileInfo := range files {
+		if opts.dateSelected(fileInfo) {
+			kept = append(kept, fileInfo)
+			continue
+		}
+		left[fileInfo.filename] = true
+		deselected[mediaClass(fileInfo.filename)]++
+	}
+
+	keeperOf := make(map[string]string)
+	for _, group := range report.Groups {
+		for _, member := range group.Members {
+			keeperOf[member.File] = group.Keeper
+		}
+	}
+	for _, trim := range report.Trims {
+		if trim.Dropped {
+			keeperOf[trim.Trimmed] = trim.Original
+		}
+	}
+	var remaining []string
+	for _, file := range dropped {
+		if left[keeperOf[file]] {
+			deselected[mediaClass(file)]++
+			continue
+		}
+		remaining = append(remaining, file)
+	}
+	report.Deselected = len(left)
+	return kept, remaining, deselected
+}
@@ -0,0 +1,328 @@
+package imagedup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/dateutil"
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+)
+
+// selfTestLongName is a generated file name close to common length limits
+var selfTestLongName = "odd/very" + strings.Repeat("-long", 40) + ".jpg"
+
+// SelfTestCheck is one expectation verified by SelfTest
+type SelfTestCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// SelfTestResult is the outcome of a SelfTest run
+type SelfTestResult struct {
+	// Source and Archive are the generated tree and the destination it was
+	// imported into
+	Source  string          `json:"source"`
+	Archive string          `json:"archive"`
+	Checks  []SelfTestCheck `json:"checks"`
+}
+
+// Passed reports whether every check passed
+func (r *SelfTestResult) Passed() bool {
+	for _, check := range r.Checks {
+		if !check.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// selfTestFile is a file of the generated tree and what an import should do
+// with it
+type selfTestFile struct {
+	rel  string
+	data []byte
+	// taken is when the file should be dated as taken, if known
+	taken time.Time
+}
+
+// SelfTest generates a small source tree under dir with known duplicates,
+// dates and awkward names, imports it with opts into a destination under dir
+// and checks the result. Every output location in opts (replicas, cold
+// storage, derivative trees, reports) is redirected under dir, so no real
+// data is read or written.
+func SelfTest(dir string, opts Options) (*SelfTestResult, error) {
+	result := &SelfTestResult{
+		Source:  filepath.Join(dir, "source"),
+		Archive: filepath.Join(dir, "archive"),
+	}
+	files, err := writeSelfTestTree(result.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the test tree: %w", err)
+	}
+	opts = selfTestOptions(dir, result.Archive, opts)
+
+	if err := ProcessFiles(result.Source, result.Archive, opts); err != nil {
+		return nil, err
+	}
+	m, err := manifest.Load(result.Archive)
+	if err != nil {
+		return nil, err
+	}
+	archived := make(map[string]manifest.Entry)
+	for _, e := range m.Entries {
+		if rel, err := filepath.Rel(result.Source, e.Source); err == nil {
+			archived[filepath.ToSlash(rel)] = e
+		}
+	}
+	count := func(rels ...string) int {
+		n := 0
+		for _, rel := range rels {
+			if _, ok := archived[rel]; ok {
+				n++
+			}
+		}
+		return n
+	}
+	add := func(name string, passed bool, detail string, args ...interface{}) {
+		check := SelfTestCheck{Name: name, Passed: passed}
+		if !passed {
+			check.Detail = fmt.Sprintf(detail, args...)
+		}
+		result.Checks = append(result.Checks, check)
+	}
+
+	n := count("holiday/beach.jpg", "backup/Copy of beach.jpg")
+	add("byte-identical copies are archived once", n == 1, "%d of 2 copies archived", n)
+
+	n = count("holiday/beach.jpg", "backup/Copy of beach.jpg", "shared/beach_small.jpg")
+	if opts.strictFor("image") {
+		add("a resized copy is kept in strict mode", n == 2, "%d of 2 distinct files archived", n)
+	} else {
+		add("a resized copy is treated as a duplicate", n == 1, "%d copies of the image archived", n)
+	}
+
+	n = count("raw/DSC_0042.NEF", "raw/DSC_0042 (1).NEF")
+	add("identical RAW files are archived once", n == 1, "%d of 2 copies archived", n)
+
+	distinct := []string{"holiday/sunset.png", "-odd/ünïcödé 写真.jpg", "odd/  spaced  name .JPG", selfTestLongName}
+	n = count(distinct...)
+	add("distinct images with awkward names are all archived", n == len(distinct), "%d of %d archived", n, len(distinct))
+
+	n = count("holiday/.DS_Store", "notes.txt")
+	add("system and non-media files are skipped", n == 0, "%d archived", n)
+
+	for _, file := range files {
+		if file.taken.IsZero() {
+			continue
+		}
+		e, ok := archived[file.rel]
+		fields := timestampFields(dateutil.Timestamp{Time: file.taken, Clock: true})
+		fields.width, fields.height = e.Width, e.Height
+		fields.parent = parentName(filepath.Join(result.Source, filepath.FromSlash(file.rel)), result.Source)
+		fields.event = eventName(opts.Events, fields.date)
+		want := filepath.ToSlash(opts.Layout.eventLayout(opts.Events).folder(fields))
+		if opts.Mirror {
+			want = path.Dir(file.rel)
+		}
+		got := filepath.ToSlash(filepath.Dir(e.Path))
+		add("dated "+file.rel+" under "+want, ok && got == want, "archived under %q", got)
+	}
+
+	fsck := manifest.Fsck(result.Archive, m, 0, time.Now())
+	add("the archive verifies against its manifest", fsck.Healthy(), "%d missing, %d corrupt", len(fsck.Missing), len(fsck.Corrupt))
+
+	// A second run over the same source must not copy anything again
+	if err := ProcessFiles(result.Source, result.Archive, opts); err != nil {
+		return nil, err
+	}
+	again, err := manifest.Load(result.Archive)
+	if err != nil {
+		return nil, err
+	}
+	add("a repeated run copies nothing", len(again.Entries) == len(m.Entries), "%d files archived, then %d", len(m.Entries), len(again.Entries))
+	return result, nil
+}
+
+// selfTestOptions redirects every location opts writes to under dir, and
+// drops settings that would wait or divert output
+func selfTestOptions(dir, archive string, opts Options) Options {
+	redirected := map[string]string{}
+	var replicas []string
+	for i, replica := range opts.Replicas {
+		root := filepath.Join(dir, fmt.Sprintf("replica-%d", i+1))
+		redirected[filepath.Clean(replica)] = root
+		replicas = append(replicas, root)
+	}
+	opts.Replicas = replicas
+	if opts.ColdStorage != "" {
+		root := filepath.Join(dir, "cold")
+		redirected[filepath.Clean(opts.ColdStorage)] = root
+		opts.ColdStorage = root
+	}
+
+	var derivatives []DerivativeProfile
+	for i, profile := range opts.Derivatives {
+		profile.Dest = filepath.Join(dir, fmt.Sprintf("derivative-%d", i+1))
+		derivatives = append(derivatives, profile)
+	}
+	opts.Derivatives = derivatives
+
+	// Encrypted roots that aren't replicas or cold storage name the primary
+	// destination
+	if opts.Encryption != nil {
+		encryption := *opts.Encryption
+		encryption.Destinations = nil
+		for _, root := range opts.Encryption.Destinations {
+			if to, ok := redirected[filepath.Clean(root)]; ok {
+				root = to
+			} else {
+				root = archive
+			}
+			encryption.Destinations = append(encryption.Destinations, root)
+		}
+		opts.Encryption = &encryption
+	}
+
+	if opts.ReportPath != "" || len(opts.ReportSinks) > 0 {
+		opts.ReportPath = filepath.Join(dir, "report.json")
+	}
+	opts.ReportSinks = nil
+	if opts.GraphPath != "" {
+		opts.GraphPath = filepath.Join(dir, "graph"+filepath.Ext(opts.GraphPath))
+	}
+	if opts.HTMLReportPath != "" {
+		opts.HTMLReportPath = filepath.Join(dir, "report.html")
+	}
+	opts.FileList = nil
+	opts.Estimate = false
+	opts.DuplicateList = nil
+	opts.Schedule = nil
+	opts.Pauser = nil
+	opts.Status = nil
+	opts.Output = io.Discard
+	return opts
+}
+
+// writeSelfTestTree writes the generated source tree and returns its files
+func writeSelfTestTree(root string) ([]selfTestFile, error) {
+	beach, err := encodeTestJPEG(testPattern(0x0f0f3c3cf0f0c3c3, 128), nil)
+	if err != nil {
+		return nil, err
+	}
+	beachSmall, err := encodeTestJPEG(testPattern(0x0f0f3c3cf0f0c3c3, 64), nil)
+	if err != nil {
+		return nil, err
+	}
+	var sunset bytes.Buffer
+	if err := png.Encode(&sunset, testPattern(0xff00ff00aa55aa55, 96)); err != nil {
+		return nil, err
+	}
+	exifDated, err := encodeTestJPEG(testPattern(0x123456789abcdef0, 128), exifDateTime("2015:03:02 10:00:00"))
+	if err != nil {
+		return nil, err
+	}
+	raw := bytes.Repeat([]byte("not really a RAW file "), 200)
+
+	files := []selfTestFile{
+		{rel: "holiday/beach.jpg", data: beach},
+		{rel: "backup/Copy of beach.jpg", data: beach},
+		{rel: "shared/beach_small.jpg", data: beachSmall},
+		{rel: "holiday/sunset.png", data: sunset.Bytes()},
+		{rel: "camera/IMG_20190704_120000.jpg", taken: time.Date(2019, 7, 4, 12, 0, 0, 0, time.Local)},
+		{rel: "camera/DSC_0001.jpg", data: exifDated, taken: time.Date(2015, 3, 2, 10, 0, 0, 0, time.Local)},
+		{rel: "raw/DSC_0042.NEF", data: raw},
+		{rel: "raw/DSC_0042 (1).NEF", data: raw},
+		{rel: "-odd/ünïcödé 写真.jpg"},
+		{rel: "odd/  spaced  name .JPG"},
+		{rel: selfTestLongName},
+		{rel: "holiday/.DS_Store", data: []byte("\x00\x00\x00\x01Bud1")},
+		{rel: "notes.txt", data: []byte("not media\n")},
+	}
+	// Files without content of their own get a distinct pattern each
+	pattern := uint64(0x8000000000000001)
+	for i := range files {
+		if files[i].data != nil {
+			continue
+		}
+		pattern = pattern*6364136223846793005 + 1442695040888963407
+		if files[i].data, err = encodeTestJPEG(testPattern(pattern, 128), nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, file := range files {
+		path := filepath.Join(root, filepath.FromSlash(file.rel))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, file.data, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// testPattern draws an 8x8 grid of light and dark cells, one per bit, so the
+// average hash of the image is the pattern whatever its size
+func testPattern(bits uint64, size int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	cell := size / 8
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			shade := uint8(30 + x/8 + y/16)
+			if bits&(1<<uint(63-(y/cell)*8-x/cell)) != 0 {
+				shade = uint8(200 + x/8)
+			}
+			img.SetGray(x, y, color.Gray{Y: shade})
+		}
+	}
+	return img
+}
+
+// encodeTestJPEG encodes img, inserting an APP1 segment after the SOI marker
+// when one is given
+func encodeTestJPEG(img image.Image, app1 []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	if app1 == nil {
+		return data, nil
+	}
+	out := append([]byte{}, data[:2]...)
+	out = append(out, 0xff, 0xe1)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(app1)+2))
+	out = append(out, app1...)
+	return append(out, data[2:]...), nil
+}
+
+// exifDateTime builds a minimal EXIF APP1 payload holding only a DateTime
+// tag, formatted "2006:01:02 15:04:05"
+func exifDateTime(value string) []byte {
+	le := binary.LittleEndian
+	value += "\x00"
+	var tiff []byte
+	tiff = append(tiff, 'I', 'I', 42, 0)
+	tiff = le.AppendUint32(tiff, 8)
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = le.AppendUint16(tiff, 0x0132) // DateTime
+	tiff = le.AppendUint16(tiff, 2)      // ASCII
+	tiff = le.AppendUint32(tiff, uint32(len(value)))
+	tiff = le.AppendUint32(tiff, 8+2+12+4)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = append(tiff, value...)
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
@@ -0,0 +1,139 @@
+package imagedup
+
+import (
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// minSequenceLength is the shortest run of frames treated as a timelapse
+const minSequenceLength = 10
+
+// sequenceNumber splits a filename such as IMG_0042.JPG into its prefix and
+// frame number
+var sequenceNumber = regexp.MustCompile(`^(.*?)(\d+)$`)
+
+// sequenceFrame is an image that may belong to a numbered sequence
+type sequenceFrame struct {
+	info   imageInfo
+	number int
+}
+
+// detectSequences finds timelapses: runs of at least minSequenceLength
+// consecutively numbered images in one folder whose neighbours hash alike and
+// were taken at a uniform interval. Their frames are returned separately so
+// they bypass deduplication, each tagged with the sequence folder they are
+// archived under.
+func detectSequences(files []imageInfo, dates *dater, tl tools.Tools, report *Report) (sequenced, rest []imageInfo) {
+	runs := make(map[string][]sequenceFrame)
+	var keys []string
+	for _, fileInfo := range files {
+		ext := filepath.Ext(fileInfo.filename)
+		if !SupportedImageFormats[strings.ToLower(ext)] {
+			rest = append(rest, fileInfo)
+			continue
+		}
+		m := sequenceNumber.FindStringSubmatch(strings.TrimSuffix(filepath.Base(fileInfo.filename), ext))
+		if m == nil {
+			rest = append(rest, fileInfo)
+			continue
+		}
+		number, err := strconv.Atoi(m[2])
+		if err != nil {
+			rest = append(rest, fileInfo)
+			continue
+		}
+		key := filepath.Join(filepath.Dir(fileInfo.filename), m[1]) + "\x00" + canonicalExt(fileInfo.filename)
+		if _, exists := runs[key]; !exists {
+			keys = append(keys, key)
+		}
+		runs[key] = append(runs[key], sequenceFrame{info: fileInfo, number: number})
+	}
+
+	for _, key := range keys {
+		frames := runs[key]
+		sort.Slice(frames, func(i, j int) bool { return frames[i].number < frames[j].number })
+
+		start := 0
+		for i := 1; i <= len(frames); i++ {
+			if i < len(frames) && frames[i].number == frames[i-1].number+1 &&
+				hashDistance(frames[i].info, frames[i-1].info) <= frameHashThreshold {
+				continue
+			}
+			run := frames[start:i]
+			start = i
+			if len(run) < minSequenceLength || !uniformInterval(run, tl) {
+				for _, frame := range run {
+					rest = append(rest, frame.info)
+				}
+				continue
+			}
+			sequenced = append(sequenced, tagSequence(run, dates, report)...)
+		}
+	}
+	return sequenced, rest
+}
+
+// uniformInterval reports whether the frames were captured at a steady
+// interval, allowing each gap to stray a quarter of the median or a second
+func uniformInterval(run []sequenceFrame, tl tools.Tools) bool {
+	var gaps []time.Duration
+	var prev time.Time
+	for i, frame := range run {
+		at, ok := captureTime(frame.info.filename, tl)
+		if !ok {
+			return false
+		}
+		if i > 0 {
+			gaps = append(gaps, at.Sub(prev))
+		}
+		prev = at
+	}
+
+	sorted := append([]time.Duration(nil), gaps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+	slack := median / 4
+	if slack < time.Second {
+		slack = time.Second
+	}
+	for _, gap := range gaps {
+		if gap <= 0 || gap < median-slack || gap > median+slack {
+			return false
+		}
+	}
+	return true
+}
+
+// tagSequence dates every frame of a run by its first frame, so a timelapse
+// spanning midnight stays in one folder, and names the sequence folder after it
+func tagSequence(run []sequenceFrame, dates *dater, report *Report) []imageInfo {
+	first := assignGroupDate(run[0].info, nil, dates)
+	base := filepath.Base(first.filename)
+	folder := "sequence-" + strings.TrimSuffix(base, filepath.Ext(base))
+
+	var frames []string
+	var tagged []imageInfo
+	for _, frame := range run {
+		info := frame.info
+		info.taken, info.period = first.taken, first.period
+		if info.filename != first.filename {
+			info.taken.Alternatives = nil
+			info.readMetadata()
+		} else {
+			info.camera, info.rating, info.label = first.camera, first.rating, first.label
+		}
+		info.sequence = folder
+		tagged = append(tagged, info)
+		frames = append(frames, info.filename)
+	}
+	report.addSequence(filepath.Join(first.dateLabel(), folder), frames)
+	log.Printf("Keeping timelapse of %d frames starting at %s in %s", len(run), first.filename, folder)
+	return tagged
+}
@@ -0,0 +1,121 @@
+package imagedup
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MaxShards is the most shards the dedup state can be split into; shards are
+// chosen by the top byte of a file's hash
+const MaxShards = 256
+
+// shardOf returns the shard of a hash when dedup runs in shards shards
+func shardOf(hash uint64, shards int) int {
+	return int(hash>>56) * shards / MaxShards
+}
+
+// spillRecord is the on-disk form of a scanned file. Only the fields set
+// while scanning are kept.
+type spillRecord struct {
+	Hash     uint64
+	Filename string
+	Size     int64
+	ModTime  time.Time
+
+	ImageWidth, ImageHeight int
+	Damaged                 bool
+
+	Duration      time.Duration
+	Width, Height int
+	Frames        []uint64
+	Bitrate       int64
+}
+
+// spill keeps scan results in temporary files on disk, one per shard, so
+// only one shard of them is in memory at a time while planning
+type spill struct {
+	dir   string
+	files []*os.File
+	encs  []*gob.Encoder
+}
+
+// newSpill creates a temporary spill directory under parent
+func newSpill(parent string, shards int) (*spill, error) {
+	dir, err := os.MkdirTemp(parent, "dedup-spill-")
+	if err != nil {
+		return nil, err
+	}
+	s := &spill{dir: dir}
+	for i := 0; i < shards; i++ {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("shard-%03d.gob", i)))
+		if err != nil {
+			s.remove()
+			return nil, err
+		}
+		s.files = append(s.files, f)
+		s.encs = append(s.encs, gob.NewEncoder(f))
+	}
+	return s, nil
+}
+
+// add writes a scanned file to its shard's file
+func (s *spill) add(fileInfo imageInfo) error {
+	return s.encs[shardOf(fileInfo.hash, len(s.files))].Encode(spillRecord{
+		Hash:     fileInfo.hash,
+		Filename: fileInfo.filename,
+		Size:     fileInfo.size,
+		ModTime:  fileInfo.modTime,
+
+		ImageWidth:  fileInfo.width,
+		ImageHeight: fileInfo.height,
+		Damaged:     fileInfo.damaged,
+
+		Duration: fileInfo.video.duration,
+		Width:    fileInfo.video.width,
+		Height:   fileInfo.video.height,
+		Frames:   fileInfo.video.frames,
+		Bitrate:  fileInfo.bitrate,
+	})
+}
+
+// shard reads back the files of one shard
+func (s *spill) shard(i int) ([]imageInfo, error) {
+	f := s.files[i]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var files []imageInfo
+	dec := gob.NewDecoder(f)
+	for {
+		var r spillRecord
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read spilled shard %d: %w", i, err)
+		}
+		files = append(files, imageInfo{
+			hash:     r.Hash,
+			filename: r.Filename,
+			size:     r.Size,
+			modTime:  r.ModTime,
+			width:    r.ImageWidth,
+			height:   r.ImageHeight,
+			damaged:  r.Damaged,
+			video:    videoMeta{duration: r.Duration, width: r.Width, height: r.Height, frames: r.Frames},
+			bitrate:  r.Bitrate,
+		})
+	}
+	return files, nil
+}
+
+// remove deletes the spill files
+func (s *spill) remove() {
+	for _, f := range s.files {
+		f.Close()
+	}
+	os.RemoveAll(s.dir)
+}
@@ -0,0 +1,181 @@
+package imagedup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultSimilarity is the cosine similarity above which embeddings group
+// images as visually similar
+const DefaultSimilarity = 0.9
+
+// Embedder turns an image into a semantic embedding vector, such as a CLIP
+// image embedding. Vectors from one embedder must share a length.
+type Embedder interface {
+	Embed(filePath string) ([]float32, error)
+}
+
+// commandEmbedder runs an external program per image
+type commandEmbedder struct {
+	name string
+	args []string
+}
+
+// NewCommandEmbedder returns an Embedder running an external command, such
+// as an ONNX runtime script, with the image's path appended to its
+// arguments. The command must print the embedding as a JSON array of numbers.
+func NewCommandEmbedder(command string) (Embedder, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty embedding command")
+	}
+	name, err := exec.LookPath(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	return &commandEmbedder{name: name, args: fields[1:]}, nil
+}
+
+// Embed runs the command on one image
+func (e *commandEmbedder) Embed(filePath string) ([]float32, error) {
+	out, err := exec.Command(e.name, append(e.args, filePath)...).Output()
+	if err != nil {
+		return nil, err
+	}
+	var vector []float32
+	if err := json.Unmarshal(out, &vector); err != nil {
+		return nil, fmt.Errorf("unexpected embedding output: %w", err)
+	}
+	return vector, nil
+}
+
+// serviceEmbedder posts images to an embedding service over HTTP
+type serviceEmbedder struct {
+	url    string
+	client *http.Client
+}
+
+// NewServiceEmbedder returns an Embedder that POSTs each image's bytes to
+// url and expects a JSON response of the form {"embedding": [...]}
+func NewServiceEmbedder(url string) Embedder {
+	return &serviceEmbedder{url: url, client: &http.Client{Timeout: time.Minute}}
+}
+
+// Embed sends one image to the service
+func (e *serviceEmbedder) Embed(filePath string) ([]float32, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := e.client.Post(e.url, "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding service returned %s", resp.Status)
+	}
+	var body struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("unexpected embedding response: %w", err)
+	}
+	return body.Embedding, nil
+}
+
+// findSimilar groups kept images whose embeddings are at least threshold
+// cosine-similar, chaining pairs into groups. Every image is still archived;
+// the groups are only reported.
+func findSimilar(files []imageInfo, embedder Embedder, threshold float64, timings *stageTimings, report *Report) {
+	var names []string
+	var vectors [][]float32
+	for _, fileInfo := range files {
+		if mediaClass(fileInfo.filename) != "image" {
+			continue
+		}
+		start := time.Now()
+		vector, err := embedder.Embed(fileInfo.filename)
+		timings.track("embed", start)
+		if err != nil {
+			log.Printf("Failed to embed %s: %v", fileInfo.filename, err)
+			continue
+		}
+		if len(vectors) > 0 && len(vector) != len(vectors[0]) {
+			log.Printf("Failed to embed %s: got %d dimensions, expected %d", fileInfo.filename, len(vector), len(vectors[0]))
+			continue
+		}
+		names = append(names, fileInfo.filename)
+		vectors = append(vectors, normalizeVector(vector))
+	}
+
+	// Union-find over every pair above the threshold
+	parent := make([]int, len(vectors))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range vectors {
+		for j := i + 1; j < len(vectors); j++ {
+			if dot(vectors[i], vectors[j]) >= threshold {
+				parent[root(j)] = root(i)
+			}
+		}
+	}
+
+	members := make(map[int][]string)
+	var order []int
+	for i, name := range names {
+		r := root(i)
+		if _, ok := members[r]; !ok {
+			order = append(order, r)
+		}
+		members[r] = append(members[r], name)
+	}
+	for _, r := range order {
+		if len(members[r]) > 1 {
+			report.addSimilar(members[r])
+		}
+	}
+}
+
+// normalizeVector scales a vector to unit length, so dot products are cosine
+// similarities
+func normalizeVector(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	norm := math.Sqrt(sum)
+	if norm == 0 {
+		return v
+	}
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(float64(x) / norm)
+	}
+	return out
+}
+
+// dot returns the dot product of two vectors of equal length
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
@@ -0,0 +1,114 @@
+package imagedup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ReportSink receives the JSON report of each run, such as a file or a
+// home-automation endpoint
+type ReportSink interface {
+	SendReport(report *Report) error
+}
+
+// ParseReportSink returns the built-in sink for a target: "-" for stdout,
+// an http:// or https:// URL to POST the report to, or otherwise a file
+func ParseReportSink(target string) (ReportSink, error) {
+	switch {
+	case target == "":
+		return nil, fmt.Errorf("empty report target")
+	case target == "-":
+		return NewWriterSink(os.Stdout), nil
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		return NewHTTPSink(target), nil
+	}
+	return NewFileSink(target), nil
+}
+
+// fileSink writes the report to a file, replacing it on every run
+type fileSink struct {
+	path string
+}
+
+// NewFileSink returns a ReportSink that writes the report to path as
+// indented JSON
+func NewFileSink(path string) ReportSink {
+	return &fileSink{path: path}
+}
+
+// SendReport writes the report to the file
+func (s *fileSink) SendReport(report *Report) error {
+	if err := report.WriteJSON(s.path); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// writerSink writes the report to a stream, such as stdout
+type writerSink struct {
+	w io.Writer
+}
+
+// NewWriterSink returns a ReportSink that writes the report to w as
+// indented JSON, followed by a newline
+func NewWriterSink(w io.Writer) ReportSink {
+	return &writerSink{w: w}
+}
+
+// SendReport writes the report to the stream
+func (s *writerSink) SendReport(report *Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// httpSink posts the report to an HTTP endpoint
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink returns a ReportSink that POSTs the report to url as
+// application/json, expecting any 2xx response
+func NewHTTPSink(url string) ReportSink {
+	return &httpSink{url: url, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// SendReport posts the report to the endpoint
+func (s *httpSink) SendReport(report *Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to send report: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to send report to %s: %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// reportSinks returns where a run's report goes: the ReportPath file, if
+// set, then the ReportSinks
+func reportSinks(opts Options) []ReportSink {
+	var sinks []ReportSink
+	if opts.ReportPath != "" {
+		sinks = append(sinks, NewFileSink(opts.ReportPath))
+	}
+	return append(sinks, opts.ReportSinks...)
+}
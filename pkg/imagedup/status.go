@@ -0,0 +1,120 @@
+package imagedup
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Status tracks how far along a run is so it can be inspected while it
+// runs. A nil *Status records nothing.
+type Status struct {
+	mu     sync.Mutex
+	snap   StatusSnapshot
+	stream *json.Encoder
+}
+
+// StatusSnapshot is a point-in-time copy of a run's progress
+type StatusSnapshot struct {
+	Phase     string    `json:"phase"`
+	Started   time.Time `json:"started"`
+	Files     int       `json:"files"`
+	Processed int       `json:"processed"`
+	Unique    int       `json:"unique"`
+	Copied    int       `json:"copied"`
+	Current   string    `json:"current,omitempty"`
+	Errors    int       `json:"errors"`
+	LastError string    `json:"last_error,omitempty"`
+	Paused    bool      `json:"paused,omitempty"`
+}
+
+// NewStatus returns an empty Status
+func NewStatus() *Status {
+	return &Status{}
+}
+
+// Stream writes every progress event to w as a line of JSON: the snapshot
+// after the event, with "event" naming it ("phase", "hashed", "copied" or
+// "error")
+func (s *Status) Stream(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stream = json.NewEncoder(w)
+}
+
+// progressEvent is a line of the progress stream
+type progressEvent struct {
+	Event string `json:"event"`
+	StatusSnapshot
+}
+
+// Snapshot returns the current progress
+func (s *Status) Snapshot() StatusSnapshot {
+	if s == nil {
+		return StatusSnapshot{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snap
+}
+
+// update applies fn to the snapshot under the lock, then streams it as the
+// named event. An empty event changes the snapshot without streaming it.
+func (s *Status) update(event string, fn func(*StatusSnapshot)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.snap)
+	if s.stream != nil && event != "" {
+		s.stream.Encode(progressEvent{Event: event, StatusSnapshot: s.snap})
+	}
+}
+
+// start begins a new run, resetting the counts but keeping the error tally
+func (s *Status) start() {
+	s.update("phase", func(st *StatusSnapshot) {
+		*st = StatusSnapshot{Phase: "scanning", Started: time.Now(), Errors: st.Errors, LastError: st.LastError}
+	})
+}
+
+// phase records the pipeline stage the run has reached
+func (s *Status) phase(name string) {
+	s.update("phase", func(st *StatusSnapshot) {
+		st.Phase = name
+		st.Current = ""
+	})
+}
+
+// ErrorCounter returns a writer that passes log output through to w while
+// counting every "Failed ..." line as an error, remembering the last one.
+// Install it with log.SetOutput to tally the errors a run logs.
+func (s *Status) ErrorCounter(w io.Writer) io.Writer {
+	return &errorCounter{status: s, w: w}
+}
+
+// errorCounter is the writer returned by ErrorCounter
+type errorCounter struct {
+	status *Status
+	w      io.Writer
+}
+
+// Write counts a log line and passes it on
+func (c *errorCounter) Write(p []byte) (int, error) {
+	// Skip the log package's date and time prefix
+	line := p
+	if fields := bytes.SplitN(line, []byte(" "), 3); len(fields) == 3 {
+		line = fields[2]
+	}
+	if bytes.HasPrefix(line, []byte("Failed")) {
+		msg := string(bytes.TrimSpace(line))
+		c.status.update("error", func(st *StatusSnapshot) {
+			st.Errors++
+			st.LastError = msg
+		})
+	}
+	return c.w.Write(p)
+}
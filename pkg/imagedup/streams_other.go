@@ -0,0 +1,31 @@
+//go:build !windows
+
+package imagedup
+
+import "strings"
+
+// streamNames are alternate data streams that copies from NTFS, such as
+// those WSL makes and SMB shares expose, leave behind as files named like
+// photo.jpg:Zone.Identifier
+var streamNames = map[string]bool{
+	"Zone.Identifier":  true,
+	"SmartScreen":      true,
+	"AFP_AfpInfo":      true,
+	"AFP_Resource":     true,
+	"com.dropbox.attr": true,
+	"encryptable":      true,
+}
+
+// isStreamName reports whether a file name is that of an NTFS alternate
+// data stream left behind as a file of its own. Other names may hold a
+// colon, like screenshots named by the time of day.
+func isStreamName(name string) bool {
+	i := strings.LastIndexByte(name, ':')
+	return i > 0 && (streamNames[name[i+1:]] || strings.HasSuffix(name, ":$DATA"))
+}
+
+// copyStreams does nothing: only NTFS, reached from Windows, has alternate
+// data streams
+func copyStreams(src, dst string) error {
+	return nil
+}
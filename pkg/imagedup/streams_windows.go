@@ -0,0 +1,95 @@
+//go:build windows
+
+package imagedup
+
+import (
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32            = syscall.NewLazyDLL("kernel32.dll")
+	procFindFirstStream = kernel32.NewProc("FindFirstStreamW")
+	procFindNextStream  = kernel32.NewProc("FindNextStreamW")
+)
+
+// findStreamData is a WIN32_FIND_STREAM_DATA
+type findStreamData struct {
+	size int64
+	name [syscall.MAX_PATH + 36]uint16
+}
+
+// isStreamName reports whether a name addresses an alternate data stream of
+// a file, as in photo.jpg:Zone.Identifier; no file name holds a colon
+func isStreamName(name string) bool {
+	return strings.Contains(name, ":")
+}
+
+// alternateStreams lists the names of a file's alternate data streams
+func alternateStreams(path string) ([]string, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	var data findStreamData
+	h, _, err := procFindFirstStream.Call(uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		if err == syscall.ERROR_HANDLE_EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer syscall.FindClose(syscall.Handle(h))
+
+	var names []string
+	for {
+		// Streams are named like :Zone.Identifier:$DATA, and the file's own
+		// content ::$DATA
+		name := strings.TrimSuffix(strings.TrimPrefix(syscall.UTF16ToString(data.name[:]), ":"), ":$DATA")
+		if name != "" {
+			names = append(names, name)
+		}
+		if ok, _, err := procFindNextStream.Call(h, uintptr(unsafe.Pointer(&data))); ok == 0 {
+			if err == syscall.ERROR_HANDLE_EOF {
+				return names, nil
+			}
+			return names, err
+		}
+	}
+}
+
+// copyStreams copies the alternate data streams of src, such as the
+// Zone.Identifier Windows attaches to downloads, onto dst
+func copyStreams(src, dst string) error {
+	names, err := alternateStreams(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := copyStream(src+":"+name, dst+":"+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyStream copies one stream, which is opened like a file
+func copyStream(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
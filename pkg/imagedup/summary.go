@@ -0,0 +1,169 @@
+package imagedup
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+)
+
+// FolderSummaryName is the summary written into destination folders with
+// Options.FolderSummaries
+const FolderSummaryName = "README.txt"
+
+// eventTagPrefix marks the tags listed as a folder's events
+const eventTagPrefix = "event:"
+
+// summaryCopiesPrefix starts the summary lines pointing at derivative copies
+const summaryCopiesPrefix = "Copies in "
+
+// writeFolderSummary writes a plain text summary of one archive folder from
+// the manifest: how many files of each class it holds, when they were taken,
+// the cameras and events, and where derivative copies of it are. It is meant
+// for browsing the archive over a file share without the tool. With nil
+// derivatives the copies an earlier summary listed are kept.
+func writeFolderSummary(destDir, folder string, m *manifest.Manifest, derivatives []DerivativeProfile) error {
+	folder = filepath.ToSlash(folder)
+	var entries []manifest.Entry
+	for _, e := range m.Sorted() {
+		if path.Dir(e.Path) == folder {
+			entries = append(entries, e)
+		}
+	}
+	dir := filepath.Join(destDir, filepath.FromSlash(folder))
+	if len(entries) == 0 {
+		err := os.Remove(filepath.Join(dir, FolderSummaryName))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	classes := make(map[string]int)
+	cameras := make(map[string]int)
+	events := make(map[string]bool)
+	var first, last string
+	for _, e := range entries {
+		classes[mediaClass(e.Path)]++
+		if name := e.Camera.Name(); name != "" {
+			cameras[name]++
+		}
+		for _, tag := range e.Tags {
+			if strings.HasPrefix(tag, eventTagPrefix) {
+				events[strings.TrimPrefix(tag, eventTagPrefix)] = true
+			}
+		}
+		when := e.Date
+		if e.Taken != nil {
+			when = e.Taken.Format("2006-01-02 15:04")
+		}
+		if when == "" {
+			continue
+		}
+		if first == "" || when < first {
+			first = when
+		}
+		if when > last {
+			last = when
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", folder)
+	var counts []string
+	for _, class := range []struct{ name, label string }{{"image", "images"}, {"raw", "RAW files"}, {"video", "videos"}} {
+		if n := classes[class.name]; n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, class.label))
+		}
+	}
+	fmt.Fprintf(&b, "%d files: %s\n", len(entries), strings.Join(counts, ", "))
+	switch {
+	case first == "":
+	case first == last:
+		fmt.Fprintf(&b, "Taken %s\n", first)
+	default:
+		fmt.Fprintf(&b, "Taken %s to %s\n", first, last)
+	}
+	if len(cameras) > 0 {
+		names := make([]string, 0, len(cameras))
+		for name := range cameras {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if cameras[names[i]] != cameras[names[j]] {
+				return cameras[names[i]] > cameras[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		for i, name := range names {
+			names[i] = fmt.Sprintf("%s (%d)", name, cameras[name])
+		}
+		fmt.Fprintf(&b, "Cameras: %s\n", strings.Join(names, ", "))
+	}
+	if len(events) > 0 {
+		names := make([]string, 0, len(events))
+		for name := range events {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&b, "Events: %s\n", strings.Join(names, ", "))
+	}
+	// Without the run's derivative profiles, the copies listed before stay
+	if derivatives == nil {
+		for _, line := range summaryCopies(filepath.Join(dir, FolderSummaryName)) {
+			fmt.Fprintf(&b, "%s\n", line)
+		}
+	}
+	for _, p := range derivatives {
+		copies := filepath.Join(p.Dest, filepath.FromSlash(folder))
+		if _, err := os.Stat(copies); err == nil {
+			fmt.Fprintf(&b, "%s%s: %s\n", summaryCopiesPrefix, p.Name, copies)
+		}
+	}
+	fmt.Fprintf(&b, "\nGenerated from the archive manifest; rewritten whenever its files change.\n")
+	return os.WriteFile(filepath.Join(dir, FolderSummaryName), []byte(b.String()), 0644)
+}
+
+// summaryCopies returns the derivative copy lines of an existing summary
+func summaryCopies(summaryPath string) []string {
+	data, err := os.ReadFile(summaryPath)
+	if err != nil {
+		return nil
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, summaryCopiesPrefix) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// refreshSummaries rewrites the summaries of the given folders that have
+// one, after their files changed
+func refreshSummaries(destDir string, m *manifest.Manifest, folders []string) {
+	for _, folder := range folders {
+		dir := filepath.Join(destDir, filepath.FromSlash(folder))
+		if _, err := os.Stat(filepath.Join(dir, FolderSummaryName)); err != nil {
+			continue
+		}
+		if err := writeFolderSummary(destDir, folder, m, nil); err != nil {
+			log.Printf("Failed to write summary for %s: %v", dir, err)
+		}
+	}
+}
+
+// writeSummaries rewrites the summary of every folder written to during the
+// run
+func (d *destination) writeSummaries(derivatives []DerivativeProfile) {
+	for folder := range d.touched {
+		if err := writeFolderSummary(d.root, folder, d.manifest, derivatives); err != nil {
+			log.Printf("Failed to write summary for %s: %v", filepath.Join(d.root, folder), err)
+		}
+	}
+}
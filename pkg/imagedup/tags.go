@@ -0,0 +1,126 @@
+package imagedup
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/manifest"
+)
+
+// ParseTag validates a tag: any text without spaces or commas, such as
+// "alice", "event:wedding-2019" or "keep-forever"
+func ParseTag(s string) (string, error) {
+	tag := strings.TrimSpace(s)
+	if tag == "" {
+		return "", fmt.Errorf("empty tag")
+	}
+	if strings.IndexFunc(tag, func(r rune) bool { return unicode.IsSpace(r) || r == ',' }) >= 0 {
+		return "", fmt.Errorf("tag %q must not contain spaces or commas", s)
+	}
+	return tag, nil
+}
+
+// Tag adds and removes tags on archived files in the manifest of destDir,
+// given as paths relative to destDir or inside it, and returns how many
+// files changed. Nothing is saved if any file isn't archived.
+func Tag(destDir string, files, add, remove []string) (int, error) {
+	lock, err := LockDestination(destDir, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Release()
+
+	m, err := manifest.Load(destDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	for _, file := range files {
+		key, err := entryKey(m, destDir, file)
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+
+	changed := 0
+	folders := make(map[string]bool)
+	for _, key := range keys {
+		e := m.Entries[key]
+		added := e.AddTags(add...)
+		removed := e.RemoveTags(remove...)
+		if added || removed {
+			m.Entries[key] = e
+			folders[path.Dir(key)] = true
+			changed++
+		}
+	}
+	if changed > 0 {
+		if err := m.Save(destDir); err != nil {
+			return 0, err
+		}
+		var touched []string
+		for folder := range folders {
+			touched = append(touched, folder)
+		}
+		refreshSummaries(destDir, m, touched)
+	}
+	return changed, nil
+}
+
+// TagCount is how many archived files carry a tag
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Files int    `json:"files"`
+}
+
+// Tags returns every tag used in the manifest of destDir with its file
+// count, ordered by tag
+func Tags(destDir string) ([]TagCount, error) {
+	m, err := manifest.Load(destDir)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, e := range m.Entries {
+		for _, tag := range e.Tags {
+			counts[tag]++
+		}
+	}
+	var tags []TagCount
+	for tag, n := range counts {
+		tags = append(tags, TagCount{Tag: tag, Files: n})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Tag < tags[j].Tag })
+	return tags, nil
+}
+
+// Tagged returns the archived files in destDir carrying every one of tags,
+// ordered by path
+func Tagged(destDir string, tags []string) ([]string, error) {
+	m, err := manifest.Load(destDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range m.Sorted() {
+		if hasTags(e, tags) {
+			files = append(files, e.Path)
+		}
+	}
+	return files, nil
+}
+
+// hasTags reports whether an entry carries every one of tags
+func hasTags(e manifest.Entry, tags []string) bool {
+	for _, tag := range tags {
+		if !e.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package imagedup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// Kinds of file in generated test data
+const (
+	// TestOriginal is the first file of a picture, which an import keeps
+	TestOriginal = "original"
+	// TestCopy is a byte-identical copy of an original
+	TestCopy = "copy"
+	// TestResized is a smaller re-encoding of an original
+	TestResized = "resized"
+	// TestStripped is an original re-encoded without its EXIF data
+	TestStripped = "stripped"
+	// TestRAW is a RAW stub or a copy of one
+	TestRAW = "raw"
+)
+
+// TestDataSpec controls what GenerateTestData writes
+type TestDataSpec struct {
+	// Images is how many distinct pictures are generated, and Size their
+	// width and height in pixels
+	Images int
+	Size   int
+
+	// Copies, Resized and Stripped are the fractions of pictures that get
+	// a byte-identical copy, a half-size copy and a copy without EXIF data
+	Copies   float64
+	Resized  float64
+	Stripped float64
+
+	// RAWs is how many RAW stubs are generated, a quarter of them twice
+	RAWs int
+
+	// Seed makes the tree reproducible; equal specs give equal trees
+	Seed int64
+}
+
+// DefaultTestDataSpec returns a small tree, quick to generate and import
+func DefaultTestDataSpec() TestDataSpec {
+	return TestDataSpec{Images: 200, Size: 256, Copies: 0.3, Resized: 0.1, Stripped: 0.1, RAWs: 20, Seed: 1}
+}
+
+// TestDataFile is one generated file. Files of one Picture are duplicates
+// of each other, and Taken is when they should be dated as taken, if the
+// file records it.
+type TestDataFile struct {
+	Path    string     `json:"path"`
+	Picture int        `json:"picture"`
+	Kind    string     `json:"kind"`
+	Taken   *time.Time `json:"taken,omitempty"`
+}
+
+// TestData describes a generated tree
+type TestData struct {
+	Spec  TestDataSpec   `json:"spec"`
+	Files []TestDataFile `json:"files"`
+
+	// Pictures counts the distinct pictures, which is how many files an
+	// import should archive with default settings
+	Pictures int `json:"pictures"`
+}
+
+// WriteJSON writes the description to path as indented JSON
+func (t *TestData) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// testDataNames are awkward names given to some pictures, as found in real
+// libraries: non-ASCII, spaces, a leading dash and upper case extensions
+var testDataNames = []string{
+	"ünïcödé 写真 %d.jpg",
+	"  spaced  name %d .JPG",
+	"-dash %d.jpeg",
+	"Copy of Copy of IMG_%04d.JPG",
+	"very" + strings.Repeat("-long", 30) + "-%d.jpg",
+}
+
+// GenerateTestData writes a tree of synthetic pictures into dir with known
+// duplicates (byte-identical copies, resized copies and copies without
+// EXIF), dates in EXIF, in filenames or nowhere, RAW stubs and odd names,
+// for trying settings and measuring performance without real photos. dir
+// must not exist or be empty.
+func GenerateTestData(dir string, spec TestDataSpec) (*TestData, error) {
+	if spec.Images < 0 || spec.RAWs < 0 || spec.Size < 16 {
+		return nil, fmt.Errorf("invalid spec: %d images of %d pixels, %d RAWs", spec.Images, spec.Size, spec.RAWs)
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("%s is not empty", dir)
+	}
+
+	rng := rand.New(rand.NewSource(spec.Seed))
+	result := &TestData{Spec: spec}
+	write := func(rel string, data []byte, picture int, kind string, taken time.Time) error {
+		abs := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(abs), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.WriteFile(abs, data, 0644); err != nil {
+			return err
+		}
+		file := TestDataFile{Path: rel, Picture: picture, Kind: kind}
+		if !taken.IsZero() {
+			file.Taken = &taken
+		}
+		result.Files = append(result.Files, file)
+		return nil
+	}
+
+	start := time.Date(2005, 1, 1, 0, 0, 0, 0, time.Local)
+	span := int64(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local).Sub(start) / time.Second)
+	for i := 0; i < spec.Images; i++ {
+		taken := start.Add(time.Duration(rng.Int63n(span)) * time.Second)
+		img := testPattern(rng.Uint64(), spec.Size)
+
+		// A third each are dated by EXIF, by filename and not at all
+		var rel string
+		var exif []byte
+		dated := taken
+		switch i % 3 {
+		case 0:
+			rel = fmt.Sprintf("camera/%s/IMG_%04d.jpg", taken.Format("2006"), i)
+			exif = exifDateTime(taken.Format("2006:01:02 15:04:05"))
+		case 1:
+			rel = fmt.Sprintf("phone/IMG_%s.jpg", taken.Format("20060102_150405"))
+		default:
+			rel = fmt.Sprintf("scans/scan %04d.jpg", i)
+			dated = time.Time{}
+		}
+		if i%17 == 5 {
+			rel = "odd/" + fmt.Sprintf(testDataNames[(i/17)%len(testDataNames)], i)
+			if exif == nil {
+				dated = time.Time{}
+			}
+		}
+		data, err := encodeTestJPEG(img, exif)
+		if err != nil {
+			return nil, err
+		}
+		if err := write(rel, data, i, TestOriginal, dated); err != nil {
+			return nil, err
+		}
+
+		base := strings.TrimSuffix(path.Base(rel), filepath.Ext(rel))
+		if rng.Float64() < spec.Copies {
+			if err := write(fmt.Sprintf("backup/%d/%s", i%10, path.Base(rel)), data, i, TestCopy, dated); err != nil {
+				return nil, err
+			}
+		}
+		if rng.Float64() < spec.Resized {
+			small := imaging.Resize(img, spec.Size/2, 0, imaging.Box)
+			resized, err := encodeTestJPEG(small, exif)
+			if err != nil {
+				return nil, err
+			}
+			if err := write("shared/"+base+"_small.jpg", resized, i, TestResized, dated); err != nil {
+				return nil, err
+			}
+		}
+		if exif != nil && rng.Float64() < spec.Stripped {
+			stripped, err := encodeTestJPEG(img, nil)
+			if err != nil {
+				return nil, err
+			}
+			if err := write("edited/"+base+".jpg", stripped, i, TestStripped, time.Time{}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i := 0; i < spec.RAWs; i++ {
+		picture := spec.Images + i
+		// RAW files are matched by size, so each stub gets its own
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "not really a RAW file %016x ", rng.Uint64())
+		data := bytes.Repeat(buf.Bytes(), 100+i)
+		rel := fmt.Sprintf("raw/DSC_%04d.NEF", i)
+		if err := write(rel, data, picture, TestRAW, time.Time{}); err != nil {
+			return nil, err
+		}
+		if i%4 == 0 {
+			if err := write(fmt.Sprintf("raw/DSC_%04d (1).NEF", i), data, picture, TestRAW, time.Time{}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// System files and other clutter an import skips
+	for rel, data := range map[string]string{"camera/.DS_Store": "\x00\x00\x00\x01Bud1", "notes.txt": "not media\n", "phone/Thumbs.db": "thumbs"} {
+		abs := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(abs), os.ModePerm); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(abs, []byte(data), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Pictures = spec.Images + spec.RAWs
+	return result, nil
+}
@@ -0,0 +1,192 @@
+package imagedup
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+const (
+	// DefaultThumbnailSize is the longest side, in pixels, of previews
+	// decoded from images without an EXIF thumbnail
+	DefaultThumbnailSize = 256
+	// DefaultThumbnailRate is how many images a second a Thumbnailer
+	// decodes in full when they have no EXIF thumbnail
+	DefaultThumbnailRate = 4
+)
+
+// thumbnailQuality is the JPEG quality of decoded previews
+const thumbnailQuality = 80
+
+// Thumbnailer makes small JPEG previews of images for reports and review.
+// The thumbnail a camera embeds in the EXIF data is used when there is one,
+// which needs no decode; other images are decoded and downscaled, at a
+// limited rate so previewing a large report doesn't load the machine like
+// an import. It is safe for concurrent use.
+type Thumbnailer struct {
+	size int
+	tl   tools.Tools
+
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewThumbnailer returns a Thumbnailer decoding previews up to size pixels
+// on their longest side, at most perSecond a second, or without a limit
+// when perSecond is zero. HEIF images are decoded with libvips when tl has
+// it.
+func NewThumbnailer(size int, perSecond float64, tl tools.Tools) *Thumbnailer {
+	if size <= 0 {
+		size = DefaultThumbnailSize
+	}
+	t := &Thumbnailer{size: size, tl: tl}
+	if perSecond > 0 {
+		t.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+	return t
+}
+
+// Thumbnail returns a JPEG preview of an image or RAW file, upright: its
+// EXIF thumbnail if it has one, else a downscaled decode. RAW files are
+// never decoded in full.
+func (t *Thumbnailer) Thumbnail(filePath string) ([]byte, error) {
+	if thumb, err := exifThumbnail(filePath); err == nil {
+		return thumb, nil
+	}
+	if mediaClass(filePath) != "image" {
+		return nil, fmt.Errorf("%s has no EXIF thumbnail", filePath)
+	}
+	t.wait()
+	return t.decoded(filePath)
+}
+
+// wait blocks until the rate limit allows another decode
+func (t *Thumbnailer) wait() {
+	if t.interval == 0 {
+		return
+	}
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	at := t.next
+	t.next = t.next.Add(t.interval)
+	t.mu.Unlock()
+	time.Sleep(time.Until(at))
+}
+
+// decoded decodes an image and downscales it to the preview size
+func (t *Thumbnailer) decoded(filePath string) ([]byte, error) {
+	if isHEIF(filePath) {
+		// HEIC/HEIF can't be decoded in pure Go, so libvips scales it
+		tmp, err := os.CreateTemp("", "thumbnail-*.jpg")
+		if err != nil {
+			return nil, err
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+		if err := t.tl.Transcode(filePath, tmp.Name(), t.size, thumbnailQuality); err != nil {
+			return nil, fmt.Errorf("HEIF transcode of %s: %w", filePath, err)
+		}
+		return os.ReadFile(tmp.Name())
+	}
+	img, err := imaging.Open(filePath, imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, err
+	}
+	return encodeThumbnail(imaging.Fit(img, t.size, t.size, imaging.Box))
+}
+
+// exifThumbnail returns the JPEG thumbnail embedded in a file's EXIF data,
+// turned upright by the file's orientation
+func exifThumbnail(filePath string) ([]byte, error) {
+	file, err := openReadOnly(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// A failure in a sub-IFD still returns what was decoded before it
+	x, _ := exif.Decode(file)
+	if x == nil {
+		return nil, fmt.Errorf("no EXIF data")
+	}
+	offset, err := exifInt(x, exif.ThumbJPEGInterchangeFormat)
+	if err != nil {
+		return nil, err
+	}
+	length, err := exifInt(x, exif.ThumbJPEGInterchangeFormatLength)
+	if err != nil {
+		return nil, err
+	}
+	if offset <= 0 || length <= 0 || offset+length > len(x.Raw) {
+		return nil, fmt.Errorf("EXIF thumbnail lies outside the EXIF data")
+	}
+	thumb := x.Raw[offset : offset+length]
+	if _, err := jpeg.DecodeConfig(bytes.NewReader(thumb)); err != nil {
+		return nil, fmt.Errorf("unreadable EXIF thumbnail: %w", err)
+	}
+
+	orientation, _ := exifInt(x, exif.Orientation)
+	if orientation < 2 || orientation > 8 {
+		return append([]byte(nil), thumb...), nil
+	}
+	img, err := jpeg.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		return nil, err
+	}
+	return encodeThumbnail(orient(img, orientation))
+}
+
+// exifInt reads the first value of an integer EXIF tag. Tag accessors panic
+// on empty values, so those count as missing.
+func exifInt(x *exif.Exif, field exif.FieldName) (int, error) {
+	tag, err := x.Get(field)
+	if err != nil {
+		return 0, err
+	}
+	if tag.Count == 0 {
+		return 0, fmt.Errorf("empty %s tag", field)
+	}
+	return tag.Int(0)
+}
+
+// orient turns an image upright according to an EXIF orientation
+func orient(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+	return img
+}
+
+// encodeThumbnail encodes a preview as JPEG
+func encodeThumbnail(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
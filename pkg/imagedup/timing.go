@@ -0,0 +1,67 @@
+package imagedup
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timedStages lists the pipeline stages timed during a run, in pipeline order
+var timedStages = []string{"walk", "decode", "hash", "faces", "embed", "exif", "copy", "index"}
+
+// StageTiming is the time spent in one pipeline stage. Stages run by
+// concurrent workers are summed across workers, so Total can exceed the
+// wall-clock time of the run.
+type StageTiming struct {
+	Stage string        `json:"stage"`
+	Total time.Duration `json:"total_ns"`
+	Calls int           `json:"calls"`
+}
+
+// stageTimings accumulates time per stage. A nil *stageTimings records nothing.
+type stageTimings struct {
+	mu    sync.Mutex
+	total map[string]time.Duration
+	calls map[string]int
+}
+
+// newStageTimings returns an empty set of timings
+func newStageTimings() *stageTimings {
+	return &stageTimings{total: make(map[string]time.Duration), calls: make(map[string]int)}
+}
+
+// track adds the time since start to a stage; use it as
+// defer t.track("copy", time.Now())
+func (t *stageTimings) track(stage string, start time.Time) {
+	if t == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total[stage] += elapsed
+	t.calls[stage]++
+}
+
+// results returns the timings of every stage that ran, in pipeline order
+func (t *stageTimings) results() []StageTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var results []StageTiming
+	for _, stage := range timedStages {
+		if t.calls[stage] > 0 {
+			results = append(results, StageTiming{Stage: stage, Total: t.total[stage], Calls: t.calls[stage]})
+		}
+	}
+	return results
+}
+
+// timingSummary renders timings as one line for the run summary
+func timingSummary(timings []StageTiming) string {
+	var parts []string
+	for _, st := range timings {
+		parts = append(parts, fmt.Sprintf("%s %s", st.Stage, st.Total.Round(time.Millisecond)))
+	}
+	return strings.Join(parts, ", ")
+}
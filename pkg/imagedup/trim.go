@@ -0,0 +1,130 @@
+package imagedup
+
+import (
+	"log"
+	"math/bits"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// trimSampleRate is the number of frames per second hashed when comparing
+// videos for trims. Higher rates align trim points more accurately.
+const trimSampleRate = 4
+
+// minTrimFrames is the shortest sequence considered for trim detection
+const minTrimFrames = 3
+
+// frameSequence hashes frames sampled at trimSampleRate across a whole video.
+// ffmpeg scales each frame to 8x8 grayscale so it can be average-hashed
+// directly from the raw output.
+func frameSequence(tl tools.Tools, filePath string) ([]uint64, error) {
+	out, err := tl.GrayFrames(filePath, trimSampleRate, 8)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []uint64
+	for len(out) >= 64 {
+		hashes = append(hashes, grayAverageHash(out[:64]))
+		out = out[64:]
+	}
+	return hashes, nil
+}
+
+// grayAverageHash computes a 64-bit average hash for 8x8 grayscale pixels
+func grayAverageHash(pixels []byte) uint64 {
+	var sum int
+	for _, p := range pixels {
+		sum += int(p)
+	}
+	mean := sum / len(pixels)
+
+	var hash uint64
+	for i, p := range pixels {
+		if int(p) > mean {
+			hash |= 1 << uint(len(pixels)-1-i)
+		}
+	}
+	return hash
+}
+
+// findSubsequence reports where short occurs within long, allowing each
+// frame an average Hamming distance of frameHashThreshold
+func findSubsequence(long, short []uint64) (int, bool) {
+	if len(short) < minTrimFrames || len(short) >= len(long) {
+		return 0, false
+	}
+	for offset := 0; offset+len(short) <= len(long); offset++ {
+		total := 0
+		for i := range short {
+			total += bits.OnesCount64(long[offset+i] ^ short[i])
+			if total > frameHashThreshold*len(short) {
+				break
+			}
+		}
+		if total <= frameHashThreshold*len(short) {
+			return offset, true
+		}
+	}
+	return 0, false
+}
+
+// detectTrims finds videos that are trimmed subsets of other videos and records
+// the relationship in the report. With drop set, trimmed copies are removed
+// from the files to copy so only the full-length original is kept.
+func detectTrims(unique []imageInfo, drop bool, tl tools.Tools, report *Report) []imageInfo {
+	if tl.FFmpeg == "" {
+		log.Printf("Trim detection requires ffmpeg, skipping")
+		return unique
+	}
+
+	sequences := make(map[string][]uint64)
+	var names []string
+	protected := make(map[string]bool)
+	for _, fileInfo := range unique {
+		protected[fileInfo.filename] = fileInfo.protected
+		if !SupportedVideoFormats[strings.ToLower(filepath.Ext(fileInfo.filename))] {
+			continue
+		}
+		seq, err := frameSequence(tl, fileInfo.filename)
+		if err != nil {
+			log.Printf("Failed to sample frames for trim detection: %s (%v)", fileInfo.filename, err)
+			continue
+		}
+		sequences[fileInfo.filename] = seq
+		names = append(names, fileInfo.filename)
+	}
+	// Pairs are compared in name order, so the report and the files dropped
+	// are the same on every run
+	sort.Strings(names)
+
+	trimmed := make(map[string]bool)
+	for _, original := range names {
+		for _, candidate := range names {
+			if candidate == original || trimmed[candidate] {
+				continue
+			}
+			if offset, ok := findSubsequence(sequences[original], sequences[candidate]); ok {
+				trimmed[candidate] = true
+				report.addTrim(original, candidate,
+					time.Duration(offset)*time.Second/trimSampleRate,
+					time.Duration(len(sequences[candidate]))*time.Second/trimSampleRate, drop && !protected[candidate])
+			}
+		}
+	}
+
+	if !drop {
+		return unique
+	}
+	var kept []imageInfo
+	for _, fileInfo := range unique {
+		if !trimmed[fileInfo.filename] || fileInfo.protected {
+			kept = append(kept, fileInfo)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,117 @@
+package imagedup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+)
+
+// unreadableHints is how many folders the summary names when files could
+// not be read
+const unreadableHints = 10
+
+// UnreadableDir lists the files in one source folder that couldn't be read
+// for lack of permission. Denied reports whether the folder itself couldn't
+// be listed, so whatever it holds went unseen.
+type UnreadableDir struct {
+	Dir    string   `json:"dir"`
+	Count  int      `json:"count"`
+	Files  []string `json:"files,omitempty"`
+	Denied bool     `json:"denied,omitempty"`
+}
+
+// isPermission reports whether an error comes from a lack of permission
+func isPermission(err error) bool {
+	return errors.Is(err, fs.ErrPermission)
+}
+
+// unreadableDirs groups the permission errors among a scan's errors by
+// folder, with the folders it couldn't list first and then those with the
+// most files
+func unreadableDirs(errs []error, denied []string) []UnreadableDir {
+	byDir := make(map[string]*UnreadableDir)
+	dir := func(path string) *UnreadableDir {
+		d, ok := byDir[path]
+		if !ok {
+			d = &UnreadableDir{Dir: path}
+			byDir[path] = d
+		}
+		return d
+	}
+	for _, path := range denied {
+		dir(path).Denied = true
+	}
+	for _, err := range errs {
+		var fe *FileError
+		if !isPermission(err) || !errors.As(err, &fe) {
+			continue
+		}
+		d := dir(filepath.Dir(fe.Path))
+		d.Count++
+		d.Files = append(d.Files, fe.Path)
+	}
+
+	dirs := make([]UnreadableDir, 0, len(byDir))
+	for _, d := range byDir {
+		sort.Strings(d.Files)
+		dirs = append(dirs, *d)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].Denied != dirs[j].Denied {
+			return dirs[i].Denied
+		}
+		if dirs[i].Count != dirs[j].Count {
+			return dirs[i].Count > dirs[j].Count
+		}
+		return dirs[i].Dir < dirs[j].Dir
+	})
+	return dirs
+}
+
+// unreadableCounts totals the unreadable files and the folders that
+// couldn't be listed
+func unreadableCounts(dirs []UnreadableDir) (files, denied int) {
+	for _, d := range dirs {
+		files += d.Count
+		if d.Denied {
+			denied++
+		}
+	}
+	return files, denied
+}
+
+// printUnreadable lists the first folders holding unreadable files, for the
+// user to fix their permissions or rerun with more privileges
+func printUnreadable(w io.Writer, dirs []UnreadableDir) {
+	for i, d := range dirs {
+		if i == unreadableHints {
+			fmt.Fprintf(w, "  and %d more folders\n", len(dirs)-i)
+			break
+		}
+		if d.Denied {
+			fmt.Fprintf(w, "  %s (can't be listed)\n", d.Dir)
+		} else {
+			fmt.Fprintf(w, "  %s (%d files)\n", d.Dir, d.Count)
+		}
+	}
+}
+
+// unreadable fails a scan that couldn't read everything in the source, when
+// the FailOnUnreadable option asks for it
+func (index *Index) unreadable(opts Options) error {
+	if !opts.FailOnUnreadable {
+		return nil
+	}
+	dirs := unreadableDirs(index.Errors, index.Denied)
+	if len(dirs) == 0 {
+		return nil
+	}
+	files, denied := unreadableCounts(dirs)
+	log.Printf("Permission denied in %d folders:", len(dirs))
+	printUnreadable(log.Writer(), dirs)
+	return fmt.Errorf("%d files and %d folders in the source can't be read; fix their permissions, run as a user who can read them, or use -skip-unreadable", files, denied)
+}
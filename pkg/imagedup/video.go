@@ -0,0 +1,292 @@
+package imagedup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"io"
+	"math/bits"
+	"time"
+
+	"github.com/corona10/goimagehash"
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// videoFrameSamples is the number of frames hashed per video for fuzzy matching
+const videoFrameSamples = 4
+
+// frameHashThreshold is the maximum average Hamming distance between sampled
+// frames for two videos to be considered the same clip
+const frameHashThreshold = 10
+
+// videoDurationTolerance is how far apart the durations of two copies of a
+// clip may be. Containers round durations differently and remuxing can pad
+// or trim the audio by a frame or two.
+const videoDurationTolerance = time.Second
+
+// maxMoovSize bounds how much of a moov box is read into memory
+const maxMoovSize = 64 << 20
+
+// videoMeta describes the properties used to fingerprint a video
+type videoMeta struct {
+	duration time.Duration
+	width    int
+	height   int
+	frames   []uint64
+}
+
+// fingerprint returns a hash bucketing videos by resolution, so the same clip
+// in a different container lands in the same group. Durations are compared
+// within a tolerance when the group is clustered, as rounding them into the
+// hash would split copies either side of a rounding boundary.
+func (m videoMeta) fingerprint() uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "video:%dx%d", m.width, m.height)
+	return h.Sum64()
+}
+
+// fingerprinted reports whether a file was probed as a video, rather than
+// matched on size
+func (m videoMeta) fingerprinted() bool {
+	return m.duration > 0 || m.width > 0
+}
+
+// durationsMatch reports whether two videos are within the duration
+// tolerance of each other
+func durationsMatch(a, b time.Duration) bool {
+	d := a - b
+	return d <= videoDurationTolerance && d >= -videoDurationTolerance
+}
+
+// probeVideo reads duration and resolution from the container (via ffprobe
+// when the pure-Go MP4 parser can't) and, when ffmpeg is available, hashes a
+// handful of frames sampled across the clip
+func probeVideo(filePath string, tl tools.Tools) (videoMeta, error) {
+	meta, err := readMP4Meta(filePath)
+	if err != nil {
+		info, probeErr := tl.ProbeVideo(filePath)
+		if probeErr != nil {
+			return meta, err
+		}
+		meta = videoMeta{duration: info.Duration, width: info.Width, height: info.Height}
+	}
+
+	meta.frames = sampleFrameHashes(tl, filePath, meta.duration)
+	return meta, nil
+}
+
+// sampleFrameHashes extracts evenly spaced frames and returns their average
+// hashes. Frames that fail to extract are skipped.
+func sampleFrameHashes(tl tools.Tools, filePath string, duration time.Duration) []uint64 {
+	if tl.FFmpeg == "" {
+		return nil
+	}
+
+	var hashes []uint64
+	for i := 1; i <= videoFrameSamples; i++ {
+		at := duration * time.Duration(i) / time.Duration(videoFrameSamples+1)
+		out, err := tl.Frame(filePath, at)
+		if err != nil {
+			continue
+		}
+		img, _, err := image.Decode(bytes.NewReader(out))
+		if err != nil {
+			continue
+		}
+		hash, err := goimagehash.AverageHash(img)
+		if err != nil {
+			continue
+		}
+		hashes = append(hashes, hash.GetHash())
+	}
+	return hashes
+}
+
+// framesMatch reports whether two sets of sampled frame hashes describe the
+// same clip. Videos without frame samples never match: resolution and
+// duration alone don't tell different clips apart.
+func framesMatch(a, b []uint64) bool {
+	if len(a) == 0 || len(a) != len(b) {
+		return false
+	}
+	total := 0
+	for i := range a {
+		total += bits.OnesCount64(a[i] ^ b[i])
+	}
+	return total/len(a) <= frameHashThreshold
+}
+
+// hashDistance returns the Hamming distance between two files' hashes, using
+// the average distance of sampled frames for fingerprinted videos
+func hashDistance(a, b imageInfo) int {
+	if len(a.video.frames) > 0 && len(a.video.frames) == len(b.video.frames) {
+		total := 0
+		for i := range a.video.frames {
+			total += bits.OnesCount64(a.video.frames[i] ^ b.video.frames[i])
+		}
+		return total / len(a.video.frames)
+	}
+	return bits.OnesCount64(a.hash ^ b.hash)
+}
+
+// clusterByFrames splits a fingerprint group into clusters whose durations
+// and sampled frames match
+func clusterByFrames(members []imageInfo) [][]imageInfo {
+	var clusters [][]imageInfo
+	for _, member := range members {
+		placed := false
+		for i, cluster := range clusters {
+			first := cluster[0].video
+			if durationsMatch(first.duration, member.video.duration) && framesMatch(first.frames, member.video.frames) {
+				clusters[i] = append(cluster, member)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			clusters = append(clusters, []imageInfo{member})
+		}
+	}
+	return clusters
+}
+
+// readMP4Meta parses the moov box of an ISO base media file (.mp4, .mov) for
+// the movie duration and the dimensions of its first visual track
+func readMP4Meta(filePath string) (videoMeta, error) {
+	var meta videoMeta
+
+	f, err := openReadOnly(filePath)
+	if err != nil {
+		return meta, err
+	}
+	defer f.Close()
+
+	moov, err := findBox(f, "moov")
+	if err != nil {
+		return meta, err
+	}
+
+	for _, box := range childBoxes(moov) {
+		switch box.kind {
+		case "mvhd":
+			meta.duration = parseMvhd(box.data)
+		case "trak":
+			if meta.width != 0 {
+				continue
+			}
+			for _, child := range childBoxes(box.data) {
+				if child.kind == "tkhd" {
+					meta.width, meta.height = parseTkhd(child.data)
+				}
+			}
+		}
+	}
+
+	if meta.duration == 0 {
+		return meta, errors.New("no movie duration found")
+	}
+	return meta, nil
+}
+
+type mp4Box struct {
+	kind string
+	data []byte
+}
+
+// findBox scans top level boxes for kind and returns its payload
+func findBox(r io.ReadSeeker, kind string) ([]byte, error) {
+	header := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return nil, fmt.Errorf("no %s box found", kind)
+		}
+		size := uint64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerLen := uint64(8)
+		if size == 1 {
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return nil, err
+			}
+			size = binary.BigEndian.Uint64(header[8:16])
+			headerLen = 16
+		}
+		if size != 0 && size < headerLen {
+			return nil, errors.New("malformed box header")
+		}
+
+		if boxType == kind {
+			if size == 0 || size-headerLen > maxMoovSize {
+				return nil, fmt.Errorf("%s box too large", kind)
+			}
+			data := make([]byte, size-headerLen)
+			_, err := io.ReadFull(r, data)
+			return data, err
+		}
+		if size == 0 {
+			return nil, fmt.Errorf("no %s box found", kind)
+		}
+		if _, err := r.Seek(int64(size-headerLen), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// childBoxes splits a container payload into its child boxes
+func childBoxes(data []byte) []mp4Box {
+	var boxes []mp4Box
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		headerLen := uint64(8)
+		if size == 1 && len(data) >= 16 {
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerLen = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < headerLen || size > uint64(len(data)) {
+			break
+		}
+		boxes = append(boxes, mp4Box{kind: string(data[4:8]), data: data[headerLen:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+// parseMvhd reads the movie duration from an mvhd payload
+func parseMvhd(data []byte) time.Duration {
+	if len(data) < 20 {
+		return 0
+	}
+	var timescale, duration uint64
+	if data[0] == 1 {
+		if len(data) < 32 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(data[20:24]))
+		duration = binary.BigEndian.Uint64(data[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(data[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+}
+
+// parseTkhd reads the 16.16 fixed point track dimensions from a tkhd payload
+func parseTkhd(data []byte) (int, int) {
+	offset := 76
+	if len(data) > 0 && data[0] == 1 {
+		offset = 88
+	}
+	if len(data) < offset+8 {
+		return 0, 0
+	}
+	width := int(binary.BigEndian.Uint32(data[offset:offset+4]) >> 16)
+	height := int(binary.BigEndian.Uint32(data[offset+4:offset+8]) >> 16)
+	return width, height
+}
@@ -0,0 +1,82 @@
+package imagedup
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// writeMP4 writes a minimal MP4 with a movie duration and a 1920x1080 track,
+// padded so clips of one length can differ in size
+func writeMP4(t *testing.T, path string, duration time.Duration, padding int) {
+	t.Helper()
+	box := func(kind string, payload []byte) []byte {
+		b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+		return append(append(b, kind...), payload...)
+	}
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], uint32(duration.Milliseconds()))
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], 1920<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], 1080<<16)
+	data := box("ftyp", []byte("isom\x00\x00\x00\x00"))
+	data = append(data, box("moov", append(box("mvhd", mvhd), box("trak", box("tkhd", tkhd))...))...)
+	data = append(data, box("free", make([]byte, padding))...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFramelessVideosMatchOnSize(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.mp4"), filepath.Join(dir, "b.mp4")
+	writeMP4(t, a, 10400*time.Millisecond, 10)
+	writeMP4(t, b, 10600*time.Millisecond, 20)
+
+	results := make(chan imageInfo, 2)
+	for _, file := range []string{a, b} {
+		if err := processVideoFile(file, true, tools.Tools{}, results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(results)
+	var files []imageInfo
+	for fileInfo := range results {
+		if fileInfo.video.fingerprinted() {
+			t.Errorf("%s was fingerprinted without frame samples", fileInfo.filename)
+		}
+		files = append(files, fileInfo)
+	}
+	if files[0].hash == files[1].hash {
+		t.Fatalf("clips of different sizes share hash %x", files[0].hash)
+	}
+
+	// Fingerprints read before frames were required still aren't merged
+	meta := videoMeta{duration: 10 * time.Second, width: 1920, height: 1080}
+	members := []imageInfo{{filename: a, video: meta}, {filename: b, video: meta}}
+	if clusters := clusterByFrames(members); len(clusters) != 2 {
+		t.Fatalf("clusterByFrames merged frameless clips into %d clusters", len(clusters))
+	}
+}
+
+func TestClusterByFrames(t *testing.T) {
+	frames := []uint64{0x0f0f, 0xf0f0, 0xff00, 0x00ff}
+	meta := func(d time.Duration, frames []uint64) videoMeta {
+		return videoMeta{duration: d, width: 1920, height: 1080, frames: frames}
+	}
+	members := []imageInfo{
+		{filename: "a.mp4", video: meta(10400*time.Millisecond, frames)},
+		{filename: "b.mov", video: meta(10600*time.Millisecond, frames)},
+		{filename: "c.mp4", video: meta(13*time.Second, frames)},
+		{filename: "d.mp4", video: meta(10*time.Second, []uint64{^uint64(0x0f0f), ^uint64(0xf0f0), ^uint64(0xff00), ^uint64(0x00ff)})},
+	}
+	clusters := clusterByFrames(members)
+	if len(clusters) != 3 || len(clusters[0]) != 2 {
+		t.Fatalf("clusterByFrames = %v, want a.mp4 and b.mov together and c.mp4 and d.mp4 apart", clusters)
+	}
+}
@@ -0,0 +1,166 @@
+package imagedup
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// systemNames are OS and NAS bookkeeping files and folders that never hold
+// user media
+var systemNames = map[string]bool{
+	".DS_Store":       true,
+	"Thumbs.db":       true,
+	"ehthumbs.db":     true,
+	"desktop.ini":     true,
+	"@eaDir":          true,
+	".Spotlight-V100": true,
+	".fseventsd":      true,
+	".Trashes":        true,
+	"$RECYCLE.BIN":    true,
+}
+
+// isSystemFile reports whether a file or folder name is filesystem noise:
+// AppleDouble resource forks, alternate data streams left as files,
+// thumbnail caches and trash
+func isSystemFile(name string) bool {
+	return systemNames[name] ||
+		isStreamName(name) ||
+		strings.HasPrefix(name, "._") ||
+		strings.HasPrefix(name, ".trashed-")
+}
+
+// sourceScan is the result of walking the source tree
+type sourceScan struct {
+	files []string
+
+	// companions maps a media file to its AppleDouble ._ resource fork
+	companions map[string]string
+
+	// denied lists the folders that couldn't be listed for lack of
+	// permission, and were skipped
+	denied []string
+}
+
+// collectFiles walks the source tree recursively, honoring .ppignore files and
+// skipping system files unless IncludeSystemFiles is set and any excluded
+// output locations. AppleDouble files
+// are paired with the media file they describe rather than listed on their own.
+func collectFiles(srcDir string, opts Options, excluded map[string]bool) (*sourceScan, error) {
+	scan := &sourceScan{companions: make(map[string]string)}
+	listed := make(map[string]bool)
+	seen := make(map[fileKey]string)
+	var forks []string
+	ignores := &ignoreSet{}
+
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil && isPermission(err) && path != srcDir {
+			log.Printf("Skipping %s: %v", path, err)
+			if info != nil && info.IsDir() {
+				scan.denied = append(scan.denied, path)
+				return filepath.SkipDir
+			}
+			scan.denied = append(scan.denied, filepath.Dir(path))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if excluded[path] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && isAppleDouble(path, info) {
+			forks = append(forks, path)
+			return nil
+		}
+		skipSystem := !opts.IncludeSystemFiles && path != srcDir && isSystemFile(info.Name())
+		if skipSystem || ignores.ignored(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return ignores.load(path)
+		}
+		if info.Name() == IgnoreFileName || info.Name() == DateFileName {
+			return nil
+		}
+
+		// The walk gives a link's own info. Links to folders aren't followed,
+		// so a linked tree isn't imported twice or looped through, and broken
+		// links have nothing to import.
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(path); err != nil || target.IsDir() {
+				log.Printf("Skipping %s: not a link to a file", path)
+				return nil
+			}
+		}
+
+		// A file reachable by two paths (symlinks, hard links, bind mounts)
+		// is one file, not a duplicate of itself
+		if target, err := os.Stat(path); err == nil {
+			if !opts.modifiedSince(target) {
+				return nil
+			}
+			if id, ok := fileID(target); ok {
+				if first, dup := seen[id]; dup {
+					log.Printf("Skipping %s: same file as %s", path, first)
+					return nil
+				}
+				seen[id] = path
+			}
+		}
+		scan.files = append(scan.files, path)
+		listed[path] = true
+		return nil
+	})
+
+	scan.pairForks(forks, listed, opts)
+	return scan, err
+}
+
+// pairForks pairs AppleDouble files with the media files they describe,
+// dropping orphans and, unless merging, the forks themselves
+func (scan *sourceScan) pairForks(forks []string, listed map[string]bool, opts Options) {
+	for _, fork := range forks {
+		parent := filepath.Join(filepath.Dir(fork), strings.TrimPrefix(filepath.Base(fork), "._"))
+		if !listed[parent] {
+			log.Printf("Dropping orphaned AppleDouble file: %s", fork)
+			continue
+		}
+		if opts.AppleDouble == AppleDoubleMerge {
+			scan.companions[parent] = fork
+		}
+	}
+	if len(forks) > 0 && opts.AppleDouble == AppleDoubleDrop {
+		log.Printf("Dropped %d AppleDouble resource fork files", len(forks))
+	}
+}
+
+// appleDoubleMagic starts every AppleDouble file
+const appleDoubleMagic = 0x00051607
+
+// isAppleDouble reports whether a ._ file really is an AppleDouble header
+func isAppleDouble(path string, info os.FileInfo) bool {
+	if !strings.HasPrefix(info.Name(), "._") || info.Size() < 26 {
+		return false
+	}
+	f, err := openReadOnly(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return binary.BigEndian.Uint32(magic) == appleDoubleMagic
+}
@@ -0,0 +1,38 @@
+package imagedup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectFilesSkipsFolderLinks(t *testing.T) {
+	src := t.TempDir()
+	photos := filepath.Join(src, "photos")
+	if err := os.Mkdir(photos, 0755); err != nil {
+		t.Fatal(err)
+	}
+	photo := filepath.Join(photos, "a.jpg")
+	if err := os.WriteFile(photo, []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"album.jpg":  photos,
+		"broken.jpg": filepath.Join(src, "missing.jpg"),
+		"b.jpg":      photo,
+	} {
+		if err := os.Symlink(target, filepath.Join(src, link)); err != nil {
+			t.Skipf("symlinks unsupported: %v", err)
+		}
+	}
+
+	scan, err := collectFiles(src, DefaultOptions(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b.jpg and photos/a.jpg are one file, listed under the first path walked
+	want := []string{filepath.Join(src, "b.jpg")}
+	if len(scan.files) != len(want) || scan.files[0] != want[0] {
+		t.Fatalf("collected %v, want %v", scan.files, want)
+	}
+}
@@ -0,0 +1,116 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FsckResult summarizes a verification pass over an archive
+type FsckResult struct {
+	Checked int
+	OK      int
+	Skipped int
+
+	// Cached counts the files judged by the checksum cached in their
+	// ChecksumAttr, in a quick check, instead of being read
+	Cached int
+
+	// Missing and Corrupt list archive paths that are gone or whose
+	// checksum no longer matches the manifest
+	Missing []string
+	Corrupt []string
+
+	// Unverifiable lists encrypted or transformed files that were only
+	// checked for presence, since their plaintext can't be re-hashed
+	Unverifiable []string
+}
+
+// Healthy reports whether no missing or corrupt files were found
+func (r *FsckResult) Healthy() bool {
+	return len(r.Missing) == 0 && len(r.Corrupt) == 0
+}
+
+// Fsck re-verifies every file in the manifest against its recorded checksum
+// and size to detect bit rot. Entries verified more recently than olderThan
+// are skipped, so frequent runs can spread the work; zero checks everything.
+// Successful checks update each entry's VerifiedAt.
+func Fsck(destDir string, m *Manifest, olderThan time.Duration, now time.Time) *FsckResult {
+	return fsck(destDir, m, olderThan, now, false)
+}
+
+// QuickFsck is Fsck trusting the checksum cached in ChecksumAttr of each
+// file whose size and modification time haven't changed since it was
+// cached, instead of reading it. It catches files modified, replaced or
+// truncated since, but not silent corruption, so files judged by the cache
+// keep their VerifiedAt. Files it reads have their checksum cached again.
+func QuickFsck(destDir string, m *Manifest, olderThan time.Duration, now time.Time) *FsckResult {
+	return fsck(destDir, m, olderThan, now, true)
+}
+
+// fsck implements Fsck and QuickFsck
+func fsck(destDir string, m *Manifest, olderThan time.Duration, now time.Time, quick bool) *FsckResult {
+	result := &FsckResult{}
+	for _, entry := range m.Sorted() {
+		if olderThan > 0 && entry.VerifiedAt != nil && now.Sub(*entry.VerifiedAt) < olderThan {
+			result.Skipped++
+			continue
+		}
+		result.Checked++
+
+		path := filepath.Join(destDir, filepath.FromSlash(entry.Path))
+		info, err := os.Stat(path)
+		if err != nil {
+			result.Missing = append(result.Missing, entry.Path)
+			continue
+		}
+		if entry.Encryption != "" || entry.Transform != "" {
+			result.Unverifiable = append(result.Unverifiable, entry.Path)
+			continue
+		}
+
+		if sum, ok := CachedChecksum(path); quick && ok {
+			result.Cached++
+			if sum != entry.SHA256 || info.Size() != entry.Size {
+				result.Corrupt = append(result.Corrupt, entry.Path)
+			} else {
+				result.OK++
+			}
+			continue
+		}
+
+		sum, err := checksum(path)
+		if err != nil || sum != entry.SHA256 || info.Size() != entry.Size {
+			result.Corrupt = append(result.Corrupt, entry.Path)
+			continue
+		}
+
+		// A quick check caches what it had to read, for the next one
+		if quick {
+			CacheChecksum(path, sum)
+		}
+		result.OK++
+		verified := now
+		entry.VerifiedAt = &verified
+		m.Add(entry)
+	}
+	return result
+}
+
+// checksum returns the hex encoded SHA-256 of a file
+func checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,68 @@
+package manifest
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// JournalName is the file next to the manifest that entries are appended to
+// as a run stores files, between saves of the manifest. Load replays it and
+// Save removes it, so a run that crashes loses no record of what it copied.
+const JournalName = "manifest.journal"
+
+// Journal appends entries to a destination's journal, one JSON line each
+type Journal struct {
+	f *os.File
+}
+
+// OpenJournal opens the journal of a destination for appending
+func OpenJournal(destDir string) (*Journal, error) {
+	f, err := os.OpenFile(filepath.Join(destDir, JournalName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{f: f}, nil
+}
+
+// Add appends an entry in a single write, so a crash leaves at most the
+// last line cut short
+func (j *Journal) Add(e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = j.f.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the journal
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
+
+// replayJournal adds the entries of a destination's journal to m, which
+// later entries for a path replace
+func replayJournal(destDir string, m *Manifest) error {
+	f, err := os.Open(filepath.Join(destDir, JournalName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<24)
+	for scanner.Scan() {
+		var e Entry
+		// A line cut short by a crash is skipped
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Path == "" {
+			continue
+		}
+		m.Add(e)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,128 @@
+package manifest
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LedgerFileName is the name of the ledger kept next to the manifest
+const LedgerFileName = "ledger.jsonl"
+
+// LedgerEntry records one content imported into an archive
+type LedgerEntry struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+
+	// Path is where the content was first stored, relative to the
+	// destination root; it may since have been moved or removed
+	Path       string     `json:"path"`
+	RunID      string     `json:"run_id,omitempty"`
+	ImportedAt *time.Time `json:"imported_at,omitempty"`
+}
+
+// Ledger is every content ever imported into a destination. Unlike the
+// manifest it is only ever appended to, so content pruned from the archive
+// is still recognized when an old backup is imported again.
+type Ledger struct {
+	entries map[string]LedgerEntry
+	sizes   map[int64]bool
+	pending []LedgerEntry
+}
+
+// LoadLedger reads the ledger of a destination. An archive without one yet
+// starts it from its manifest.
+func LoadLedger(destDir string) (*Ledger, error) {
+	l := &Ledger{entries: make(map[string]LedgerEntry), sizes: make(map[int64]bool)}
+	f, err := os.Open(filepath.Join(destDir, LedgerFileName))
+	if os.IsNotExist(err) {
+		m, err := Load(destDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range m.Sorted() {
+			l.Add(LedgerEntry{SHA256: e.SHA256, Size: e.Size, Path: e.Path, RunID: e.RunID, ImportedAt: e.ImportedAt})
+		}
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var e LedgerEntry
+		// A line cut short by a crash is skipped
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.SHA256 == "" {
+			continue
+		}
+		if _, exists := l.entries[e.SHA256]; !exists {
+			l.entries[e.SHA256] = e
+			l.sizes[e.Size] = true
+		}
+	}
+	return l, scanner.Err()
+}
+
+// Len returns how many contents the ledger holds
+func (l *Ledger) Len() int {
+	return len(l.entries)
+}
+
+// HasSize reports whether any content of this size was imported, which is
+// checked before paying for a checksum
+func (l *Ledger) HasSize(size int64) bool {
+	return l.sizes[size]
+}
+
+// Lookup returns the ledger entry of a content by its SHA-256
+func (l *Ledger) Lookup(sum string) (LedgerEntry, bool) {
+	e, ok := l.entries[sum]
+	return e, ok
+}
+
+// Add records a content, unless it is already in the ledger. It is written
+// by the next Save.
+func (l *Ledger) Add(e LedgerEntry) {
+	if e.SHA256 == "" {
+		return
+	}
+	if _, exists := l.entries[e.SHA256]; exists {
+		return
+	}
+	l.entries[e.SHA256] = e
+	l.sizes[e.Size] = true
+	l.pending = append(l.pending, e)
+}
+
+// Save appends the contents added since the ledger was loaded or last saved
+func (l *Ledger) Save(destDir string) error {
+	if len(l.pending) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(filepath.Join(destDir, LedgerFileName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range l.pending {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	l.pending = nil
+	return nil
+}
@@ -0,0 +1,262 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileName is the name of the manifest kept at the root of each destination
+const FileName = "manifest.json"
+
+// Entry describes one file stored in an archive
+type Entry struct {
+	// Path is the file's location relative to the destination root
+	Path string `json:"path"`
+	// Source is the path the file was copied from
+	Source string `json:"source"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	Date   string `json:"date"`
+
+	// Period is the span of time, such as "1980s", a file without a known
+	// date was filed under instead
+	Period string `json:"period,omitempty"`
+
+	// Taken is the capture time, when its source recorded the time of day
+	Taken *time.Time `json:"taken,omitempty"`
+
+	// DateSource is where Date came from: "exif", "filename", "directory"
+	// or "mtime", from most to least trustworthy
+	DateSource string `json:"date_source,omitempty"`
+
+	// PHash is the hex average hash of an image, used to find near
+	// duplicates without decoding the archive again
+	PHash string `json:"phash,omitempty"`
+
+	// Width and Height are an image's size in pixels, when it could be read
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+
+	// Damaged marks an image that only partly decoded when it was archived
+	Damaged bool `json:"damaged,omitempty"`
+
+	// Encryption names the tool the stored file was encrypted with, if
+	// any. SHA256 and Size always describe the plaintext.
+	Encryption string `json:"encryption,omitempty"`
+
+	// Transform names the archival transform applied to the stored file
+	// ("zstd" or "dng"), if any. SHA256 and Size describe the original.
+	Transform string `json:"transform,omitempty"`
+
+	// SourceRoot is the directory the import that copied the file scanned,
+	// and SourceModTime the original's modification time
+	SourceRoot    string     `json:"source_root,omitempty"`
+	SourceModTime *time.Time `json:"source_mtime,omitempty"`
+
+	// RunID identifies the import that copied the file, which started at
+	// ImportedAt
+	RunID      string     `json:"run_id,omitempty"`
+	ImportedAt *time.Time `json:"imported_at,omitempty"`
+
+	// Faces and EyesOpen are what a face detector found in an image, if one
+	// was run
+	Faces    *int `json:"faces,omitempty"`
+	EyesOpen *int `json:"eyes_open,omitempty"`
+
+	// Camera holds the camera and exposure settings read from the file's
+	// EXIF data, if it had any
+	Camera *Camera `json:"camera,omitempty"`
+
+	// Rating is the XMP star rating, 1 to 5 or -1 for rejected, and Label
+	// the XMP colour label
+	Rating int    `json:"rating,omitempty"`
+	Label  string `json:"label,omitempty"`
+
+	// Catalog names the Lightroom catalogs or Capture One sessions that
+	// managed the source when it was imported, and Collections the
+	// collections it was in there
+	Catalog     string   `json:"catalog,omitempty"`
+	Collections []string `json:"collections,omitempty"`
+
+	// Tags are labels attached with the tag command, such as a person, an
+	// event or keep-forever, kept sorted
+	Tags []string `json:"tags,omitempty"`
+
+	// VerifiedAt is when fsck last confirmed the stored file's checksum
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+
+	// SourceGoneAt is when reconcile first found none of the file's
+	// sources left, making the archive the only copy
+	SourceGoneAt *time.Time `json:"source_gone_at,omitempty"`
+}
+
+// Camera is the camera, lens and exposure settings a photo was taken with.
+// Zero fields weren't recorded.
+type Camera struct {
+	Make  string `json:"make,omitempty"`
+	Model string `json:"model,omitempty"`
+	Lens  string `json:"lens,omitempty"`
+	ISO   int    `json:"iso,omitempty"`
+
+	// Aperture is the f-number, Exposure the shutter speed in seconds and
+	// FocalLength the focal length in millimetres
+	Aperture    float64 `json:"aperture,omitempty"`
+	Exposure    float64 `json:"exposure,omitempty"`
+	FocalLength float64 `json:"focal_length,omitempty"`
+
+	// Orientation is the EXIF orientation, 1 for upright through 8
+	Orientation int `json:"orientation,omitempty"`
+}
+
+// Name is the camera make and model
+func (c *Camera) Name() string {
+	if c == nil {
+		return ""
+	}
+	// Models often repeat the make, as in "Canon Canon EOS 5D"
+	if c.Make == "" || strings.HasPrefix(strings.ToLower(c.Model), strings.ToLower(c.Make)) {
+		return c.Model
+	}
+	return strings.TrimSpace(c.Make + " " + c.Model)
+}
+
+// String summarizes the settings, e.g. "Canon EOS 5D, EF50mm f/1.8, ISO 200,
+// f/2.8, 1/250s, 50mm"
+func (c *Camera) String() string {
+	if c == nil {
+		return ""
+	}
+	var parts []string
+	for _, s := range []string{c.Name(), c.Lens} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	if c.ISO > 0 {
+		parts = append(parts, fmt.Sprintf("ISO %d", c.ISO))
+	}
+	if c.Aperture > 0 {
+		parts = append(parts, "f/"+strconv.FormatFloat(c.Aperture, 'f', -1, 64))
+	}
+	if c.Exposure > 0 {
+		if c.Exposure < 1 {
+			parts = append(parts, fmt.Sprintf("1/%.0fs", 1/c.Exposure))
+		} else {
+			parts = append(parts, strconv.FormatFloat(c.Exposure, 'f', -1, 64)+"s")
+		}
+	}
+	if c.FocalLength > 0 {
+		parts = append(parts, strconv.FormatFloat(c.FocalLength, 'f', -1, 64)+"mm")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Manifest is the catalog of every file stored in one destination
+type Manifest struct {
+	Entries map[string]Entry `json:"entries"`
+}
+
+// New returns an empty manifest
+func New() *Manifest {
+	return &Manifest{Entries: make(map[string]Entry)}
+}
+
+// Load reads the manifest of a destination, returning an empty manifest if
+// none has been written yet, with the entries of any journal a crashed run
+// left
+func Load(destDir string) (*Manifest, error) {
+	m := New()
+	data, err := os.ReadFile(filepath.Join(destDir, FileName))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, err
+		}
+		if m.Entries == nil {
+			m.Entries = make(map[string]Entry)
+		}
+	}
+	if err := replayJournal(destDir, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Save writes the manifest to the root of a destination, replacing the old
+// file atomically so a crash never leaves a truncated manifest, and removes
+// the journal it now includes
+func (m *Manifest) Save(destDir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(destDir, FileName+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(destDir, FileName)); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(destDir, JournalName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Add records an entry, replacing any previous entry for the same path
+func (m *Manifest) Add(e Entry) {
+	m.Entries[filepath.ToSlash(e.Path)] = e
+}
+
+// Sorted returns the entries ordered by path
+func (m *Manifest) Sorted() []Entry {
+	entries := make([]Entry, 0, len(m.Entries))
+	for _, e := range m.Entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+// HasTag reports whether the entry carries a tag
+func (e Entry) HasTag(tag string) bool {
+	i := sort.SearchStrings(e.Tags, tag)
+	return i < len(e.Tags) && e.Tags[i] == tag
+}
+
+// AddTags attaches tags to the entry, reporting whether any were new
+func (e *Entry) AddTags(tags ...string) bool {
+	changed := false
+	for _, tag := range tags {
+		if !e.HasTag(tag) {
+			e.Tags = append(e.Tags, tag)
+			sort.Strings(e.Tags)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// RemoveTags detaches tags from the entry, reporting whether any were present
+func (e *Entry) RemoveTags(tags ...string) bool {
+	changed := false
+	for _, tag := range tags {
+		if i := sort.SearchStrings(e.Tags, tag); i < len(e.Tags) && e.Tags[i] == tag {
+			e.Tags = append(e.Tags[:i], e.Tags[i+1:]...)
+			changed = true
+		}
+	}
+	if len(e.Tags) == 0 {
+		e.Tags = nil
+	}
+	return changed
+}
@@ -0,0 +1,44 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ChecksumAttr is the extended attribute caching an archived file's SHA-256
+const ChecksumAttr = "user.pictureprocess.hash"
+
+// CacheChecksum records a file's SHA-256 in its ChecksumAttr together with
+// the size and modification time it has now, so while neither changes the
+// file needn't be read again to know its checksum
+func CacheChecksum(path, sum string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	value := fmt.Sprintf("%s %d %d", sum, info.Size(), info.ModTime().UnixNano())
+	return setxattr(path, ChecksumAttr, []byte(value))
+}
+
+// CachedChecksum returns the SHA-256 cached in a file's ChecksumAttr, if it
+// has one and the file's size and modification time are still those it was
+// cached with
+func CachedChecksum(path string) (string, bool) {
+	value, err := getxattr(path, ChecksumAttr)
+	if err != nil {
+		return "", false
+	}
+	fields := strings.Fields(string(value))
+	if len(fields) != 3 {
+		return "", false
+	}
+	size, err1 := strconv.ParseInt(fields[1], 10, 64)
+	mtime, err2 := strconv.ParseInt(fields[2], 10, 64)
+	info, err := os.Stat(path)
+	if err1 != nil || err2 != nil || err != nil || info.Size() != size || info.ModTime().UnixNano() != mtime {
+		return "", false
+	}
+	return fields[0], true
+}
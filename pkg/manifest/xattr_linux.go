@@ -0,0 +1,20 @@
+//go:build linux
+
+package manifest
+
+import "syscall"
+
+// setxattr sets an extended attribute of a file
+func setxattr(path, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}
+
+// getxattr reads an extended attribute of a file, of at most 256 bytes
+func getxattr(path, name string) ([]byte, error) {
+	buf := make([]byte, 256)
+	n, err := syscall.Getxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
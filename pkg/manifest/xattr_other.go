@@ -0,0 +1,15 @@
+//go:build !linux
+
+package manifest
+
+import "errors"
+
+// setxattr is only implemented on Linux
+func setxattr(path, name string, value []byte) error {
+	return errors.ErrUnsupported
+}
+
+// getxattr is only implemented on Linux
+func getxattr(path, name string) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
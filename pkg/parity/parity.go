@@ -0,0 +1,247 @@
+package parity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/gavinmcnair/pictureprocess/pkg/tools"
+)
+
+// Parity methods
+const (
+	// XOR keeps one XOR parity block per folder, enough to rebuild any
+	// single damaged file once fsck has identified it
+	XOR = "xor"
+	// PAR2 uses par2cmdline with 10% Reed-Solomon redundancy
+	PAR2 = "par2"
+)
+
+// Sidecar file names written into each protected folder
+const (
+	xorDataFile  = ".parity"
+	xorIndexFile = ".parity.json"
+	par2File     = ".parity.par2"
+)
+
+// xorIndex lists the files covered by a folder's XOR parity
+type xorIndex struct {
+	Files  []xorMember `json:"files"`
+	Length int64       `json:"length"`
+}
+
+type xorMember struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// Create (re)generates the parity sidecars for the named files in dir
+func Create(dir string, names []string, method string, tl tools.Tools) error {
+	sort.Strings(names)
+	switch method {
+	case XOR:
+		return createXOR(dir, names)
+	case PAR2:
+		return tl.Par2Create(filepath.Join(dir, par2File), dir, names)
+	}
+	return fmt.Errorf("unknown parity method %q", method)
+}
+
+// Repair rebuilds a damaged or missing file in dir from its parity sidecars,
+// using whichever method the folder was protected with
+func Repair(dir, name string, tl tools.Tools) error {
+	if _, err := os.Stat(filepath.Join(dir, xorIndexFile)); err == nil {
+		return repairXOR(dir, name)
+	}
+	if _, err := os.Stat(filepath.Join(dir, par2File)); err == nil {
+		return tl.Par2Repair(filepath.Join(dir, par2File))
+	}
+	return errors.New("no parity data for folder")
+}
+
+// Method reports which parity method protects dir, or "" if none does
+func Method(dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, xorIndexFile)); err == nil {
+		return XOR
+	}
+	if _, err := os.Stat(filepath.Join(dir, par2File)); err == nil {
+		return PAR2
+	}
+	return ""
+}
+
+// Remove deletes every parity sidecar in dir, including par2 recovery volumes
+func Remove(dir string) error {
+	sidecars, err := filepath.Glob(filepath.Join(dir, xorDataFile+"*"))
+	if err != nil {
+		return err
+	}
+	for _, sidecar := range sidecars {
+		if err := os.Remove(sidecar); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkSize bounds memory use while streaming files through the parity block
+const chunkSize = 1 << 20
+
+// createXOR writes the XOR of every file, zero padded to the longest, plus an
+// index recording each file's size. Files are streamed in chunks so large
+// videos don't have to fit in memory.
+func createXOR(dir string, names []string) error {
+	tmp := filepath.Join(dir, xorDataFile+".tmp")
+	parity, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer parity.Close()
+
+	index := xorIndex{}
+	for _, name := range names {
+		size, err := xorFileInto(parity, filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if size > index.Length {
+			index.Length = size
+		}
+		index.Files = append(index.Files, xorMember{Name: name, Size: size})
+	}
+	if err := parity.Close(); err != nil {
+		return err
+	}
+
+	meta, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, xorDataFile)); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, xorIndexFile), meta, 0644)
+}
+
+// repairXOR rebuilds name by XORing the parity block with every other file
+func repairXOR(dir, name string) error {
+	meta, err := os.ReadFile(filepath.Join(dir, xorIndexFile))
+	if err != nil {
+		return err
+	}
+	var index xorIndex
+	if err := json.Unmarshal(meta, &index); err != nil {
+		return err
+	}
+
+	size := int64(-1)
+	for _, member := range index.Files {
+		if member.Name == name {
+			size = member.Size
+		}
+	}
+	if size < 0 {
+		return fmt.Errorf("%s is not covered by the folder's parity", name)
+	}
+
+	// Start from a copy of the parity block and cancel out every other file
+	tmp := filepath.Join(dir, name+".repair")
+	if err := copyFile(filepath.Join(dir, xorDataFile), tmp); err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	out, err := os.OpenFile(tmp, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if info, err := out.Stat(); err != nil || info.Size() != index.Length {
+		return errors.New("parity block is damaged")
+	}
+
+	for _, member := range index.Files {
+		if member.Name == name {
+			continue
+		}
+		n, err := xorFileInto(out, filepath.Join(dir, member.Name))
+		if err != nil {
+			return fmt.Errorf("can't repair with %s also unavailable: %w", member.Name, err)
+		}
+		if n != member.Size {
+			return fmt.Errorf("can't repair with %s also damaged", member.Name)
+		}
+	}
+	if err := out.Truncate(size); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, name))
+}
+
+// xorFileInto XORs a file into the start of the parity file, extending it as
+// needed, and returns the file's size
+func xorFileInto(parity *os.File, path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	data := make([]byte, chunkSize)
+	block := make([]byte, chunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(f, data)
+		if n > 0 {
+			m, readErr := parity.ReadAt(block[:n], offset)
+			if readErr != nil && readErr != io.EOF {
+				return 0, readErr
+			}
+			// Bytes past the end of the parity file are implicitly zero
+			for i := m; i < n; i++ {
+				block[i] = 0
+			}
+			for i := 0; i < n; i++ {
+				block[i] ^= data[i]
+			}
+			if _, err := parity.WriteAt(block[:n], offset); err != nil {
+				return 0, err
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return offset, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// copyFile copies src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,314 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnavailable is returned when the external tool needed is not installed
+var ErrUnavailable = errors.New("external tool not available")
+
+// Paths configures where external tools live. Empty values are looked up on
+// the PATH; Disabled skips detection so only pure-Go code paths are used.
+type Paths struct {
+	FFmpeg   string
+	FFprobe  string
+	ExifTool string
+	Vips     string
+	Age      string
+	GPG      string
+	Zstd     string
+	DNGLab   string
+	Par2     string
+	SQLite3  string
+	Disabled bool
+}
+
+// Tools holds the resolved locations of optional external programs. An empty
+// path means the tool is unavailable.
+type Tools struct {
+	FFmpeg   string
+	FFprobe  string
+	ExifTool string
+	Vips     string
+	Age      string
+	GPG      string
+	Zstd     string
+	DNGLab   string
+	Par2     string
+	SQLite3  string
+}
+
+// Detect resolves the configured tools, falling back to the PATH
+func Detect(paths Paths) Tools {
+	if paths.Disabled {
+		return Tools{}
+	}
+	return Tools{
+		FFmpeg:   resolve(paths.FFmpeg, "ffmpeg"),
+		FFprobe:  resolve(paths.FFprobe, "ffprobe"),
+		ExifTool: resolve(paths.ExifTool, "exiftool"),
+		Vips:     resolve(paths.Vips, "vips"),
+		Age:      resolve(paths.Age, "age"),
+		GPG:      resolve(paths.GPG, "gpg"),
+		Zstd:     resolve(paths.Zstd, "zstd"),
+		DNGLab:   resolve(paths.DNGLab, "dnglab"),
+		Par2:     resolve(paths.Par2, "par2"),
+		SQLite3:  resolve(paths.SQLite3, "sqlite3"),
+	}
+}
+
+// resolve returns the configured path if it is executable, else looks up name
+func resolve(configured, name string) string {
+	if configured != "" {
+		name = configured
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// String lists which tools were found
+func (t Tools) String() string {
+	var found []string
+	for _, tool := range [][2]string{{"ffmpeg", t.FFmpeg}, {"ffprobe", t.FFprobe}, {"exiftool", t.ExifTool}, {"vips", t.Vips}, {"age", t.Age}, {"gpg", t.GPG}, {"zstd", t.Zstd}, {"dnglab", t.DNGLab}, {"par2", t.Par2}, {"sqlite3", t.SQLite3}} {
+		if tool[1] != "" {
+			found = append(found, tool[0])
+		}
+	}
+	if len(found) == 0 {
+		return "none"
+	}
+	return strings.Join(found, ", ")
+}
+
+// VideoInfo is the subset of ffprobe output used for fingerprinting
+type VideoInfo struct {
+	Duration time.Duration
+	Width    int
+	Height   int
+}
+
+// ProbeVideo reads duration and resolution of any container ffprobe supports
+func (t Tools) ProbeVideo(path string) (VideoInfo, error) {
+	var info VideoInfo
+	if t.FFprobe == "" {
+		return info, ErrUnavailable
+	}
+
+	out, err := exec.Command(t.FFprobe, "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration", "-of", "json", path).Output()
+	if err != nil {
+		return info, err
+	}
+
+	var probe struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return info, err
+	}
+
+	seconds, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil {
+		return info, fmt.Errorf("no duration reported: %w", err)
+	}
+	info.Duration = time.Duration(seconds * float64(time.Second))
+	if len(probe.Streams) > 0 {
+		info.Width, info.Height = probe.Streams[0].Width, probe.Streams[0].Height
+	}
+	return info, nil
+}
+
+// Frame extracts a single PNG encoded frame at the given offset
+func (t Tools) Frame(path string, at time.Duration) ([]byte, error) {
+	if t.FFmpeg == "" {
+		return nil, ErrUnavailable
+	}
+	return exec.Command(t.FFmpeg, "-v", "error",
+		"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64), "-i", path,
+		"-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "-").Output()
+}
+
+// GrayFrames decodes frames at fps, each scaled to size x size grayscale, and
+// returns the raw pixels concatenated
+func (t Tools) GrayFrames(path string, fps, size int) ([]byte, error) {
+	if t.FFmpeg == "" {
+		return nil, ErrUnavailable
+	}
+	return exec.Command(t.FFmpeg, "-v", "error", "-i", path,
+		"-vf", fmt.Sprintf("fps=%d,scale=%d:%d", fps, size, size),
+		"-f", "rawvideo", "-pix_fmt", "gray", "-").Output()
+}
+
+// CreateDate reads the capture date exiftool reports for any media file, as
+// local time like the other date sources
+func (t Tools) CreateDate(path string) (time.Time, error) {
+	if t.ExifTool == "" {
+		return time.Time{}, ErrUnavailable
+	}
+
+	out, err := exec.Command(t.ExifTool, "-s3", "-d", "%Y-%m-%dT%H:%M:%S",
+		"-DateTimeOriginal", "-CreateDate", "-MediaCreateDate", path).Output()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if t, err := time.ParseInLocation("2006-01-02T15:04:05", strings.TrimSpace(line), time.Local); err == nil && !t.IsZero() && t.Year() > 1 {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("no date reported by exiftool")
+}
+
+// Thumbnail shrinks an image on load to a size x size PNG using libvips,
+// which avoids decoding the full resolution image
+func (t Tools) Thumbnail(path string, size int) ([]byte, error) {
+	if t.Vips == "" {
+		return nil, ErrUnavailable
+	}
+	return exec.Command(t.Vips, "thumbnail", path, ".png", strconv.Itoa(size),
+		"--height", strconv.Itoa(size), "--size", "force").Output()
+}
+
+// Encrypt reads plaintext from r and writes it encrypted for recipients to
+// dst, using "age" or "gpg"
+func (t Tools) Encrypt(method string, recipients []string, r io.Reader, dst string) error {
+	var cmd *exec.Cmd
+	switch method {
+	case "age":
+		if t.Age == "" {
+			return ErrUnavailable
+		}
+		args := []string{"-o", dst}
+		for _, recipient := range recipients {
+			args = append(args, "-r", recipient)
+		}
+		cmd = exec.Command(t.Age, args...)
+	case "gpg":
+		if t.GPG == "" {
+			return ErrUnavailable
+		}
+		args := []string{"--batch", "--yes", "--trust-model", "always", "--encrypt", "-o", dst}
+		for _, recipient := range recipients {
+			args = append(args, "-r", recipient)
+		}
+		cmd = exec.Command(t.GPG, args...)
+	default:
+		return fmt.Errorf("unknown encryption method %q", method)
+	}
+
+	cmd.Stdin = r
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", method, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Compress writes a zstd compressed copy of src to dst
+func (t Tools) Compress(src, dst string) error {
+	if t.Zstd == "" {
+		return ErrUnavailable
+	}
+	if out, err := exec.Command(t.Zstd, "-q", "-f", "-19", src, "-o", dst).CombinedOutput(); err != nil {
+		return fmt.Errorf("zstd failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ConvertDNG losslessly converts a RAW file to DNG with dnglab
+func (t Tools) ConvertDNG(src, dst string) error {
+	if t.DNGLab == "" {
+		return ErrUnavailable
+	}
+	if out, err := exec.Command(t.DNGLab, "convert", "-f", src, dst).CombinedOutput(); err != nil {
+		return fmt.Errorf("dnglab failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Transcode converts an image to a JPEG at dst with libvips, shrinking its
+// longest edge to maxSize when non-zero
+func (t Tools) Transcode(src, dst string, maxSize, quality int) error {
+	if t.Vips == "" {
+		return ErrUnavailable
+	}
+	target := fmt.Sprintf("%s[Q=%d]", dst, quality)
+	var cmd *exec.Cmd
+	if maxSize > 0 {
+		cmd = exec.Command(t.Vips, "thumbnail", src, target, strconv.Itoa(maxSize), "--size", "down")
+	} else {
+		cmd = exec.Command(t.Vips, "copy", src, target)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("vips failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Par2Create writes PAR2 recovery files for the named files in dir
+func (t Tools) Par2Create(par2File, dir string, names []string) error {
+	if t.Par2 == "" {
+		return ErrUnavailable
+	}
+	// Remove stale recovery volumes so the set matches the folder
+	if stale, err := filepath.Glob(strings.TrimSuffix(par2File, ".par2") + "*.par2"); err == nil {
+		for _, f := range stale {
+			os.Remove(f)
+		}
+	}
+	args := append([]string{"create", "-q", "-r10", par2File}, names...)
+	cmd := exec.Command(t.Par2, args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("par2 create failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Par2Repair repairs the files covered by a PAR2 set
+func (t Tools) Par2Repair(par2File string) error {
+	if t.Par2 == "" {
+		return ErrUnavailable
+	}
+	cmd := exec.Command(t.Par2, "repair", "-q", par2File)
+	cmd.Dir = filepath.Dir(par2File)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("par2 repair failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Query runs a read-only SQL query on an SQLite database with sqlite3,
+// returning the rows
+func (t Tools) Query(db, query string) ([][]string, error) {
+	if t.SQLite3 == "" {
+		return nil, ErrUnavailable
+	}
+	out, err := exec.Command(t.SQLite3, "-readonly", "-csv", "-noheader", db, query).Output()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite3 failed on %s: %w", db, err)
+	}
+	r := csv.NewReader(bytes.NewReader(out))
+	r.FieldsPerRecord = -1
+	return r.ReadAll()
+}
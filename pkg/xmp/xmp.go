@@ -0,0 +1,123 @@
+package xmp
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// scanLimit is how much of a file is searched for an embedded packet. JPEG
+// keeps it in an APP1 segment near the start, as do most RAW formats.
+const scanLimit = 1 << 20
+
+// Packet is the XML of one XMP packet
+type Packet []byte
+
+// propertyPatterns caches the pattern Property compiles for each name
+var propertyPatterns sync.Map
+
+// propertyPattern returns the pattern matching a property as an attribute
+// or an element
+func propertyPattern(name string) *regexp.Regexp {
+	if pattern, ok := propertyPatterns.Load(name); ok {
+		return pattern.(*regexp.Regexp)
+	}
+	quoted := regexp.QuoteMeta(name)
+	pattern := regexp.MustCompile(quoted + `\s*=\s*["']([^"']*)["']|<` + quoted + `>([^<]*)</` + quoted + `>`)
+	actual, _ := propertyPatterns.LoadOrStore(name, pattern)
+	return actual.(*regexp.Regexp)
+}
+
+// Property finds a simple property's value, such as "xmp:CreateDate",
+// whether it is written as an attribute or an element
+func (p Packet) Property(name string) (string, bool) {
+	m := propertyPattern(name).FindSubmatch(p)
+	if m == nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(append(m[1], m[2]...))), true
+}
+
+// Rating is the xmp:Rating star rating: 1 to 5, 0 for unrated or -1 for
+// rejected. ok is false if the packet has no rating.
+func (p Packet) Rating() (rating int, ok bool) {
+	value, found := p.Property("xmp:Rating")
+	if !found {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < -1 || f > 5 {
+		return 0, false
+	}
+	return int(math.Round(f)), true
+}
+
+// Label is the xmp:Label colour label, such as "Red", or ""
+func (p Packet) Label() string {
+	label, _ := p.Property("xmp:Label")
+	return label
+}
+
+// Find returns the first packet in data, such as a whole file's contents,
+// or nil if there is none
+func Find(data []byte) Packet {
+	start := bytes.Index(data, []byte("<x:xmpmeta"))
+	if start < 0 {
+		return nil
+	}
+	end := bytes.Index(data[start:], []byte("</x:xmpmeta>"))
+	if end < 0 {
+		return nil
+	}
+	return Packet(data[start : start+end+len("</x:xmpmeta>")])
+}
+
+// Embedded returns the packet embedded in a file, or nil if it has none
+func Embedded(filePath string) (Packet, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, scanLimit))
+	if err != nil {
+		return nil, err
+	}
+	return Find(data), nil
+}
+
+// SidecarPath returns the path of a file's sidecar, IMG_1234.xmp or
+// IMG_1234.CR2.xmp as editors write them, or "" if it has none
+func SidecarPath(filePath string) string {
+	base := strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	for _, candidate := range []string{base + ".xmp", base + ".XMP", filePath + ".xmp", filePath + ".XMP"} {
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// Read returns a file's packets in order of precedence: its sidecar, where
+// editors write changes, then its embedded packet. Either may be missing.
+func Read(filePath string) []Packet {
+	var packets []Packet
+	if sidecar := SidecarPath(filePath); sidecar != "" {
+		if data, err := os.ReadFile(sidecar); err == nil {
+			if p := Find(data); p != nil {
+				packets = append(packets, p)
+			}
+		}
+	}
+	if p, err := Embedded(filePath); err == nil && p != nil {
+		packets = append(packets, p)
+	}
+	return packets
+}